@@ -0,0 +1,136 @@
+package amazbot
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/igolaizola/amazbot/internal/notify"
+)
+
+var variants = []string{"a", "b"}
+
+func abKey(chat string) string {
+	return fmt.Sprintf("%s/ab", chat)
+}
+
+// abtestCommand handles /abtest [a|b <template>|off|reset]
+func (b *bot) abtestCommand(user int, chat, args string) {
+	args = strings.TrimSpace(args)
+	split := strings.SplitN(args, " ", 2)
+	var tmpls map[string]string
+	if err := b.db.Get("config", abKey(chat), &tmpls); err != nil {
+		b.log(err)
+		return
+	}
+	if tmpls == nil {
+		tmpls = make(map[string]string)
+	}
+	switch split[0] {
+	case "":
+		if len(tmpls) == 0 {
+			b.message(user, fmt.Sprintf("no ab test for %s", chat))
+			return
+		}
+		text := fmt.Sprintf("ab test for %s:", chat)
+		for _, v := range variants {
+			var s linkStats
+			if err := b.db.Get("stats", statsKey(chat, v), &s); err != nil {
+				b.log(err)
+			}
+			var ctr float64
+			if s.Sent > 0 {
+				ctr = 100 * float64(s.Clicks) / float64(s.Sent)
+			}
+			text = fmt.Sprintf("%s\n\n%s: sent %d, clicks %d, ctr %.1f%%\n%s", text, v, s.Sent, s.Clicks, ctr, tmpls[v])
+		}
+		if b.baseURL == "" {
+			text = fmt.Sprintf("%s\n\nclicks aren't tracked, base url is not configured", text)
+		}
+		b.message(user, text)
+		return
+	case "off":
+		if err := b.db.Delete("config", abKey(chat)); err != nil {
+			b.log(err)
+			return
+		}
+		b.message(user, fmt.Sprintf("ab test disabled for %s", chat))
+		return
+	case "reset":
+		for _, v := range variants {
+			if err := b.db.Delete("stats", statsKey(chat, v)); err != nil {
+				b.log(err)
+				return
+			}
+		}
+		b.message(user, fmt.Sprintf("ab test stats reset for %s", chat))
+		return
+	case "a", "b":
+		if len(split) < 2 || strings.TrimSpace(split[1]) == "" {
+			b.message(user, "usage: /abtest a|b <template>")
+			return
+		}
+		if _, err := b.variantTemplate(chat, split[1]); err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		tmpls[split[0]] = split[1]
+	default:
+		b.message(user, "usage: /abtest [a|b <template>|off|reset]")
+		return
+	}
+	if err := b.db.Put("config", abKey(chat), tmpls); err != nil {
+		b.log(err)
+		return
+	}
+	b.message(user, fmt.Sprintf("ab test variant %s updated for %s", split[0], chat))
+}
+
+// variantTemplate returns the template set of the chat with the text
+// replaced by the variant
+func (b *bot) variantTemplate(chat, text string) (*template.Template, error) {
+	scheme, _, _ := notify.Split(chat)
+	set := templateSet(scheme)
+	t, err := b.template(set).Clone()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't clone %s templates: %w", set, err)
+	}
+	if _, err := t.Parse(fmt.Sprintf(`{{define "text"}}%s{{end}}`, text)); err != nil {
+		return nil, fmt.Errorf("couldn't parse template: %w", err)
+	}
+	return t, nil
+}
+
+// variant returns the ab test variant with less posts and its template, an
+// empty variant is returned if the chat has no ab test
+func (b *bot) variant(chat string) (string, *template.Template) {
+	var tmpls map[string]string
+	if err := b.db.Get("config", abKey(chat), &tmpls); err != nil {
+		b.log(err)
+		return "", nil
+	}
+	var variant string
+	var sent int
+	for _, v := range variants {
+		if _, ok := tmpls[v]; !ok {
+			continue
+		}
+		var s linkStats
+		if err := b.db.Get("stats", statsKey(chat, v), &s); err != nil {
+			b.log(err)
+			return "", nil
+		}
+		if variant == "" || s.Sent < sent {
+			variant, sent = v, s.Sent
+		}
+	}
+	if variant == "" {
+		return "", nil
+	}
+	t, err := b.variantTemplate(chat, tmpls[variant])
+	if err != nil {
+		b.log(err)
+		return "", nil
+	}
+	return variant, t
+}
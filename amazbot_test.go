@@ -0,0 +1,2328 @@
+package amazbot
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/fx"
+	"github.com/igolaizola/amazbot/internal/metrics"
+	"github.com/igolaizola/amazbot/internal/notify"
+	"github.com/igolaizola/amazbot/internal/store"
+	"github.com/patrickmn/go-cache"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// fakeTelegram records the messages sent by the bot, the bot can post in all
+// chats except the ones in members
+type fakeTelegram struct {
+	lock    sync.Mutex
+	sent    []tgbot.MessageConfig
+	edits   []tgbot.EditMessageTextConfig
+	ids     int
+	answers []string
+	members map[string]tgbot.ChatMember
+	files   map[string]string
+}
+
+func (f *fakeTelegram) Self() tgbot.User {
+	return tgbot.User{ID: 1, UserName: "amazbot"}
+}
+
+func (f *fakeTelegram) SendMessage(_ context.Context, msg tgbot.MessageConfig) (tgbot.Message, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sent = append(f.sent, msg)
+	f.ids++
+	return tgbot.Message{MessageID: f.ids}, nil
+}
+
+func (f *fakeTelegram) EditMessage(edit tgbot.EditMessageTextConfig) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.edits = append(f.edits, edit)
+	return nil
+}
+
+func (f *fakeTelegram) AnswerCallback(id string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.answers = append(f.answers, id)
+	return nil
+}
+
+func (f *fakeTelegram) ChatAdmins(tgbot.ChatConfig) ([]tgbot.ChatMember, error) {
+	return nil, nil
+}
+
+func (f *fakeTelegram) Chat(chat tgbot.ChatConfig) (tgbot.Chat, error) {
+	if chat.SuperGroupUsername == "@missing" {
+		return tgbot.Chat{}, errors.New("Bad Request: chat not found")
+	}
+	return tgbot.Chat{ID: chat.ChatID, Type: "channel"}, nil
+}
+
+func (f *fakeTelegram) ChatMember(member tgbot.ChatConfigWithUser) (tgbot.ChatMember, error) {
+	if m, ok := f.members[member.SuperGroupUsername]; ok {
+		return m, nil
+	}
+	return tgbot.ChatMember{Status: "administrator", CanPostMessages: true}, nil
+}
+
+func (f *fakeTelegram) GetUpdates(context.Context) (<-chan tgbot.Update, error) {
+	return make(chan tgbot.Update), nil
+}
+
+func (f *fakeTelegram) FileURL(file string) (string, error) {
+	if u, ok := f.files[file]; ok {
+		return u, nil
+	}
+	return "", errors.New("Bad Request: invalid file_id")
+}
+
+// messages returns the texts sent to the chat and clears them
+func (f *fakeTelegram) messages(chat int64) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var texts []string
+	var rest []tgbot.MessageConfig
+	for _, m := range f.sent {
+		if m.ChatID == chat {
+			texts = append(texts, m.Text)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	f.sent = rest
+	return texts
+}
+
+const (
+	testAdmin = 100
+	testUser  = 200
+)
+
+func newTestBot(t *testing.T) (*bot, *fakeTelegram) {
+	t.Helper()
+	db, err := store.New(t.TempDir() + "/amazbot.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	templates, err := loadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg := &fakeTelegram{}
+	b := &bot{
+		ctx:       context.Background(),
+		tg:        tg,
+		db:        db,
+		admin:     testAdmin,
+		userChats: map[int]string{testAdmin: "-1", testUser: "-2"},
+		cache:     cache.New(dedupTTL, dedupTTL),
+		bus:       newBus(),
+		metrics:   metrics.New(),
+		scrapes:   make(map[string]scrapeStat),
+		failures:  make(map[string]int),
+		templates: templates,
+		fx:        fx.Fixed(map[string]float64{"GBP": 0.85, "USD": 1.25}),
+	}
+	b.throttle = newThrottle(0, nil, b.log)
+	b.router = b.newRouter()
+	return b, tg
+}
+
+func commandUpdate(user int, text string) tgbot.Update {
+	cmd := strings.SplitN(text, " ", 2)[0]
+	return tgbot.Update{Message: &tgbot.Message{
+		Chat:     &tgbot.Chat{ID: int64(user), Type: "private"},
+		Text:     text,
+		Entities: &[]tgbot.MessageEntity{{Type: "bot_command", Length: len(cmd)}},
+	}}
+}
+
+func TestChatCommand(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	b.handle(ctx, commandUpdate(testUser, "/chat"))
+	if got := tg.messages(testUser); len(got) != 1 || !strings.HasSuffix(got[0], ": -2") {
+		t.Fatalf("unexpected messages %q", got)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/chat @deals"))
+	b.handle(ctx, commandUpdate(testUser, "/chat"))
+	if got := tg.messages(testUser); len(got) != 2 || !strings.HasSuffix(got[1], ": @deals") {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	var chat string
+	if err := b.db.Get("config", "200", &chat); err != nil || chat != "@deals" {
+		t.Errorf("chat not persisted: %q %v", chat, err)
+	}
+
+	// Unknown users are ignored
+	b.handle(ctx, commandUpdate(300, "/chat"))
+	if got := tg.messages(300); len(got) != 0 {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	b.handle(ctx, commandUpdate(testUser, "/queue"))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "only operators can see the queue" {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	b.handle(ctx, commandUpdate(testAdmin, "/queue"))
+	if got := tg.messages(testAdmin); len(got) != 1 || strings.HasPrefix(got[0], "only") {
+		t.Fatalf("unexpected messages %q", got)
+	}
+
+	// Operators can't run owner commands
+	b.operators = map[int]bool{testUser: true}
+	b.handle(ctx, commandUpdate(testUser, "/pauseall"))
+	b.handle(ctx, commandUpdate(testUser, "/update"))
+	want := []string{"paused all", "only the admin can update the bot"}
+	if got := tg.messages(testUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCallback(t *testing.T) {
+	b, tg := newTestBot(t)
+	update := tgbot.Update{CallbackQuery: &tgbot.CallbackQuery{
+		ID:   "cb",
+		From: &tgbot.User{ID: testUser},
+		Data: "/chat",
+	}}
+	b.handle(context.Background(), update)
+	if len(tg.answers) != 1 || tg.answers[0] != "cb" {
+		t.Errorf("callback not answered: %q", tg.answers)
+	}
+	if got := tg.messages(testUser); len(got) != 1 {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	b, tg := newTestBot(t)
+	item := api.Item{
+		ID:       "B000000000",
+		Domain:   "es",
+		Link:     "https://www.amazon.es/dp/B000000000",
+		Title:    "Café <molido>",
+		MinPrice: 20,
+		Prices:   [5]float64{10},
+	}
+	alert := api.Alert{Kind: api.PriceAlert, Price: 10, Ref: 20}
+	e := Event{Type: PriceDropDetected, Search: "-2/B000000000.es", Chat: "-2", Item: &item, Alert: &alert}
+
+	b.notify(e)
+	var got []tgbot.MessageConfig
+	for _, m := range tg.sent {
+		if m.ChannelUsername == "-2" {
+			got = append(got, m)
+		}
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(got))
+	}
+	m := got[0]
+	if m.ParseMode != tgbot.ModeHTML {
+		t.Errorf("unexpected parse mode %q", m.ParseMode)
+	}
+	for _, want := range []string{"Café &lt;molido&gt;", "10", item.Link} {
+		if !strings.Contains(m.Text, want) {
+			t.Errorf("%q not found in %q", want, m.Text)
+		}
+	}
+
+	// Duplicated alerts aren't sent again
+	b.notify(e)
+	if len(tg.sent) != 1 {
+		t.Errorf("duplicated alert sent")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	b.handle(ctx, commandUpdate(testUser, "/search"))
+	b.handle(ctx, commandUpdate(testUser, "/unknown"))
+	b.handle(ctx, commandUpdate(testUser, "/help stop"))
+	want := []string{
+		"search arguments not provided",
+		"unknown command /unknown, see /help",
+		"/stop <asin[.domain]|*>\nstop a search or all of them",
+	}
+	if got := tg.messages(testUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/help"))
+	got := tg.messages(testUser)
+	if len(got) != 1 || !strings.Contains(got[0], "/queue - show the scrape queue") {
+		t.Errorf("unexpected help %q", got)
+	}
+}
+
+func TestStopWhileSearching(t *testing.T) {
+	b, _ := newTestBot(t)
+	parsed, err := parseArgs("B000000000.es", "-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.add(parsed)
+	b.scrape = func(ctx context.Context, query string, item *api.Item, _ func(api.Item, api.Alert) error) error {
+		item.ID = "B000000000"
+		b.stop(parsed)
+		return nil
+	}
+	b.search(context.Background(), parsed)
+
+	if _, ok := b.searchs.Load(parsed.id); ok {
+		t.Error("stopped search was stored again")
+	}
+	keys, err := b.db.Keys("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("stopped search was saved: %v", keys)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.bus.Subscribe(b.notify, PriceDropDetected, SearchAnnounced)
+	parsed, err := parseArgs("B000000000.es?announce=1", "-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.add(parsed)
+	b.scrape = func(ctx context.Context, query string, item *api.Item, _ func(api.Item, api.Alert) error) error {
+		item.ID = "B000000000"
+		item.Domain = "es"
+		item.Title = "Disco SSD"
+		item.Prices = [5]float64{30, 25}
+		return nil
+	}
+	b.search(context.Background(), parsed)
+	b.search(context.Background(), parsed)
+
+	var got []string
+	for _, m := range tg.sent {
+		if m.ChannelUsername == "-2" {
+			got = append(got, m.Text)
+		}
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 announcement, got %q", got)
+	}
+	for _, want := range []string{"SEGUIMIENTO", "Precio actual: 25", "Disco SSD"} {
+		if !strings.Contains(got[0], want) {
+			t.Errorf("%q not found in %q", want, got[0])
+		}
+	}
+}
+
+func TestCheckChat(t *testing.T) {
+	b, tg := newTestBot(t)
+	tg.members = map[string]tgbot.ChatMember{
+		"@left":   {Status: "left"},
+		"@member": {Status: "member"},
+	}
+	ctx := context.Background()
+
+	b.handle(ctx, commandUpdate(testUser, "/chat @member"))
+	b.handle(ctx, commandUpdate(testUser, "/chat @missing"))
+	b.handle(ctx, commandUpdate(testUser, "/search @left/B000000000.es"))
+	b.handle(ctx, commandUpdate(testUser, "/chat @deals,ntfy:topic"))
+	want := []string{
+		"chat id for searchs not updated: bot can't post in @member, make it an admin with permission to post messages",
+		"chat id for searchs not updated: bot can't access @missing: Bad Request: chat not found",
+		"couldn't search @left/B000000000.es: bot isn't a member of @left",
+		"chat id for searchs updated: @deals,ntfy:topic",
+	}
+	if got := tg.messages(testUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAddedToGroup(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	parsed, _ := parseArgs("B000000000.es", "-2")
+	b.add(parsed)
+
+	b.handle(ctx, tgbot.Update{Message: &tgbot.Message{
+		From:           &tgbot.User{ID: testUser},
+		Chat:           &tgbot.Chat{ID: -300, Type: "group", Title: "deals"},
+		NewChatMembers: &[]tgbot.User{{ID: 1}},
+	}})
+	var btns []string
+	for _, m := range tg.sent {
+		if m.ChatID != testUser {
+			continue
+		}
+		markup, ok := m.ReplyMarkup.(tgbot.InlineKeyboardMarkup)
+		if !ok {
+			t.Fatalf("unexpected markup %T", m.ReplyMarkup)
+		}
+		for _, btn := range markup.InlineKeyboard[0] {
+			btns = append(btns, *btn.CallbackData)
+		}
+	}
+	if want := []string{"/chat -300", "/import -300"}; !reflect.DeepEqual(btns, want) {
+		t.Fatalf("got %q, want %q", btns, want)
+	}
+	tg.messages(testUser)
+
+	b.handle(ctx, commandUpdate(testUser, "/import -300"))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "imported 1 searchs from -2 to -300" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	if _, ok := b.searchs.Load("-300/B000000000.es"); !ok {
+		t.Error("search not imported")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	b.handle(ctx, commandUpdate(testUser, "/chat @deals"))
+	b.handle(ctx, commandUpdate(testUser, "/settings emoji drop 🔥"))
+	b.handle(ctx, commandUpdate(testUser, "/settings header Ofertas <top>"))
+	b.handle(ctx, commandUpdate(testUser, "/settings footer Síguenos en @deals"))
+	got := tg.messages(testUser)
+	if want := "settings updated for @deals:\nemoji drop: 🔥\nheader: Ofertas <top>\nfooter: Síguenos en @deals"; got[len(got)-1] != want {
+		t.Fatalf("got %q, want %q", got[len(got)-1], want)
+	}
+
+	item := api.Item{ID: "B000000000", Domain: "es", Title: "Disco", MinPrice: 20, Prices: [5]float64{10}}
+	alert := api.Alert{Kind: api.PriceAlert, Price: 10, Ref: 20}
+	b.notify(Event{Type: PriceDropDetected, Chat: "@deals", Item: &item, Alert: &alert})
+	var text string
+	for _, m := range tg.sent {
+		if m.ChannelUsername == "@deals" {
+			text = m.Text
+		}
+	}
+	if !strings.HasPrefix(text, "Ofertas &lt;top&gt;\n\n<b>🔥 BAJADA DE PRECIO</b>") {
+		t.Errorf("unexpected header in %q", text)
+	}
+	if !strings.HasSuffix(text, "\n\nSíguenos en @deals") || strings.Contains(text, "Más anuncios") {
+		t.Errorf("unexpected footer in %q", text)
+	}
+}
+
+func TestPermalink(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.baseURL = "https://deals.example.com"
+	ctx := context.Background()
+	b.handle(ctx, commandUpdate(testUser, "/settings permalink on"))
+
+	item := api.Item{ID: "B000000000", Domain: "es", Title: "Disco <SSD>", MinPrice: 20}
+	for _, p := range []float64{20, 15, 10} {
+		item.Prices[0] = p
+		b.record(Event{Type: PriceChanged, Item: &item})
+	}
+	alert := api.Alert{Kind: api.PriceAlert, Price: 10, Ref: 20}
+	b.notify(Event{Type: PriceDropDetected, Chat: "-2", Item: &item, Alert: &alert})
+
+	var text string
+	for _, m := range tg.sent {
+		if m.ChannelUsername == "-2" {
+			text = m.Text
+		}
+	}
+	i := strings.Index(text, "📄 https://deals.example.com/d/")
+	if i < 0 {
+		t.Fatalf("permalink not found in %q", text)
+	}
+	code := strings.Fields(text[i:])[1][len("https://deals.example.com/d/"):]
+
+	rec := httptest.NewRecorder()
+	b.dealHandler(rec, httptest.NewRequest("GET", "/d/"+code, nil))
+	page := rec.Body.String()
+	for _, want := range []string{"Disco &lt;SSD&gt;", "10.00€", "<polyline", `href="https://deals.example.com/s/`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("%q not found in page %q", want, page)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	b, _ := newTestBot(t)
+	valid := api.Item{ID: "B000000001", Domain: "es", Title: "SSD", Prices: [5]float64{10}}
+	gone := api.Item{ID: "B000000002", Domain: "es", Title: "HDD", Prices: [5]float64{10}}
+	for _, i := range []api.Item{valid, gone} {
+		i := i
+		b.remember(Event{Item: &i, Alert: &api.Alert{Kind: api.PriceAlert, Price: 10, Ref: 20}})
+	}
+	gone.Prices[0] = 12
+	b.searchs.Store("-2/B000000001.es", valid)
+	b.searchs.Store("-2/B000000002.es", gone)
+	b.refreshFeed()
+
+	rec := httptest.NewRecorder()
+	b.feedHandler(rec, httptest.NewRequest("GET", "/feed.json", nil))
+	var got struct {
+		Deals []feedDeal `json:"deals"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Deals) != 1 || got.Deals[0].ID != "B000000001" || got.Deals[0].Current != 10 {
+		t.Errorf("unexpected deals %+v", got.Deals)
+	}
+	keys, _ := b.db.Keys("feed")
+	if len(keys) != 1 {
+		t.Errorf("expired deals not removed: %v", keys)
+	}
+}
+
+func TestWebLoginRoutes(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.login = newWebLogin(b, "amazbot", "123:token")
+	h := b.httpHandler("")
+	tests := map[string]int{
+		"/metrics":     http.StatusFound,
+		"/feed.json":   http.StatusFound,
+		"/sitemap.xml": http.StatusFound,
+		"/d/unknown":   http.StatusNotFound,
+		"/s/unknown":   http.StatusNotFound,
+	}
+	for path, want := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestWebLogin(t *testing.T) {
+	b, _ := newTestBot(t)
+	l := newWebLogin(b, "amazbot", "123:token")
+	mux := http.NewServeMux()
+	private := http.NewServeMux()
+	(&viewer{db: b.db, allow: l.allowed}).register(private)
+	mux.Handle("/", l.require(private))
+	l.register(mux)
+	for _, k := range []string{"-1/B000000001.es", "-2/B000000002.es"} {
+		if err := b.db.Put("db", k, api.Item{ID: k[3:13], Domain: "es"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Login data signed as the telegram widget does
+	login := url.Values{
+		"id":         {strconv.Itoa(testUser)},
+		"first_name": {"user"},
+		"auth_date":  {strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	var pairs []string
+	for k, v := range login {
+		pairs = append(pairs, k+"="+v[0])
+	}
+	sort.Strings(pairs)
+	secret := sha256.Sum256([]byte("123:token"))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	login.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/items", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status without session %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/auth?"+strings.Replace(login.Encode(), "hash=", "hash=0", 1), nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status with invalid hash %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/auth?"+login.Encode(), nil))
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusFound || len(cookies) != 1 {
+		t.Fatalf("login failed %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/items", nil)
+	req.AddCookie(cookies[0])
+	mux.ServeHTTP(rec, req)
+	var items []viewItem
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	if len(items) != 1 || items[0].Item.ID != "B000000002" {
+		t.Errorf("unexpected items %+v", items)
+	}
+}
+
+func TestOpsWebhook(t *testing.T) {
+	b, _ := newTestBot(t)
+	events := make(chan Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+	}))
+	defer srv.Close()
+	b.bus.Subscribe(b.webhook(context.Background(), srv.URL), CircuitOpened, CircuitClosed, CaptchaFailed, StoreFailed)
+
+	parsed, _ := parseArgs("B000000000.es", "-2")
+	for i := 0; i < circuitFailures+2; i++ {
+		b.scraped(parsed, time.Second, errors.New("api: 503 Service Unavailable"))
+	}
+	b.scraped(parsed, time.Second, nil)
+	for i := 0; i < 2; i++ {
+		b.log(fmt.Errorf("%w: timeout", api.ErrCaptcha))
+	}
+	b.wg.Wait()
+	close(events)
+
+	var got []string
+	for e := range events {
+		got = append(got, e.Type+" "+e.Domain)
+	}
+	sort.Strings(got)
+	want := []string{"captcha_failed ", "circuit_closed es", "circuit_opened es"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReload(t *testing.T) {
+	b, _ := newTestBot(t)
+	client, err := api.New(context.Background(), "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.client = client
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plain.tmpl")
+	b.load = func() (*Config, error) {
+		return &Config{
+			Templates: dir,
+			Users:     []int{300},
+			Operators: []int{400},
+			Interval:  time.Minute,
+		}, nil
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{{define "title"}}{{.Item.Title}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.handle(context.Background(), commandUpdate(testAdmin, "/reload"))
+	if _, ok := b.userChat(300); ok {
+		t.Fatal("configuration applied with invalid templates")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{{define "title"}}reloaded{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.handle(context.Background(), commandUpdate(testUser, "/reload"))
+	if _, ok := b.userChat(300); ok {
+		t.Fatal("configuration reloaded by a user")
+	}
+	b.handle(context.Background(), commandUpdate(testAdmin, "/reload"))
+
+	for _, u := range []int{testAdmin, 300, 400} {
+		if _, ok := b.userChat(u); !ok {
+			t.Errorf("user %d not allowed after reload", u)
+		}
+	}
+	if _, ok := b.userChat(testUser); ok {
+		t.Errorf("user %d still allowed after reload", testUser)
+	}
+	if chat, _ := b.userChat(testAdmin); chat != "-1" {
+		t.Errorf("admin chat lost on reload: %s", chat)
+	}
+	if !b.operators[400] {
+		t.Error("operator not reloaded")
+	}
+	if got := b.cycleInterval(); got != time.Minute {
+		t.Errorf("interval not reloaded: %s", got)
+	}
+	title, _, err := b.render("plain", alertData{Item: api.Item{Title: "foo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "reloaded" {
+		t.Errorf("templates not reloaded, got title %q", title)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, spec := range []string{"", "08:00", "25:00-26:00", "08:00-09:60", "08:00-09:00@Foo/Bar"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+
+	b, _ := newTestBot(t)
+	b.schedules, err = parseSchedules(map[string]string{
+		"co.jp": "00:00-08:00,22:00-24:00@Asia/Tokyo",
+		"*":     "20:00-06:00@UTC",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(h, m int) time.Time { return time.Date(2021, 5, 1, h, m, 0, 0, tokyo) }
+	tests := []struct {
+		domain string
+		t      time.Time
+		want   bool
+	}{
+		{"co.jp", day(7, 59), true},
+		{"co.jp", day(8, 0), false},
+		{"co.jp", day(22, 30), false}, // 13:30 UTC
+		{"es", day(5, 0), true},       // 20:00 UTC
+		{"es", day(15, 0), false},     // 06:00 UTC, the end is excluded
+		{"es", day(14, 59), true},
+		{"es", day(16, 0), false},
+	}
+	for _, tt := range tests {
+		if got := b.scheduled(tt.domain, tt.t); got != tt.want {
+			t.Errorf("%s at %s: got %v, want %v", tt.domain, tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestSearchNormalize(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	b.handle(ctx, commandUpdate(testUser, "/search b000000000.ES?2"))
+	b.handle(ctx, commandUpdate(testUser, "/search B0000.es"))
+	b.handle(ctx, tgbot.Update{Message: &tgbot.Message{
+		Chat: &tgbot.Chat{ID: testUser, Type: "private"},
+		Text: "https://www.amazon.es/dp/B0000/ref=x",
+	}})
+	got := tg.messages(testUser)
+	if len(got) != 3 || got[0] != "searching -2/B000000000.es?2" ||
+		!strings.Contains(got[1], "invalid asin") || !strings.Contains(got[2], "invalid asin") {
+		t.Errorf("unexpected messages %q", got)
+	}
+	if _, ok := b.searchs.Load("-2/B000000000.es?2"); !ok {
+		t.Error("normalized search not stored")
+	}
+
+	// Unregistered users get no replies to the links they paste
+	b.handle(ctx, tgbot.Update{Message: &tgbot.Message{
+		Chat: &tgbot.Chat{ID: 999, Type: "private"},
+		Text: "https://www.amazon.es/dp/B0000/ref=x",
+	}})
+	if got := tg.messages(999); len(got) != 0 {
+		t.Errorf("unexpected messages to unregistered user %q", got)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	b.handle(ctx, commandUpdate(testUser, "/defaults domain .DE"))
+	b.handle(ctx, commandUpdate(testUser, "/defaults threshold 15%"))
+	b.handle(ctx, commandUpdate(testUser, "/defaults condition 9"))
+	tg.messages(testUser)
+
+	b.handle(ctx, commandUpdate(testUser, "/search B000000000"))
+	b.handle(ctx, commandUpdate(testUser, "/search B000000001.es?0&drop=5"))
+	b.handle(ctx, commandUpdate(testUser, "/defaults condition 2"))
+	tg.messages(testUser)
+	b.handle(ctx, tgbot.Update{Message: &tgbot.Message{
+		Chat: &tgbot.Chat{ID: testUser, Type: "private"},
+		Text: "https://www.amazon.it/dp/B000000002",
+	}})
+	b.handle(ctx, commandUpdate(testAdmin, "/search B000000003"))
+	for _, k := range []string{"-2/B000000000.de?drop=15", "-2/B000000001.es?0&drop=5", "-2/B000000002.it?2&drop=15"} {
+		if _, ok := b.searchs.Load(k); !ok {
+			t.Errorf("search %s not found", k)
+		}
+	}
+	if got := tg.messages(testAdmin); len(got) != 1 || !strings.Contains(got[0], "expected ASIN.domain") {
+		t.Errorf("defaults of another user applied: %q", got)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/defaults"))
+	want := "domain: de\ncondition: Very good (2)\nthreshold: 15%\nchat: -2"
+	if got := tg.messages(testUser); len(got) != 2 || got[1] != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestConversation(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	send := func(text string) {
+		b.handle(ctx, tgbot.Update{Message: &tgbot.Message{
+			Chat: &tgbot.Chat{ID: testUser, Type: "private"},
+			Text: text,
+		}})
+	}
+	click := func(data string) {
+		b.handle(ctx, tgbot.Update{CallbackQuery: &tgbot.CallbackQuery{
+			ID:   "cb",
+			From: &tgbot.User{ID: testUser},
+			Data: data,
+		}})
+	}
+	buttons := func() []tgbot.InlineKeyboardButton {
+		tg.lock.Lock()
+		defer tg.lock.Unlock()
+		markup, _ := tg.sent[len(tg.sent)-1].ReplyMarkup.(tgbot.InlineKeyboardMarkup)
+		tg.sent = nil
+		if len(markup.InlineKeyboard) == 0 {
+			return nil
+		}
+		return markup.InlineKeyboard[0]
+	}
+
+	send("https://www.amazon.es/dp/B000000000")
+	btns := buttons()
+	if len(btns) != 6 || btns[5].Text != "Any condition" || *btns[2].CallbackData != "/answer 2" {
+		t.Fatalf("unexpected condition buttons %+v", btns)
+	}
+	click(*btns[2].CallbackData)
+	if btns = buttons(); len(btns) != 1 || *btns[0].CallbackData != "/answer no" {
+		t.Fatalf("unexpected target buttons %+v", btns)
+	}
+	send("doce")
+	if got := tg.messages(testUser); len(got) != 1 || !strings.HasPrefix(got[0], "invalid target price") {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	send("12,50 €")
+	if btns = buttons(); len(btns) != 2 || *btns[0].CallbackData != "/answer -2" {
+		t.Fatalf("unexpected chat buttons %+v", btns)
+	}
+	click(*btns[0].CallbackData)
+	if btns = buttons(); len(btns) != 2 || btns[0].Text != "Confirm" {
+		t.Fatalf("unexpected confirm buttons %+v", btns)
+	}
+	send("yes")
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "searching -2/B000000000.es?2&target=12.5" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	if _, ok := b.conversation(testUser); ok {
+		t.Error("conversation not finished")
+	}
+
+	// Cancel and expired conversations
+	b.handle(ctx, commandUpdate(testUser, "/quickadd B000000001.es?1"))
+	if c, ok := b.conversation(testUser); !ok || c.Step != stepTarget {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+	b.handle(ctx, commandUpdate(testUser, "/cancel"))
+	send("no")
+	b.handle(ctx, commandUpdate(testUser, "/answer no"))
+	if got := tg.messages(testUser); len(got) != 3 || got[1] != "cancelled" || got[2] != "nothing to answer, paste a link to start again" {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestNote(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	b.bus.Subscribe(b.forget, SearchStopped)
+
+	b.handle(ctx, commandUpdate(testUser, "/search B000000000.es?2"))
+	b.handle(ctx, commandUpdate(testUser, "/note B000000001.es gift"))
+	b.handle(ctx, commandUpdate(testUser, "/note B000000000.es birthday gift for Ana"))
+	got := tg.messages(testUser)
+	want := []string{"searching -2/B000000000.es?2", "search -2/B000000001.es not found", "note added to -2/B000000000.es?2"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/status"))
+	if got := tg.messages(testUser); len(got) != 2 || !strings.Contains(got[1], "\n📝 birthday gift for Ana\n") {
+		t.Errorf("unexpected status %q", got)
+	}
+	_, text, err := b.render("plain", alertData{Item: api.Item{Title: "foo"}, Note: b.note("-2/B000000000.es?2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "foo\n📝 birthday gift for Ana\n") {
+		t.Errorf("note not in alert %q", text)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/stop B000000000.es?2"))
+	if note := b.note("-2/B000000000.es?2"); note != "" {
+		t.Errorf("note not removed: %q", note)
+	}
+}
+
+func TestStatusSort(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	b.searchs.Store("-2/B000000001.es", api.Item{ID: "B000000001", Domain: "es", Title: "Kindle", MinPrice: 100, Prices: [5]float64{90}})
+	b.searchs.Store("-2/B000000002.de", api.Item{ID: "B000000002", Domain: "de", Title: "Echo dot", MinPrice: 20, Prices: [5]float64{30, 25}})
+	b.searchs.Store("-2/B000000003.com", api.Item{ID: "B000000003", Domain: "com", Title: "Kindle case", MinPrice: 10, Prices: [5]float64{10}})
+	b.searchs.Store("-1/B000000004.es", nil)
+	if err := b.db.Put("history", historyKey("B000000002", "de"), []pricePoint{{Time: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(args string) string {
+		b.handle(ctx, commandUpdate(testUser, "/status "+args))
+		var keys []string
+		for _, m := range tg.messages(testUser)[1:] {
+			keys = append(keys, strings.Fields(m)[0])
+		}
+		return strings.Join(keys, " ")
+	}
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"", "B000000001.es B000000002.de B000000003.com"},
+		{"by price", "B000000003.com B000000002.de B000000001.es"},
+		{"by discount", "B000000001.es B000000003.com B000000002.de"},
+		{"by change", "B000000002.de B000000001.es B000000003.com"},
+		{"by domain", "B000000003.com B000000002.de B000000001.es"},
+		{"by price kindle", "B000000003.com B000000001.es"},
+		{"* b000000004", "B000000004.es"},
+	}
+	for _, tt := range tests {
+		if got := keys(tt.args); got != tt.want {
+			t.Errorf("/status %s: got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/status by name"))
+	b.handle(ctx, commandUpdate(testUser, "/status ipad"))
+	got := tg.messages(testUser)
+	if len(got) != 2 || !strings.HasPrefix(got[0], "invalid sort name") || got[1] != "no searchs match ipad" {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestStatusGroups(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	item := api.Item{ID: "B000000001", Domain: "es", Title: "Kindle", MinPrice: 100, Prices: [5]float64{90}}
+	b.searchs.Store("-1/B000000001.es", item)
+	b.searchs.Store("-2/B000000001.es?2", item)
+	b.searchs.Store("-2/B000000001.es", nil)
+	b.searchs.Store("-2/B000000002.de", nil)
+	parsed, _ := parseArgs("B000000001.es?2", "-2")
+	b.scraped(parsed, time.Second, errors.New("api: 503 Service Unavailable"))
+	b.scraped(parsed, time.Second, errors.New("api: 503 Service Unavailable"))
+
+	b.handle(ctx, commandUpdate(testAdmin, "/status *"))
+	got := tg.messages(testAdmin)
+	want := []string{
+		"status info:",
+		"B000000001.es Kindle\nchats: -1, -2, -2 (?2)\n⚠️ duplicated in -2\n❌ errors: 2, failing: 1/3\nmin:100.00€, price:90.00€",
+		"B000000002.de\nchats: -2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTitleChanged(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.bus.Subscribe(b.renamed, TitleChanged)
+
+	kindle := "Kindle Paperwhite 8GB, pantalla de 6,8\" y luz cálida ajustable, negro"
+	publish := func(title string) {
+		b.bus.Publish(Event{Type: TitleChanged, Search: "-2/B000000000.es", Chat: "-2", Title: kindle, Item: &api.Item{Title: title, Link: "https://www.amazon.es/dp/B000000000"}})
+	}
+	publish("Kindle Paperwhite 16GB, pantalla de 7\" y luz cálida ajustable, negro")
+	if got := tg.messages(testUser); len(got) != 0 {
+		t.Errorf("unexpected messages %q", got)
+	}
+	publish("Funda de silicona para iPhone 15, azul")
+	if got := tg.messages(testUser); len(got) != 1 || !strings.HasPrefix(got[0], "⚠️ possible listing hijack of -2/B000000000.es\nbefore: Kindle") {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestListingChanged(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.bus.Subscribe(b.hijacked, ListingChanged)
+	b.searchs.Store("-2/B000000000.es", nil)
+	b.searchs.Store("-1/B000000000.es?1", nil)
+	parsed, _ := parseArgs("B000000000.es", "-2")
+
+	item := func(category, brand, seller string) api.Item {
+		return api.Item{ID: "B000000000", Domain: "es", Category: []string{category}, Brand: brand, Sellers: [5]string{seller}}
+	}
+	for i := 0; i < minSellerScrapes; i++ {
+		b.inspect(parsed, item("Electrónica", "Amazon", "Amazon.es"))
+	}
+	b.inspect(parsed, item("Electrónica", "Amazon", "Tienda X"))
+	b.inspect(parsed, item("Hogar", "Amazon", "Amazon.es"))
+	if got := append(tg.messages(testUser), tg.messages(testAdmin)...); len(got) != 0 {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	b.inspect(parsed, item("Jardín", "Acme", "Tienda Y"))
+	want := "🚨 listing of B000000000.es changed\ncategory: Hogar → Jardín\nbrand: Amazon → Acme\nseller: Amazon.es → Tienda Y\n"
+	for _, u := range []int64{testUser, testAdmin} {
+		if got := tg.messages(u); len(got) != 1 || got[0] != want {
+			t.Errorf("user %d: got %q, want %q", u, got, want)
+		}
+	}
+}
+
+func TestFakeDiscount(t *testing.T) {
+	now := time.Now()
+	point := func(days int, price float64) pricePoint {
+		return pricePoint{Time: now.Add(-time.Duration(days) * 24 * time.Hour), Prices: [5]float64{price}}
+	}
+	inflated := []pricePoint{point(40, 52), point(20, 50), point(3, 70)}
+	tests := []struct {
+		points []pricePoint
+		price  float64
+		want   bool
+	}{
+		{inflated, 55, true},
+		{inflated, 50, true},
+		{inflated, 45, false},
+		{[]pricePoint{point(20, 50), point(3, 52)}, 50, false},
+		{[]pricePoint{point(3, 50), point(2, 70)}, 55, false},
+		{nil, 55, false},
+	}
+	for i, tt := range tests {
+		if got := fakeDiscount(tt.points, 0, tt.price, now); got != tt.want {
+			t.Errorf("test %d: got %v, want %v", i, got, tt.want)
+		}
+	}
+
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	item := api.Item{ID: "B000000000", Domain: "es", Title: "Disco", MinPrice: 50, Prices: [5]float64{55}}
+	if err := b.db.Put("history", historyKey(item.ID, item.Domain), inflated); err != nil {
+		t.Fatal(err)
+	}
+	alert := api.Alert{Kind: api.PriceAlert, Price: 55, Ref: 70}
+	notify := func(search string) string {
+		b.notify(Event{Type: PriceDropDetected, Search: search, Chat: "-2", Item: &item, Alert: &alert})
+		tg.lock.Lock()
+		defer tg.lock.Unlock()
+		var text string
+		for _, m := range tg.sent {
+			if m.ChannelUsername == "-2" {
+				text = m.Text
+			}
+		}
+		tg.sent = nil
+		return text
+	}
+	b.handle(ctx, commandUpdate(testUser, "/settings fakes annotate"))
+	if text := notify("a"); !strings.Contains(text, "⚠️ Descuento dudoso") {
+		t.Errorf("fake discount not annotated in %q", text)
+	}
+	b.cache.Flush()
+	b.handle(ctx, commandUpdate(testUser, "/settings fakes suppress"))
+	if text := notify("b"); text != "" {
+		t.Errorf("fake discount not suppressed %q", text)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	b.handle(ctx, commandUpdate(testUser, "/settings cooldown used 48h"))
+	b.handle(ctx, commandUpdate(testUser, "/settings cooldown 4 off"))
+	got := tg.messages(testUser)
+	want := "settings updated for -2:\ncooldown Like new: 48h0m0s\ncooldown Very good: 48h0m0s\ncooldown Good: 48h0m0s"
+	if len(got) != 2 || got[1] != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	item := api.Item{ID: "B000000000", Domain: "es", Title: "Disco", MinPrice: 20}
+	sent := 0
+	for _, a := range []api.Alert{
+		{Kind: api.PriceAlert, State: 1, Price: 10},
+		{Kind: api.PriceAlert, State: 1, Price: 9},
+		{Kind: api.PriceAlert, State: 2, Price: 9},
+		{Kind: api.PriceAlert, State: 0, Price: 15},
+		{Kind: api.PriceAlert, State: 0, Price: 14},
+	} {
+		a := a
+		b.notify(Event{Type: PriceDropDetected, Search: "-2/B000000000.es", Chat: "-2", Item: &item, Alert: &a})
+	}
+	tg.lock.Lock()
+	for _, m := range tg.sent {
+		if m.ChannelUsername == "-2" {
+			sent++
+		}
+	}
+	tg.lock.Unlock()
+	if sent != 4 {
+		t.Errorf("got %d alerts, want 4", sent)
+	}
+}
+
+func TestUsedAppeared(t *testing.T) {
+	b, _ := newTestBot(t)
+	item := api.Item{ID: "B000000000", Domain: "es", Title: "Disco", MinPrice: 20, Prices: [5]float64{20, 0, 25}}
+	_, text, err := b.render("plain", newAlertData(item, api.Alert{Kind: api.UsedAlert, State: 2, Price: 25}, "-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(text, "🆕 OFERTA USADA DISPONIBLE\n\nDisco\n\n✅ Precio: 25.00€\n🚫 Nuevo: 20.00€\n🎁 Estado: Muy bueno") {
+		t.Errorf("unexpected text %q", text)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	b.bus.Subscribe(b.expire, PriceChanged)
+
+	item := api.Item{ID: "B000000000", Domain: "es", Title: "Disco", MinPrice: 20, Prices: [5]float64{10}}
+	alert := api.Alert{Kind: api.PriceAlert, Price: 10, Ref: 20}
+	changed := func(price float64) {
+		i := item
+		i.Prices = [5]float64{price}
+		b.bus.Publish(Event{Type: PriceChanged, Search: "-2/B000000000.es", Chat: "-2", Item: &i})
+	}
+	b.handle(ctx, commandUpdate(testUser, "/settings expired edit"))
+	b.notify(Event{Type: PriceDropDetected, Search: "-2/B000000000.es", Chat: "-2", Item: &item, Alert: &alert})
+	changed(9)
+	changed(12)
+	changed(8)
+	tg.lock.Lock()
+	edits := tg.edits
+	tg.lock.Unlock()
+	if len(edits) != 1 || edits[0].ChannelUsername != "-2" || edits[0].MessageID != 2 || !strings.HasPrefix(edits[0].Text, "⌛ <b>OFERTA CADUCADA</b>\n\n<b>⚡️ BAJADA DE PRECIO</b>") {
+		t.Fatalf("unexpected edits %+v", edits)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/settings expired notify"))
+	b.cache.Flush()
+	b.notify(Event{Type: PriceDropDetected, Search: "-2/B000000000.es", Chat: "-2", Item: &item, Alert: &alert})
+	changed(0)
+	tg.lock.Lock()
+	last := tg.sent[len(tg.sent)-1]
+	tg.lock.Unlock()
+	if last.Text != "⌛ Oferta caducada: Disco" || last.ReplyToMessageID != 4 {
+		t.Errorf("unexpected message %+v", last)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	for n, score := range []float64{10, 40, 25} {
+		item := api.Item{ID: fmt.Sprintf("B00000000%d", n), Domain: "es", Title: fmt.Sprintf("Deal <%d>", n), MinPrice: 100}
+		alert := api.Alert{Kind: api.PriceAlert, Price: 100 - score}
+		b.tally("@deals", item, alert, score)
+		b.tally("200", item, alert, score)
+	}
+	now := time.Now().UTC()
+	clicks := dayStats{Clicks: 5, Deals: map[string]dealStat{"B000000000": {Title: "Deal <0>", Clicks: 4}, "B000000002": {Title: "Deal <2>", Clicks: 1}}}
+	if err := b.db.Put("stats", dayKey("@deals", now), clicks); err != nil {
+		t.Fatal(err)
+	}
+
+	// Post on the next leaderboard day
+	next := now.AddDate(0, 0, 1)
+	for next.Weekday() != leaderboardDay {
+		next = next.AddDate(0, 0, 1)
+	}
+	next = time.Date(next.Year(), next.Month(), next.Day(), leaderboardHour, 0, 0, 0, time.UTC)
+	b.postLeaderboards(ctx, next.Add(-time.Hour))
+	b.postLeaderboards(ctx, next)
+	b.postLeaderboards(ctx, next.Add(time.Hour))
+	tg.lock.Lock()
+	sent := tg.sent
+	tg.lock.Unlock()
+	want := "🏆 <b>RESUMEN SEMANAL</b>\n\n📦 3 ofertas publicadas\n\n🔥 <b>Mayores descuentos</b>\n" +
+		"1. -40% · 60.00€ · Deal &lt;1&gt;\n2. -25% · 75.00€ · Deal &lt;2&gt;\n3. -10% · 90.00€ · Deal &lt;0&gt;\n\n" +
+		"👆 <b>Más clicadas</b>\n1. 4 clics · Deal &lt;0&gt;\n2. 1 clics · Deal &lt;2&gt;"
+	if len(sent) != 1 || sent[0].ChannelUsername != "@deals" || sent[0].Text != want {
+		t.Errorf("unexpected leaderboards %+v", sent)
+	}
+}
+
+func TestMaster(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	b.handle(ctx, commandUpdate(testUser, "/master @firehose"))
+	b.handle(ctx, commandUpdate(testAdmin, "/master @firehose"))
+	if got := tg.messages(testAdmin); len(got) != 1 || got[0] != "master channel updated: @firehose" {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	if b.master() != "@firehose" {
+		t.Fatal("master channel not set by the admin")
+	}
+
+	item := api.Item{ID: "B000000000", Domain: "es", Title: "Disco", MinPrice: 20, Prices: [5]float64{10}}
+	alert := api.Alert{Kind: api.PriceAlert, Price: 10, Ref: 20}
+	e := Event{Type: PriceDropDetected, Search: "-2,@deals/B000000000.es", Chat: "-2,@deals", Item: &item, Alert: &alert}
+	b.notify(e)
+	b.notify(e)
+	var texts []string
+	tg.lock.Lock()
+	for _, m := range tg.sent {
+		if m.ChannelUsername == "@firehose" {
+			texts = append(texts, m.Text)
+		}
+	}
+	tg.lock.Unlock()
+	if len(texts) != 1 || !strings.HasPrefix(texts[0], "📡 -2, @deals\n\n<b>⚡️ BAJADA DE PRECIO</b>") {
+		t.Errorf("unexpected master alerts %q", texts)
+	}
+}
+
+func TestWatchlist(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	searchs := func() []string {
+		var ids []string
+		b.searchs.Range(func(k, _ interface{}) bool {
+			ids = append(ids, k.(string))
+			return true
+		})
+		sort.Strings(ids)
+		b.searchs = sync.Map{}
+		return ids
+	}
+
+	// camelcamelcamel export pasted
+	camel := "/watchlist \n" +
+		"Product Name,ASIN,Locale,Desired Price (Amazon),Desired Price (3rd Party New),Desired Price (3rd Party Used)\n" +
+		"\"Disco SSD, 1TB\",B000000001,es,\"89,99 €\",85.50,\n" +
+		"Raton,B000000002,uk,\"£1,234.00\",,20\n" +
+		"Broken,XX,es,10,,\n"
+	b.handle(ctx, commandUpdate(testUser, camel))
+	want := []string{"-2/B000000001.es?0&target=85.5", "-2/B000000002.co.uk?0&target=1234", "-2/B000000002.co.uk?4&target=20"}
+	if got := searchs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "imported 3 searchs to -2, 1 rows skipped" {
+		t.Errorf("unexpected messages %q", got)
+	}
+
+	// keepa export uploaded to another chat
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ASIN;Domain;Title;Desired price\nB000000003;3;Kopfhörer;49,90\n")
+	}))
+	defer srv.Close()
+	tg.files = map[string]string{"file": srv.URL}
+	b.handle(ctx, tgbot.Update{Message: &tgbot.Message{
+		Chat:     &tgbot.Chat{ID: testUser, Type: "private"},
+		Caption:  "/watchlist @deals",
+		Document: &tgbot.Document{FileID: "file"},
+	}})
+	want = []string{"@deals/B000000003.de?target=49.9"}
+	if got := searchs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "imported 1 searchs to @deals" {
+		t.Errorf("unexpected messages %q", got)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/watchlist \nTitle,Price\nfoo,10"))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "couldn't import watchlist: asin column not found" {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestTrack(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.baseURL = "https://deals.example.com"
+	ctx := context.Background()
+
+	b.handle(ctx, commandUpdate(testUser, "/token"))
+	got := tg.messages(testUser)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "🔑 token: ") {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	token := strings.Fields(got[0])[2]
+
+	track := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/track", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		b.trackHandler(rec, req)
+		return rec
+	}
+	body := url.Values{"link": {"https://www.amazon.es/dp/B000000001?th=1"}, "threshold": {"20"}}.Encode()
+	rec := track(token, body)
+	if rec.Code != http.StatusCreated || rec.Body.String() != `{"id":"-2/B000000001.es?drop=20","created":true}`+"\n" {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+	if _, ok := b.searchs.Load("-2/B000000001.es?drop=20"); !ok {
+		t.Error("search not added")
+	}
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "searching -2/B000000001.es?drop=20" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	if rec := track(token, body); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"created":false`) {
+		t.Errorf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := track(token, "link=foo"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid link accepted: %d", rec.Code)
+	}
+	if rec := track("", "token="+token+"&link=https://www.amazon.de/dp/B000000002"); rec.Code != http.StatusCreated {
+		t.Errorf("form token rejected: %d", rec.Code)
+	}
+	if rec := track("wrong", body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token accepted: %d", rec.Code)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/token off"))
+	if rec := track(token, body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token accepted: %d", rec.Code)
+	}
+}
+
+func TestInbox(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.inboxDomain = "deals.example.com"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		b.wg.Wait()
+	}()
+
+	b.handle(ctx, commandUpdate(testUser, "/token"))
+	got := tg.messages(testUser)
+	if len(got) != 1 || !strings.Contains(got[0], "@deals.example.com") {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	token := strings.Fields(got[0])[2]
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	if err := b.serveInbox(ctx, addr); err != nil {
+		t.Fatal(err)
+	}
+	email := strings.Join([]string{
+		"From: Amazon.es <pedidos@amazon.es>",
+		"Subject: =?UTF-8?Q?Confirmaci=C3=B3n_de_pedido?=",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/alternative; boundary="b"`,
+		"",
+		"--b",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"Auriculares https://www.amazon.de/gp/product/B000000002",
+		"--b",
+		"Content-Type: text/html; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		`<a href=3D"https://www.amazon.es/gp/r.html?C=3D1&amp;U=3Dhttps%3A%2F%2Fwww.a=`,
+		`mazon.es%2Fdp%2FB000000001%3Fref%3Dpe">Disco</a>`,
+		"--b--",
+		"",
+	}, "\r\n")
+	if err := smtp.SendMail(addr, nil, "user@example.com", []string{"nobody@deals.example.com"}, []byte(email)); err == nil {
+		t.Error("unknown address accepted")
+	}
+	if err := smtp.SendMail(addr, nil, "user@example.com", []string{token + "@deals.example.com"}, []byte(email)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"📧 searching from email \"Confirmación de pedido\":\n-2/B000000002.de\n-2/B000000001.es"}
+	if got := tg.messages(testUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	for _, id := range []string{"-2/B000000001.es", "-2/B000000002.de"} {
+		if _, ok := b.searchs.Load(id); !ok {
+			t.Errorf("search %s not added", id)
+		}
+	}
+}
+
+func TestProtect(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	b.bus.Subscribe(b.notify, PriceDropDetected)
+	b.bus.Subscribe(b.protect, PriceChanged)
+
+	b.handle(ctx, commandUpdate(testUser, "/bought B000000001.es 100"))
+	until := time.Now().UTC().Truncate(24 * time.Hour).Add(returnWindow).Format("2006-01-02")
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != fmt.Sprintf("🛡️ price protection of B000000001.es until %s, paid 100.00€", until) {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	search := "200=protect/B000000001.es?0"
+	if _, ok := b.searchs.Load(search); !ok {
+		t.Fatalf("search %s not added", search)
+	}
+
+	item := api.Item{ID: "B000000001", Domain: "es", Title: "Disco", Link: "https://www.amazon.es/dp/B000000001", MinPrice: 100}
+	changed := func(price float64) {
+		i := item
+		i.Prices = [5]float64{price}
+		a := api.Alert{Kind: api.PriceAlert, Price: price, Ref: 100}
+		b.bus.Publish(Event{Type: PriceDropDetected, Search: search, Chat: "200=protect", Item: &i, Alert: &a})
+		b.bus.Publish(Event{Type: PriceChanged, Search: search, Chat: "200=protect", Item: &i})
+	}
+	changed(90)
+	changed(95)
+	changed(80)
+	got := tg.messages(testUser)
+	if len(got) != 2 || !strings.Contains(got[0], "Reembolso posible: <b>10.00€</b> (10%)") || !strings.Contains(got[1], "Reembolso posible: <b>20.00€</b> (20%)") {
+		t.Fatalf("unexpected messages %q", got)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/bought"))
+	if got := tg.messages(testUser); len(got) != 1 || !strings.HasPrefix(got[0], "🛡️ price protection:\nB000000001.es paid 100.00€, ") || !strings.HasSuffix(got[0], "refund 20.00€") {
+		t.Errorf("unexpected messages %q", got)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/bought B000000002.es 50 2020-01-01"))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "the return window of B000000002.es already ended" {
+		t.Errorf("unexpected messages %q", got)
+	}
+
+	b.expirePurchases(time.Now().Add(returnWindow + 24*time.Hour))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "⌛ return window of B000000001.es ended, price protection stopped" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	if _, ok := b.searchs.Load(search); ok {
+		t.Error("search not stopped")
+	}
+	if ps := b.purchases(testUser); len(ps) != 0 {
+		t.Errorf("purchases not removed %+v", ps)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	click := func(data string) {
+		b.handle(ctx, tgbot.Update{CallbackQuery: &tgbot.CallbackQuery{
+			ID:   "1",
+			From: &tgbot.User{ID: testUser},
+			Data: data,
+		}})
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/settings bought on"))
+	tg.messages(testUser)
+	item := api.Item{ID: "B000000001", Domain: "es", Title: "Disco", MinPrice: 100, Prices: [5]float64{60}}
+	alert := api.Alert{Kind: api.PriceAlert, Price: 60, Ref: 100}
+	b.notify(Event{Type: PriceDropDetected, Search: "-2/B000000001.es", Chat: "-2", Item: &item, Alert: &alert})
+	tg.lock.Lock()
+	markup, _ := tg.sent[len(tg.sent)-1].ReplyMarkup.(tgbot.InlineKeyboardMarkup)
+	tg.lock.Unlock()
+	if len(markup.InlineKeyboard) != 1 || *markup.InlineKeyboard[0][0].CallbackData != "/bought B000000001.es 60.00" {
+		t.Fatalf("unexpected markup %+v", markup)
+	}
+
+	month := time.Now().UTC().Format("2006-01")
+	b.handle(ctx, commandUpdate(testUser, "/budget 100"))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != fmt.Sprintf("💸 spent 0.00 in %s, budget 100.00, left 100.00", month) {
+		t.Errorf("unexpected messages %q", got)
+	}
+	click("/bought B000000001.es 60.00")
+	click("/bought B000000001.es 60.00")
+	if got := tg.messages(testUser); len(got) != 2 {
+		t.Errorf("unexpected messages %q", got)
+	}
+	b.handle(ctx, commandUpdate(testUser, "/bought B000000002.es 50"))
+	got := tg.messages(testUser)
+	if len(got) != 2 || got[1] != fmt.Sprintf("⚠️ monthly budget exceeded: spent 110.00 of 100.00 in %s", month) {
+		t.Errorf("unexpected messages %q", got)
+	}
+
+	b.handle(ctx, commandUpdate(testUser, "/bought B000000002.es off"))
+	b.handle(ctx, commandUpdate(testUser, "/budget"))
+	got = tg.messages(testUser)
+	if len(got) != 2 || !strings.HasPrefix(got[1], fmt.Sprintf("💸 spent 60.00 in %s, budget 100.00, left 40.00\n", month)) {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestAutoBuy(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	orders := make(chan order, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var o order
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			t.Error(err)
+		}
+		orders <- o
+	}))
+	defer srv.Close()
+	b.autoBuy = srv.URL
+
+	b.handle(ctx, commandUpdate(testUser, "/autobuy B000000001.es 50 amazon"))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "🛒 auto-buy rule added: B000000001.es ≤ 50.00€, New, sold by amazon, you will be asked to confirm each checkout" {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	if _, ok := b.searchs.Load("-2/B000000001.es?0"); !ok {
+		t.Error("search not added")
+	}
+
+	alert := func(price float64, seller string) {
+		i := api.Item{ID: "B000000001", Domain: "es", Title: "Disco", Link: "https://www.amazon.es/dp/B000000001", Prices: [5]float64{price}, Sellers: [5]string{seller}}
+		a := api.Alert{Kind: api.PriceAlert, Price: price, Ref: 60}
+		b.autobuy(Event{Search: "-2/B000000001.es?0", Chat: "-2", Item: &i, Alert: &a})
+	}
+	alert(55, "Amazon.es")
+	alert(45, "Tienda X")
+	if got := tg.messages(testUser); len(got) != 0 {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	alert(45, "Amazon.es")
+	alert(45, "Amazon.es")
+	tg.lock.Lock()
+	sent := tg.sent[len(tg.sent)-1]
+	tg.lock.Unlock()
+	if got := tg.messages(testUser); len(got) != 1 || !strings.HasPrefix(got[0], "🛒 <b>COMPRA AUTOMÁTICA</b>\n\nDisco\n\n💶 45.00€ (máx. 50.00€)") {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	markup := sent.ReplyMarkup.(tgbot.InlineKeyboardMarkup)
+	confirm := *markup.InlineKeyboard[0][0].CallbackData
+
+	click := func(data string) {
+		b.handle(ctx, tgbot.Update{CallbackQuery: &tgbot.CallbackQuery{ID: "1", From: &tgbot.User{ID: testUser}, Data: data}})
+		b.wg.Wait()
+	}
+	click(confirm)
+	select {
+	case o := <-orders:
+		if o.ASIN != "B000000001" || o.Price != 45 || o.Seller != "Amazon.es" || o.User != testUser {
+			t.Errorf("unexpected order %+v", o)
+		}
+	default:
+		t.Fatal("order not sent")
+	}
+	click(confirm)
+	if got := tg.messages(testUser); len(got) != 2 || got[0] != "✅ checkout of B000000001.es at 45.00 sent" || got[1] != "order not found or expired" {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestCurrency(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+
+	b.handle(ctx, commandUpdate(testUser, "/defaults currency xyz"))
+	b.handle(ctx, commandUpdate(testUser, "/defaults currency eur"))
+	if got := tg.messages(testUser); len(got) != 2 || got[0] != "unknown currency XYZ" || got[1] != "defaults updated\ncurrency: EUR" {
+		t.Fatalf("unexpected messages %q", got)
+	}
+	b.handle(ctx, commandUpdate(testUser, "/search B000000001.co.uk?target=50"))
+	b.handle(ctx, commandUpdate(testUser, "/search B000000002.es?target=50"))
+	for _, k := range []string{"-2/B000000001.co.uk?target=50&currency=EUR", "-2/B000000002.es?target=50"} {
+		if _, ok := b.searchs.Load(k); !ok {
+			t.Errorf("search %s not found", k)
+		}
+	}
+	tg.messages(testUser)
+
+	item := api.Item{ID: "B000000001", Domain: "co.uk", Title: "Disco", MinPrice: 51, Prices: [5]float64{42.5}}
+	alert := api.Alert{Kind: api.PriceAlert, Price: 42.5, Ref: 51}
+	b.notify(Event{Type: PriceDropDetected, Search: "-2/B000000001.co.uk?target=50&currency=EUR", Chat: "-2", Item: &item, Alert: &alert})
+	var got []string
+	for _, m := range tg.sent {
+		if m.ChannelUsername == "-2" {
+			got = append(got, m.Text)
+		}
+	}
+	if len(got) != 1 || !strings.Contains(got[0], "42.50£ ≈ 50.00€") {
+		t.Errorf("unexpected alert %q", got)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	b, _ := newTestBot(t)
+	item := api.Item{ID: "B000000001", Domain: "co.uk", Prices: [5]float64{85}}
+	b.record(Event{Type: PriceChanged, Item: &item})
+	points := b.history("B000000001", "co.uk")
+	if len(points) != 1 || points[0].FX != time.Now().UTC().Format("2006-01-02") {
+		t.Fatalf("unexpected history %+v", points)
+	}
+	// Rates of the day are kept even if the current ones change
+	b.fx = fx.Fixed(map[string]float64{"GBP": 0.5, "USD": 1})
+	item.Prices[0] = 80
+	b.record(Event{Type: PriceChanged, Item: &item})
+	points = append(b.history("B000000001", "co.uk"), pricePoint{Prices: [5]float64{50}})
+	got := b.normalize(points, "co.uk", "usd")
+	var prices []string
+	for _, p := range got {
+		prices = append(prices, fmt.Sprintf("%.2f", p.Prices[0]))
+	}
+	if want := "125.00 117.65 100.00"; strings.Join(prices, " ") != want {
+		t.Errorf("want %s, got %s", want, strings.Join(prices, " "))
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.baseURL = "https://deals.example.com"
+	b.handle(context.Background(), commandUpdate(testUser, "/token"))
+	token := strings.Fields(tg.messages(testUser)[0])[2]
+
+	kindle := api.Item{ID: "B000000001", Domain: "es", Title: "Kindle", Prices: [5]float64{90}}
+	b.searchs.Store("-2/B000000001.es", kindle)
+	b.searchs.Store("-2/B000000002.de?0", api.Item{ID: "B000000002", Domain: "de", Title: "Echo"})
+	b.searchs.Store("-1/B000000003.es", api.Item{ID: "B000000003", Domain: "es", Title: "Other"})
+	b.record(Event{Type: PriceChanged, Item: &kindle})
+	b.events.add(Event{Type: PriceChanged, Search: "-2/B000000001.es", Time: time.Now()})
+	b.events.add(Event{Type: PriceChanged, Search: "-1/B000000003.es", Time: time.Now()})
+
+	query := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		b.graphqlHandler(rec, req)
+		return rec
+	}
+	if rec := query("invalid", `{"query":"{ searches { id } }"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token accepted: %d", rec.Code)
+	}
+	body := `{"query":"query Dash($first: Int) { searches(first: $first) { id item { title price: prices } } es: searches(domain: \"es\") { ...s } history(item: \"B000000001.es\") { prices } events { search type } } fragment s on Search { query }","variables":{"first":1}}`
+	rec := query(token, body)
+	want := `{"data":{"searches":[{"id":"-2/B000000001.es","item":{"title":"Kindle","price":[90,0,0,0,0]}}],"es":[{"query":"B000000001.es"}],"history":[{"prices":[90,0,0,0,0]}],"events":[{"search":"-2/B000000001.es","type":"price_changed"}]}}` + "\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("unexpected response %d\n got %s\nwant %s", rec.Code, rec.Body.String(), want)
+	}
+	rec = query(token, `{"query":"{ searches { id } nope }"}`)
+	if !strings.Contains(rec.Body.String(), `"errors":[{"message":"graphql: unknown field nope","path":["nope"]}]`) {
+		t.Errorf("unexpected response %s", rec.Body.String())
+	}
+}
+
+func TestStream(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.baseURL = "https://deals.example.com"
+	b.handle(context.Background(), commandUpdate(testUser, "/token"))
+	token := strings.Fields(tg.messages(testUser)[0])[2]
+
+	srv := httptest.NewServer(http.HandlerFunc(b.streamHandler))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"?domain=es&discount=10&token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	// The events of a scrape share the same time
+	now := time.Now()
+	drop := func(id, domain string, prev, price float64) Event {
+		return Event{
+			Type: PriceChanged, Search: id, Time: now,
+			Item:     &api.Item{ID: "B000000001", Domain: domain, Prices: [5]float64{price}},
+			Previous: &[5]float64{prev},
+		}
+	}
+	b.events.add(drop("-1/B000000001.es", "es", 100, 50))
+	b.events.add(drop("-2/B000000001.de", "de", 100, 50))
+	b.events.add(drop("-2/B000000001.es", "es", 100, 95))
+	b.events.add(drop("-2/B000000001.es", "es", 100, 80))
+	b.events.add(drop("-2/B000000001.es", "es", 100, 70))
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for len(lines) < 7 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) < 7 || lines[0] != "id: 4" || lines[1] != "event: price_changed" || lines[4] != "id: 5" ||
+		!strings.Contains(lines[2], `"prices":[80,`) || !strings.Contains(lines[6], `"prices":[70,`) {
+		t.Fatalf("unexpected stream %q", lines)
+	}
+	cancel()
+
+	// Reconnections only get the events after the last id
+	req, err = http.NewRequestWithContext(context.Background(), "GET", srv.URL+"?domain=es&discount=10&token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "4")
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err = http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner = bufio.NewScanner(resp.Body)
+	if !scanner.Scan() || scanner.Text() != "id: 5" {
+		t.Errorf("unexpected replay %q", scanner.Text())
+	}
+}
+
+func TestStreamFilter(t *testing.T) {
+	item := func(domain string, price float64) *api.Item {
+		return &api.Item{ID: "B000000001", Domain: domain, Prices: [5]float64{price}}
+	}
+	drop := Event{Type: PriceChanged, Search: "-2/B000000001.es", Item: item("es", 80), Previous: &[5]float64{100}}
+	tests := []struct {
+		query string
+		event Event
+		want  bool
+	}{
+		{"", drop, true},
+		{"type=price_changed,title_changed", drop, true},
+		{"type=title_changed", drop, false},
+		{"chat=-2", drop, true},
+		{"chat=-3", drop, false},
+		{"domain=es", drop, true},
+		{"domain=de", drop, false},
+		{"discount=20", drop, true},
+		{"discount=20.5", drop, false},
+		{"discount=10", Event{Type: TitleChanged, Search: "-2/B000000001.es", Item: item("es", 80)}, false},
+		{"domain=es&chat=-2&type=price_changed&discount=5", drop, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/stream?"+tt.query, nil)
+		f, err := parseStreamFilter(r)
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if got := f.match(tt.event); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+	for _, query := range []string{"discount=x", "discount=-1"} {
+		if _, err := parseStreamFilter(httptest.NewRequest("GET", "/stream?"+query, nil)); err == nil {
+			t.Errorf("%q: invalid discount accepted", query)
+		}
+	}
+}
+
+func TestApp(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.baseURL = "https://deals.example.com"
+	b.webAppSecret = webAppSecret("123:token")
+	b.handle(context.Background(), commandUpdate(testUser, "/app"))
+	tg.lock.Lock()
+	markup, ok := tg.sent[len(tg.sent)-1].ReplyMarkup.(webAppMarkup)
+	tg.lock.Unlock()
+	if !ok || markup.InlineKeyboard[0][0].WebApp.URL != "https://deals.example.com/app" {
+		t.Fatalf("unexpected markup %+v", markup)
+	}
+
+	sign := func(user int, date time.Time) string {
+		values := url.Values{
+			"auth_date": {strconv.FormatInt(date.Unix(), 10)},
+			"query_id":  {"AAH"},
+			"user":      {fmt.Sprintf(`{"id":%d,"first_name":"Test"}`, user)},
+		}
+		check := fmt.Sprintf("auth_date=%s\nquery_id=AAH\nuser=%s", values.Get("auth_date"), values.Get("user"))
+		mac := hmac.New(sha256.New, webAppSecret("123:token"))
+		mac.Write([]byte(check))
+		values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+		return values.Encode()
+	}
+	b.searchs.Store("-2/B000000001.es", api.Item{ID: "B000000001", Domain: "es", Title: "Kindle"})
+	tests := []struct {
+		initData string
+		code     int
+	}{
+		{sign(testUser, time.Now()), http.StatusOK},
+		{sign(testUser, time.Now().Add(-48*time.Hour)), http.StatusUnauthorized},
+		{sign(300, time.Now()), http.StatusUnauthorized},
+		{strings.Replace(sign(testUser, time.Now()), "Test", "Evil", 1), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ searches { id } }"}`))
+		req.Header.Set("Authorization", "tma "+tt.initData)
+		rec := httptest.NewRecorder()
+		b.graphqlHandler(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: unexpected code %d", tt.initData, rec.Code)
+		}
+		if tt.code == http.StatusOK && !strings.Contains(rec.Body.String(), "-2/B000000001.es") {
+			t.Errorf("unexpected response %s", rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/app/settings", nil)
+	req.Header.Set("Authorization", "tma "+sign(testUser, time.Now()))
+	rec := httptest.NewRecorder()
+	b.appSettingsHandler(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"chat":"-2"`) {
+		t.Errorf("unexpected settings %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHealthReport(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.searchs.Store("-2/B000000001.es", api.Item{})
+	b.searchs.Store("-2/B000000002.de", api.Item{})
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		b.scraped(parsedArgs{id: "-2/B000000001.es", query: "B000000001.es"}, time.Second, nil)
+	}
+	b.reportHealth(context.Background(), now)
+	if msgs := tg.messages(testAdmin); len(msgs) != 0 {
+		t.Fatalf("first sample reported: %q", msgs)
+	}
+
+	for i := 0; i < 4; i++ {
+		b.scraped(parsedArgs{id: "-2/B000000001.es", query: "B000000001.es"}, time.Second, errors.New("timeout"))
+	}
+	for i := 0; i < 16; i++ {
+		b.scraped(parsedArgs{id: "-2/B000000002.de", query: "B000000002.de"}, time.Second, nil)
+	}
+	b.metrics.Add("amazbot_captchas_total", 2, "domain", "es")
+	b.searchs.Store("-2/B000000003.es", api.Item{})
+	b.reportHealth(context.Background(), now.Add(time.Hour))
+	if msgs := tg.messages(testAdmin); len(msgs) != 0 {
+		t.Fatalf("reported before the interval: %q", msgs)
+	}
+	b.reportHealth(context.Background(), now.Add(healthInterval))
+	msgs := tg.messages(testAdmin)
+	if len(msgs) != 1 {
+		t.Fatalf("unexpected messages %q", msgs)
+	}
+	for _, want := range []string{"👀 3 searchs (+1)", "❌ errors: de 0.0% (0/16), es 100.0% (4/4)", "🧩 captchas 10.0% (2/20)", "💾 store"} {
+		if !strings.Contains(msgs[0], want) {
+			t.Errorf("%q not found in %q", want, msgs[0])
+		}
+	}
+}
+
+func TestDomainsCommand(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.searchs.Store("-2/B000000001.es", api.Item{})
+	b.searchs.Store("-2/B000000002.es", api.Item{})
+	b.searchs.Store("-2/B000000003.de", api.Item{})
+	b.searchs.Store("-2/B000000004.fr", api.Item{})
+	b.scraped(parsedArgs{id: "-2/B000000001.es", query: "B000000001.es"}, time.Second, nil)
+	b.scraped(parsedArgs{id: "-2/B000000002.es", query: "B000000002.es"}, 3*time.Second, nil)
+	b.metrics.Add("amazbot_captchas_total", 1, "domain", "es")
+	for i := 0; i < circuitFailures; i++ {
+		b.scraped(parsedArgs{id: "-2/B000000003.de", query: "B000000003.de"}, time.Second, errors.New("blocked"))
+	}
+	b.handle(context.Background(), commandUpdate(testAdmin, "/domains"))
+	msgs := tg.messages(testAdmin)
+	if len(msgs) != 1 {
+		t.Fatalf("unexpected messages %q", msgs)
+	}
+	want := []string{
+		"de: 1 searchs, 1s avg, 🧩 0.0% 🔴 circuit open (5 failures)",
+		"es: 2 searchs, 2s avg, 🧩 50.0%, ✅ 0s ago 🟢",
+		"fr: 1 searchs 🟢",
+	}
+	if got := strings.Split(msgs[0], "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	b.handle(context.Background(), commandUpdate(testUser, "/domains"))
+	if msgs := tg.messages(testUser); len(msgs) != 1 || strings.Contains(msgs[0], "searchs") {
+		t.Errorf("domains shown to user: %q", msgs)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	b, tg := newTestBot(t)
+	if _, err := parseTenants(map[string]string{"a": "users:300", "b": "users:300,301"}); err == nil {
+		t.Error("user in two tenants accepted")
+	}
+	tenants, err := parseTenants(map[string]string{"friends": "users:300 chats:@Friends max:2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.setTenants(tenants)
+	b.setUsers([]int{testUser}, nil)
+	friend := 300
+
+	b.handle(context.Background(), commandUpdate(friend, "/search B000000001.es"))
+	b.handle(context.Background(), commandUpdate(friend, "/search -2/B000000002.es"))
+	b.handle(context.Background(), commandUpdate(testUser, "/search @friends/B000000002.es"))
+	b.handle(context.Background(), commandUpdate(friend, "/search B000000002.es"))
+	b.handle(context.Background(), commandUpdate(friend, "/search B000000003.es"))
+	b.handle(context.Background(), commandUpdate(friend, "/tenant"))
+	want := []string{
+		"searching 300/B000000001.es",
+		"couldn't search -2/B000000002.es: -2 belongs to another tenant",
+		"searching 300/B000000002.es",
+		"couldn't search 300/B000000003.es: the quota of 2 searchs of tenant friends is reached",
+		"friends: 1 users, 2 searchs of 2, chats @friends",
+	}
+	if got := tg.messages(int64(friend)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "couldn't search @friends/B000000002.es: @friends belongs to another tenant" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	if _, ok := b.searchs.Load("300/B000000003.es"); ok {
+		t.Error("search over quota added")
+	}
+}
+
+func TestTenantQuotaConcurrent(t *testing.T) {
+	b, _ := newTestBot(t)
+	tenants, err := parseTenants(map[string]string{"friends": "users:300 max:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.setTenants(tenants)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			parsed, err := parseArgs(fmt.Sprintf("B00000000%d.es", i), "300")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = b.add(parsed)
+		}(i)
+	}
+	wg.Wait()
+	if n := b.tenantSearchs("friends"); n != 1 {
+		t.Errorf("got %d searchs, want 1", n)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	b, tg := newTestBot(t)
+	ctx := context.Background()
+	tenants, err := parseTenants(map[string]string{"friends": "users:300"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.setTenants(tenants)
+	b.setUsers([]int{testUser}, nil)
+	friend := 300
+	b.searchs.Store("300/B000000001.es", nil)
+	b.searchs.Store("-2/B000000002.es", nil)
+
+	b.handle(ctx, commandUpdate(friend, "/stop -2/B000000002.es"))
+	b.handle(ctx, commandUpdate(friend, "/note -2/B000000002.es mine"))
+	b.handle(ctx, commandUpdate(friend, "/export"))
+	b.handle(ctx, commandUpdate(friend, "/status *"))
+	want := []string{
+		"couldn't stop -2/B000000002.es: it belongs to another tenant",
+		"couldn't note -2/B000000002.es: it belongs to another tenant",
+		"/batch 300/B000000001.es",
+		"status info:",
+		"B000000001.es\nchats: 300",
+	}
+	if got := tg.messages(int64(friend)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	b.handle(ctx, commandUpdate(friend, "/stop *"))
+	if _, ok := b.searchs.Load("300/B000000001.es"); ok {
+		t.Error("search of the tenant not stopped")
+	}
+	if _, ok := b.searchs.Load("-2/B000000002.es"); !ok {
+		t.Error("search of another tenant stopped")
+	}
+
+	tg.messages(testAdmin)
+	b.handle(ctx, commandUpdate(testAdmin, "/export"))
+	if got := tg.messages(testAdmin); len(got) != 1 || got[0] != "/batch -2/B000000002.es" {
+		t.Errorf("unexpected admin export %q", got)
+	}
+}
+
+func TestTenantRoute(t *testing.T) {
+	b, tg := newTestBot(t)
+	tenants, err := parseTenants(map[string]string{"friends": "users:300 chats:@friends"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.setTenants(tenants)
+	b.setUsers([]int{testUser}, nil)
+	b.handle(context.Background(), commandUpdate(testUser, "/route books @friends"))
+	b.handle(context.Background(), commandUpdate(300, "/route books @friends"))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "couldn't route to @friends: @friends belongs to another tenant" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	if got := tg.messages(300); len(got) != 1 || got[0] != "books deals of 300 routed to @friends" {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.retention = defaultRetention
+	kindle := api.Item{ID: "B000000001", Domain: "es", Title: "Kindle", Prices: [5]float64{90}}
+	echo := api.Item{ID: "B000000002", Domain: "es", Title: "Echo", Prices: [5]float64{40}}
+	for id, i := range map[string]api.Item{"-2/B000000001.es": kindle, "-1/B000000001.es?0": kindle, "-2/B000000002.es": echo} {
+		i := i
+		b.searchs.Store(id, i)
+		if err := b.db.Put("db", id, i); err != nil {
+			t.Fatal(err)
+		}
+		b.record(Event{Type: PriceChanged, Item: &i})
+	}
+	if err := b.db.Put("notes", "-2/B000000001.es", "gift"); err != nil {
+		t.Fatal(err)
+	}
+	b.handle(context.Background(), commandUpdate(testUser, "/stop B000000001.es"))
+	b.handle(context.Background(), commandUpdate(testUser, "/stop B000000002.es"))
+	if got := tg.messages(testUser); len(got) != 2 || got[0] != "stopped -2/B000000001.es, its history is kept 30 days" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	var d deletedSearch
+	if err := b.db.Get("deleted", "-2/B000000001.es", &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Item.Title != "Kindle" || d.Note != "gift" || d.Deleted.IsZero() {
+		t.Errorf("unexpected deleted search %+v", d)
+	}
+	if keys, _ := b.db.Keys("db"); len(keys) != 1 {
+		t.Errorf("unexpected searchs %v", keys)
+	}
+
+	b.purge(time.Now().Add(24 * time.Hour))
+	if keys, _ := b.db.Keys("deleted"); len(keys) != 2 {
+		t.Errorf("purged before the retention: %v", keys)
+	}
+	b.purge(time.Now().Add(defaultRetention + time.Hour))
+	if keys, _ := b.db.Keys("deleted"); len(keys) != 0 {
+		t.Errorf("not purged after the retention: %v", keys)
+	}
+	if len(b.history("B000000001", "es")) != 1 {
+		t.Error("history of an item still searched was purged")
+	}
+	if len(b.history("B000000002", "es")) != 0 {
+		t.Error("history of a purged item was kept")
+	}
+}
+
+func TestRetrack(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.retention = defaultRetention
+	kindle := api.Item{ID: "B000000001", Domain: "es", Title: "Kindle", MinPrice: 70, Prices: [5]float64{0, 0, 0, 0, 90}}
+	echo := api.Item{ID: "B000000002", Domain: "es", Title: "Echo", MinPrice: 30, Prices: [5]float64{0, 0, 0, 0, 40}}
+	for id, i := range map[string]api.Item{"-2/B000000001.es?4": kindle, "-2/B000000002.es?4": echo} {
+		i := i
+		b.searchs.Store(id, i)
+		if err := b.db.Put("db", id, i); err != nil {
+			t.Fatal(err)
+		}
+		b.record(Event{Type: PriceChanged, Item: &i})
+	}
+	if err := b.db.Put("notes", "-2/B000000001.es?4", "gift"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.Put("notes", "-2/B000000002.es?4", "kitchen"); err != nil {
+		t.Fatal(err)
+	}
+	b.handle(context.Background(), commandUpdate(testUser, "/stop B000000001.es?4"))
+	b.handle(context.Background(), commandUpdate(testUser, "/stop B000000002.es?4"))
+	b.handle(context.Background(), commandUpdate(testUser, "/search B000000001.es?4"))
+	b.handle(context.Background(), commandUpdate(testUser, "/search B000000002.es?0"))
+	b.handle(context.Background(), commandUpdate(testUser, "/search B000000003.es"))
+	want := []string{
+		"stopped -2/B000000001.es?4, its history is kept 30 days",
+		"stopped -2/B000000002.es?4, its history is kept 30 days",
+		"searching -2/B000000001.es?4, resumed with its previous minimum and history",
+		"searching -2/B000000002.es?0, resumed with its history",
+		"searching -2/B000000003.es",
+	}
+	if got := tg.messages(testUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var i api.Item
+	if err := b.db.Get("db", "-2/B000000001.es?4", &i); err != nil {
+		t.Fatal(err)
+	}
+	if i.MinPrice != 70 {
+		t.Errorf("minimum price not restored: %+v", i)
+	}
+	if v, _ := b.searchs.Load("-2/B000000001.es?4"); v == nil {
+		t.Error("restored item not loaded")
+	}
+	if note := b.note("-2/B000000001.es?4"); note != "gift" {
+		t.Errorf("note not restored: %q", note)
+	}
+
+	// the minimum of the used state isn't restored to the new state
+	i = api.Item{}
+	if err := b.db.Get("db", "-2/B000000002.es?0", &i); err != nil {
+		t.Fatal(err)
+	}
+	if i.MinPrice != 0 {
+		t.Errorf("minimum price of other options restored: %+v", i)
+	}
+	if v, _ := b.searchs.Load("-2/B000000002.es?0"); v != nil {
+		t.Errorf("item of other options loaded: %+v", v)
+	}
+	if note := b.note("-2/B000000002.es?0"); note != "kitchen" {
+		t.Errorf("note not restored: %q", note)
+	}
+	if len(b.history("B000000002", "es")) != 1 {
+		t.Error("history not kept")
+	}
+	if keys, _ := b.db.Keys("deleted"); len(keys) != 0 {
+		t.Errorf("restored search still deleted: %v", keys)
+	}
+}
+
+func TestSearchTarget(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.handle(context.Background(), commandUpdate(testUser, "/search B000000001.es @ 45,50"))
+	b.handle(context.Background(), commandUpdate(testUser, "/search B000000002.es?0 @45€"))
+	b.handle(context.Background(), commandUpdate(testUser, "/search B000000003.es @ cheap"))
+	want := []string{
+		"searching -2/B000000001.es?target=45.5",
+		"searching -2/B000000002.es?0&target=45",
+		`invalid target price "cheap"`,
+	}
+	if got := tg.messages(testUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestShare(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.searchs.Store("-1/B000000001.es?0&target=45", nil)
+	b.searchs.Store("-1/B000000002.de", nil)
+	b.handle(context.Background(), commandUpdate(testAdmin, "/share *"))
+	msgs := tg.messages(testAdmin)
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0], "🔗 share 2 searchs with this link, it expires in 7 days:\nhttps://t.me/amazbot?start=share_") {
+		t.Fatalf("unexpected messages %q", msgs)
+	}
+	payload := strings.Split(msgs[0], "?start=")[1]
+
+	b.handle(context.Background(), commandUpdate(testUser, "/start "+payload))
+	want := []string{"📥 shared searchs:\n-2/B000000001.es?0&target=45\n-2/B000000002.de"}
+	if got := tg.messages(testUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, ok := b.searchs.Load("-2/B000000002.de"); !ok {
+		t.Error("shared search not added")
+	}
+	b.handle(context.Background(), commandUpdate(testUser, "/start share_unknown"))
+	b.handle(context.Background(), commandUpdate(testUser, "/share B000000003.es?1 B000000004.es"))
+	msgs = tg.messages(testUser)
+	if len(msgs) != 2 || msgs[0] != "share link not found or expired" || !strings.HasPrefix(msgs[1], "🔗 share 2 searchs") {
+		t.Errorf("unexpected messages %q", msgs)
+	}
+
+	if err := b.db.Put("shares", "invalid", sharedSearchs{User: testAdmin, Queries: []string{"B000000005.es @ cheap", "B000000006.es"}, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	b.handle(context.Background(), commandUpdate(testUser, "/start share_invalid"))
+	want = []string{"📥 shared searchs:\nB000000005.es @ cheap: invalid target price \"cheap\"\n-2/B000000006.es"}
+	if got := tg.messages(testUser); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := b.db.Put("shares", "old", sharedSearchs{User: testAdmin, Queries: []string{"B000000007.es"}, Created: time.Now().Add(-shareTTL - time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	b.handle(context.Background(), commandUpdate(testUser, "/start share_old"))
+	if got := tg.messages(testUser); len(got) != 1 || got[0] != "share link not found or expired" {
+		t.Errorf("unexpected messages %q", got)
+	}
+	b.purgeShares(time.Now())
+	if codes, _ := b.db.Keys("shares"); len(codes) != 3 {
+		t.Errorf("unexpected share links after purge %v", codes)
+	}
+}
+
+func TestGRPCToken(t *testing.T) {
+	b, _ := newTestBot(t)
+	if err := b.serveGRPC(context.Background(), "127.0.0.1:0", "", "", ""); err == nil {
+		t.Error("grpc service started without token")
+	}
+}
+
+func TestGRPCAddSearch(t *testing.T) {
+	b, _ := newTestBot(t)
+	tenants, err := parseTenants(map[string]string{"friends": "users:300 max:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.setTenants(tenants)
+	s := &grpcServer{bot: b}
+	if _, err := s.AddSearch(context.Background(), wrapperspb.String("300/B000000001.es")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.AddSearch(context.Background(), wrapperspb.String("300/B000000002.es"))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got %v, want resource exhausted", err)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	var logs, got []string
+	th := newThrottle(2, nil, func(v interface{}) { logs = append(logs, fmt.Sprint(v)) })
+	push := func(chat string, score float64) {
+		th.push(chat, score, func() { got = append(got, fmt.Sprintf("%s:%.0f", chat, score)) })
+	}
+	sent := func() []string {
+		sort.Strings(got)
+		s := got
+		got = nil
+		return s
+	}
+	now := time.Now()
+
+	// The queue keeps the best deals that fit in the budget
+	push("a", 1)
+	push("a", 3)
+	push("a", 2)
+	push("b", 1)
+	if len(logs) != 1 || logs[0] != "throttle: dropped 1 deals for a" {
+		t.Errorf("unexpected logs %q", logs)
+	}
+	th.flush(now.Add(-30 * time.Minute))
+	if s := sent(); fmt.Sprint(s) != "[a:2 a:3 b:1]" {
+		t.Fatalf("unexpected sent %q", s)
+	}
+
+	// The budget is spent until the window of the sent posts ends
+	push("a", 4)
+	push("b", 2)
+	th.flush(now)
+	if s := sent(); fmt.Sprint(s) != "[b:2]" {
+		t.Fatalf("unexpected sent %q", s)
+	}
+	th.flush(now.Add(31 * time.Minute))
+	if s := sent(); fmt.Sprint(s) != "[a:4]" {
+		t.Fatalf("unexpected sent %q", s)
+	}
+
+	// Queued deals expire after the window
+	push("a", 5)
+	push("a", 6)
+	logs = nil
+	th.flush(now.Add(61 * time.Minute))
+	if s := sent(); len(s) != 0 {
+		t.Errorf("expired deals sent %q", s)
+	}
+	if len(logs) != 2 || logs[0] != "throttle: deal expired for a" {
+		t.Errorf("unexpected logs %q", logs)
+	}
+	if len(th.queues) != 0 {
+		t.Errorf("queues not cleaned %v", th.queues)
+	}
+
+	// Without budget deals are sent right away
+	th = newThrottle(0, nil, func(interface{}) {})
+	push("a", 1)
+	if s := sent(); fmt.Sprint(s) != "[a:1]" {
+		t.Errorf("unexpected sent %q", s)
+	}
+}
+
+// fakeNotifier records the messages sent to each destination
+type fakeNotifier struct {
+	sent map[string][]notify.Message
+}
+
+func (n *fakeNotifier) Send(dest string, m notify.Message) error {
+	if n.sent == nil {
+		n.sent = make(map[string][]notify.Message)
+	}
+	n.sent[dest] = append(n.sent[dest], m)
+	return nil
+}
+
+func TestDestinations(t *testing.T) {
+	tests := map[string][]destination{
+		"-2":                      {{chat: "-2"}},
+		"-2=new,ntfy:deals=used":  {{chat: "-2", rule: "new"}, {chat: "ntfy:deals", rule: "used"}},
+		",@deals,,pushover:u1Key": {{chat: "@deals"}, {chat: "pushover:u1Key"}},
+	}
+	for chat, want := range tests {
+		if got := destinations(chat); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", chat, got, want)
+		}
+	}
+
+	b, tg := newTestBot(t)
+	ntfy := &fakeNotifier{}
+	b.notifiers = map[string]notify.Notifier{"ntfy": ntfy}
+	item := api.Item{ID: "B000000000", Domain: "es", Title: "Disco", Category: []string{"Informática", "Discos SSD"}, MinPrice: 20, Prices: [5]float64{10, 8}}
+	alert := func(state int, price float64) *api.Alert {
+		return &api.Alert{Kind: api.PriceAlert, State: state, Price: price, Ref: 20}
+	}
+
+	// The rules split new and used deals between telegram and ntfy
+	b.notify(Event{Type: PriceDropDetected, Search: "-2/B000000000.es", Chat: "-2=new,ntfy:deals=used", Item: &item, Alert: alert(0, 10)})
+	b.notify(Event{Type: PriceDropDetected, Search: "-2/B000000000.es", Chat: "-2=new,ntfy:deals=used", Item: &item, Alert: alert(2, 8)})
+	var texts []string
+	for _, m := range tg.sent {
+		if m.ChannelUsername == "-2" {
+			texts = append(texts, m.Text)
+		}
+	}
+	if len(texts) != 1 || !strings.Contains(texts[0], "Precio: 10.00€") {
+		t.Errorf("unexpected telegram messages %q", texts)
+	}
+	if got := ntfy.sent["deals"]; len(got) != 1 || !strings.Contains(got[0].Text, "Precio: 8.00€") {
+		t.Errorf("unexpected ntfy messages %+v", got)
+	}
+
+	// Routes replace the destinations keeping their rules
+	if err := b.db.Put("config", routeKey("-2"), map[string]string{"ssd": "ntfy:storage", "audio": "@audio"}); err != nil {
+		t.Fatal(err)
+	}
+	got := b.route(destinations("-2=used,@other"), item)
+	want := []destination{{chat: "ntfy:storage", rule: "used"}, {chat: "@other"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got routes %+v, want %+v", got, want)
+	}
+	item.Category = nil
+	if got := b.route(destinations("-2"), item); !reflect.DeepEqual(got, []destination{{chat: "-2"}}) {
+		t.Errorf("item without category routed to %+v", got)
+	}
+
+	// Destinations of services without notifier are reported
+	b.notify(Event{Type: PriceDropDetected, Search: "-2/B000000000.es", Chat: "pushover:u1Key", Item: &item, Alert: alert(0, 9)})
+	if got := tg.messages(testAdmin); len(got) != 1 || !strings.Contains(got[0], "notifier pushover not configured") {
+		t.Errorf("unexpected admin messages %q", got)
+	}
+}
@@ -0,0 +1,41 @@
+package amazbot
+
+import (
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// announced returns true if the search query has the announce option
+// (ASIN.domain?announce=1), any value other than 0 enables it
+func announced(query string) bool {
+	split := strings.SplitN(query, "?", 2)
+	if len(split) < 2 {
+		return false
+	}
+	for _, o := range strings.Split(split[1], "&") {
+		kv := strings.SplitN(o, "=", 2)
+		if kv[0] != "announce" {
+			continue
+		}
+		return len(kv) < 2 || kv[1] != "0"
+	}
+	return false
+}
+
+// announce posts the current price of a search the first time it is scraped
+// so subscribers have a reference point
+func (b *bot) announce(parsed parsedArgs, item api.Item) {
+	alert := api.Alert{Kind: api.PriceAlert, State: -1}
+	for state, p := range item.Prices {
+		if p > 0 && (alert.State < 0 || p < alert.Price) {
+			alert.State = state
+			alert.Price = p
+		}
+	}
+	if alert.State < 0 {
+		return
+	}
+	alert.Ref = alert.Price
+	b.bus.Publish(Event{Type: SearchAnnounced, Search: parsed.id, Chat: parsed.chat, Item: &item, Alert: &alert})
+}
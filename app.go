@@ -0,0 +1,186 @@
+package amazbot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// webAppSecret returns the key of the init data signatures of the mini app,
+// see https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+func webAppSecret(token string) []byte {
+	mac := hmac.New(sha256.New, []byte("WebAppData"))
+	mac.Write([]byte(token))
+	return mac.Sum(nil)
+}
+
+// verifyInitData checks the init data of the mini app and returns the
+// telegram user id
+func verifyInitData(secret []byte, initData string, now time.Time) (int, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return 0, fmt.Errorf("invalid init data: %w", err)
+	}
+	var pairs []string
+	for k, v := range values {
+		if k == "hash" || len(v) == 0 {
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v[0]))
+	}
+	sort.Strings(pairs)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(values.Get("hash")), []byte(want)) {
+		return 0, fmt.Errorf("invalid init data hash")
+	}
+	date, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || now.Sub(time.Unix(date, 0)) > sessionTTL {
+		return 0, fmt.Errorf("init data expired")
+	}
+	var user struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(values.Get("user")), &user); err != nil || user.ID == 0 {
+		return 0, fmt.Errorf("invalid init data user")
+	}
+	return user.ID, nil
+}
+
+// initDataUser returns the user of the init data sent by the mini app as
+// "tma <init data>" authorization header
+func (b *bot) initDataUser(r *http.Request) (int, bool) {
+	auth := r.Header.Get("Authorization")
+	if b.webAppSecret == nil || !strings.HasPrefix(auth, "tma ") {
+		return 0, false
+	}
+	user, err := verifyInitData(b.webAppSecret, strings.TrimPrefix(auth, "tma "), time.Now())
+	if err != nil {
+		return 0, false
+	}
+	if _, ok := b.userChat(user); !ok {
+		return 0, false
+	}
+	return user, true
+}
+
+// webAppButton opens a mini app, the telegram library doesn't support them
+type webAppButton struct {
+	Text   string `json:"text"`
+	WebApp struct {
+		URL string `json:"url"`
+	} `json:"web_app"`
+}
+
+type webAppMarkup struct {
+	InlineKeyboard [][]webAppButton `json:"inline_keyboard"`
+}
+
+// appCommand sends the button that opens the dashboard mini app
+func (b *bot) appCommand(ctx context.Context, r request) {
+	if !strings.HasPrefix(b.baseURL, "https://") {
+		b.message(r.user, "the mini app isn't available, there is no https base url configured")
+		return
+	}
+	btn := webAppButton{Text: "📊 amazbot"}
+	btn.WebApp.URL = fmt.Sprintf("%s/app", b.baseURL)
+	msg := tgbot.NewMessage(int64(r.user), "Open the dashboard to browse your searchs, their history and settings")
+	msg.ReplyMarkup = webAppMarkup{InlineKeyboard: [][]webAppButton{{btn}}}
+	if _, err := b.tg.SendMessage(ctx, msg); err != nil {
+		b.log(fmt.Errorf("couldn't send app button to %d: %w", r.user, err))
+	}
+}
+
+// appSettings are the settings of the user shown in the mini app
+type appSettings struct {
+	Chat     string       `json:"chat"`
+	Defaults userDefaults `json:"defaults"`
+	Settings chatSettings `json:"settings"`
+	Filter   chatFilter   `json:"filter"`
+}
+
+// appHandler serves the mini app page, its data is requested to the graphql
+// api and the settings handler with the init data
+func (b *bot) appHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := fmt.Fprint(w, appPage); err != nil {
+		log.Println(fmt.Errorf("couldn't write app page: %w", err))
+	}
+}
+
+// appSettingsHandler returns the settings of the user of the init data
+func (b *bot) appSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := b.initDataUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	chat, _ := b.userChat(user)
+	writeJSON(w, appSettings{
+		Chat:     chat,
+		Defaults: b.defaults(user),
+		Settings: b.settings(chat),
+		Filter:   b.filter(chat),
+	})
+}
+
+const appPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>amazbot</title>
+<script src="https://telegram.org/js/telegram-web-app.js"></script>
+<style>
+body{font-family:sans-serif;margin:0 12px;color:var(--tg-theme-text-color);background:var(--tg-theme-bg-color)}
+a{color:var(--tg-theme-link-color)}li{margin:8px 0;cursor:pointer}small{color:var(--tg-theme-hint-color)}
+svg{width:100%;height:160px}polyline{fill:none;stroke:var(--tg-theme-button-color,#2481cc);stroke-width:2}
+</style></head>
+<body><h3>Búsquedas</h3><ul id="searches"></ul><div id="item"></div><h3>Ajustes</h3><pre id="settings"></pre>
+<script>
+const app = window.Telegram.WebApp;
+app.ready();
+const headers = {"Authorization": "tma " + app.initData, "Content-Type": "application/json"};
+const text = s => { const e = document.createElement("span"); e.textContent = s; return e.innerHTML; };
+async function gql(query, variables) {
+  const r = await fetch("/graphql", {method: "POST", headers, body: JSON.stringify({query, variables})});
+  const body = await r.json();
+  if (body.errors) throw new Error(body.errors[0].message);
+  return body.data;
+}
+async function show(id, title) {
+  const data = await gql("query($item: String) { history(item: $item) { time prices } }", {item: id});
+  const points = data.history.filter(p => p.prices[0] > 0);
+  let chart = "<small>sin histórico</small>";
+  if (points.length > 1) {
+    const prices = points.map(p => p.prices[0]), min = Math.min(...prices), max = Math.max(...prices);
+    const line = points.map((p, i) => (i * 300 / (points.length - 1)) + "," + (150 - (p.prices[0] - min) * 140 / ((max - min) || 1))).join(" ");
+    chart = "<svg viewBox='0 0 300 160'><polyline points='" + line + "'/></svg><small>" + min + " - " + max + "</small>";
+  }
+  document.getElementById("item").innerHTML = "<h3>" + text(title) + "</h3>" + chart;
+}
+async function load() {
+  const data = await gql("{ searches { id note item { id domain title link prices } } }");
+  const list = document.getElementById("searches");
+  for (const s of data.searches) {
+    if (!s.item) continue;
+    const li = document.createElement("li");
+    li.innerHTML = text(s.item.title || s.id) + " <small>" + text(s.item.domain) + " " + s.item.prices.filter(p => p > 0).join(" / ") + (s.note ? " 📝 " + text(s.note) : "") + "</small> <a href=\"" + text(encodeURI(s.item.link)) + "\">🔗</a>";
+    li.onclick = () => show(s.item.id + "." + s.item.domain, s.item.title);
+    list.appendChild(li);
+  }
+  const r = await fetch("/app/settings", {headers});
+  document.getElementById("settings").textContent = JSON.stringify(await r.json(), null, 2);
+}
+load().catch(e => app.showAlert(e.message));
+</script></body></html>
+`
@@ -0,0 +1,163 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+type arbitrage struct {
+	Chat     string   `json:"chat"`
+	ASIN     string   `json:"asin"`
+	Domains  []string `json:"domains"`
+	Spread   float64  `json:"spread"`
+	Shipping float64  `json:"shipping"`
+}
+
+// arbitrageCommand handles /arbitrage, /arbitrage add <asin> <domains> <spread> [shipping]
+// and /arbitrage stop <asin>
+func (b *bot) arbitrageCommand(user int, chat, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		keys, err := b.db.Keys("arbitrage")
+		if err != nil {
+			b.log(err)
+			return
+		}
+		sort.Strings(keys)
+		b.message(user, "arbitrage info:")
+		prefix := fmt.Sprintf("%s/", chat)
+		for _, k := range keys {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			var a arbitrage
+			if err := b.db.Get("arbitrage", k, &a); err != nil {
+				b.log(err)
+				continue
+			}
+			btns := []tgbot.InlineKeyboardButton{
+				tgbot.NewInlineKeyboardButtonData("stop", fmt.Sprintf("/arbitrage stop %s", a.ASIN)),
+			}
+			b.messageOpts(b.ctx, user, fmt.Sprintf("%s %s\nspread:%.0f%%, shipping:%.2f€", a.ASIN, strings.Join(a.Domains, ","), a.Spread, a.Shipping), false, btns)
+		}
+		return
+	}
+	switch fields[0] {
+	case "add":
+		if len(fields) < 4 {
+			b.message(user, "usage: /arbitrage add <asin> <domain,domain...> <spread> [shipping]")
+			return
+		}
+		a := arbitrage{
+			Chat:    chat,
+			ASIN:    strings.ToUpper(fields[1]),
+			Domains: strings.Split(strings.ToLower(fields[2]), ","),
+		}
+		if len(a.Domains) < 2 {
+			b.message(user, "at least two domains are required")
+			return
+		}
+		var err error
+		if a.Spread, err = strconv.ParseFloat(fields[3], 64); err != nil {
+			b.message(user, fmt.Sprintf("couldn't parse spread %s", fields[3]))
+			return
+		}
+		if len(fields) > 4 {
+			if a.Shipping, err = strconv.ParseFloat(fields[4], 64); err != nil {
+				b.message(user, fmt.Sprintf("couldn't parse shipping %s", fields[4]))
+				return
+			}
+		}
+		if err := b.db.Put("arbitrage", fmt.Sprintf("%s/%s", chat, a.ASIN), a); err != nil {
+			b.log(err)
+			return
+		}
+		b.message(user, fmt.Sprintf("arbitrage %s on %s", a.ASIN, strings.Join(a.Domains, ",")))
+	case "stop":
+		if len(fields) < 2 {
+			b.message(user, "usage: /arbitrage stop <asin>")
+			return
+		}
+		asin := strings.ToUpper(fields[1])
+		if err := b.db.Delete("arbitrage", fmt.Sprintf("%s/%s", chat, asin)); err != nil {
+			b.log(err)
+			return
+		}
+		b.message(user, fmt.Sprintf("stopped arbitrage %s", asin))
+	default:
+		b.message(user, fmt.Sprintf("unknown arbitrage command %s", fields[0]))
+	}
+}
+
+// arbitrages compares the prices of each arbitrage entry across its domains
+func (b *bot) arbitrages(ctx context.Context) {
+	keys, err := b.db.Keys("arbitrage")
+	if err != nil {
+		b.log(err)
+		return
+	}
+	for _, k := range keys {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		var a arbitrage
+		if err := b.db.Get("arbitrage", k, &a); err != nil {
+			b.log(err)
+			continue
+		}
+		b.arbitrage(ctx, a)
+	}
+}
+
+type arbitragePrice struct {
+	domain string
+	price  float64
+	item   api.Item
+}
+
+func (b *bot) arbitrage(ctx context.Context, a arbitrage) {
+	var prices []arbitragePrice
+	for _, d := range a.Domains {
+		var item api.Item
+		id := fmt.Sprintf("%s.%s?0", a.ASIN, d)
+		if err := b.scrape(ctx, id, &item, func(api.Item, api.Alert) error { return nil }); err != nil {
+			b.log(fmt.Errorf("arbitrage %s: %w", id, err))
+			continue
+		}
+		if item.Prices[0] == 0 {
+			continue
+		}
+		eur, err := b.fx.Convert(item.Prices[0], api.Currency(d), "EUR")
+		if err != nil {
+			b.log(fmt.Errorf("arbitrage %s: %w", id, err))
+			continue
+		}
+		prices = append(prices, arbitragePrice{domain: d, price: eur, item: item})
+	}
+	if len(prices) < 2 {
+		return
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].price < prices[j].price })
+	buy := prices[0]
+	sell := prices[len(prices)-1]
+	cost := buy.price + a.Shipping
+	spread := (sell.price - cost) / cost * 100
+	if spread < a.Spread {
+		return
+	}
+	cacheID := fmt.Sprintf("%s/arbitrage/%s/%s/%.2f/%s/%.2f", a.Chat, a.ASIN, buy.domain, buy.price, sell.domain, sell.price)
+	if !b.claim(cacheID) {
+		return
+	}
+	text := fmt.Sprintf("🌍 ARBITRAJE\n\n%s\n\n✅ Compra: %.2f€ en amazon.%s\n💶 Venta: %.2f€ en amazon.%s\n🚚 Envío: %.2f€\n📈 Diferencia: %.0f%%\n\n🔗 %s\n🔗 %s",
+		buy.item.Title, buy.price, buy.domain, sell.price, sell.domain, a.Shipping, spread, buy.item.Link, sell.item.Link)
+	b.message(a.Chat, text)
+}
@@ -0,0 +1,309 @@
+package amazbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// checkoutTTL is how long an auto-buy order waits for its confirmation
+const checkoutTTL = 10 * time.Minute
+
+// buyRule is a strict rule of an user that prepares the checkout of an item
+// when its price reaches the maximum price
+type buyRule struct {
+	Item     string  `json:"item"`
+	MaxPrice float64 `json:"max_price"`
+	MaxState int     `json:"max_state"`
+	Amazon   bool    `json:"amazon,omitempty"`
+}
+
+func (r buyRule) String() string {
+	text := fmt.Sprintf("%s ≤ %.2f%s, %s", r.Item, r.MaxPrice, api.Coin(strings.SplitN(r.Item, ".", 2)[1]), api.StateText("en", r.MaxState))
+	if r.MaxState > 0 {
+		text = fmt.Sprintf("%s or better", text)
+	}
+	if r.Amazon {
+		text = fmt.Sprintf("%s, sold by amazon", text)
+	}
+	return text
+}
+
+// matches returns true if the alert of the item fulfills the rule
+func (r buyRule) matches(i api.Item, a api.Alert) bool {
+	if a.Kind != api.PriceAlert && a.Kind != api.UsedAlert {
+		return false
+	}
+	if fmt.Sprintf("%s.%s", i.ID, i.Domain) != r.Item || a.Price <= 0 || a.Price > r.MaxPrice || a.State > r.MaxState {
+		return false
+	}
+	return !r.Amazon || strings.HasPrefix(strings.ToLower(i.Sellers[a.State]), "amazon")
+}
+
+// order is the data sent to the external checkout bot
+type order struct {
+	User     int       `json:"user"`
+	Search   string    `json:"search"`
+	ASIN     string    `json:"asin"`
+	Domain   string    `json:"domain"`
+	Link     string    `json:"link"`
+	Title    string    `json:"title"`
+	Price    float64   `json:"price"`
+	Currency string    `json:"currency"`
+	State    int       `json:"state"`
+	Seller   string    `json:"seller,omitempty"`
+	MaxPrice float64   `json:"max_price"`
+	Time     time.Time `json:"time"`
+}
+
+func buyRulesKey(user int) string {
+	return fmt.Sprintf("user/%d/autobuy", user)
+}
+
+func (b *bot) buyRules(user int) []buyRule {
+	var rules []buyRule
+	if err := b.db.Get("config", buyRulesKey(user), &rules); err != nil {
+		b.log(err)
+	}
+	return rules
+}
+
+// autobuy asks the users with a matching rule to confirm the checkout of
+// the alerted item
+func (b *bot) autobuy(e Event) {
+	i, a := *e.Item, *e.Alert
+	keys, err := b.db.Keys("config")
+	if err != nil {
+		b.log(err)
+		return
+	}
+	for _, k := range keys {
+		var user int
+		if _, err := fmt.Sscanf(k, "user/%d/autobuy", &user); err != nil || k != buyRulesKey(user) {
+			continue
+		}
+		if _, ok := b.userChat(user); !ok {
+			continue
+		}
+		for _, r := range b.buyRules(user) {
+			if !r.matches(i, a) {
+				continue
+			}
+			if !b.claimFor(fmt.Sprintf("autobuy/%d/%s/%d/%.2f", user, r.Item, a.State, a.Price), checkoutTTL) {
+				break
+			}
+			b.confirmCheckout(user, order{
+				User:     user,
+				Search:   e.Search,
+				ASIN:     i.ID,
+				Domain:   i.Domain,
+				Link:     b.affiliate(i.Link, i.Domain),
+				Title:    i.Title,
+				Price:    a.Price,
+				Currency: api.Currency(i.Domain),
+				State:    a.State,
+				Seller:   i.Sellers[a.State],
+				MaxPrice: r.MaxPrice,
+				Time:     e.Time,
+			})
+			break
+		}
+	}
+}
+
+// confirmCheckout sends the order to the user with a one-time button that
+// fires the checkout hook
+func (b *bot) confirmCheckout(user int, o order) {
+	code, err := newCode()
+	if err != nil {
+		b.log(fmt.Errorf("couldn't generate checkout code: %w", err))
+		return
+	}
+	b.cache.Set(fmt.Sprintf("checkout/%s", code), o, checkoutTTL)
+	coin := api.Coin(o.Domain)
+	text := fmt.Sprintf("🛒 <b>COMPRA AUTOMÁTICA</b>\n\n%s\n\n💶 %.2f%s (máx. %.2f%s)\n🎁 %s",
+		html.EscapeString(o.Title), o.Price, coin, o.MaxPrice, coin, api.StateText(o.Domain, o.State))
+	if o.Seller != "" {
+		text = fmt.Sprintf("%s\n🏪 %s", text, html.EscapeString(o.Seller))
+	}
+	text = fmt.Sprintf("%s\n\n%s\n\n⏳ Confirma antes de %s", text, o.Link, time.Now().Add(checkoutTTL).Format("15:04"))
+	btns := []tgbot.InlineKeyboardButton{
+		tgbot.NewInlineKeyboardButtonData("✅ Comprar", fmt.Sprintf("/checkout %s", code)),
+		tgbot.NewInlineKeyboardButtonData("❌ Cancelar", fmt.Sprintf("/checkout %s cancel", code)),
+	}
+	b.send(b.ctx, user, text, tgbot.ModeHTML, false, btns)
+}
+
+// checkoutCommand confirms or cancels a pending order, orders are fired only
+// once in the background
+func (b *bot) checkoutCommand(ctx context.Context, r request) {
+	fields := strings.Fields(r.args)
+	key := fmt.Sprintf("checkout/%s", fields[0])
+	v, ok := b.cache.Get(key)
+	o, _ := v.(order)
+	if !ok || o.User != r.user {
+		b.message(r.user, "order not found or expired")
+		return
+	}
+	b.cache.Delete(key)
+	if len(fields) > 1 && fields[1] == "cancel" {
+		b.message(r.user, fmt.Sprintf("order of %s.%s cancelled", o.ASIN, o.Domain))
+		return
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		if err := b.checkout(ctx, o); err != nil {
+			b.log(fmt.Errorf("checkout: %w", err))
+			b.message(r.user, fmt.Sprintf("❌ checkout of %s.%s failed", o.ASIN, o.Domain))
+			return
+		}
+		b.message(r.user, fmt.Sprintf("✅ checkout of %s.%s at %.2f sent", o.ASIN, o.Domain, o.Price))
+	}()
+}
+
+// checkout posts the order json to the auto-buy url or runs the auto-buy
+// executable with it on stdin
+func (b *bot) checkout(ctx context.Context, o order) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal order: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if strings.HasPrefix(b.autoBuy, "http://") || strings.HasPrefix(b.autoBuy, "https://") {
+		req, err := http.NewRequestWithContext(ctx, "POST", b.autoBuy, bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("couldn't create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("request to %s failed: %w", req.URL.Host, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+		}
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, b.autoBuy)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("AMAZBOT_ASIN=%s", o.ASIN),
+		fmt.Sprintf("AMAZBOT_DOMAIN=%s", o.Domain),
+		fmt.Sprintf("AMAZBOT_STATE=%d", o.State),
+		fmt.Sprintf("AMAZBOT_PRICE=%.2f", o.Price),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", b.autoBuy, err, out)
+	}
+	return nil
+}
+
+const autoBuyUsage = "usage: /autobuy <asin[.domain]> <max price> [new|used|0-4] [amazon], /autobuy <asin[.domain]> off or /autobuy to list the rules"
+
+// autoBuyCommand adds, removes or lists the auto-buy rules of the user, a
+// search of the item is started in the user chat to track it
+func (b *bot) autoBuyCommand(_ context.Context, r request) {
+	if b.autoBuy == "" {
+		b.message(r.user, "auto-buy isn't configured")
+		return
+	}
+	fields := strings.Fields(r.args)
+	rules := b.buyRules(r.user)
+	if len(fields) == 0 {
+		if len(rules) == 0 {
+			b.message(r.user, fmt.Sprintf("no auto-buy rules, %s", autoBuyUsage))
+			return
+		}
+		lines := []string{"🛒 auto-buy rules:"}
+		for _, rule := range rules {
+			lines = append(lines, rule.String())
+		}
+		b.message(r.user, strings.Join(lines, "\n"))
+		return
+	}
+	item, err := api.NormalizeID(userDefaults{Domain: b.defaults(r.user).Domain}.apply(fields[0]))
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	item = strings.SplitN(item, "?", 2)[0]
+	var rest []buyRule
+	for _, rule := range rules {
+		if rule.Item != item {
+			rest = append(rest, rule)
+		}
+	}
+	var rule buyRule
+	switch {
+	case len(fields) == 2 && fields[1] == "off":
+		if len(rest) == len(rules) {
+			b.message(r.user, fmt.Sprintf("auto-buy rule of %s not found", item))
+			return
+		}
+	case len(fields) < 2 || len(fields) > 4:
+		b.message(r.user, autoBuyUsage)
+		return
+	default:
+		price, ok := parseWatchlistPrice(fields[1])
+		if !ok {
+			b.message(r.user, fmt.Sprintf("invalid price %s", fields[1]))
+			return
+		}
+		rule = buyRule{Item: item, MaxPrice: price}
+		for _, f := range fields[2:] {
+			switch f = strings.ToLower(f); f {
+			case "new":
+			case "used":
+				rule.MaxState = 4
+			case "amazon":
+				rule.Amazon = true
+			default:
+				state, err := strconv.Atoi(f)
+				if err != nil || state < 0 || state > 4 {
+					b.message(r.user, autoBuyUsage)
+					return
+				}
+				rule.MaxState = state
+			}
+		}
+		rest = append(rest, rule)
+	}
+	if len(rest) == 0 {
+		err = b.db.Delete("config", buyRulesKey(r.user))
+	} else {
+		err = b.db.Put("config", buyRulesKey(r.user), rest)
+	}
+	if err != nil {
+		b.log(err)
+		return
+	}
+	if rule.Item == "" {
+		b.message(r.user, fmt.Sprintf("auto-buy rule of %s removed", item))
+		return
+	}
+	chat, _ := b.userChat(r.user)
+	parsed, err := parseArgs(fmt.Sprintf("%s/%s?%d", chat, item, rule.MaxState), chat)
+	if err != nil {
+		b.log(err)
+		return
+	}
+	if err := b.add(parsed); err != nil {
+		b.message(r.user, fmt.Sprintf("couldn't add auto-buy rule: %s", err))
+		return
+	}
+	b.message(r.user, fmt.Sprintf("🛒 auto-buy rule added: %s, you will be asked to confirm each checkout", rule))
+}
@@ -0,0 +1,119 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// spending is a deal bought by an user, tallied in the month of the purchase
+type spending struct {
+	Item  string    `json:"item"`
+	Price float64   `json:"price"`
+	Date  time.Time `json:"date"`
+}
+
+func budgetKey(user int) string {
+	return fmt.Sprintf("user/%d/budget", user)
+}
+
+func spentKey(user int, month time.Time) string {
+	return fmt.Sprintf("user/%d/spent/%s", user, month.Format("2006-01"))
+}
+
+func (b *bot) monthlyBudget(user int) float64 {
+	var budget float64
+	if err := b.db.Get("config", budgetKey(user), &budget); err != nil {
+		b.log(err)
+	}
+	return budget
+}
+
+func (b *bot) spent(user int, month time.Time) []spending {
+	var ss []spending
+	if err := b.db.Get("config", spentKey(user, month), &ss); err != nil {
+		b.log(err)
+	}
+	return ss
+}
+
+func spentTotal(ss []spending) float64 {
+	var sum float64
+	for _, s := range ss {
+		sum += s.Price
+	}
+	return sum
+}
+
+// spend tallies the purchase in its month replacing a previous one of the
+// same item, a zero price removes it, the user is warned when the monthly
+// budget is exceeded, purchaseLock must be held
+func (b *bot) spend(user int, item string, price float64, date time.Time) {
+	var ss []spending
+	for _, s := range b.spent(user, date) {
+		if s.Item != item {
+			ss = append(ss, s)
+		}
+	}
+	if price > 0 {
+		ss = append(ss, spending{Item: item, Price: price, Date: date})
+	}
+	var err error
+	if len(ss) == 0 {
+		err = b.db.Delete("config", spentKey(user, date))
+	} else {
+		err = b.db.Put("config", spentKey(user, date), ss)
+	}
+	if err != nil {
+		b.log(err)
+		return
+	}
+	if budget := b.monthlyBudget(user); price > 0 && budget > 0 && spentTotal(ss) > budget {
+		b.message(user, fmt.Sprintf("⚠️ monthly budget exceeded: spent %.2f of %.2f in %s", spentTotal(ss), budget, date.Format("2006-01")))
+	}
+}
+
+// budgetCommand shows or sets the monthly budget of the deals bought
+func (b *bot) budgetCommand(_ context.Context, r request) {
+	switch value := strings.TrimSpace(r.args); value {
+	case "":
+	case "off":
+		if err := b.db.Delete("config", budgetKey(r.user)); err != nil {
+			b.log(err)
+			return
+		}
+	default:
+		budget, ok := parseWatchlistPrice(value)
+		if !ok {
+			b.message(r.user, "usage: /budget [amount|off]")
+			return
+		}
+		if err := b.db.Put("config", budgetKey(r.user), budget); err != nil {
+			b.log(err)
+			return
+		}
+	}
+	b.message(r.user, b.budgetText(r.user, time.Now().UTC()))
+}
+
+// budgetText returns the spending of the month and the budget left
+func (b *bot) budgetText(user int, now time.Time) string {
+	ss := b.spent(user, now)
+	budget := b.monthlyBudget(user)
+	lines := []string{fmt.Sprintf("💸 spent %.2f in %s", spentTotal(ss), now.Format("2006-01"))}
+	if budget > 0 {
+		left := budget - spentTotal(ss)
+		lines[0] = fmt.Sprintf("%s, budget %.2f, left %.2f", lines[0], budget, left)
+		if left < 0 {
+			lines[0] = fmt.Sprintf("%s ⚠️", lines[0])
+		}
+	} else {
+		lines[0] = fmt.Sprintf("%s, no budget set", lines[0])
+	}
+	for _, s := range ss {
+		lines = append(lines, fmt.Sprintf("%s %s: %s", s.Date.Format("02/01"), s.Item, strconv.FormatFloat(s.Price, 'f', 2, 64)))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// resetter is implemented by the flags that accumulate values, their default
+// is empty
+type resetter interface {
+	Reset()
+}
+
+// loadConfig sets the flags that weren't set in the command line to the
+// values of the config file, the file has a flag per line as name=value,
+// repeated flags are allowed and lines starting with # are ignored
+func loadConfig(fs *flag.FlagSet, path string, explicit map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open config file: %w", err)
+	}
+	defer f.Close()
+	values := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		split := strings.SplitN(line, "=", 2)
+		name := strings.TrimLeft(strings.TrimSpace(split[0]), "-")
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config file line %d: unknown flag %s", n, name)
+		}
+		if name == "config" {
+			return fmt.Errorf("config file line %d: config can't be nested", n)
+		}
+		var value string
+		if len(split) > 1 {
+			value = strings.TrimSpace(split[1])
+		} else if _, ok := fs.Lookup(name).Value.(interface{ IsBoolFlag() bool }); ok {
+			value = "true"
+		}
+		values[name] = append(values[name], value)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("couldn't read config file: %w", err)
+	}
+	// Flags missing in the file go back to their defaults so that removed
+	// lines take effect on reload
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if setErr != nil || explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		if r, ok := f.Value.(resetter); ok {
+			r.Reset()
+		} else if err := f.Value.Set(f.DefValue); err != nil {
+			setErr = fmt.Errorf("config file: couldn't reset %s: %w", f.Name, err)
+			return
+		}
+		for _, v := range values[f.Name] {
+			if err := f.Value.Set(v); err != nil {
+				setErr = fmt.Errorf("config file: invalid value for %s: %w", f.Name, err)
+				return
+			}
+		}
+	})
+	return setErr
+}
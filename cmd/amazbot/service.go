@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// startService is a no-op, windows services are only available on windows
+func startService(cancel context.CancelFunc) (func(), error) {
+	return func() {}, nil
+}
+
+func installService(args []string) error {
+	return errors.New("windows services not supported on this platform")
+}
+
+func uninstallService() error {
+	return errors.New("windows services not supported on this platform")
+}
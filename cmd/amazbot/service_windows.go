@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "amazbot"
+
+// startService reports the status to the service manager when the process
+// runs as a windows service, cancel is called when the service is stopped.
+// The returned function must be called once the bot has stopped.
+func startService(cancel context.CancelFunc) (func(), error) {
+	ok, err := svc.IsWindowsService()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't detect windows service: %w", err)
+	}
+	if !ok {
+		return func() {}, nil
+	}
+	done := make(chan struct{})
+	errC := make(chan error, 1)
+	go func() {
+		errC <- svc.Run(serviceName, &service{cancel: cancel, done: done})
+	}()
+	return func() {
+		close(done)
+		<-errC
+	}, nil
+}
+
+type service struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (s *service) Execute(args []string, r <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-s.done:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				s.cancel()
+			}
+		}
+	}
+}
+
+// installService registers the executable as an automatic windows service
+// launched with the args
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("couldn't get executable path: %w", err)
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return fmt.Errorf("couldn't get executable path: %w", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("couldn't connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceName,
+		Description: "Amazon price tracker telegram bot",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("couldn't create service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+	return nil
+}
+
+// uninstallService removes the windows service
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("couldn't connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("couldn't delete service %s: %w", serviceName, err)
+	}
+	return nil
+}
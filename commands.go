@@ -0,0 +1,418 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// request is a command sent by a user, chat is the destination configured
+// by the user for searches
+type request struct {
+	name string
+	user int
+	chat string
+	args string
+}
+
+// fields returns the first n-1 space separated arguments and the rest of
+// them, missing arguments are empty
+func (r request) fields(n int) []string {
+	split := strings.SplitN(strings.TrimSpace(r.args), " ", n)
+	for len(split) < n {
+		split = append(split, "")
+	}
+	return split
+}
+
+type handlerFunc func(ctx context.Context, r request)
+
+// middleware wraps a handler to run checks before it
+type middleware func(handlerFunc) handlerFunc
+
+// command is a registered command with its help text
+type command struct {
+	name    string
+	usage   string
+	help    string
+	handler handlerFunc
+}
+
+// syntax returns the command with its usage
+func (c *command) syntax() string {
+	return strings.TrimSpace(fmt.Sprintf("/%s %s", c.name, c.usage))
+}
+
+// router dispatches requests to the registered commands
+type router struct {
+	commands map[string]*command
+}
+
+func newRouter() *router {
+	return &router{commands: make(map[string]*command)}
+}
+
+// handle registers a command, middlewares are run in the given order
+func (r *router) handle(name, usage, help string, h handlerFunc, mws ...middleware) {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	r.commands[name] = &command{name: name, usage: usage, help: help, handler: h}
+}
+
+// dispatch runs the command of the request, false is returned if it isn't
+// registered
+func (r *router) dispatch(ctx context.Context, req request) bool {
+	c, ok := r.commands[req.name]
+	if !ok {
+		return false
+	}
+	c.handler(ctx, req)
+	return true
+}
+
+// help returns the help text of a command or of all of them
+func (r *router) help(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "/")
+	if c, ok := r.commands[name]; ok {
+		return fmt.Sprintf("%s\n%s", c.syntax(), c.help)
+	}
+	var names []string
+	for n := range r.commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, n := range names {
+		c := r.commands[n]
+		lines = append(lines, fmt.Sprintf("%s - %s", c.syntax(), c.help))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// operatorOnly rejects the requests of users other than the operators and
+// the admin
+func (b *bot) operatorOnly(action string) middleware {
+	return func(h handlerFunc) handlerFunc {
+		return func(ctx context.Context, r request) {
+			if r.user != b.admin && !b.operators[r.user] {
+				b.message(r.user, fmt.Sprintf("only operators can %s", action))
+				return
+			}
+			h(ctx, r)
+		}
+	}
+}
+
+// adminOnly rejects the requests of users other than the admin, it is used
+// by owner commands
+func (b *bot) adminOnly(action string) middleware {
+	return func(h handlerFunc) handlerFunc {
+		return func(ctx context.Context, r request) {
+			if r.user != b.admin {
+				b.message(r.user, fmt.Sprintf("only the admin can %s", action))
+				return
+			}
+			h(ctx, r)
+		}
+	}
+}
+
+// requireArgs rejects the requests without arguments
+func (b *bot) requireArgs(h handlerFunc) handlerFunc {
+	return func(ctx context.Context, r request) {
+		if strings.TrimSpace(r.args) == "" {
+			b.message(r.user, fmt.Sprintf("%s arguments not provided", r.name))
+			return
+		}
+		h(ctx, r)
+	}
+}
+
+// chatCommand adapts commands taking the user, chat and arguments
+func chatCommand(f func(user int, chat, args string)) handlerFunc {
+	return func(_ context.Context, r request) {
+		f(r.user, r.chat, r.args)
+	}
+}
+
+func (b *bot) newRouter() *router {
+	r := newRouter()
+	r.handle("help", "[command]", "show the available commands", func(_ context.Context, req request) {
+		b.message(req.user, r.help(req.args))
+	})
+	r.handle("start", "[payload]", "show the available commands or add the searchs of a share link", func(_ context.Context, req request) {
+		if code := strings.TrimPrefix(req.args, sharePrefix); code != req.args && code != "" {
+			b.startShared(req, code)
+			return
+		}
+		b.message(req.user, r.help(""))
+	})
+	r.handle("chat", "[chat]", "show or set the chat id for searchs", b.chatCommand)
+	r.handle("defaults", "[domain|condition|threshold|chat <value>|reset]", "show or set the defaults of your searchs", b.defaultsCommand)
+	r.handle("search", "<asin[.domain][?state]> [@ target]", "start a search, alerting only below the target price if set", b.searchCommand, b.requireArgs)
+	r.handle("quickadd", "<asin[.domain][?state]>", "set up a search step by step", b.quickAddCommand, b.requireArgs)
+	r.handle("answer", "<answer>", "answer the current step of the setup", b.answerCommand, b.requireArgs)
+	r.handle("cancel", "", "cancel the current setup", b.cancelCommand)
+	r.handle("batch", "<searchs>", "start a search per line", b.batchCommand, b.requireArgs)
+	r.handle("status", "[*] [by price|discount|change|domain] [text]", "show, sort and search the searchs of the chat or all of them", b.statusCommand)
+	r.handle("note", "<asin[.domain]> [text|off]", "show, set or remove the note of a search", b.noteCommand, b.requireArgs)
+	r.handle("stop", "<asin[.domain]|*>", "stop a search or all of them", b.stopCommand, b.requireArgs)
+	r.handle("bought", "[<asin[.domain]> <price> [YYYY-MM-DD] [days]|<asin[.domain]> off]", "track the price of a purchase during the return window or list them", b.boughtCommand)
+	r.handle("autobuy", "[<asin[.domain]> <max price> [new|used|0-4] [amazon]|<asin[.domain]> off]", "prepare the checkout of an item when it reaches the price, each one is confirmed", b.autoBuyCommand)
+	r.handle("checkout", "<code> [cancel]", "confirm or cancel an auto-buy order", b.checkoutCommand, b.requireArgs)
+	r.handle("budget", "[amount|off]", "show or set the monthly budget of the deals bought", b.budgetCommand)
+	r.handle("watchlist", "[chat] <csv>", "import a camelcamelcamel or keepa csv export, pasted or uploaded with the command as caption", b.watchlistCommand, b.requireArgs)
+	r.handle("token", "[off]", "create or revoke the token to track products from the browser or by email", b.tokenCommand)
+	r.handle("app", "", "open the dashboard mini app", b.appCommand)
+	r.handle("tenant", "", "show the searchs, quota and chats of your tenant", func(_ context.Context, req request) {
+		b.tenantCommand(req.user)
+	})
+	r.handle("share", "<asin[.domain][?options]...|*>", "create a link that adds the searchs to the chat of whoever opens it", b.shareCommand, b.requireArgs)
+	r.handle("import", "<chat>", "copy the searchs of the chat to another one", b.importCommand, b.requireArgs)
+	r.handle("export", "", "export the searchs", func(_ context.Context, req request) {
+		b.export(req.user)
+	})
+	r.handle("twitter", "on|off|<min deal score>", "post deals of the chat to twitter", chatCommand(b.twitterCommand))
+	r.handle("abtest", "[a|b <template>|off|reset]", "compare two alert templates", chatCommand(b.abtestCommand))
+	r.handle("leaderboard", "", "show the weekly leaderboard of the chat", chatCommand(b.leaderboardCommand))
+	r.handle("revenue", "[days]", "estimate the affiliate revenue", chatCommand(b.revenueCommand))
+	r.handle("route", "[<category> <chat>|off <category>]", "route alerts by category", chatCommand(b.routeCommand))
+	r.handle("filter", "[allow|block|brand|seller|remove <keyword>|discount <n>|off]", "filter the alerts of the chat", chatCommand(b.filterCommand))
+	r.handle("settings", "[emoji <name> <emoji>|header <text>|footer <text>|permalink on|off|fakes annotate|suppress|off|cooldown <state> <duration>|expired notify|edit|off|leaderboard on|off|reset]", "customize the alerts of the chat", chatCommand(b.settingsCommand))
+	r.handle("arbitrage", "[add <asin> <domains> <spread> [shipping]|stop <asin>]", "watch price spreads between domains", chatCommand(b.arbitrageCommand))
+
+	pause := b.operatorOnly("pause the bot")
+	r.handle("pauseall", "[duration]", "pause all domains", func(_ context.Context, req request) {
+		b.pauseCommand(req.user, pauseAll, req.args)
+	}, pause)
+	r.handle("pausedomain", "[<domain> [duration]]", "pause a domain or list the pauses", func(_ context.Context, req request) {
+		if req.args == "" {
+			text := b.pausesText()
+			if s := b.schedulesText(); s != "" {
+				text = fmt.Sprintf("%s\n%s", text, s)
+			}
+			b.message(req.user, text)
+			return
+		}
+		split := req.fields(2)
+		b.pauseCommand(req.user, strings.ToLower(split[0]), split[1])
+	}, pause)
+	r.handle("resume", "[domain]", "resume a domain or all of them", func(_ context.Context, req request) {
+		b.resumeCommand(req.user, req.args)
+	}, pause)
+
+	update := b.adminOnly("update the bot")
+	r.handle("version", "", "show the version and check for updates", func(ctx context.Context, req request) {
+		b.versionCommand(ctx, req.user)
+	}, update)
+	r.handle("update", "", "update the bot to the latest release", func(ctx context.Context, req request) {
+		b.updateCommand(ctx, req.user)
+	}, update)
+	r.handle("master", "[chat|off]", "show or set the channel that receives all the alerts", b.masterCommand, b.adminOnly("set the master channel"))
+	r.handle("reload", "", "reload the configuration file", func(_ context.Context, req request) {
+		b.reloadCommand(req.user)
+	}, b.adminOnly("reload the configuration"))
+	r.handle("health", "", "show the scraping health since the last daily report", b.healthCommand, b.adminOnly("see the health report"))
+	r.handle("queue", "", "show the scrape queue", func(_ context.Context, req request) {
+		b.queueCommand(req.user)
+	}, b.operatorOnly("see the queue"))
+	r.handle("domains", "", "show the scrape stats and circuit of each domain", func(_ context.Context, req request) {
+		b.domainsCommand(req.user)
+	}, b.operatorOnly("see the domains"))
+	return r
+}
+
+func (b *bot) chatCommand(_ context.Context, r request) {
+	if r.args == "" {
+		b.message(r.user, fmt.Sprintf("current chat id for searchs: %s", r.chat))
+		return
+	}
+	if err := b.checkChat(r.user, r.args); err != nil {
+		b.message(r.user, fmt.Sprintf("chat id for searchs not updated: %s", err))
+		return
+	}
+	b.usersLock.Lock()
+	b.userChats[r.user] = r.args
+	b.usersLock.Unlock()
+	if err := b.db.Put("config", strconv.Itoa(r.user), r.args); err != nil {
+		b.log(fmt.Errorf("couldn't put config for %d: %w", r.user, err))
+	}
+	b.message(r.user, fmt.Sprintf("chat id for searchs updated: %s", r.args))
+}
+
+func (b *bot) searchCommand(_ context.Context, r request) {
+	parsed, err := parseArgs(r.args, r.chat)
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	query, err := api.NormalizeID(b.defaults(r.user).apply(parsed.query))
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	if parsed, err = parseArgs(fmt.Sprintf("%s/%s", parsed.chat, query), r.chat); err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	if parsed.chat != r.chat {
+		if err := b.checkChat(r.user, parsed.chat); err != nil {
+			b.message(r.user, fmt.Sprintf("couldn't search %s: %s", parsed.id, err))
+			return
+		}
+	}
+	var retained string
+	if _, exists := b.searchs.Load(parsed.id); !exists {
+		retained = b.retained(parsed)
+	}
+	if err := b.add(parsed); err != nil {
+		b.message(r.user, fmt.Sprintf("couldn't search %s: %s", parsed.id, err))
+		return
+	}
+	switch retained {
+	case "":
+		b.message(r.user, fmt.Sprintf("searching %s", parsed.id))
+	case parsed.id:
+		b.message(r.user, fmt.Sprintf("searching %s, resumed with its previous minimum and history", parsed.id))
+	default:
+		// the minimum of other options isn't restored
+		b.message(r.user, fmt.Sprintf("searching %s, resumed with its history", parsed.id))
+	}
+}
+
+func (b *bot) batchCommand(ctx context.Context, r request) {
+	for _, s := range strings.Split(r.args, "\n") {
+		r.args = s
+		b.searchCommand(ctx, r)
+	}
+}
+
+// importCommand copies the searchs of the user chat to the requested one
+func (b *bot) importCommand(_ context.Context, r request) {
+	chat := strings.TrimSpace(r.args)
+	if err := b.checkChat(r.user, chat); err != nil {
+		b.message(r.user, fmt.Sprintf("couldn't import searchs: %s", err))
+		return
+	}
+	prefix := fmt.Sprintf("%s/", r.chat)
+	var queries []string
+	b.searchs.Range(func(k interface{}, _ interface{}) bool {
+		if key := k.(string); strings.HasPrefix(key, prefix) {
+			queries = append(queries, strings.TrimPrefix(key, prefix))
+		}
+		return true
+	})
+	sort.Strings(queries)
+	var imported int
+	for _, q := range queries {
+		parsed, err := parseArgs(fmt.Sprintf("%s/%s", chat, q), r.chat)
+		if err != nil {
+			b.message(r.user, err.Error())
+			continue
+		}
+		if err := b.add(parsed); err != nil {
+			b.message(r.user, fmt.Sprintf("import stopped: %s", err))
+			break
+		}
+		imported++
+	}
+	b.message(r.user, fmt.Sprintf("imported %d searchs from %s to %s", imported, r.chat, chat))
+}
+
+func (b *bot) stopCommand(_ context.Context, r request) {
+	parsed, err := parseArgs(r.args, r.chat)
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	if parsed.query == "*" {
+		b.stopAll(r.user)
+		b.message(r.user, "stopped all")
+		return
+	}
+	if !b.ownsSearch(r.user, parsed.id) {
+		b.message(r.user, fmt.Sprintf("couldn't stop %s: it belongs to another tenant", parsed.id))
+		return
+	}
+	b.stop(parsed)
+	b.message(r.user, fmt.Sprintf("stopped %s, its history is kept %d days", parsed.id, int(b.retention.Hours()/24)))
+}
+
+func (b *bot) statusCommand(ctx context.Context, r request) {
+	opts, err := parseStatusOptions(r.args)
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	entries := b.statusEntries(r.user, r.chat, opts)
+	if len(entries) == 0 && opts.search != "" {
+		b.message(r.user, fmt.Sprintf("no searchs match %s", opts.search))
+		return
+	}
+	b.message(r.user, "status info:")
+	if opts.all {
+		b.statusGroups(ctx, r.user, entries)
+		return
+	}
+	for _, e := range entries {
+		var min float64
+		var new float64
+		var used float64
+		var tradeIn float64
+		var outlet float64
+		var available time.Time
+		var points float64
+		var title string
+		split := strings.Split(e.key, "/")
+		link := api.Link(split[len(split)-1])
+		if i, ok := e.item(); ok {
+			link = i.Link
+			min = i.MinPrice
+			new = i.Prices[0]
+			title = i.Title
+			tradeIn = i.TradeIn
+			outlet = i.Outlet
+			if i.Available != nil {
+				available = *i.Available
+			}
+			points = i.Points
+			for j := 1; j < 5; j++ {
+				if i.Prices[j] == 0 {
+					continue
+				}
+				if used == 0 || i.Prices[j] < used {
+					used = i.Prices[j]
+				}
+			}
+		}
+		btns := []tgbot.InlineKeyboardButton{
+			tgbot.NewInlineKeyboardButtonURL("link", link),
+			tgbot.NewInlineKeyboardButtonData("stop", fmt.Sprintf("/stop %s", e.key)),
+		}
+		text := fmt.Sprintf("%s %s\nmin:%.2f€, new:%.2f€, used:%.2f€", e.key, title, min, new, used)
+		if e.note != "" {
+			text = fmt.Sprintf("%s %s\n📝 %s\nmin:%.2f€, new:%.2f€, used:%.2f€", e.key, title, e.note, min, new, used)
+		}
+		if tradeIn > 0 {
+			text = fmt.Sprintf("%s, trade-in:%.2f€", text, tradeIn)
+		}
+		if outlet > 0 {
+			text = fmt.Sprintf("%s, outlet:%.2f€", text, outlet)
+		}
+		if points > 0 {
+			text = fmt.Sprintf("%s, points:%.0f%%", text, points)
+		}
+		if available.After(time.Now()) {
+			text = fmt.Sprintf("%s\navailable: %s", text, available.Format("2006-01-02"))
+		}
+		b.messageOpts(ctx, r.user, text, false, btns)
+	}
+	b.log(fmt.Sprintf("elapsed: %s", b.elapsed))
+}
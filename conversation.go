@@ -0,0 +1,174 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// conversationTTL is how long a multi-step setup waits for the next answer
+const conversationTTL = 15 * time.Minute
+
+// conversation steps, in order
+const (
+	stepCondition = "condition"
+	stepTarget    = "target"
+	stepChat      = "chat"
+	stepConfirm   = "confirm"
+)
+
+// conversation is the state of the multi-step setup of a search, answers
+// arrive as /answer callbacks or as plain messages
+type conversation struct {
+	Step  string
+	Chat  string
+	Query string
+}
+
+func conversationKey(user int) string {
+	return fmt.Sprintf("conversation/%d", user)
+}
+
+// conversation returns the active conversation of the user
+func (b *bot) conversation(user int) (conversation, bool) {
+	v, ok := b.cache.Get(conversationKey(user))
+	if !ok {
+		return conversation{}, false
+	}
+	c, ok := v.(conversation)
+	return c, ok
+}
+
+// converse saves the conversation, which renews its TTL, and prompts the
+// user for the answer of the current step
+func (b *bot) converse(ctx context.Context, user int, c conversation) {
+	b.cache.Set(conversationKey(user), c, conversationTTL)
+	answer := func(text, value string) tgbot.InlineKeyboardButton {
+		return tgbot.NewInlineKeyboardButtonData(text, fmt.Sprintf("/answer %s", value))
+	}
+	var text string
+	var btns []tgbot.InlineKeyboardButton
+	switch c.Step {
+	case stepCondition:
+		text = fmt.Sprintf("Select minimum product condition to search %s:", c.Query)
+		for i := 0; i < 5; i++ {
+			btns = append(btns, answer(api.StateText("en", i), strconv.Itoa(i)))
+		}
+		btns = append(btns, answer("Any condition", "4"))
+	case stepTarget:
+		text = fmt.Sprintf("🎯 Target price for %s?\nReply with a price or \"no\" to search without target", c.Query)
+		btns = append(btns, answer("No target", "no"))
+	case stepChat:
+		text = fmt.Sprintf("Chat for the alerts of %s?\nReply with a chat id or choose one", c.Query)
+		btns = append(btns, answer(fmt.Sprintf("Current (%s)", c.Chat), c.Chat))
+		if private := strconv.Itoa(user); private != c.Chat {
+			btns = append(btns, answer("This chat", private))
+		}
+	case stepConfirm:
+		text = fmt.Sprintf("Search %s in %s?", c.Query, c.Chat)
+		btns = append(btns, answer("Confirm", "yes"), tgbot.NewInlineKeyboardButtonData("Cancel", "/cancel"))
+	}
+	b.messageOpts(ctx, user, text, false, btns)
+}
+
+// quickAddCommand starts the setup of a search
+func (b *bot) quickAddCommand(ctx context.Context, r request) {
+	parsed, err := parseArgs(r.args, r.chat)
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	query, err := api.NormalizeID(parsed.query)
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	c := conversation{Step: stepCondition, Chat: parsed.chat, Query: query}
+	if strings.Contains(query, "?") {
+		c.Step = stepTarget
+	}
+	b.converse(ctx, r.user, c)
+}
+
+// answerCommand handles the answer to the current step of the conversation
+func (b *bot) answerCommand(ctx context.Context, r request) {
+	c, ok := b.conversation(r.user)
+	if !ok {
+		b.message(r.user, "nothing to answer, paste a link to start again")
+		return
+	}
+	answer := strings.ToLower(strings.TrimSpace(r.args))
+	switch c.Step {
+	case stepCondition:
+		state, err := strconv.Atoi(answer)
+		if err != nil || state < 0 || state > 4 {
+			b.message(r.user, "invalid condition, choose one of the buttons")
+			return
+		}
+		c.Query = fmt.Sprintf("%s?%d", c.Query, state)
+		c.Step = stepTarget
+	case stepTarget:
+		target, err := parseTarget(answer)
+		if err != nil {
+			b.message(r.user, err.Error())
+			return
+		}
+		if target != "" {
+			c.Query = fmt.Sprintf("%s&target=%s", c.Query, target)
+		}
+		c.Step = stepChat
+	case stepChat:
+		chat := strings.TrimSpace(r.args)
+		if chat != c.Chat && chat != strconv.Itoa(r.user) {
+			if err := b.checkChat(r.user, chat); err != nil {
+				b.message(r.user, fmt.Sprintf("invalid chat %s: %s", chat, err))
+				return
+			}
+		}
+		c.Chat = chat
+		c.Step = stepConfirm
+	case stepConfirm:
+		switch answer {
+		case "yes", "y", "confirm", "ok":
+		case "no", "n":
+			b.cancelCommand(ctx, r)
+			return
+		default:
+			b.message(r.user, "reply yes or no")
+			return
+		}
+		b.cache.Delete(conversationKey(r.user))
+		b.router.dispatch(ctx, request{name: "search", user: r.user, chat: r.chat, args: fmt.Sprintf("%s/%s", c.Chat, c.Query)})
+		return
+	}
+	b.converse(ctx, r.user, c)
+}
+
+// cancelCommand drops the active conversation of the user
+func (b *bot) cancelCommand(_ context.Context, r request) {
+	if _, ok := b.conversation(r.user); !ok {
+		b.message(r.user, "nothing to cancel")
+		return
+	}
+	b.cache.Delete(conversationKey(r.user))
+	b.message(r.user, "cancelled")
+}
+
+// parseTarget parses a target price, an empty string means no target
+func parseTarget(text string) (string, error) {
+	switch text {
+	case "", "no", "-", "0":
+		return "", nil
+	}
+	text = strings.TrimSpace(strings.Trim(text, "€$£¥"))
+	price, err := strconv.ParseFloat(strings.Replace(text, ",", ".", 1), 64)
+	if err != nil || price <= 0 {
+		return "", fmt.Errorf("invalid target price %s, reply with a price or \"no\"", text)
+	}
+	return strconv.FormatFloat(price, 'f', -1, 64), nil
+}
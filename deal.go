@@ -0,0 +1,142 @@
+package amazbot
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// deal is an alert published as a permalink page
+type deal struct {
+	Item    api.Item  `json:"item"`
+	Alert   api.Alert `json:"alert"`
+	Link    string    `json:"link"`
+	Created time.Time `json:"created"`
+}
+
+// permalink stores the deal and returns the url of its page, an empty string
+// is returned if there is no base url configured
+func (b *bot) permalink(i api.Item, a api.Alert, link string) string {
+	if b.baseURL == "" {
+		return ""
+	}
+	code, err := newCode()
+	if err != nil {
+		b.log(fmt.Errorf("couldn't generate permalink: %w", err))
+		return ""
+	}
+	d := deal{Item: i, Alert: a, Link: link, Created: time.Now().UTC()}
+	if err := b.db.Put("deals", code, d); err != nil {
+		b.log(err)
+		return ""
+	}
+	return fmt.Sprintf("%s/d/%s", b.baseURL, code)
+}
+
+// chart returns the svg polyline points of the lowest price of each point
+func chart(points []pricePoint, width, height float64) string {
+	var prices []float64
+	min, max := 0.0, 0.0
+	for _, p := range points {
+		low := 0.0
+		for _, v := range p.Prices {
+			if v > 0 && (low == 0 || v < low) {
+				low = v
+			}
+		}
+		if low == 0 {
+			continue
+		}
+		if min == 0 || low < min {
+			min = low
+		}
+		if low > max {
+			max = low
+		}
+		prices = append(prices, low)
+	}
+	if len(prices) < 2 {
+		return ""
+	}
+	var coords []string
+	for i, v := range prices {
+		x := float64(i) / float64(len(prices)-1) * width
+		y := height / 2
+		if max > min {
+			y = height - (v-min)/(max-min)*height
+		}
+		coords = append(coords, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return strings.Join(coords, " ")
+}
+
+var dealPage = template.Must(template.New("deal").Funcs(template.FuncMap{
+	"price": func(v float64, coin string) string {
+		if v == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f%s", v, coin)
+	},
+	"state": func(i int) string { return api.StateText("es", i) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Item.Title}}</title></head>
+<body>
+<h1>{{.Item.Title}}</h1>
+{{with .Item.Image}}<img src="{{.}}" alt="" height="200">{{end}}
+<p><b>{{price .Alert.Price .Coin}}</b> {{state .Alert.State}} · {{.Created.Format "2006-01-02 15:04"}}</p>
+<table>
+<tr><th>Estado</th><th>Precio</th><th>Vendedor</th></tr>
+{{range $i, $p := .Item.Prices}}{{if $p}}<tr><td>{{state $i}}</td><td>{{price $p $.Coin}}</td><td>{{index $.Item.Sellers $i}}</td></tr>
+{{end}}{{end}}</table>
+{{with .Chart}}<svg width="600" height="200" viewBox="0 0 600 200"><polyline fill="none" stroke="#e47911" stroke-width="2" points="{{.}}"/></svg>
+<p>Histórico en {{$.Currency}}{{if ne $.Currency $.Native}} (<a href="?">{{$.Native}}</a>){{end}}</p>{{end}}
+<p><a href="{{.Link}}">Ver en Amazon</a></p>
+</body></html>
+`))
+
+// dealHandler serves the permalink pages at /d/<code>
+func (b *bot) dealHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/d/")
+	var d deal
+	if err := b.db.Get("deals", code, &d); err != nil {
+		b.log(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if d.Item.ID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	// The chart is normalized to the currency parameter at the rates of
+	// each observation
+	points := b.history(d.Item.ID, d.Item.Domain)
+	native := api.Currency(d.Item.Domain)
+	currency := native
+	if c := strings.ToUpper(r.URL.Query().Get("currency")); len(c) == 3 {
+		currency = c
+		points = b.normalize(points, d.Item.Domain, currency)
+	}
+	data := struct {
+		deal
+		Coin     string
+		Chart    string
+		Currency string
+		Native   string
+	}{
+		deal:     d,
+		Coin:     api.Coin(d.Item.Domain),
+		Chart:    chart(points, 600, 200),
+		Currency: currency,
+		Native:   native,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dealPage.Execute(w, data); err != nil {
+		log.Println(fmt.Errorf("couldn't render deal: %w", err))
+	}
+}
@@ -0,0 +1,35 @@
+package amazbot
+
+import "time"
+
+// dedupTTL is how long sent alerts are remembered
+const dedupTTL = 6 * time.Hour
+
+// claim marks the alert as sent and returns false if it was already sent,
+// the redis store is used when configured so duplicates are suppressed across
+// instances
+func (b *bot) claim(id string) bool {
+	return b.claimFor(id, dedupTTL)
+}
+
+// claimFor claims the alert for the duration
+func (b *bot) claimFor(id string, ttl time.Duration) bool {
+	if b.redis != nil {
+		ok, err := b.redis.SetNX("dedup:"+id, "1", ttl)
+		if err == nil {
+			return ok
+		}
+		b.log(err)
+	}
+	return b.cache.Add(id, struct{}{}, ttl) == nil
+}
+
+// release forgets a claimed alert so it can be sent again
+func (b *bot) release(id string) {
+	if b.redis != nil {
+		if err := b.redis.Del("dedup:" + id); err != nil {
+			b.log(err)
+		}
+	}
+	b.cache.Delete(id)
+}
@@ -0,0 +1,195 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// userDefaults are applied to the searchs of the user that omit them, the
+// default destination is the chat set with /chat
+type userDefaults struct {
+	Domain    string `json:"domain,omitempty"`
+	Condition *int   `json:"condition,omitempty"`
+	// Threshold is the minimum drop percentage of the alerts
+	Threshold float64 `json:"threshold,omitempty"`
+	// Currency is the ISO 4217 code of the target prices and the converted
+	// prices of the alerts
+	Currency string `json:"currency,omitempty"`
+}
+
+// defaultsKey is namespaced by user as private chats share ids with users
+func defaultsKey(user int) string {
+	return fmt.Sprintf("user/%d/defaults", user)
+}
+
+func (b *bot) defaults(user int) userDefaults {
+	var d userDefaults
+	if err := b.db.Get("config", defaultsKey(user), &d); err != nil {
+		b.log(err)
+	}
+	return d
+}
+
+// apply adds the default domain, condition and threshold to the query
+// (ASIN[.domain][?options]) when they are missing, target prices are set in
+// the default currency
+func (d userDefaults) apply(query string) string {
+	split := strings.SplitN(query, "?", 2)
+	id := split[0]
+	if d.Domain != "" && !strings.Contains(id, ".") {
+		id = fmt.Sprintf("%s.%s", id, d.Domain)
+	}
+	var opts []string
+	if len(split) > 1 && split[1] != "" {
+		opts = strings.Split(split[1], "&")
+	}
+	var state, drop, target, currency bool
+	for _, o := range opts {
+		if _, err := strconv.Atoi(o); err == nil {
+			state = true
+		}
+		if strings.HasPrefix(o, "drop=") {
+			drop = true
+		}
+		if strings.HasPrefix(o, "target=") {
+			target = true
+		}
+		if strings.HasPrefix(o, "currency=") {
+			currency = true
+		}
+	}
+	if d.Condition != nil && !state {
+		opts = append([]string{strconv.Itoa(*d.Condition)}, opts...)
+	}
+	if d.Threshold > 0 && !drop {
+		opts = append(opts, fmt.Sprintf("drop=%s", strconv.FormatFloat(d.Threshold, 'f', -1, 64)))
+	}
+	if split := strings.SplitN(id, ".", 2); d.Currency != "" && target && !currency && len(split) == 2 && api.Currency(split[1]) != d.Currency {
+		opts = append(opts, fmt.Sprintf("currency=%s", d.Currency))
+	}
+	if len(opts) == 0 {
+		return id
+	}
+	return fmt.Sprintf("%s?%s", id, strings.Join(opts, "&"))
+}
+
+func (d userDefaults) String() string {
+	var lines []string
+	if d.Domain != "" {
+		lines = append(lines, fmt.Sprintf("domain: %s", d.Domain))
+	}
+	if d.Condition != nil {
+		lines = append(lines, fmt.Sprintf("condition: %s (%d)", api.StateText("en", *d.Condition), *d.Condition))
+	}
+	if d.Threshold > 0 {
+		lines = append(lines, fmt.Sprintf("threshold: %s%%", strconv.FormatFloat(d.Threshold, 'f', -1, 64)))
+	}
+	if d.Currency != "" {
+		lines = append(lines, fmt.Sprintf("currency: %s", d.Currency))
+	}
+	return strings.Join(lines, "\n")
+}
+
+const defaultsUsage = "usage: /defaults [domain <domain>|condition <0-4>|threshold <percentage>|currency <code>|chat <chat>|<name> off|reset]"
+
+// defaultsCommand handles /defaults [domain|condition|threshold|currency|chat <value>|reset]
+func (b *bot) defaultsCommand(ctx context.Context, r request) {
+	d := b.defaults(r.user)
+	split := r.fields(2)
+	name, value := strings.ToLower(split[0]), strings.ToLower(strings.TrimSpace(split[1]))
+	switch {
+	case name == "":
+		b.message(r.user, fmt.Sprintf("%s\nchat: %s", defaultsText(d), r.chat))
+		return
+	case name == "reset":
+		d = userDefaults{}
+	case value == "":
+		b.message(r.user, defaultsUsage)
+		return
+	case name == "chat":
+		r.args = split[1]
+		b.chatCommand(ctx, r)
+		return
+	case value == "off":
+		switch name {
+		case "domain":
+			d.Domain = ""
+		case "condition":
+			d.Condition = nil
+		case "threshold":
+			d.Threshold = 0
+		case "currency":
+			d.Currency = ""
+		default:
+			b.message(r.user, defaultsUsage)
+			return
+		}
+	case name == "domain":
+		d.Domain = strings.TrimPrefix(value, ".")
+	case name == "condition":
+		c, err := strconv.Atoi(value)
+		if err != nil || c < 0 || c > 4 {
+			b.message(r.user, "usage: /defaults condition <0-4>, 0 is new and 4 acceptable")
+			return
+		}
+		d.Condition = &c
+	case name == "threshold":
+		t, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || t <= 0 || t >= 100 {
+			b.message(r.user, "usage: /defaults threshold <percentage>, the minimum drop of the alerts")
+			return
+		}
+		d.Threshold = t
+	case name == "currency":
+		c := strings.ToUpper(value)
+		if len(c) != 3 {
+			b.message(r.user, "usage: /defaults currency <code>, an ISO 4217 code like EUR or GBP")
+			return
+		}
+		if _, err := b.fx.Convert(1, "EUR", c); err != nil {
+			b.message(r.user, fmt.Sprintf("unknown currency %s", c))
+			return
+		}
+		d.Currency = c
+	default:
+		b.message(r.user, defaultsUsage)
+		return
+	}
+	if err := b.db.Put("config", defaultsKey(r.user), d); err != nil {
+		b.log(err)
+		return
+	}
+	b.message(r.user, fmt.Sprintf("defaults updated\n%s", defaultsText(d)))
+}
+
+func defaultsText(d userDefaults) string {
+	if text := d.String(); text != "" {
+		return text
+	}
+	return "no defaults"
+}
+
+// chatCurrency returns the default currency of the users whose searchs are
+// sent to the chat
+func (b *bot) chatCurrency(chat string) string {
+	b.usersLock.RLock()
+	var users []int
+	for u, c := range b.userChats {
+		if c == chat {
+			users = append(users, u)
+		}
+	}
+	b.usersLock.RUnlock()
+	sort.Ints(users)
+	for _, u := range users {
+		if c := b.defaults(u).Currency; c != "" {
+			return c
+		}
+	}
+	return ""
+}
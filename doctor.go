@@ -0,0 +1,130 @@
+package amazbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/store"
+)
+
+// Doctor validates the configuration printing the result of each check, an
+// error is returned if any check fails
+func Doctor(ctx context.Context, cfg *Config, w io.Writer) error {
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "✘ %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(w, "✔ %s\n", name)
+	}
+
+	check("telegram token", checkToken(cfg.Token))
+	if cfg.Proxy != "" {
+		check("proxy", checkProxy(ctx, cfg.Proxy))
+	}
+	if cfg.CaptchaURL != "" {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := api.TestCaptcha(ctx, cfg.CaptchaURL)
+		cancel()
+		if err != nil {
+			err = fmt.Errorf("%w (is the captcha service running at %s?)", err, cfg.CaptchaURL)
+		}
+		check("captcha solver", err)
+	}
+	domains, err := checkStore(ctx, cfg.DBPath)
+	check(fmt.Sprintf("store %s", cfg.DBPath), err)
+	for d := range cfg.Tags {
+		domains[d] = true
+	}
+	for d := range cfg.VAT {
+		domains[d] = true
+	}
+	var sorted []string
+	for d := range domains {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+	for _, d := range sorted {
+		host := fmt.Sprintf("www.amazon.%s", d)
+		_, err := net.DefaultResolver.LookupHost(ctx, host)
+		check(fmt.Sprintf("dns %s", host), err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("doctor: %d checks failed", failed)
+	}
+	return nil
+}
+
+func checkToken(token string) error {
+	if token == "" {
+		return errors.New("not provided, set it with -token")
+	}
+	bot, err := tgbot.NewBotAPI(token)
+	if err != nil {
+		return fmt.Errorf("%w (check the token given by @BotFather)", err)
+	}
+	if bot.Self.UserName == "" {
+		return errors.New("bot user not found")
+	}
+	return nil
+}
+
+func checkProxy(ctx context.Context, proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid url %q, use scheme://host:port", proxy)
+	}
+	d := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return fmt.Errorf("unreachable: %w", err)
+	}
+	return conn.Close()
+}
+
+// checkStore writes a key in the store and returns the domains of the searchs
+func checkStore(ctx context.Context, path string) (map[string]bool, error) {
+	domains := make(map[string]bool)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	db, err := store.Wait(ctx, path)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return domains, errors.New("locked, is another instance running?")
+	}
+	if err != nil {
+		return domains, err
+	}
+	defer db.Close()
+	if err := db.Put("config", "doctor", time.Now()); err != nil {
+		return domains, err
+	}
+	if err := db.Delete("config", "doctor"); err != nil {
+		return domains, err
+	}
+	keys, err := db.Keys("db")
+	if err != nil {
+		return domains, err
+	}
+	for _, k := range keys {
+		if p, err := parseArgs(k, ""); err == nil {
+			if d := searchDomain(p.query); d != "" {
+				domains[d] = true
+			}
+		}
+	}
+	return domains, nil
+}
@@ -0,0 +1,83 @@
+package amazbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// domainStat aggregates the scrapes of the searchs of a domain
+type domainStat struct {
+	searchs   int
+	succeeded time.Time
+}
+
+// domainsCommand handles /domains, it shows the searchs, the average scrape
+// latency, the last successful scrape, the circuit and the captcha rate of
+// each domain
+func (b *bot) domainsCommand(user int) {
+	stats := make(map[string]*domainStat)
+	b.loopLock.Lock()
+	b.searchs.Range(func(k, _ interface{}) bool {
+		p, err := parseArgs(k.(string), "")
+		if err != nil {
+			return true
+		}
+		domain := searchDomain(p.query)
+		s, ok := stats[domain]
+		if !ok {
+			s = &domainStat{}
+			stats[domain] = s
+		}
+		s.searchs++
+		if t := b.scrapes[k.(string)].succeeded; t.After(s.succeeded) {
+			s.succeeded = t
+		}
+		return true
+	})
+	failures := make(map[string]int)
+	for d, n := range b.failures {
+		failures[d] = n
+	}
+	b.loopLock.Unlock()
+	if len(stats) == 0 {
+		b.message(user, "no searchs")
+		return
+	}
+
+	var domains []string
+	for d := range stats {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	now := time.Now()
+	var lines []string
+	for _, d := range domains {
+		s := stats[d]
+		line := fmt.Sprintf("%s: %d searchs", d, s.searchs)
+		count, sum := b.metrics.Summary("amazbot_scrape_seconds", "domain", d)
+		if count > 0 {
+			line = fmt.Sprintf("%s, %s avg", line, time.Duration(sum/float64(count)*float64(time.Second)).Round(100*time.Millisecond))
+			captchas := b.metrics.Counter("amazbot_captchas_total", "domain", d)
+			line = fmt.Sprintf("%s, 🧩 %.1f%%", line, captchas/float64(count)*100)
+		}
+		if !s.succeeded.IsZero() {
+			line = fmt.Sprintf("%s, ✅ %s ago", line, now.Sub(s.succeeded).Round(time.Second))
+		}
+		switch n := failures[d]; {
+		case b.paused(d):
+			line = fmt.Sprintf("%s ⏸", line)
+		case !b.scheduled(d, now):
+			line = fmt.Sprintf("%s 💤", line)
+		case n >= circuitFailures:
+			line = fmt.Sprintf("%s 🔴 circuit open (%d failures)", line, n)
+		case n > 0:
+			line = fmt.Sprintf("%s 🟡 %d failures", line, n)
+		default:
+			line = fmt.Sprintf("%s 🟢", line)
+		}
+		lines = append(lines, line)
+	}
+	b.message(user, strings.Join(lines, "\n"))
+}
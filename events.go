@@ -0,0 +1,84 @@
+package amazbot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// Event types published on the bus
+const (
+	SearchAdded       = "search_added"
+	SearchStopped     = "search_stopped"
+	PriceChanged      = "price_changed"
+	PriceDropDetected = "price_drop_detected"
+	ScrapeFailed      = "scrape_failed"
+	CaptchaSolved     = "captcha_solved"
+	SearchAnnounced   = "search_announced"
+	TitleChanged      = "title_changed"
+	ListingChanged    = "listing_changed"
+	// Operational events
+	CircuitOpened = "circuit_opened"
+	CircuitClosed = "circuit_closed"
+	CaptchaFailed = "captcha_failed"
+	StoreFailed   = "store_failed"
+)
+
+// Event is published on the bus, only the fields related to its type are
+// set, title is the previous title of title changed events and changes the
+// traits that changed in listing changed events
+type Event struct {
+	Type     string      `json:"type"`
+	Time     time.Time   `json:"time"`
+	Search   string      `json:"search,omitempty"`
+	Domain   string      `json:"domain,omitempty"`
+	Chat     string      `json:"chat,omitempty"`
+	Item     *api.Item   `json:"item,omitempty"`
+	Alert    *api.Alert  `json:"alert,omitempty"`
+	Previous *[5]float64 `json:"previous,omitempty"`
+	Title    string      `json:"title,omitempty"`
+	Changes  []string    `json:"changes,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	// Seq is the increasing number of the event in the event log
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// Bus dispatches events to its subscribers
+type Bus struct {
+	lock     sync.RWMutex
+	handlers map[string][]func(Event)
+}
+
+// Plugin subscribes to bus events when the bot starts
+type Plugin func(*Bus)
+
+func newBus() *Bus {
+	return &Bus{handlers: make(map[string][]func(Event))}
+}
+
+// Subscribe registers a handler for the event types, all types are
+// subscribed if none is provided.
+func (b *Bus) Subscribe(handler func(Event), types ...string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(types) == 0 {
+		types = []string{""}
+	}
+	for _, t := range types {
+		b.handlers[t] = append(b.handlers[t], handler)
+	}
+}
+
+// Publish calls synchronously the handlers subscribed to the event type
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b.lock.RLock()
+	handlers := append(append([]func(Event){}, b.handlers[e.Type]...), b.handlers[""]...)
+	b.lock.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
@@ -0,0 +1,129 @@
+package amazbot
+
+import (
+	"fmt"
+	"html"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/notify"
+)
+
+// maxPosted limits the alerts tracked per search
+const maxPosted = 20
+
+// Expired modes of the chat settings
+const (
+	expiredNotify = "notify"
+	expiredEdit   = "edit"
+)
+
+// postedAlert is an alert sent to a chat that is tracked until its offer is
+// gone, message id and text are set for telegram chats
+type postedAlert struct {
+	Chat      string  `json:"chat"`
+	MessageID int     `json:"message_id,omitempty"`
+	Text      string  `json:"text,omitempty"`
+	Title     string  `json:"title"`
+	State     int     `json:"state"`
+	Price     float64 `json:"price"`
+}
+
+func (b *bot) posted(search string) []postedAlert {
+	var posted []postedAlert
+	if err := b.db.Get("posted", search, &posted); err != nil {
+		b.log(err)
+	}
+	return posted
+}
+
+// post tracks an alert sent for the search
+func (b *bot) post(search string, p postedAlert) {
+	b.postedLock.Lock()
+	defer b.postedLock.Unlock()
+	posted := append(b.posted(search), p)
+	if len(posted) > maxPosted {
+		posted = posted[len(posted)-maxPosted:]
+	}
+	if err := b.db.Put("posted", search, posted); err != nil {
+		b.log(err)
+	}
+}
+
+// unpost forgets the alerts of a stopped search
+func (b *bot) unpost(e Event) {
+	b.postedLock.Lock()
+	defer b.postedLock.Unlock()
+	if err := b.db.Delete("posted", e.Search); err != nil {
+		b.log(err)
+	}
+}
+
+// expire notifies or edits the alerts of the search whose offer is gone or
+// more expensive than alerted
+func (b *bot) expire(e Event) {
+	b.postedLock.Lock()
+	defer b.postedLock.Unlock()
+	posted := b.posted(e.Search)
+	if len(posted) == 0 {
+		return
+	}
+	var keep []postedAlert
+	for _, p := range posted {
+		if current := e.Item.Prices[p.State]; current > 0 && current <= p.Price+0.005 {
+			keep = append(keep, p)
+			continue
+		}
+		b.expired(p, e.Item.Link)
+	}
+	if len(keep) == len(posted) {
+		return
+	}
+	var err error
+	if len(keep) == 0 {
+		err = b.db.Delete("posted", e.Search)
+	} else {
+		err = b.db.Put("posted", e.Search, keep)
+	}
+	if err != nil {
+		b.log(err)
+	}
+}
+
+// expired notifies the chat that the offer of the alert is gone, telegram
+// posts are edited if the chat prefers it
+func (b *bot) expired(p postedAlert, link string) {
+	settings := b.settings(p.Chat)
+	if settings.Expired == "" {
+		return
+	}
+	emoji := alertData{Settings: settings}.Emoji("expired")
+	if scheme, dest, ok := notify.Split(p.Chat); ok {
+		n, ok := b.notifiers[scheme]
+		if !ok {
+			return
+		}
+		text := fmt.Sprintf("%s Oferta caducada: %s\n%s", emoji, p.Title, link)
+		if err := n.Send(dest, notify.Message{Title: "Oferta caducada", Text: text, Link: link}); err != nil {
+			b.log(err)
+		}
+		return
+	}
+	if settings.Expired == expiredEdit && p.MessageID != 0 {
+		edit := tgbot.EditMessageTextConfig{
+			BaseEdit:              tgbot.BaseEdit{ChannelUsername: p.Chat, MessageID: p.MessageID},
+			Text:                  fmt.Sprintf("%s <b>OFERTA CADUCADA</b>\n\n%s", emoji, p.Text),
+			ParseMode:             tgbot.ModeHTML,
+			DisableWebPagePreview: true,
+		}
+		if err := b.tg.EditMessage(edit); err != nil {
+			b.log(fmt.Errorf("couldn't edit message %d of %s: %w", p.MessageID, p.Chat, err))
+		}
+		return
+	}
+	msg := tgbot.NewMessageToChannel(p.Chat, fmt.Sprintf("%s Oferta caducada: %s", emoji, html.EscapeString(p.Title)))
+	msg.ParseMode = tgbot.ModeHTML
+	msg.ReplyToMessageID = p.MessageID
+	if _, err := b.tg.SendMessage(b.ctx, msg); err != nil {
+		b.log(fmt.Errorf("couldn't send message to %s: %w", p.Chat, err))
+	}
+}
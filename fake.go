@@ -0,0 +1,57 @@
+package amazbot
+
+import (
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+const (
+	// fakeWindow is the history used to find the usual price of an item
+	fakeWindow = 30 * 24 * time.Hour
+	// inflationWindow is how long before a drop an inflated price is looked
+	// for
+	inflationWindow = 7 * 24 * time.Hour
+	// fakeInflation is the minimum raise over the usual price that makes a
+	// price inflated
+	fakeInflation = 0.1
+)
+
+// Fake discount modes of the chat settings
+const (
+	fakeAnnotate = "annotate"
+	fakeSuppress = "suppress"
+)
+
+// fakeDiscount returns true if the price of the state was raised over its
+// usual price shortly before dropping to a price that isn't lower than the
+// usual one, each price point lasts until the next one
+func fakeDiscount(points []pricePoint, state int, price float64, now time.Time) bool {
+	from, inflated := now.Add(-fakeWindow), now.Add(-inflationWindow)
+	var usual, peak float64
+	for i, p := range points {
+		v := p.Prices[state]
+		if v == 0 {
+			continue
+		}
+		end := now
+		if i+1 < len(points) {
+			end = points[i+1].Time
+		}
+		if end.After(from) && p.Time.Before(inflated) && (usual == 0 || v < usual) {
+			usual = v
+		}
+		if end.After(inflated) && v > peak {
+			peak = v
+		}
+	}
+	return usual > 0 && peak > usual*(1+fakeInflation) && price >= usual
+}
+
+// fake returns true if the price alert is a fake discount
+func (b *bot) fake(i api.Item, a api.Alert) bool {
+	if a.Kind != api.PriceAlert {
+		return false
+	}
+	return fakeDiscount(b.history(i.ID, i.Domain), a.State, a.Price, time.Now())
+}
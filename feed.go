@@ -0,0 +1,203 @@
+package amazbot
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// feedTTL is how long an alerted deal stays in the feed
+const feedTTL = 7 * 24 * time.Hour
+
+// feedDeal is an alerted deal published in the feed
+type feedDeal struct {
+	ID      string    `json:"id"`
+	Domain  string    `json:"domain"`
+	Title   string    `json:"title"`
+	Link    string    `json:"link"`
+	Image   string    `json:"image,omitempty"`
+	Kind    string    `json:"kind"`
+	State   int       `json:"state"`
+	Price   float64   `json:"price"`
+	Current float64   `json:"current"`
+	Ref     float64   `json:"ref,omitempty"`
+	Score   float64   `json:"score"`
+	Alerted time.Time `json:"alerted"`
+}
+
+// feed holds the rendered feed and sitemap of the last cycle
+type feed struct {
+	lock    sync.RWMutex
+	json    []byte
+	sitemap []byte
+}
+
+func feedKey(i api.Item, a api.Alert) string {
+	return fmt.Sprintf("%s/%d/%d", historyKey(i.ID, i.Domain), a.Kind, a.State)
+}
+
+// remember adds the alerted deal to the feed
+func (b *bot) remember(e Event) {
+	i, a := *e.Item, *e.Alert
+	d := feedDeal{
+		ID:      i.ID,
+		Domain:  i.Domain,
+		Title:   i.Title,
+		Link:    b.affiliate(i.Link, i.Domain),
+		Image:   i.Image,
+		Kind:    newAlertData(i, a, "").Kind,
+		State:   a.State,
+		Price:   a.Price,
+		Current: a.Price,
+		Ref:     a.Ref,
+		Score:   dealScore(i, a),
+		Alerted: time.Now().UTC(),
+	}
+	if err := b.db.Put("feed", feedKey(i, a), d); err != nil {
+		b.log(err)
+	}
+}
+
+// current returns the last price of the deal, it is zero if the item isn't
+// searched anymore or the offer is gone
+func (d feedDeal) current(items map[string]api.Item) float64 {
+	i, ok := items[historyKey(d.ID, d.Domain)]
+	if !ok || d.State < 0 || d.State >= len(i.Prices) {
+		return 0
+	}
+	if d.Kind == "tradein" {
+		return i.TradeIn
+	}
+	return i.Prices[d.State]
+}
+
+// valid returns true if the current price is still at the alerted level
+func (d feedDeal) valid() bool {
+	if d.Current <= 0 {
+		return false
+	}
+	if d.Kind == "tradein" {
+		return d.Current >= d.Price
+	}
+	return d.Current <= d.Price
+}
+
+// refreshFeed renders the deals still valid with the last prices and removes
+// the rest
+func (b *bot) refreshFeed() {
+	items := make(map[string]api.Item)
+	b.searchs.Range(func(_ interface{}, v interface{}) bool {
+		if i, ok := v.(api.Item); ok {
+			items[historyKey(i.ID, i.Domain)] = i
+		}
+		return true
+	})
+	keys, err := b.db.Keys("feed")
+	if err != nil {
+		b.log(err)
+		return
+	}
+	now := time.Now()
+	deals := []feedDeal{}
+	for _, k := range keys {
+		var d feedDeal
+		if err := b.db.Get("feed", k, &d); err != nil {
+			b.log(err)
+			continue
+		}
+		d.Current = d.current(items)
+		if !d.valid() || now.Sub(d.Alerted) > feedTTL {
+			if err := b.db.Delete("feed", k); err != nil {
+				b.log(err)
+			}
+			continue
+		}
+		deals = append(deals, d)
+	}
+	sort.Slice(deals, func(i, j int) bool { return deals[i].Alerted.After(deals[j].Alerted) })
+	data, err := json.Marshal(struct {
+		Updated time.Time  `json:"updated"`
+		Deals   []feedDeal `json:"deals"`
+	}{now.UTC(), deals})
+	if err != nil {
+		b.log(fmt.Errorf("couldn't encode feed: %w", err))
+		return
+	}
+	sitemap, err := b.sitemap(items)
+	if err != nil {
+		b.log(err)
+		return
+	}
+	b.feed.lock.Lock()
+	defer b.feed.lock.Unlock()
+	b.feed.json = data
+	b.feed.sitemap = sitemap
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemap lists the permalink pages of the deals still valid
+func (b *bot) sitemap(items map[string]api.Item) ([]byte, error) {
+	if b.baseURL == "" {
+		return nil, nil
+	}
+	codes, err := b.db.Keys("deals")
+	if err != nil {
+		return nil, err
+	}
+	var urls []sitemapURL
+	for _, code := range codes {
+		var d deal
+		if err := b.db.Get("deals", code, &d); err != nil {
+			return nil, err
+		}
+		fd := feedDeal{ID: d.Item.ID, Domain: d.Item.Domain, State: d.Alert.State, Price: d.Alert.Price}
+		if d.Alert.Kind == api.TradeInAlert {
+			fd.Kind = "tradein"
+		}
+		fd.Current = fd.current(items)
+		if !fd.valid() {
+			continue
+		}
+		urls = append(urls, sitemapURL{
+			Loc:     fmt.Sprintf("%s/d/%s", b.baseURL, code),
+			LastMod: d.Created.Format("2006-01-02"),
+		})
+	}
+	data, err := xml.MarshalIndent(struct {
+		XMLName xml.Name     `xml:"urlset"`
+		XMLNS   string       `xml:"xmlns,attr"`
+		URLs    []sitemapURL `xml:"url"`
+	}{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: urls}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't encode sitemap: %w", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// feedHandler serves the json feed (/feed.json) and the sitemap
+// (/sitemap.xml) rendered in the last cycle
+func (b *bot) feedHandler(w http.ResponseWriter, r *http.Request) {
+	b.feed.lock.RLock()
+	defer b.feed.lock.RUnlock()
+	data, kind := b.feed.json, "application/json"
+	if strings.HasSuffix(r.URL.Path, ".xml") {
+		data, kind = b.feed.sitemap, "application/xml"
+	}
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", kind)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,155 @@
+package amazbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// chatFilter discards alerts of a chat, titles must contain one of the allow
+// keywords if there are any and none of the block keywords, brand and seller
+// of the offer can't contain any of the blacklisted ones and price drops
+// must have at least the minimum discount
+type chatFilter struct {
+	Allow       []string `json:"allow,omitempty"`
+	Block       []string `json:"block,omitempty"`
+	Brands      []string `json:"brands,omitempty"`
+	Sellers     []string `json:"sellers,omitempty"`
+	MinDiscount float64  `json:"min_discount,omitempty"`
+}
+
+func filterKey(chat string) string {
+	return fmt.Sprintf("%s/filter", chat)
+}
+
+func (b *bot) filter(chat string) chatFilter {
+	var f chatFilter
+	if err := b.db.Get("config", filterKey(chat), &f); err != nil {
+		b.log(err)
+	}
+	return f
+}
+
+// allowed returns true if the alert passes the filter
+func (f chatFilter) allowed(i api.Item, a api.Alert) bool {
+	if a.Kind == api.PriceAlert && f.MinDiscount > 0 && dealScore(i, a) < f.MinDiscount {
+		return false
+	}
+	title := strings.ToLower(i.Title)
+	for _, k := range f.Block {
+		if strings.Contains(title, k) {
+			return false
+		}
+	}
+	brand := strings.ToLower(i.Brand)
+	for _, k := range f.Brands {
+		if brand != "" && strings.Contains(brand, k) {
+			return false
+		}
+	}
+	if a.Kind != api.TradeInAlert {
+		seller := strings.ToLower(i.Sellers[a.State])
+		for _, k := range f.Sellers {
+			if seller != "" && strings.Contains(seller, k) {
+				return false
+			}
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, k := range f.Allow {
+		if strings.Contains(title, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f chatFilter) String() string {
+	var lines []string
+	if len(f.Allow) > 0 {
+		lines = append(lines, fmt.Sprintf("allow: %s", strings.Join(f.Allow, ", ")))
+	}
+	if len(f.Block) > 0 {
+		lines = append(lines, fmt.Sprintf("block: %s", strings.Join(f.Block, ", ")))
+	}
+	if len(f.Brands) > 0 {
+		lines = append(lines, fmt.Sprintf("blocked brands: %s", strings.Join(f.Brands, ", ")))
+	}
+	if len(f.Sellers) > 0 {
+		lines = append(lines, fmt.Sprintf("blocked sellers: %s", strings.Join(f.Sellers, ", ")))
+	}
+	if f.MinDiscount > 0 {
+		lines = append(lines, fmt.Sprintf("minimum discount: %.0f%%", f.MinDiscount))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// filterCommand handles /filter [allow|block|brand|seller|remove <keyword>|discount <n>|off]
+func (b *bot) filterCommand(user int, chat, args string) {
+	f := b.filter(chat)
+	split := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	keyword := ""
+	if len(split) > 1 {
+		keyword = strings.ToLower(strings.TrimSpace(split[1]))
+	}
+	switch {
+	case split[0] == "":
+		if text := f.String(); text != "" {
+			b.message(user, fmt.Sprintf("filter for %s:\n%s", chat, text))
+		} else {
+			b.message(user, fmt.Sprintf("no filter for %s", chat))
+		}
+		return
+	case split[0] == "off":
+		f = chatFilter{}
+	case keyword == "":
+		b.message(user, "usage: /filter [allow|block|brand|seller|remove <keyword>|discount <n>|off]")
+		return
+	case split[0] == "discount":
+		n, err := strconv.ParseFloat(strings.TrimSuffix(keyword, "%"), 64)
+		if err != nil || n < 0 {
+			b.message(user, "usage: /filter discount <n>")
+			return
+		}
+		f.MinDiscount = n
+	case split[0] == "allow":
+		f.Allow = append(remove(f.Allow, keyword), keyword)
+	case split[0] == "block":
+		f.Block = append(remove(f.Block, keyword), keyword)
+	case split[0] == "brand":
+		f.Brands = append(remove(f.Brands, keyword), keyword)
+	case split[0] == "seller":
+		f.Sellers = append(remove(f.Sellers, keyword), keyword)
+	case split[0] == "remove":
+		f.Allow = remove(f.Allow, keyword)
+		f.Block = remove(f.Block, keyword)
+		f.Brands = remove(f.Brands, keyword)
+		f.Sellers = remove(f.Sellers, keyword)
+	default:
+		b.message(user, "usage: /filter [allow|block|brand|seller|remove <keyword>|discount <n>|off]")
+		return
+	}
+	if err := b.db.Put("config", filterKey(chat), f); err != nil {
+		b.log(err)
+		return
+	}
+	if text := f.String(); text != "" {
+		b.message(user, fmt.Sprintf("filter updated for %s:\n%s", chat, text))
+	} else {
+		b.message(user, fmt.Sprintf("filter disabled for %s", chat))
+	}
+}
+
+func remove(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
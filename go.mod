@@ -5,8 +5,12 @@ go 1.16
 require (
 	github.com/PuerkitoBio/goquery v1.6.1
 	github.com/boltdb/bolt v1.3.1
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
 	golang.org/x/net v0.0.0-20210502030024-e5908800b52b
+	golang.org/x/sys v0.0.0-20210423082822-04245dca01da
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
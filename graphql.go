@@ -0,0 +1,273 @@
+package amazbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/graphql"
+)
+
+const (
+	// maxRecentEvents is the number of bus events kept for the graphql api
+	maxRecentEvents = 1000
+	// maxPage is the maximum number of results of a graphql list
+	maxPage = 1000
+)
+
+// eventLog keeps the recent events of the bus in memory
+type eventLog struct {
+	lock     sync.Mutex
+	seq      uint64
+	events   []Event
+	watchers map[chan Event]struct{}
+}
+
+func (l *eventLog) add(e Event) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.seq++
+	e.Seq = l.seq
+	l.events = append(l.events, e)
+	if len(l.events) > maxRecentEvents {
+		l.events = l.events[len(l.events)-maxRecentEvents:]
+	}
+	for c := range l.watchers {
+		// slow watchers lose events instead of blocking the bus
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+func (l *eventLog) list() []Event {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]Event{}, l.events...)
+}
+
+// gqlSearch is a search of the graphql api
+type gqlSearch struct {
+	ID    string    `json:"id"`
+	Chat  string    `json:"chat"`
+	Query string    `json:"query"`
+	Note  string    `json:"note,omitempty"`
+	Item  *api.Item `json:"item"`
+}
+
+// page returns the first results after the cursor, the cursor of a result
+// is returned by the key function
+func page(n int, key func(i int) string, after string, first int) (int, int) {
+	if first <= 0 || first > maxPage {
+		first = maxPage
+	}
+	start := 0
+	if after != "" {
+		start = sort.Search(n, func(i int) bool { return key(i) > after })
+	}
+	end := start + first
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+// visible returns true if the search or event chat can be queried by the
+// user, the admin can query all of them
+func (b *bot) visible(user int, chat string) bool {
+	if user == b.admin {
+		return true
+	}
+	own, ok := b.userChat(user)
+	if !ok {
+		return false
+	}
+	for _, d := range destinations(chat) {
+		if d.chat == own {
+			return true
+		}
+	}
+	return false
+}
+
+// searchsOf returns the searchs visible by the user sorted by id
+func (b *bot) searchsOf(user int) []gqlSearch {
+	var ss []gqlSearch
+	b.searchs.Range(func(k, v interface{}) bool {
+		p, err := parseArgs(k.(string), "")
+		if err != nil || !b.visible(user, p.chat) {
+			return true
+		}
+		s := gqlSearch{ID: k.(string), Chat: p.chat, Query: p.query}
+		if i, ok := v.(api.Item); ok {
+			s.Item = &i
+		}
+		ss = append(ss, s)
+		return true
+	})
+	sort.Slice(ss, func(i, j int) bool { return ss[i].ID < ss[j].ID })
+	return ss
+}
+
+// schema returns the graphql resolvers of the data visible by the user
+func (b *bot) schema(user int) graphql.Schema {
+	return graphql.Schema{
+		// searches(search, domain, first, after) lists the searchs whose
+		// id contains the search text
+		"searches": func(args graphql.Args) (interface{}, error) {
+			search, domain := strings.ToLower(args.String("search")), args.String("domain")
+			ss := []gqlSearch{}
+			for _, s := range b.searchsOf(user) {
+				if search != "" && !strings.Contains(strings.ToLower(s.ID), search) {
+					continue
+				}
+				if domain != "" && searchDomain(s.Query) != domain {
+					continue
+				}
+				ss = append(ss, s)
+			}
+			start, end := page(len(ss), func(i int) string { return ss[i].ID }, args.String("after"), args.Int("first", 0))
+			for i := start; i < end; i++ {
+				ss[i].Note = b.note(ss[i].ID)
+			}
+			return ss[start:end], nil
+		},
+		// item(id) returns the item ASIN.domain of any visible search
+		"item": func(args graphql.Args) (interface{}, error) {
+			i, ok := b.visibleItem(user, args.String("id"))
+			if !ok {
+				return nil, nil
+			}
+			return i, nil
+		},
+		// history(item, since, until, first, currency) returns the price
+		// points of a visible item, optionally normalized to a currency
+		"history": func(args graphql.Args) (interface{}, error) {
+			i, ok := b.visibleItem(user, args.String("item"))
+			if !ok {
+				return []pricePoint{}, nil
+			}
+			since, until, err := timeRange(args)
+			if err != nil {
+				return nil, err
+			}
+			points := b.history(i.ID, i.Domain)
+			if c := args.String("currency"); c != "" {
+				points = b.normalize(points, i.Domain, c)
+			}
+			filtered := []pricePoint{}
+			for _, p := range points {
+				if p.Time.After(since) && (until.IsZero() || p.Time.Before(until)) {
+					filtered = append(filtered, p)
+				}
+			}
+			if first := args.Int("first", 0); first > 0 && first < len(filtered) {
+				filtered = filtered[:first]
+			}
+			return filtered, nil
+		},
+		// events(type, search, first, after) returns the recent events of
+		// the visible searchs after the time cursor
+		"events": func(args graphql.Args) (interface{}, error) {
+			typ, search := args.String("type"), args.String("search")
+			es := []Event{}
+			for _, e := range b.events.list() {
+				if typ != "" && e.Type != typ {
+					continue
+				}
+				if search != "" && e.Search != search {
+					continue
+				}
+				if !b.visible(user, eventChat(e)) {
+					continue
+				}
+				es = append(es, e)
+			}
+			cursor := func(i int) string { return es[i].Time.Format(time.RFC3339Nano) }
+			start, end := page(len(es), cursor, args.String("after"), args.Int("first", 0))
+			return es[start:end], nil
+		},
+	}
+}
+
+// visibleItem returns the item ASIN.domain of a search visible by the user
+func (b *bot) visibleItem(user int, id string) (api.Item, bool) {
+	for _, s := range b.searchsOf(user) {
+		if s.Item != nil && historyKey(s.Item.ID, s.Item.Domain) == id {
+			return *s.Item, true
+		}
+	}
+	return api.Item{}, false
+}
+
+// eventChat returns the chat of the event or of its search
+func eventChat(e Event) string {
+	if e.Chat != "" {
+		return e.Chat
+	}
+	return strings.SplitN(e.Search, "/", 2)[0]
+}
+
+// requestUser returns the user of the api token of the authorization header
+// or the token query parameter, or of the init data of the mini app
+func (b *bot) requestUser(r *http.Request) (int, bool) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "tma ") {
+		return b.initDataUser(r)
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return b.tokenUser(token)
+}
+
+// timeRange parses the since and until RFC 3339 arguments
+func timeRange(args graphql.Args) (time.Time, time.Time, error) {
+	var times [2]time.Time
+	for i, name := range []string{"since", "until"} {
+		v := args.String(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return times[0], times[1], fmt.Errorf("invalid %s %q, expected a RFC 3339 time", name, v)
+		}
+		times[i] = t
+	}
+	return times[0], times[1], nil
+}
+
+// graphqlHandler executes the graphql queries of dashboards authenticated
+// with the api token of an user
+func (b *bot) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	user, ok := b.requestUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req, err := graphql.ReadRequest(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		if err := json.NewEncoder(w).Encode(graphql.Response{Errors: []graphql.Error{{Message: err.Error()}}}); err != nil {
+			log.Println(fmt.Errorf("couldn't write graphql response: %w", err))
+		}
+		return
+	}
+	writeJSON(w, b.schema(user).Execute(req))
+}
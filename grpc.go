@@ -0,0 +1,281 @@
+package amazbot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// grpcService is the service defined in proto/amazbot.proto
+type grpcService interface {
+	AddSearch(context.Context, *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+	RemoveSearch(context.Context, *wrapperspb.StringValue) (*emptypb.Empty, error)
+	ListSearches(context.Context, *emptypb.Empty) (*structpb.ListValue, error)
+	StreamEvents(*emptypb.Empty, grpc.ServerStream) error
+	NextJob(context.Context, *emptypb.Empty) (*wrapperspb.BytesValue, error)
+	CompleteJob(context.Context, *wrapperspb.BytesValue) (*emptypb.Empty, error)
+}
+
+type grpcServer struct {
+	bot  *bot
+	lock sync.Mutex
+	subs map[chan *structpb.Struct]struct{}
+}
+
+// serveGRPC serves the grpc service until the context is done, the token is
+// required because the service controls the searchs and the workers
+func (b *bot) serveGRPC(ctx context.Context, addr, token, certFile, keyFile string) error {
+	if token == "" {
+		return fmt.Errorf("the grpc service requires a token")
+	}
+	if certFile == "" {
+		log.Printf("warning: grpc service on %s without tls, the token is sent in plain text\n", addr)
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("couldn't listen grpc on %s: %w", addr, err)
+	}
+	var opts []grpc.ServerOption
+	if certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("couldn't load grpc tls credentials: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	opts = append(opts,
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := grpcAuth(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := grpcAuth(ss.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	b.grpc = &grpcServer{
+		bot:  b,
+		subs: make(map[chan *structpb.Struct]struct{}),
+	}
+	b.bus.Subscribe(func(e Event) {
+		if err := b.grpc.broadcast(e); err != nil {
+			b.log(err)
+		}
+	}, PriceChanged)
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&grpcServiceDesc, b.grpc)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		<-ctx.Done()
+		srv.Stop()
+	}()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		if err := srv.Serve(lis); err != nil {
+			b.log(fmt.Errorf("grpc server failed: %w", err))
+		}
+	}()
+	return nil
+}
+
+func grpcAuth(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(v, "Bearer ")), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid token")
+}
+
+func (s *grpcServer) AddSearch(_ context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	parsed, err := parseArgs(in.GetValue(), "")
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if parsed.chat == "" || parsed.query == "" {
+		return nil, status.Error(codes.InvalidArgument, "search must have the format chat/ASIN.domain")
+	}
+	// the service isn't bound to a user, only the quota of the tenant of the
+	// chat can fail
+	if err := s.bot.add(parsed); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return wrapperspb.String(parsed.id), nil
+}
+
+func (s *grpcServer) RemoveSearch(_ context.Context, in *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	parsed, err := parseArgs(in.GetValue(), "")
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, ok := s.bot.searchs.Load(parsed.id); !ok {
+		return nil, status.Error(codes.NotFound, parsed.id)
+	}
+	s.bot.stop(parsed)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) ListSearches(context.Context, *emptypb.Empty) (*structpb.ListValue, error) {
+	var keys []string
+	s.bot.searchs.Range(func(k interface{}, _ interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	sort.Strings(keys)
+	list := &structpb.ListValue{}
+	for _, k := range keys {
+		list.Values = append(list.Values, structpb.NewStringValue(k))
+	}
+	return list, nil
+}
+
+func (s *grpcServer) StreamEvents(_ *emptypb.Empty, stream grpc.ServerStream) error {
+	c := make(chan *structpb.Struct, 100)
+	s.lock.Lock()
+	s.subs[c] = struct{}{}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.subs, c)
+		s.lock.Unlock()
+	}()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-c:
+			if err := stream.SendMsg(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// broadcast sends the event to all the subscribed streams
+func (s *grpcServer) broadcast(event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal grpc event: %w", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("couldn't unmarshal grpc event: %w", err)
+	}
+	e, err := structpb.NewStruct(m)
+	if err != nil {
+		return fmt.Errorf("couldn't create grpc event: %w", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for c := range s.subs {
+		select {
+		case c <- e:
+		default:
+			// Drop events for slow consumers
+		}
+	}
+	return nil
+}
+
+// The service descriptor is written by hand instead of generated with protoc
+// because it only uses well-known protobuf types.
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: "amazbot.Amazbot",
+	HandlerType: (*grpcService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddSearch",
+			Handler: grpcUnary("AddSearch", func() proto.Message { return &wrapperspb.StringValue{} },
+				func(s grpcService, ctx context.Context, in proto.Message) (interface{}, error) {
+					return s.AddSearch(ctx, in.(*wrapperspb.StringValue))
+				}),
+		},
+		{
+			MethodName: "RemoveSearch",
+			Handler: grpcUnary("RemoveSearch", func() proto.Message { return &wrapperspb.StringValue{} },
+				func(s grpcService, ctx context.Context, in proto.Message) (interface{}, error) {
+					return s.RemoveSearch(ctx, in.(*wrapperspb.StringValue))
+				}),
+		},
+		{
+			MethodName: "ListSearches",
+			Handler: grpcUnary("ListSearches", func() proto.Message { return &emptypb.Empty{} },
+				func(s grpcService, ctx context.Context, in proto.Message) (interface{}, error) {
+					return s.ListSearches(ctx, in.(*emptypb.Empty))
+				}),
+		},
+		{
+			MethodName: "NextJob",
+			Handler: grpcUnary("NextJob", func() proto.Message { return &emptypb.Empty{} },
+				func(s grpcService, ctx context.Context, in proto.Message) (interface{}, error) {
+					return s.NextJob(ctx, in.(*emptypb.Empty))
+				}),
+		},
+		{
+			MethodName: "CompleteJob",
+			Handler: grpcUnary("CompleteJob", func() proto.Message { return &wrapperspb.BytesValue{} },
+				func(s grpcService, ctx context.Context, in proto.Message) (interface{}, error) {
+					return s.CompleteJob(ctx, in.(*wrapperspb.BytesValue))
+				}),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "StreamEvents",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := &emptypb.Empty{}
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(grpcService).StreamEvents(in, stream)
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/amazbot.proto",
+}
+
+type grpcHandler = func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error)
+
+func grpcUnary(method string, newIn func() proto.Message, call func(grpcService, context.Context, proto.Message) (interface{}, error)) grpcHandler {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := newIn()
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(grpcService), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fmt.Sprintf("/amazbot.Amazbot/%s", method),
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(grpcService), ctx, req.(proto.Message))
+		}
+		return interceptor(ctx, in, info, handler)
+	}
+}
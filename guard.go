@@ -0,0 +1,98 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// startSearchLoop launches the search loop, a running loop is cancelled
+func (b *bot) startSearchLoop(ctx context.Context) {
+	b.loopLock.Lock()
+	defer b.loopLock.Unlock()
+	if b.loopCancel != nil {
+		b.loopCancel()
+	}
+	ctx, b.loopCancel = context.WithCancel(ctx)
+	b.current = ""
+	b.wg.Add(1)
+	go func() {
+		defer log.Println("search routine finished")
+		defer b.wg.Done()
+		b.searchLoop(ctx)
+	}()
+}
+
+// scraping sets the search being scraped, empty when none, cancelled loops
+// are ignored
+func (b *bot) scraping(ctx context.Context, key string) {
+	b.loopLock.Lock()
+	defer b.loopLock.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	b.current = key
+	b.started = time.Now()
+}
+
+// guard monitors goroutines, heap usage and stuck scrapes alerting the admin
+// when the thresholds are exceeded
+func (b *bot) guard(ctx context.Context, cfg *Config) {
+	if cfg.MaxGoroutines <= 0 && cfg.MaxHeap <= 0 && cfg.MaxScrape <= 0 {
+		return
+	}
+	b.wg.Add(1)
+	go func() {
+		defer log.Println("guard routine finished")
+		defer b.wg.Done()
+		// Each alert is sent once until the value is back under the threshold
+		alerted := make(map[string]bool)
+		alert := func(kind string, exceeded bool, msg string) {
+			if exceeded && !alerted[kind] {
+				b.log(fmt.Sprintf("guard: %s", msg))
+			}
+			alerted[kind] = exceeded
+		}
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if cfg.MaxGoroutines > 0 {
+				n := runtime.NumGoroutine()
+				alert("goroutines", n > cfg.MaxGoroutines, fmt.Sprintf("%d goroutines running, max %d", n, cfg.MaxGoroutines))
+			}
+			if cfg.MaxHeap > 0 {
+				var m runtime.MemStats
+				runtime.ReadMemStats(&m)
+				heap := int(m.HeapAlloc / 1024 / 1024)
+				exceeded := heap > cfg.MaxHeap
+				alert("heap", exceeded, fmt.Sprintf("heap usage %dMB, max %dMB", heap, cfg.MaxHeap))
+				if exceeded {
+					debug.FreeOSMemory()
+				}
+			}
+			if cfg.MaxScrape > 0 {
+				b.loopLock.Lock()
+				key, elapsed := b.current, time.Since(b.started)
+				b.loopLock.Unlock()
+				stuck := key != "" && elapsed > cfg.MaxScrape
+				msg := fmt.Sprintf("scrape of %s running for %s, max %s", key, elapsed.Round(time.Second), cfg.MaxScrape)
+				if stuck && cfg.GuardRestart {
+					msg = fmt.Sprintf("%s, restarting search loop", msg)
+				}
+				alert("scrape", stuck, msg)
+				if stuck && cfg.GuardRestart {
+					b.startSearchLoop(ctx)
+					alerted["scrape"] = false
+				}
+			}
+		}
+	}()
+}
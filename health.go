@@ -0,0 +1,163 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// healthInterval is how often the health report is sent to the admin
+const healthInterval = 24 * time.Hour
+
+// healthSample are the metrics of the scrapes when a health report is sent,
+// the next report shows the difference with them
+type healthSample struct {
+	Time     time.Time          `json:"time"`
+	Searchs  int                `json:"searchs"`
+	Cycle    time.Duration      `json:"cycle"`
+	Scrapes  map[string]float64 `json:"scrapes"`
+	Errors   map[string]float64 `json:"errors"`
+	Captchas float64            `json:"captchas"`
+}
+
+// sampleHealth reads the current metrics of the domains of the searchs
+func (b *bot) sampleHealth(now time.Time) healthSample {
+	s := healthSample{Time: now, Scrapes: make(map[string]float64), Errors: make(map[string]float64)}
+	b.searchs.Range(func(k, _ interface{}) bool {
+		s.Searchs++
+		p, err := parseArgs(k.(string), "")
+		if err != nil {
+			return true
+		}
+		domain := searchDomain(p.query)
+		if _, ok := s.Scrapes[domain]; ok {
+			return true
+		}
+		n, _ := b.metrics.Summary("amazbot_scrape_seconds", "domain", domain)
+		s.Scrapes[domain] = float64(n)
+		s.Errors[domain] = b.metrics.Counter("amazbot_scrape_errors_total", "domain", domain)
+		s.Captchas += b.metrics.Counter("amazbot_captchas_total", "domain", domain)
+		return true
+	})
+	s.Cycle = b.elapsed
+	return s
+}
+
+// since returns the increase of a counter, the metrics restart with the bot
+func since(current, previous float64) float64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// healthReport renders the health of the scrapes since the previous sample
+func (b *bot) healthReport(ctx context.Context, current, previous healthSample) string {
+	lines := []string{"🩺 health report"}
+	if !previous.Time.IsZero() {
+		lines[0] = fmt.Sprintf("🩺 health report of the last %s", current.Time.Sub(previous.Time).Round(time.Hour))
+	}
+	line := fmt.Sprintf("👀 %d searchs", current.Searchs)
+	if !previous.Time.IsZero() {
+		line = fmt.Sprintf("%s (%+d)", line, current.Searchs-previous.Searchs)
+	}
+	lines = append(lines, line)
+
+	line = fmt.Sprintf("⏱ cycle %s", current.Cycle.Round(time.Second))
+	if previous.Cycle > 0 {
+		line = fmt.Sprintf("%s (%s before)", line, previous.Cycle.Round(time.Second))
+	}
+	lines = append(lines, line)
+
+	var domains []string
+	for d := range current.Scrapes {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	var total float64
+	var errs []string
+	for _, d := range domains {
+		scrapes := since(current.Scrapes[d], previous.Scrapes[d])
+		failed := since(current.Errors[d], previous.Errors[d])
+		total += scrapes
+		if scrapes == 0 {
+			continue
+		}
+		errs = append(errs, fmt.Sprintf("%s %.1f%% (%.0f/%.0f)", d, failed/scrapes*100, failed, scrapes))
+	}
+	if len(errs) > 0 {
+		lines = append(lines, fmt.Sprintf("❌ errors: %s", strings.Join(errs, ", ")))
+	}
+	if total > 0 {
+		captchas := since(current.Captchas, previous.Captchas)
+		lines = append(lines, fmt.Sprintf("🧩 captchas %.1f%% (%.0f/%.0f)", captchas/total*100, captchas, total))
+	}
+
+	b.loopLock.Lock()
+	proxy := b.proxy
+	b.loopLock.Unlock()
+	if proxy != "" {
+		if err := checkProxy(ctx, proxy); err != nil {
+			lines = append(lines, fmt.Sprintf("🌐 proxy: %s", err))
+		} else {
+			lines = append(lines, "🌐 proxy ok")
+		}
+	}
+	if size, err := b.db.Size(); err != nil {
+		b.log(err)
+	} else {
+		lines = append(lines, fmt.Sprintf("💾 store %.1f MB", float64(size)/(1<<20)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reportHealth sends the health report to the admin once per interval
+func (b *bot) reportHealth(ctx context.Context, now time.Time) {
+	var previous healthSample
+	if err := b.db.Get("config", "health", &previous); err != nil {
+		b.log(err)
+		return
+	}
+	if !previous.Time.IsZero() && now.Sub(previous.Time) < healthInterval {
+		return
+	}
+	current := b.sampleHealth(now)
+	if !previous.Time.IsZero() {
+		b.messageOpts(ctx, b.admin, b.healthReport(ctx, current, previous), false, nil)
+	}
+	if err := b.db.Put("config", "health", current); err != nil {
+		b.log(err)
+	}
+}
+
+// healthReports checks every hour if the health report must be sent, the
+// first sample is only stored
+func (b *bot) healthReports(ctx context.Context) {
+	b.wg.Add(1)
+	go func() {
+		defer log.Println("health routine finished")
+		defer b.wg.Done()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			b.reportHealth(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// healthCommand handles /health, it shows the report since the last one
+func (b *bot) healthCommand(ctx context.Context, r request) {
+	var previous healthSample
+	if err := b.db.Get("config", "health", &previous); err != nil {
+		b.log(err)
+	}
+	b.messageOpts(ctx, r.user, b.healthReport(ctx, b.sampleHealth(time.Now()), previous), false, nil)
+}
@@ -0,0 +1,117 @@
+package amazbot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// maxHistory is the maximum number of price points kept per item
+const maxHistory = 1000
+
+// pricePoint are the prices of an item at a time, FX is the day of the
+// exchange rates stored in the fx bucket when they were observed
+type pricePoint struct {
+	Time   time.Time  `json:"time"`
+	Prices [5]float64 `json:"prices"`
+	FX     string     `json:"fx,omitempty"`
+}
+
+// historyKey identifies an item in the history bucket, searchs of the same
+// item in several chats share it
+func historyKey(id, domain string) string {
+	return fmt.Sprintf("%s.%s", id, domain)
+}
+
+func (b *bot) history(id, domain string) []pricePoint {
+	var points []pricePoint
+	if err := b.db.Get("history", historyKey(id, domain), &points); err != nil {
+		b.log(err)
+	}
+	return points
+}
+
+// record adds the new prices of a price changed event to the item history
+func (b *bot) record(e Event) {
+	b.historyLock.Lock()
+	defer b.historyLock.Unlock()
+	points := b.history(e.Item.ID, e.Item.Domain)
+	if n := len(points); n > 0 && points[n-1].Prices == e.Item.Prices {
+		return
+	}
+	now := time.Now().UTC()
+	points = append(points, pricePoint{Time: now, Prices: e.Item.Prices, FX: b.fxDay(now)})
+	if len(points) > maxHistory {
+		points = points[len(points)-maxHistory:]
+	}
+	if err := b.db.Put("history", historyKey(e.Item.ID, e.Item.Domain), points); err != nil {
+		b.log(err)
+	}
+}
+
+// fxDay stores the exchange rates of the day once and returns the day, an
+// empty string is returned if they aren't available
+func (b *bot) fxDay(now time.Time) string {
+	day := now.Format("2006-01-02")
+	key := fmt.Sprintf("fx/%s", day)
+	if _, ok := b.cache.Get(key); ok {
+		return day
+	}
+	if len(b.rates(day)) == 0 {
+		rates, err := b.fx.Rates()
+		if err != nil {
+			log.Println(fmt.Errorf("couldn't get exchange rates: %w", err))
+			return ""
+		}
+		if err := b.db.Put("fx", day, rates); err != nil {
+			b.log(err)
+			return ""
+		}
+	}
+	b.cache.Set(key, true, 24*time.Hour)
+	return day
+}
+
+// rates returns the euro reference rates stored for the day
+func (b *bot) rates(day string) map[string]float64 {
+	var rates map[string]float64
+	if err := b.db.Get("fx", day, &rates); err != nil {
+		b.log(err)
+	}
+	return rates
+}
+
+// normalize converts the history of the domain to the currency with the
+// rates of the day of each point, points observed before the rates were
+// stored use the current ones
+func (b *bot) normalize(points []pricePoint, domain, currency string) []pricePoint {
+	from, to := api.Currency(domain), strings.ToUpper(currency)
+	if from == to {
+		return points
+	}
+	days := make(map[string]map[string]float64)
+	var normalized []pricePoint
+	for _, p := range points {
+		rates, ok := days[p.FX]
+		if !ok {
+			if p.FX != "" {
+				rates = b.rates(p.FX)
+			}
+			if len(rates) == 0 {
+				rates, _ = b.fx.Rates()
+			}
+			days[p.FX] = rates
+		}
+		if rates[from] == 0 || rates[to] == 0 {
+			continue
+		}
+		for i, v := range p.Prices {
+			p.Prices[i] = v / rates[from] * rates[to]
+		}
+		normalized = append(normalized, p)
+	}
+	return normalized
+}
@@ -0,0 +1,41 @@
+package amazbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// runHook returns a bus handler that executes the hook with the item json on
+// stdin and the alert details as environment variables.
+func (b *bot) runHook(ctx context.Context, path string) func(Event) {
+	return func(e Event) {
+		data, err := json.Marshal(e.Item)
+		if err != nil {
+			b.log(fmt.Errorf("hook: couldn't marshal item: %w", err))
+			return
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, path)
+			cmd.Stdin = bytes.NewReader(data)
+			cmd.Env = append(os.Environ(),
+				fmt.Sprintf("AMAZBOT_SEARCH=%s", e.Search),
+				fmt.Sprintf("AMAZBOT_CHAT=%s", e.Chat),
+				fmt.Sprintf("AMAZBOT_KIND=%d", e.Alert.Kind),
+				fmt.Sprintf("AMAZBOT_STATE=%d", e.Alert.State),
+				fmt.Sprintf("AMAZBOT_PRICE=%.2f", e.Alert.Price),
+			)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				b.log(fmt.Errorf("hook: %s failed: %w: %s", path, err, out))
+			}
+		}()
+	}
+}
@@ -0,0 +1,215 @@
+package amazbot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// shortLink is a link of an alert redirected through the bot to count clicks
+type shortLink struct {
+	URL      string    `json:"url"`
+	Chat     string    `json:"chat"`
+	Item     string    `json:"item"`
+	Title    string    `json:"title"`
+	Domain   string    `json:"domain"`
+	Category []string  `json:"category,omitempty"`
+	Price    float64   `json:"price"`
+	Tagged   bool      `json:"tagged,omitempty"`
+	Variant  string    `json:"variant,omitempty"`
+	Created  time.Time `json:"created"`
+	Clicks   int       `json:"clicks"`
+}
+
+// linkStats are the posts and clicks counted for a chat and template variant
+type linkStats struct {
+	Sent   int `json:"sent"`
+	Clicks int `json:"clicks"`
+}
+
+func statsKey(chat, variant string) string {
+	return fmt.Sprintf("ab/%s/%s", chat, variant)
+}
+
+// count adds posts and clicks to the stats of the chat variant
+func (b *bot) count(chat, variant string, sent, clicks int) {
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+	var s linkStats
+	key := statsKey(chat, variant)
+	if err := b.db.Get("stats", key, &s); err != nil {
+		b.log(err)
+		return
+	}
+	s.Sent += sent
+	s.Clicks += clicks
+	if err := b.db.Put("stats", key, s); err != nil {
+		b.log(err)
+	}
+}
+
+const codeChars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newCode returns a random code for short links and permalinks
+func newCode() (string, error) {
+	code := make([]byte, 7)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeChars))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = codeChars[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// shorten returns a short link to the affiliate link of the item that counts
+// clicks, the item link is returned if there is no base url configured
+func (b *bot) shorten(chat, variant string, i api.Item, price float64) string {
+	if b.baseURL == "" {
+		return i.Link
+	}
+	url := b.affiliate(i.Link, i.Domain)
+	code, err := newCode()
+	if err != nil {
+		b.log(fmt.Errorf("couldn't generate short link: %w", err))
+		return url
+	}
+	_, tagged := b.tags[i.Domain]
+	l := shortLink{
+		URL:      url,
+		Chat:     chat,
+		Item:     i.ID,
+		Title:    i.Title,
+		Domain:   i.Domain,
+		Category: i.Category,
+		Price:    price,
+		Tagged:   tagged,
+		Variant:  variant,
+		Created:  time.Now().UTC(),
+	}
+	if err := b.db.Put("links", code, l); err != nil {
+		b.log(err)
+		return url
+	}
+	return fmt.Sprintf("%s/s/%s", b.baseURL, code)
+}
+
+func (b *bot) redirect(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/s/")
+	var l shortLink
+	if err := b.db.Get("links", code, &l); err != nil {
+		b.log(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if l.URL == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, l.URL, http.StatusFound)
+	l.Clicks++
+	if err := b.db.Put("links", code, l); err != nil {
+		b.log(err)
+	}
+	if l.Variant != "" {
+		b.count(l.Chat, l.Variant, 0, 1)
+	}
+	b.click(l, time.Now().UTC())
+}
+
+// serveHTTP launches the http listener used by short links, metrics and the
+// viewer, pprof debug
+// endpoints are added if a token is provided
+func (b *bot) serveHTTP(ctx context.Context, addr, pprofToken string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("couldn't listen http on %s: %w", addr, err)
+	}
+	srv := &http.Server{
+		Handler:     b.httpHandler(pprofToken),
+		ReadTimeout: 10 * time.Second,
+		// requests are cancelled on shutdown so streams are closed
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			b.log(fmt.Errorf("http server failed: %w", err))
+		}
+	}()
+	return nil
+}
+
+// httpHandler returns the routes of the http listener.
+// With web login the feed, with the deals of all the chats, and the metrics
+// require a session. Short links and deal pages stay public because they are
+// posted to channels, their codes are random and they don't show the chat.
+func (b *bot) httpHandler(pprofToken string) http.Handler {
+	private := func(h http.Handler) http.Handler { return h }
+	if b.login != nil {
+		private = b.login.require
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/s/", b.redirect)
+	mux.HandleFunc("/d/", b.dealHandler)
+	mux.Handle("/feed.json", private(http.HandlerFunc(b.feedHandler)))
+	mux.Handle("/sitemap.xml", private(http.HandlerFunc(b.feedHandler)))
+	mux.HandleFunc("/track", b.trackHandler)
+	mux.HandleFunc("/graphql", b.graphqlHandler)
+	mux.HandleFunc("/stream", b.streamHandler)
+	mux.HandleFunc("/app", b.appHandler)
+	mux.HandleFunc("/app/settings", b.appSettingsHandler)
+	mux.Handle("/metrics", private(b.metrics.Handler()))
+	if b.login != nil {
+		v := &viewer{db: b.db, allow: b.login.allowed}
+		pages := http.NewServeMux()
+		v.register(pages)
+		mux.Handle("/", b.login.require(pages))
+		b.login.register(mux)
+	} else {
+		(&viewer{db: b.db}).register(mux)
+	}
+	if pprofToken != "" {
+		mux.Handle("/debug/pprof/", authorize(pprofToken, http.HandlerFunc(pprof.Index)))
+		mux.Handle("/debug/pprof/cmdline", authorize(pprofToken, http.HandlerFunc(pprof.Cmdline)))
+		mux.Handle("/debug/pprof/profile", authorize(pprofToken, http.HandlerFunc(pprof.Profile)))
+		mux.Handle("/debug/pprof/symbol", authorize(pprofToken, http.HandlerFunc(pprof.Symbol)))
+		mux.Handle("/debug/pprof/trace", authorize(pprofToken, http.HandlerFunc(pprof.Trace)))
+	}
+	return mux
+}
+
+// authorize checks the token of the authorization header or the token query
+// parameter
+func authorize(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if got == "" {
+			got = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
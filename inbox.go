@@ -0,0 +1,131 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/inbox"
+)
+
+const (
+	// maxInboxItems is the maximum number of products tracked from an email
+	maxInboxItems = 20
+	// maxInboxSize is the maximum size of the received emails
+	maxInboxSize = 10 << 20
+)
+
+var emailLinkRegex = regexp.MustCompile(`https?://[^\s"'<>()\[\]]+`)
+
+// emailItems returns the ids (ASIN.domain) of the product links of an email,
+// the links wrapped by tracking redirects of amazon emails are unwrapped
+func emailItems(text string) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	var visit func(link string, depth int)
+	visit = func(link string, depth int) {
+		if id, err := api.ItemID(link); err == nil {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+			return
+		}
+		u, err := url.Parse(link)
+		if err != nil || depth > 1 {
+			return
+		}
+		for _, values := range u.Query() {
+			for _, v := range values {
+				if strings.HasPrefix(v, "http") {
+					visit(v, depth+1)
+				}
+			}
+		}
+	}
+	for _, link := range emailLinkRegex.FindAllString(html.UnescapeString(text), -1) {
+		visit(link, 0)
+	}
+	if len(ids) > maxInboxItems {
+		ids = ids[:maxInboxItems]
+	}
+	return ids
+}
+
+// inboxUser returns the user of an inbox address, the local part is the api
+// token of the user
+func (b *bot) inboxUser(addr string) (int, bool) {
+	local := strings.SplitN(addr, "@", 2)[0]
+	return b.tokenUser(local)
+}
+
+// receive tracks the products of an email forwarded to the inbox of an user
+func (b *bot) receive(m inbox.Message) {
+	for _, to := range m.To {
+		user, ok := b.inboxUser(to)
+		if !ok {
+			continue
+		}
+		ids := emailItems(m.Text)
+		if len(ids) == 0 {
+			b.message(user, fmt.Sprintf("📧 no amazon products found in email %q", m.Subject))
+			continue
+		}
+		chat, _ := b.userChat(user)
+		d := b.defaults(user)
+		var lines []string
+		for _, id := range ids {
+			query, err := api.NormalizeID(d.apply(id))
+			if err != nil {
+				continue
+			}
+			parsed, err := parseArgs(fmt.Sprintf("%s/%s", chat, query), chat)
+			if err != nil {
+				continue
+			}
+			if err := b.add(parsed); err != nil {
+				lines = append(lines, fmt.Sprintf("%s: %s", parsed.id, err))
+				continue
+			}
+			lines = append(lines, parsed.id)
+		}
+		b.message(user, fmt.Sprintf("📧 searching from email %q:\n%s", m.Subject, strings.Join(lines, "\n")))
+	}
+}
+
+// serveInbox launches the smtp receiver of the emails forwarded by users to
+// their inbox address
+func (b *bot) serveInbox(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("couldn't listen smtp on %s: %w", addr, err)
+	}
+	srv := &inbox.Server{
+		Domain:  b.inboxDomain,
+		MaxSize: maxInboxSize,
+		Accept: func(addr string) bool {
+			_, ok := b.inboxUser(addr)
+			return ok
+		},
+		Handler: b.receive,
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		<-ctx.Done()
+		_ = lis.Close()
+	}()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		if err := srv.Serve(lis); err != nil && ctx.Err() == nil {
+			b.log(fmt.Errorf("smtp server failed: %w", err))
+		}
+	}()
+	return nil
+}
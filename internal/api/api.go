@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
@@ -19,6 +20,7 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/igolaizola/amazbot/internal/trace"
 	"golang.org/x/net/proxy"
 )
 
@@ -27,19 +29,62 @@ type Item struct {
 	Domain   string     `json:"domain"`
 	Link     string     `json:"link"`
 	Title    string     `json:"title"`
+	Image    string     `json:"image,omitempty"`
+	Category []string   `json:"category,omitempty"`
+	Brand    string     `json:"brand,omitempty"`
 	MinPrice float64    `json:"min_price"`
 	Prices   [5]float64 `json:"prices"`
+	Sellers  [5]string  `json:"sellers"`
+	TradeIn  float64    `json:"trade_in,omitempty"`
+	// Outlet is the lowest price of the used offers sold by Amazon Warehouse
+	// or Outlet
+	Outlet float64 `json:"outlet,omitempty"`
+	// Available is the date when the item will be available, if any
+	Available *time.Time `json:"available,omitempty"`
+	// Points is the points-back percentage of the buy box offer, PointsNet
+	// is set when they are subtracted from the new offer sold by Amazon
+	Points    float64 `json:"points,omitempty"`
+	PointsNet bool    `json:"points_net,omitempty"`
+	VAT       float64 `json:"vat,omitempty"`
+}
+
+// Alert kinds reported to search callbacks
+const (
+	PriceAlert = iota
+	TradeInAlert
+	MarginAlert
+	// UsedAlert is reported when a used offer appears regardless of its price
+	UsedAlert
+)
+
+// Alert is reported to search callbacks, ref is the price it is compared to
+// and margin the percentage difference for margin alerts.
+type Alert struct {
+	Kind   int     `json:"kind"`
+	State  int     `json:"state"`
+	Price  float64 `json:"price"`
+	Ref    float64 `json:"ref"`
+	Margin float64 `json:"margin,omitempty"`
 }
 
 type Client struct {
 	client     *http.Client
 	ctx        context.Context
+	lck        sync.RWMutex
 	captchaURL string
 	transport  *transport
 	started    map[string]struct{}
+	vat        map[string]float64
+	locations  map[string]string
+	convert    func(amount float64, from, to string) (float64, error)
+	onCaptcha  func(id string)
+	parallel   int
+	dumper     *dumper
+	warmUp     time.Duration
 }
 
-func New(ctx context.Context, captchaURL, proxyURL string) (*Client, error) {
+// New creates an api client, vat rates override the default ones per domain.
+func New(ctx context.Context, captchaURL, proxyURL string, vat map[string]float64) (*Client, error) {
 	captchaURL = strings.TrimLeft(captchaURL, "/")
 	if captchaURL != "" {
 		_, err := url.Parse(captchaURL)
@@ -60,27 +105,116 @@ func New(ctx context.Context, captchaURL, proxyURL string) (*Client, error) {
 		captchaURL: captchaURL,
 		transport:  tr,
 		started:    make(map[string]struct{}),
+		vat:        make(map[string]float64),
+		parallel:   1,
+	}
+	for k, v := range vatRates {
+		cli.vat[k] = v
+	}
+	for k, v := range vat {
+		cli.vat[k] = v
 	}
 	// test captcha resolver
 	if captchaURL != "" {
-		c, err := cli.resolveCaptcha("https://images-na.ssl-images-amazon.com/captcha/usvmgloq/Captcha_kwrrnqwkph.jpg")
-		switch {
-		case err != nil:
+		if err := TestCaptcha(ctx, captchaURL); err != nil {
 			log.Println(err)
-		case c != "AAFXMX":
-			log.Println(fmt.Errorf("api: captcha resolver failed: %s", c))
-		default:
+		} else {
 			log.Println("api: captcha resolver test succeeded")
 		}
 	}
 	return cli, nil
 }
 
-func ItemID(link string) (string, bool) {
+// TestCaptcha resolves a known captcha with the captcha service
+func TestCaptcha(ctx context.Context, captchaURL string) error {
+	cli := &Client{captchaURL: captchaURL}
+	c, err := cli.resolveCaptcha(ctx, "https://images-na.ssl-images-amazon.com/captcha/usvmgloq/Captcha_kwrrnqwkph.jpg")
+	if err != nil {
+		return err
+	}
+	if c != "AAFXMX" {
+		return fmt.Errorf("api: captcha resolver failed: %s", c)
+	}
+	return nil
+}
+
+// OnCaptcha sets a function to be called each time a captcha is solved
+func (c *Client) OnCaptcha(f func(id string)) {
+	c.onCaptcha = f
+}
+
+// Parallel sets the max number of concurrent requests, offer listing pages
+// of an item are fetched concurrently when it is greater than one. It must
+// be called before searching.
+func (c *Client) Parallel(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.parallel = n
+	c.transport.slots = make(chan struct{}, n)
+}
+
+// Locations sets the delivery location per domain, a postal code or a
+// country code, offers that don't ship there are skipped. By default the
+// postal code 44001 is used in es and the country ES in the rest, keeping
+// all the offers. It must be called before searching.
+func (c *Client) Locations(locations map[string]string) {
+	c.locations = locations
+}
+
+// Converter sets the currency converter used by the target option of the
+// searchs with a currency option. It must be called before searching.
+func (c *Client) Converter(convert func(amount float64, from, to string) (float64, error)) {
+	c.convert = convert
+}
+
+// Languages overrides the accept-language header per domain, which defaults
+// to the locale of the domain. It must be called before searching.
+func (c *Client) Languages(languages map[string]string) {
+	c.transport.languages = languages
+}
+
+// ErrNoLink is returned by ItemID when the text has no amazon product link
+var ErrNoLink = errors.New("api: no amazon product link found")
+
+// ASIN uppercases the asin removing the trailing slugs or params pasted
+// along with it and validates it has 10 alphanumeric chars
+func ASIN(s string) (string, error) {
+	asin := strings.ToUpper(strings.TrimSpace(s))
+	if i := strings.IndexFunc(asin, func(r rune) bool {
+		return (r < 'A' || r > 'Z') && (r < '0' || r > '9')
+	}); i >= 0 {
+		asin = asin[:i]
+	}
+	if len(asin) != 10 {
+		return "", fmt.Errorf("api: invalid asin %q, it must have 10 letters or digits (e.g. B08XYZ1234)", s)
+	}
+	return asin, nil
+}
+
+// NormalizeID normalizes the asin and the domain of an id with the format
+// ASIN.domain?options
+func NormalizeID(id string) (string, error) {
+	split := strings.SplitN(id, ".", 2)
+	if len(split) != 2 {
+		return "", fmt.Errorf("api: invalid id %s, expected ASIN.domain", id)
+	}
+	asin, err := ASIN(split[0])
+	if err != nil {
+		return "", err
+	}
+	ext := strings.SplitN(split[1], "?", 2)
+	ext[0] = strings.ToLower(ext[0])
+	return fmt.Sprintf("%s.%s", asin, strings.Join(ext, "?")), nil
+}
+
+// ItemID returns the id of the product link in the text as ASIN.domain,
+// ErrNoLink if there isn't any
+func ItemID(link string) (string, error) {
 	// Isolate link
 	idx := strings.Index(link, "http")
 	if idx < 0 {
-		return "", false
+		return "", ErrNoLink
 	}
 	link = link[idx:]
 	link = strings.Split(link, " ")[0]
@@ -88,27 +222,31 @@ func ItemID(link string) (string, bool) {
 	// Parse url and get product id
 	u, err := url.Parse(link)
 	if err != nil {
-		return "", false
+		return "", ErrNoLink
 	}
-	idx = strings.Index(u.Host, "amazon.")
-	if idx < 0 {
-		return "", false
+	domain, ok := marketplace(u.Hostname())
+	if !ok {
+		return "", ErrNoLink
 	}
-	domain := u.Host[idx+len("amazon."):]
 	split := strings.Split(u.Path, "/")
 	var id string
-	var prev string
-	for _, s := range split {
-		if prev == "dp" {
-			id = s
-			break
+	// Product paths are /dp/<id>, /gp/product/<id>, /gp/aw/d/<id> and
+	// /exec/obidos/ASIN/<id>
+	for i := 1; i < len(split) && id == ""; i++ {
+		switch prev := strings.ToLower(split[i-1]); {
+		case prev == "dp", prev == "product", prev == "asin",
+			prev == "d" && i > 1 && strings.ToLower(split[i-2]) == "aw":
+			id = split[i]
 		}
-		prev = s
 	}
 	if id == "" {
-		return "", false
+		return "", ErrNoLink
+	}
+	id, err = ASIN(id)
+	if err != nil {
+		return "", err
 	}
-	return fmt.Sprintf("%s.%s", id, domain), true
+	return fmt.Sprintf("%s.%s", id, domain), nil
 }
 
 func Link(id string) string {
@@ -119,35 +257,68 @@ func Link(id string) string {
 	return fmt.Sprintf("https://www.amazon.%s/dp/%s", domain, id)
 }
 
-func (c *Client) Search(id string, item *Item, callback func(Item, int) error) error {
-	id, domain, maxState, err := parseID(id)
+// Tag adds an affiliate tag to the link
+func Tag(link, tag string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	q := u.Query()
+	q.Set("tag", tag)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (c *Client) Search(id string, item *Item, callback func(Item, Alert) error) error {
+	return c.SearchContext(c.ctx, id, item, callback)
+}
+
+// SearchContext is like Search but its context deadline covers all the
+// requests of the search, including captcha resolution.
+func (c *Client) SearchContext(ctx context.Context, id string, item *Item, callback func(Item, Alert) error) error {
+	id, domain, opts, err := parseID(id)
 	if err != nil {
 		return err
 	}
+	// The item isn't scraped until the stated day ends, it is checked again
+	// in the first search after that
+	if opts.wait && item.Available != nil && time.Now().Before(item.Available.Add(24*time.Hour)) {
+		return nil
+	}
 	if _, ok := c.started[domain]; !ok {
-		if err := c.reset(domain); err != nil {
+		if err := c.reset(ctx, domain); err != nil {
 			return err
 		}
+		c.browse(ctx, domain, id)
 		c.started[domain] = struct{}{}
 	}
 	var retry bool
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("api: search %s.%s: %w", id, domain, ctx.Err())
+			}
 			return nil
 		default:
 		}
-		err := c.search(id, domain, maxState, item, callback)
+		actx, span := trace.Start(ctx, "attempt", "retry", strconv.FormatBool(retry))
+		err := c.search(actx, id, domain, opts, item, callback)
+		span.Error(err)
+		span.End()
 		var netErr net.Error
 		if errors.As(err, &netErr) && netErr.Timeout() {
 			continue
 		}
 		if errors.Is(err, errRetry) {
-			c.reset(domain)
+			resetErr := c.reset(ctx, domain)
 			if retry {
 				return err
 			}
 			retry = true
+			if resetErr == nil {
+				c.browse(ctx, domain, id)
+			}
 			continue
 		}
 		return err
@@ -156,54 +327,75 @@ func (c *Client) Search(id string, item *Item, callback func(Item, int) error) e
 
 var errRetry = errors.New("retriable error")
 
-func (c *Client) search(id, domain string, maxState int, item *Item, callback func(Item, int) error) error {
+func (c *Client) search(ctx context.Context, id, domain string, opts options, item *Item, callback func(Item, Alert) error) error {
 	if item == nil {
 		return fmt.Errorf("api: item is nil")
 	}
+	known := item.ID != ""
 	u := fmt.Sprintf("https://www.amazon.%s/dp/%s", domain, id)
-	doc, err := c.getDoc(u, id, 0)
+	doc, err := c.getDoc(ctx, u, id, 0)
 	if err != nil {
 		return err
 	}
+	d := parseDetail(doc)
 
-	// search title
-	var title string
-	doc.Find("#productTitle").EachWithBreak(func(i int, s *goquery.Selection) bool {
-		title = strings.TrimSpace(s.Text())
-		return false
-	})
+	title := d.title
 	if title == "" {
-		h, _ := doc.Html()
-		ioutil.WriteFile(fmt.Sprintf("%s_err.html", id), []byte(h), 0644)
+		if c.dumper != nil {
+			h, _ := doc.Html()
+			c.dump(fmt.Sprintf("%s.%s_title", id, domain), []byte(h))
+		}
 		return fmt.Errorf("api: title not found: %s.%s", id, domain)
 	}
-
-	// search link
-	var link string
-	doc.Find("link").EachWithBreak(func(i int, s *goquery.Selection) bool {
-		rel, _ := s.Attr("rel")
-		if rel != "canonical" {
-			return true
-		}
-		link, _ = s.Attr("href")
-		return false
-	})
+	link := d.link
 	if link == "" {
 		return fmt.Errorf("api: link not found: %s.%s", id, domain)
 	}
+	image := d.image
+	category := d.category
+	brand := parseBrand(d.brand)
+
+	// search availability date
+	var available *time.Time
+	if t, ok := parseDate(d.availability); ok {
+		available = &t
+	}
+
+	// search trade-in value
+	var tradeIn float64
+	if d.tradeIn != "" {
+		if p, err := parsePrice(domain, d.tradeIn); err == nil {
+			tradeIn = p
+		}
+	}
+
+	// search points-back percentage
+	var points float64
+	if domain == "co.jp" {
+		if p, ok := parsePoints(d.points); ok {
+			points = p
+		}
+	}
+
+	filter := offerFilter{shippable: c.locations[domain] != "" && !opts.anyShip, outlet: opts.outlet}
+	if opts.maxDelivery > 0 {
+		y, m, d := time.Now().UTC().Date()
+		filter.latest = time.Date(y, m, d+opts.maxDelivery, 0, 0, 0, 0, time.UTC)
+	}
 
 	var prices [5]float64
+	var sellers [5]string
+	var outlet float64
 	var sha [32]byte
+	var pages int
+	var last *goquery.Document
 	i := 0
 	for {
-		u = fmt.Sprintf("https://www.amazon.%s/gp/aod/ajax/ref=aod_page_2?asin=%s&pc=dp&pageno=%d", domain, id, i)
-		if domain == "co.jp" || domain == "com" {
-			u = fmt.Sprintf("%s&language=en_US", u)
-		}
-		doc, err := c.getDoc(u, id, 0)
+		doc, err := c.getAjax(ctx, aodURL(domain, id, i), u, id)
 		if err != nil {
 			return err
 		}
+		last = doc
 		currSHA := sha256.Sum256([]byte(doc.Text()))
 		if bytes.Equal(sha[:], currSHA[:]) {
 			break
@@ -213,9 +405,45 @@ func (c *Client) search(id, domain string, maxState int, item *Item, callback fu
 			break
 		}
 		i++
-		prices = extractPrices(domain, id, doc, prices)
+		prices, sellers, outlet = extractOffers(domain, id, doc, prices, sellers, outlet, filter)
+		// Stop once all the offers of the first page count are fetched
+		if pages == 0 {
+			if n, ok := offerCount(doc); ok {
+				pages = (n + offersPerPage - 1) / offersPerPage
+				if pages == 0 {
+					pages = 1
+				}
+			}
+		}
+		if pages > 0 && i >= pages {
+			break
+		}
+		// Fetch the remaining pages concurrently
+		if pages > 0 && c.parallel > 1 {
+			var urls []string
+			for p := i; p < pages && p <= 10; p++ {
+				urls = append(urls, aodURL(domain, id, p))
+			}
+			docs, err := c.getDocs(ctx, urls, u, id)
+			if err != nil {
+				return err
+			}
+			for _, doc := range docs {
+				prices, sellers, outlet = extractOffers(domain, id, doc, prices, sellers, outlet, filter)
+			}
+			break
+		}
 	}
 
+	item.ID = id
+	item.Domain = domain
+	item.Link = link
+	item.Title = title
+	item.Image = image
+	item.Category = category
+	item.Brand = brand
+	item.Available = available
+
 	found := false
 	for _, p := range prices {
 		if p == 0 {
@@ -226,22 +454,44 @@ func (c *Client) search(id, domain string, maxState int, item *Item, callback fu
 	}
 
 	if !found {
-		h, _ := doc.Html()
-		ioutil.WriteFile(fmt.Sprintf("err_%s.%s.html", id, domain), []byte(h), 0644)
+		if c.dumper != nil && last != nil {
+			h, _ := last.Html()
+			c.dump(fmt.Sprintf("%s.%s_prices", id, domain), []byte(h))
+		}
 		log.Println(fmt.Sprintf("api: prices not found: %s.%s", id, domain))
+		// Forget the used offers so they are reported when they return
+		if opts.anyUsed {
+			for i := 1; i < len(item.Prices); i++ {
+				item.Prices[i] = 0
+			}
+		}
 		return nil
 	}
 
 	log.Println("prices", prices)
 
-	item.ID = id
-	item.Domain = domain
-	item.Link = link
-	item.Title = title
+	item.Points = points
+	// Only the new offer sold by Amazon earns the points
+	item.PointsNet = opts.points && points > 0 && prices[0] > 0 && isAmazon(sellers[0])
+	if item.PointsNet {
+		prices[0] = math.Round(prices[0] * (100 - points) / 100)
+	}
+	item.VAT = 0
+	if rate := c.vat[domain]; opts.vat && rate > 0 {
+		item.VAT = rate
+		for i, p := range prices {
+			prices[i] = math.Round(p/(1+rate/100)*100) / 100
+		}
+		outlet = math.Round(outlet/(1+rate/100)*100) / 100
+	}
+	item.Outlet = outlet
+
+	prevTradeIn := item.TradeIn
+	item.TradeIn = tradeIn
 	prevMin := item.MinPrice
 	var newMin bool
-	if item.MinPrice == 0 || prices[0] < item.MinPrice {
-		item.MinPrice = prices[0]
+	if m := minPrice(prices, opts.minState); item.MinPrice == 0 || m < item.MinPrice {
+		item.MinPrice = m
 		newMin = true
 	}
 	prev := item.Prices
@@ -249,9 +499,20 @@ func (c *Client) search(id, domain string, maxState int, item *Item, callback fu
 		item.Prices[i] = p
 	}
 	item.Prices = prices
+	item.Sellers = sellers
+	// Report the cheapest used offer when they appear after none was found
+	var appeared bool
+	if before, _ := cheapestUsed(prev, opts.maxState); opts.anyUsed && known && before == 0 {
+		if state, p := cheapestUsed(prices, opts.maxState); state > 0 {
+			appeared = true
+			if err := callback(*item, Alert{Kind: UsedAlert, State: state, Price: p}); err != nil {
+				return err
+			}
+		}
+	}
 	for i, p := range prices {
 		// TODO(igolaizola): disabled some states
-		if i > maxState {
+		if i > opts.maxState {
 			break
 		}
 		// Price not found, continue
@@ -266,15 +527,66 @@ func (c *Client) search(id, domain string, maxState int, item *Item, callback fu
 		if i == 0 && !newMin {
 			continue
 		}
+		// Skip used prices already reported as appeared
+		if i > 0 && appeared {
+			continue
+		}
 		// Skip prices higher than previous ones
 		if prev[i] > 0 && p >= prev[i] {
 			continue
 		}
-		// Skip used prices higher than min
-		if i > 0 && item.MinPrice > 0 && p >= item.MinPrice {
+		// Skip used prices higher than min, used states counted in the min
+		// are compared to the previous one
+		if i > 0 && i <= opts.minState && (prevMin == 0 || p >= prevMin) {
+			continue
+		}
+		if i > opts.minState && item.MinPrice > 0 && p >= item.MinPrice {
 			continue
 		}
-		if err := callback(*item, i); err != nil {
+		// Skip drops smaller than the percentage of the drop option
+		if opts.drop > 0 && prev[i] > 0 && (prev[i]-p)*100/prev[i] < opts.drop {
+			continue
+		}
+		// Skip prices over the target option, in the currency option if set
+		if opts.target > 0 {
+			target, ok := p, true
+			if opts.currency != "" && opts.currency != Currency(domain) {
+				target, ok = c.inCurrency(p, domain, opts.currency)
+			}
+			if !ok || target > opts.target {
+				continue
+			}
+		}
+		if err := callback(*item, Alert{Kind: PriceAlert, State: i, Price: p, Ref: prev[i]}); err != nil {
+			return err
+		}
+	}
+	if opts.tradeIn && prevTradeIn > 0 && tradeIn > prevTradeIn {
+		if err := callback(*item, Alert{Kind: TradeInAlert, Price: tradeIn, Ref: prevTradeIn}); err != nil {
+			return err
+		}
+	}
+	for i, p := range prices {
+		if i > opts.maxState || p == 0 {
+			continue
+		}
+		var ref, margin float64
+		switch {
+		case opts.sell > 0:
+			// Buy at amazon and sell at the user price
+			ref = opts.sell
+			margin = (opts.sell - p) / p * 100
+		case opts.cost > 0 && i == 0:
+			// Sell the user stock competing with amazon new price
+			ref = opts.cost
+			margin = (p - opts.cost) / opts.cost * 100
+		default:
+			continue
+		}
+		if margin < opts.margin {
+			continue
+		}
+		if err := callback(*item, Alert{Kind: MarginAlert, State: i, Price: p, Ref: ref, Margin: margin}); err != nil {
 			return err
 		}
 	}
@@ -282,7 +594,69 @@ func (c *Client) search(id, domain string, maxState int, item *Item, callback fu
 	return nil
 }
 
+// inCurrency converts the price of the domain to the currency
+func (c *Client) inCurrency(p float64, domain, currency string) (float64, bool) {
+	if c.convert == nil {
+		log.Println(fmt.Errorf("api: no currency converter for %s", currency))
+		return 0, false
+	}
+	v, err := c.convert(p, Currency(domain), currency)
+	if err != nil {
+		log.Println(fmt.Errorf("api: couldn't convert price: %w", err))
+		return 0, false
+	}
+	return v, true
+}
+
 func extractPrices(domain, id string, doc *goquery.Document, prices [5]float64) [5]float64 {
+	prices, _, _ = extractOffers(domain, id, doc, prices, [5]string{}, 0, offerFilter{})
+	return prices
+}
+
+// offerFilter skips the offers delivered after the latest date (if not zero),
+// if shippable is set, the ones that don't ship to the location and, if
+// outlet is set, the used ones not sold by Amazon Warehouse or Outlet
+type offerFilter struct {
+	latest    time.Time
+	shippable bool
+	outlet    bool
+}
+
+// outletSellers are the names of the Amazon sellers of returned and outlet
+// offers
+var outletSellers = []string{
+	"amazon warehouse", "amazon resale", "amazon outlet", "amazon アウトレット",
+}
+
+// isOutlet returns true if the seller is Amazon Warehouse or Outlet
+func isOutlet(seller string) bool {
+	lower := strings.ToLower(seller)
+	for _, s := range outletSellers {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAmazon returns true if the seller is Amazon itself and not one of its
+// outlet sellers
+func isAmazon(seller string) bool {
+	return strings.HasPrefix(strings.ToLower(seller), "amazon") && !isOutlet(seller)
+}
+
+// noShipTexts are the messages of the offers that don't ship to the
+// delivery location
+var noShipTexts = []string{
+	"cannot be shipped", "does not ship", "kann nicht an", "no se puede enviar",
+	"ne peut pas être expédié", "non può essere spedito", "não pode ser enviado",
+	"発送できません",
+}
+
+// extractOffers returns the lowest prices of each state and their sellers and
+// the lowest price of the used offers of Amazon Warehouse or Outlet, the
+// offers excluded by the filter are skipped
+func extractOffers(domain, id string, doc *goquery.Document, prices [5]float64, sellers [5]string, outlet float64, filter offerFilter) ([5]float64, [5]string, float64) {
 	divs := [][2]string{
 		// First pinned offer
 		{"#pinned-de-id", "#pinned-offer-top-id"},
@@ -290,6 +664,7 @@ func extractPrices(domain, id string, doc *goquery.Document, prices [5]float64)
 		{"#aod-offer", "#aod-offer-price"},
 	}
 	for _, div := range divs {
+		var last string
 		doc.Find(div[0]).Each(func(i int, s *goquery.Selection) {
 			state := -1
 			s.Find(fmt.Sprintf("%s #aod-offer-heading", div[0])).EachWithBreak(func(i int, s *goquery.Selection) bool {
@@ -328,6 +703,40 @@ func extractPrices(domain, id string, doc *goquery.Document, prices [5]float64)
 					return false
 				})
 			}
+			skip := false
+			s.Find(fmt.Sprintf("%s %s .aod-delivery-promise", div[0], div[1])).EachWithBreak(func(i int, s *goquery.Selection) bool {
+				text := s.Text()
+				if filter.shippable {
+					lower := strings.ToLower(text)
+					for _, t := range noShipTexts {
+						if strings.Contains(lower, t) {
+							skip = true
+						}
+					}
+				}
+				if !filter.latest.IsZero() {
+					if t, ok := parseDelivery(text, time.Now().UTC()); ok && t.After(filter.latest) {
+						skip = true
+					}
+				}
+				return false
+			})
+			if skip {
+				return
+			}
+			var seller string
+			s.Find(fmt.Sprintf("%s #aod-offer-soldBy .a-col-right > a, %s #aod-offer-soldBy .a-col-right > span", div[0], div[0])).EachWithBreak(func(i int, s *goquery.Selection) bool {
+				seller = strings.Join(strings.Fields(s.Text()), " ")
+				return false
+			})
+			// The pinned offer seller and price may be in different blocks
+			if seller == "" && i > 0 {
+				seller = last
+			}
+			last = seller
+			if filter.outlet && state > 0 && !isOutlet(seller) {
+				return
+			}
 			s.Find(fmt.Sprintf("%s %s .a-offscreen", div[0], div[1])).EachWithBreak(func(i int, s *goquery.Selection) bool {
 				text := s.Text()
 				price, err := parsePrice(domain, text)
@@ -338,22 +747,107 @@ func extractPrices(domain, id string, doc *goquery.Document, prices [5]float64)
 				price = price + delivery
 				if prices[state] == 0 || price < prices[state] {
 					prices[state] = price
+					sellers[state] = seller
+				}
+				if state > 0 && isOutlet(seller) && (outlet == 0 || price < outlet) {
+					outlet = price
 				}
 				return false
 			})
 		})
 	}
-	return prices
+	return prices, sellers, outlet
 }
 
-func (c *Client) getDoc(u string, id string, depth int) (*goquery.Document, error) {
-	req, err := http.NewRequest("GET", u, nil)
+const offersPerPage = 10
+
+// aodURL returns the url of an offer listing page
+func aodURL(domain, id string, page int) string {
+	u := fmt.Sprintf("https://www.amazon.%s/gp/aod/ajax/ref=aod_page_2?asin=%s&pc=dp&pageno=%d", domain, id, page)
+	if domain == "co.jp" || domain == "com" {
+		u = fmt.Sprintf("%s&language=en_US", u)
+	}
+	return u
+}
+
+// getDocs fetches the urls concurrently, bounded by the parallel setting
+func (c *Client) getDocs(ctx context.Context, urls []string, referer, id string) ([]*goquery.Document, error) {
+	docs := make([]*goquery.Document, len(urls))
+	errs := make([]error, len(urls))
+	sem := make(chan struct{}, c.parallel)
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			docs[i], errs[i] = c.getAjax(ctx, u, referer, id)
+		}(i, u)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// offerCount returns the total number of offers of the offer listing, the
+// pinned offer isn't included
+func offerCount(doc *goquery.Document) (int, bool) {
+	var n int
+	var ok bool
+	doc.Find("#aod-total-offer-count").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		v, _ := s.Attr("value")
+		if c, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			n, ok = c, true
+		}
+		return false
+	})
+	return n, ok
+}
+
+var brandPrefixes = []string{"visit the ", "visita la tienda de ", "besuche den ", "visitez la boutique ", "visita lo store di "}
+
+// parseBrand returns the brand name of the byline
+func parseBrand(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if i := strings.Index(text, ":"); i >= 0 {
+		return strings.TrimSpace(text[i+1:])
+	}
+	lower := strings.ToLower(text)
+	for _, p := range brandPrefixes {
+		if strings.HasPrefix(lower, p) {
+			text = text[len(p):]
+			break
+		}
+	}
+	for _, s := range []string{" Store", "-Store", " store"} {
+		text = strings.TrimSuffix(text, s)
+	}
+	return text
+}
+
+func (c *Client) getDoc(ctx context.Context, u string, id string, depth int) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
 		return nil, fmt.Errorf("api: couldn't create request: %w", err)
 	}
 	return c.getDocWithReq(req, id, depth)
 }
 
+// getAjax requests the url as an ajax call of the referer page
+func (c *Client) getAjax(ctx context.Context, u, referer, id string) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("api: couldn't create request: %w", err)
+	}
+	ajax(req, referer)
+	return c.getDocWithReq(req, id, 0)
+}
+
 func (c *Client) getDocWithReq(req *http.Request, id string, depth int) (*goquery.Document, error) {
 	if depth > 2 {
 		return nil, fmt.Errorf("api: recursion aborted on depth %d", depth)
@@ -421,10 +915,13 @@ func (c *Client) getDocWithReq(req *http.Request, id string, depth int) (*goquer
 		}
 
 		// resolve captcha
-		solution, err := c.resolveCaptcha(img)
+		solution, err := c.resolveCaptcha(req.Context(), img)
 		if err != nil {
 			return nil, err
 		}
+		if c.onCaptcha != nil {
+			c.onCaptcha(id)
+		}
 
 		u, err := url.Parse("https://www.amazon.es/errors/validateCaptcha")
 		if err != nil {
@@ -435,40 +932,207 @@ func (c *Client) getDocWithReq(req *http.Request, id string, depth int) (*goquer
 		q.Set("amzn-r", amznr)
 		q.Set("field-keywords", solution)
 		u.RawQuery = q.Encode()
-		return c.getDoc(u.String(), id, depth+1)
+		validate, err := http.NewRequestWithContext(req.Context(), "GET", u.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("api: couldn't create request: %w", err)
+		}
+		validate.Header.Set("referer", req.URL.String())
+		return c.getDocWithReq(validate, id, depth+1)
 	}
 	return doc, nil
 }
 
-func parseID(id string) (string, string, int, error) {
+type options struct {
+	maxState int
+	// minState is the worst state counted in the min price
+	minState int
+	anyUsed  bool
+	anyShip  bool
+	// outlet only counts the used offers of Amazon Warehouse or Outlet
+	outlet  bool
+	tradeIn bool
+	wait    bool
+	points  bool
+	vat     bool
+	sell    float64
+	cost    float64
+	margin  float64
+	drop    float64
+	target  float64
+	// currency is the ISO 4217 code of the target price
+	currency string
+	// maxDelivery is the maximum number of days to deliver an offer
+	maxDelivery int
+}
+
+// cheapestUsed returns the state and price of the cheapest used offer up to
+// maxState, state is 0 if there is none
+func cheapestUsed(prices [5]float64, maxState int) (int, float64) {
+	var state int
+	var price float64
+	for i := 1; i < len(prices) && i <= maxState; i++ {
+		if p := prices[i]; p > 0 && (price == 0 || p < price) {
+			state, price = i, p
+		}
+	}
+	return state, price
+}
+
+// minPrice returns the lowest price of the states up to minState, the new
+// price is returned as is if it is the only state counted
+func minPrice(prices [5]float64, minState int) float64 {
+	m := prices[0]
+	for _, p := range prices[1 : minState+1] {
+		if p > 0 && (m == 0 || p < m) {
+			m = p
+		}
+	}
+	return m
+}
+
+// parseID parses ids with the format ASIN.domain?maxState&option&key=value
+func parseID(id string) (string, string, options, error) {
+	opts := options{maxState: 4, margin: 10}
 	split := strings.SplitN(id, ".", 2)
 	if len(split) != 2 {
-		return "", "", 0, fmt.Errorf("api: invalid id: %s", id)
+		return "", "", opts, fmt.Errorf("api: invalid id: %s", id)
 	}
 	id = split[0]
 	ext := split[1]
 	split = strings.SplitN(ext, "?", 2)
-	maxState := 4
 	if len(split) > 1 {
 		ext = split[0]
-		var err error
-		maxState, err = strconv.Atoi(split[1])
-		if err != nil {
-			return "", "", 0, fmt.Errorf("api: couldn't parse max state: %s", split[1])
+		for _, o := range strings.Split(split[1], "&") {
+			kv := strings.SplitN(o, "=", 2)
+			switch kv[0] {
+			case "":
+			case "tradein":
+				opts.tradeIn = true
+			case "wait":
+				opts.wait = true
+			case "points":
+				opts.points = true
+			case "vat":
+				opts.vat = true
+			case "any-used":
+				opts.anyUsed = true
+			case "any-ship":
+				opts.anyShip = true
+			case "outlet":
+				opts.outlet = true
+			case "announce":
+				// handled by the bot
+			case "delivery", "delivery<":
+				if len(kv) < 2 {
+					return "", "", opts, fmt.Errorf("api: missing value for option: %s", o)
+				}
+				v, ok := parseDays(kv[1])
+				if !ok {
+					return "", "", opts, fmt.Errorf("api: couldn't parse option: %s, expected days like 5d or weeks like 2w", o)
+				}
+				opts.maxDelivery = v
+			case "currency":
+				if len(kv) < 2 || len(kv[1]) != 3 {
+					return "", "", opts, fmt.Errorf("api: couldn't parse option: %s, expected a currency code like EUR", o)
+				}
+				opts.currency = strings.ToUpper(kv[1])
+			case "min":
+				if len(kv) < 2 {
+					return "", "", opts, fmt.Errorf("api: missing value for option: %s", o)
+				}
+				v, err := strconv.Atoi(kv[1])
+				if err != nil || v < 0 || v > 4 {
+					return "", "", opts, fmt.Errorf("api: couldn't parse option: %s, expected a state from 0 to 4", o)
+				}
+				opts.minState = v
+			case "sell", "cost", "margin", "drop", "target":
+				if len(kv) < 2 {
+					return "", "", opts, fmt.Errorf("api: missing value for option: %s", o)
+				}
+				v, err := strconv.ParseFloat(kv[1], 64)
+				if err != nil {
+					return "", "", opts, fmt.Errorf("api: couldn't parse option: %s", o)
+				}
+				switch kv[0] {
+				case "sell":
+					opts.sell = v
+				case "cost":
+					opts.cost = v
+				case "margin":
+					opts.margin = v
+				case "drop":
+					opts.drop = v
+				case "target":
+					opts.target = v
+				}
+			default:
+				var err error
+				opts.maxState, err = strconv.Atoi(kv[0])
+				if err != nil {
+					return "", "", opts, fmt.Errorf("api: couldn't parse option: %s", o)
+				}
+			}
+		}
+	}
+	return id, ext, opts, nil
+}
+
+// ErrCaptcha is returned when the captcha service fails
+var ErrCaptcha = errors.New("api: captcha service failed")
+
+// Reconfigure replaces the captcha service, the proxy and the delay after
+// each request (0 keeps the current one) without interrupting the searchs,
+// nothing is changed if any of them is invalid.
+func (c *Client) Reconfigure(captchaURL, proxyURL string, delay time.Duration) error {
+	captchaURL = strings.TrimLeft(captchaURL, "/")
+	if captchaURL != "" {
+		if _, err := url.Parse(captchaURL); err != nil {
+			return fmt.Errorf("api: couldn't parse captcha service url %s: %w", captchaURL, err)
 		}
 	}
-	return id, ext, maxState, nil
+	tr, err := proxyTransport(proxyURL)
+	if err != nil {
+		return err
+	}
+	c.lck.Lock()
+	c.captchaURL = captchaURL
+	c.lck.Unlock()
+	c.transport.lck.Lock()
+	c.transport.tr = tr
+	if delay > 0 {
+		c.transport.delay = delay
+	}
+	c.transport.lck.Unlock()
+	return nil
 }
 
-func (c *Client) resolveCaptcha(link string) (string, error) {
-	if c.captchaURL == "" {
+func (c *Client) resolveCaptcha(ctx context.Context, link string) (string, error) {
+	c.lck.RLock()
+	captchaURL := c.captchaURL
+	c.lck.RUnlock()
+	if captchaURL == "" {
 		return "", errors.New("api:missing captcha service")
 	}
-	u := fmt.Sprintf("%s/%s", c.captchaURL, link)
+	ctx, span := trace.Start(ctx, "captcha")
+	defer span.End()
+	solution, err := solveCaptcha(ctx, captchaURL, link)
+	span.Error(err)
+	if err != nil && ctx.Err() == nil {
+		return "", fmt.Errorf("%w: %v", ErrCaptcha, err)
+	}
+	return solution, err
+}
+
+func solveCaptcha(ctx context.Context, captchaURL, link string) (string, error) {
+	u := fmt.Sprintf("%s/%s", captchaURL, link)
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 	}
-	r, err := client.Get(u)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", fmt.Errorf("api: couldn't create request: %w", err)
+	}
+	r, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("api: get request failed: %w", err)
 	}
@@ -487,7 +1151,7 @@ func (c *Client) resolveCaptcha(link string) (string, error) {
 	return captcha, nil
 }
 
-func (c *Client) reset(domain string) error {
+func (c *Client) reset(ctx context.Context, domain string) error {
 	c.transport.userAgent = randomUserAgent()
 	cookieJar, err := cookiejar.New(nil)
 	if err != nil {
@@ -495,21 +1159,21 @@ func (c *Client) reset(domain string) error {
 	}
 	c.client.Jar = cookieJar
 	u := fmt.Sprintf("https://www.amazon.%s", domain)
-	doc, err := c.getDoc(u, "", 0)
+	doc, err := c.getDoc(ctx, u, "", 0)
 	if err != nil {
 		return err
 	}
-	postalCode := "44001"
+	location := c.location(domain)
 	hasLocation := false
 	doc.Find("#glow-ingress-line2").EachWithBreak(func(i int, s *goquery.Selection) bool {
-		if !strings.Contains(s.Text(), postalCode) {
+		if isCountry(location) || !strings.Contains(s.Text(), location) {
 			return true
 		}
 		hasLocation = true
 		return false
 	})
 	if !hasLocation {
-		if err := c.changeLocation(domain, doc, postalCode); err != nil {
+		if err := c.changeLocation(ctx, domain, doc, location); err != nil {
 			return err
 		}
 	}
@@ -517,7 +1181,32 @@ func (c *Client) reset(domain string) error {
 	return nil
 }
 
-func (c *Client) changeLocation(domain string, doc *goquery.Document, postalCode string) error {
+// location returns the delivery location of the domain
+func (c *Client) location(domain string) string {
+	if l := c.locations[domain]; l != "" {
+		return l
+	}
+	if domain == "es" {
+		return "44001"
+	}
+	return "ES"
+}
+
+// isCountry returns true if the location is a country code instead of a
+// postal code
+func isCountry(location string) bool {
+	if len(location) != 2 {
+		return false
+	}
+	for _, r := range strings.ToUpper(location) {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) changeLocation(ctx context.Context, domain string, doc *goquery.Document, location string) error {
 	modal := locationModal{}
 	doc.Find("#nav-global-location-data-modal-action").EachWithBreak(func(i int, s *goquery.Selection) bool {
 		data, ok := s.Attr("data-a-modal")
@@ -535,10 +1224,12 @@ func (c *Client) changeLocation(domain string, doc *goquery.Document, postalCode
 	}
 
 	u := fmt.Sprintf("https://www.amazon.%s/%s", domain, strings.TrimLeft(modal.URL, "/"))
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
 		return fmt.Errorf("api: couldn't create post request: %w", err)
 	}
+	home := fmt.Sprintf("https://www.amazon.%s/", domain)
+	ajax(req, home)
 	req.Header.Add("anti-csrftoken-a2z", modal.Ajax.Token)
 	doc, err = c.getDocWithReq(req, "", 0)
 	if err != nil {
@@ -565,23 +1256,24 @@ func (c *Client) changeLocation(domain string, doc *goquery.Document, postalCode
 
 	u = fmt.Sprintf("https://www.amazon.%s/gp/delivery/ajax/address-change.html", domain)
 	form := url.Values{}
-	if domain == "es" {
+	if !isCountry(location) {
 		form.Add("locationType", "LOCATION_INPUT")
-		form.Add("zipCode", postalCode)
+		form.Add("zipCode", location)
 	} else {
 		form.Add("locationType", "COUNTRY")
-		form.Add("district", "ES")
-		form.Add("countryCode", "ES")
+		form.Add("district", strings.ToUpper(location))
+		form.Add("countryCode", strings.ToUpper(location))
 	}
 	form.Add("storeContext", "generic")
 	form.Add("deviceType", "web")
 	form.Add("pageType", "Gateway")
 	form.Add("actionSource", "glow")
 	form.Add("almBrandId", "undefined")
-	req, err = http.NewRequest("POST", u, strings.NewReader(form.Encode()))
+	req, err = http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(form.Encode()))
 	if err != nil {
 		return fmt.Errorf("api: couldn't create post request: %w", err)
 	}
+	ajax(req, home)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("anti-csrftoken-a2z", token)
 	_, err = c.getDocWithReq(req, "", 0)
@@ -601,6 +1293,21 @@ type ajaxHeaders struct {
 }
 
 func newTransport(ctx context.Context, proxyURL string) (*transport, error) {
+	tr, err := proxyTransport(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	return &transport{
+		slots: make(chan struct{}, 1),
+		ctx:   ctx,
+		tr:    tr,
+		delay: 5 * time.Second,
+	}, nil
+}
+
+// proxyTransport returns the round tripper of the proxy, the default one if
+// the proxy is empty
+func proxyTransport(proxyURL string) (http.RoundTripper, error) {
 	tr := http.DefaultTransport
 	if proxyURL != "" {
 		u, err := url.Parse(proxyURL)
@@ -624,42 +1331,52 @@ func newTransport(ctx context.Context, proxyURL string) (*transport, error) {
 			return nil, fmt.Errorf("api: unsupported scheme: %s", u.Scheme)
 		}
 	}
-	return &transport{
-		ctx: ctx,
-		tr:  tr,
-	}, nil
+	return tr, nil
 }
 
 type transport struct {
-	lock      sync.Mutex
+	slots     chan struct{}
 	ctx       context.Context
+	lck       sync.RWMutex
 	tr        http.RoundTripper
 	userAgent string
+	languages map[string]string
+	record    string
+	// delay is the pause after each request before the slot is released
+	delay time.Duration
 }
 
 func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
-	r.Header.Set("cache-control", "max-age=0")
-	r.Header.Set("rtt", "150")
-	r.Header.Set("downlink", "10")
-	r.Header.Set("ect", "4g")
-	r.Header.Set("sec-ch-ua", `"Google Chrome";v="89", "Chromium";v="89", ";Not A Brand";v="99"`)
-	r.Header.Set("sec-ch-ua-mobile", "?0")
-	r.Header.Set("upgrade-insecure-requests", "1")
-	r.Header.Set("user-agent", t.userAgent)
-	r.Header.Set("accept", "ext/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9")
-	r.Header.Set("sec-fetch-site", "none")
-	r.Header.Set("sec-fetch-mode", "navigate")
-	r.Header.Set("sec-fetch-user", "?1")
-	r.Header.Set("sec-fetch-dest", "document")
-	r.Header.Set("accept-language", "es-ES,es;q=0.9,en-US;q=0.8,en;q=0.7,eu;q=0.6,fr;q=0.5")
-
-	t.lock.Lock()
+	setHeaders(r, t.userAgent, t.languages)
+
+	_, span := trace.Start(r.Context(), fmt.Sprintf("http %s", r.Method), "http.url", r.URL.String())
+	defer span.End()
+	start := time.Now()
+	slots := t.slots
+	t.lck.RLock()
+	tr, delay := t.tr, t.delay
+	t.lck.RUnlock()
+	select {
+	case slots <- struct{}{}:
+	case <-r.Context().Done():
+		span.Error(r.Context().Err())
+		return nil, r.Context().Err()
+	}
 	defer func() {
 		select {
 		case <-t.ctx.Done():
-		case <-time.After(5000 * time.Millisecond):
+		case <-time.After(delay):
 		}
-		t.lock.Unlock()
+		<-slots
 	}()
-	return t.tr.RoundTrip(r)
+	span.Set("wait", time.Since(start).String())
+	resp, err := tr.RoundTrip(r)
+	span.Error(err)
+	if resp != nil {
+		span.Set("http.status_code", strconv.Itoa(resp.StatusCode))
+		if t.record != "" {
+			t.save(r, resp)
+		}
+	}
+	return resp, err
 }
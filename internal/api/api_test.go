@@ -4,27 +4,33 @@ import (
 	"bytes"
 	_ "embed"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
 var (
-	//go:embed html/de.html
+	//go:embed testdata/golden/de/B000000000.aod0.html
 	de []byte
-	//go:embed html/es.html
+	//go:embed testdata/golden/es/B000000000.aod0.html
 	es []byte
-	//go:embed html/co.uk.html
+	//go:embed testdata/golden/co.uk/B000000000.aod0.html
 	couk []byte
-	//go:embed html/co.jp.html
+	//go:embed testdata/golden/co.jp/B000000000.aod0.html
 	cojp []byte
-	//go:embed html/com.br.html
+	//go:embed testdata/golden/com.br/B000000000.aod0.html
 	combr []byte
-	//go:embed html/com.au.html
+	//go:embed testdata/golden/com.au/B000000000.aod0.html
 	comau []byte
-	//go:embed html/ca.html
+	//go:embed testdata/golden/ca/B000000000.aod0.html
 	ca []byte
-	//go:embed html/com.html
+	//go:embed testdata/golden/com/B000000000.aod0.html
 	com []byte
 )
 
@@ -33,8 +39,8 @@ func TestPrices(t *testing.T) {
 		html []byte
 		want string
 	}{
-		"es":     {es, "11.49 11.50 10.22 10.22 0.00"},
-		"de":     {de, "10.99 10.21 10.22 10.22 0.00"},
+		"es":     {es, "11.49 11.50 10.22 0.00 0.00"},
+		"de":     {de, "10.99 10.21 10.22 0.00 0.00"},
 		"co.uk":  {couk, "15.27 0.00 0.00 0.00 0.00"},
 		"co.jp":  {cojp, "3900.00 0.00 0.00 0.00 0.00"},
 		"com.br": {combr, "164.00 0.00 0.00 0.00 0.00"},
@@ -51,10 +57,369 @@ func TestPrices(t *testing.T) {
 			}
 			var p [5]float64
 			p = extractPrices(domain, "", doc, p)
-			got := fmt.Sprintf("%.2f %.2f %.2f %.2f %.2f", p[0], p[1], p[2], p[2], p[4])
+			got := fmt.Sprintf("%.2f %.2f %.2f %.2f %.2f", p[0], p[1], p[2], p[3], p[4])
 			if tt.want != got {
 				t.Errorf("invalid price: want %s, got %s", tt.want, got)
 			}
 		})
 	}
 }
+
+func TestSellers(t *testing.T) {
+	tests := map[string]struct {
+		html []byte
+		want [5]string
+	}{
+		"es":  {es, [5]string{"Amazon", "FaTBaT - Tienda de gadgets de calidad.", "Amazon Warehouse"}},
+		"de":  {de, [5]string{"Amazon", "FatBat - Hohe Qualität und niedriger Preis!", "Amazon Warehouse"}},
+		"com": {com, [5]string{"Amazon.com"}},
+	}
+	for domain, tt := range tests {
+		tt := tt
+		t.Run(domain, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(bytes.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, got, _ := extractOffers(domain, "", doc, [5]float64{}, [5]string{}, 0, offerFilter{})
+			if tt.want != got {
+				t.Errorf("invalid sellers: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestShippable(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(comau))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// None of the offers of the golden page ship to the delivery location
+	if p, _, _ := extractOffers("com.au", "", doc, [5]float64{}, [5]string{}, 0, offerFilter{}); p[0] == 0 {
+		t.Error("offers skipped without filter")
+	}
+	if p, _, _ := extractOffers("com.au", "", doc, [5]float64{}, [5]string{}, 0, offerFilter{shippable: true}); p[0] != 0 {
+		t.Errorf("offer that doesn't ship not skipped: got %.2f", p[0])
+	}
+	for _, loc := range []string{"ES", "de", "44001", "SW1A 1AA"} {
+		if got, want := isCountry(loc), loc == "ES" || loc == "de"; got != want {
+			t.Errorf("%s: want country %v, got %v", loc, want, got)
+		}
+	}
+}
+
+func TestOutlet(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(es))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Amazon Warehouse offer is the only outlet one of the golden page
+	p, _, outlet := extractOffers("es", "", doc, [5]float64{}, [5]string{}, 0, offerFilter{})
+	if p[1] == 0 || fmt.Sprintf("%.2f", outlet) != "10.22" {
+		t.Errorf("unexpected prices %v and outlet %.2f", p, outlet)
+	}
+	p, _, _ = extractOffers("es", "", doc, [5]float64{}, [5]string{}, 0, offerFilter{outlet: true})
+	if got := fmt.Sprintf("%.2f %.2f %.2f", p[0], p[1], p[2]); got != "11.49 0.00 10.22" {
+		t.Errorf("used offers of other sellers not skipped: got %s", got)
+	}
+	if !isOutlet("Amazon Resale") || isOutlet("FaTBaT") {
+		t.Error("invalid outlet sellers")
+	}
+}
+
+func TestOfferCount(t *testing.T) {
+	tests := map[string]struct {
+		html []byte
+		want int
+	}{
+		"es":  {es, 25},
+		"de":  {de, 32},
+		"com": {com, 1},
+	}
+	for domain, tt := range tests {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(tt.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := offerCount(doc)
+		if !ok || got != tt.want {
+			t.Errorf("%s: invalid offer count: want %d, got %d", domain, tt.want, got)
+		}
+	}
+}
+
+func TestParseDetail(t *testing.T) {
+	page := `<html><head><title>x</title>
+<link rel="canonical" href="https://www.amazon.es/dp/B000000000">
+<style>#productTitle { color: red }</style></head><body>
+<div id="wayfinding-breadcrumbs_feature_div"><ul>
+<li><a href="/a"> Informática </a><li>›<li><a href="/b"><span>Discos</span> SSD<br></a>
+</ul><p>Implicitly closed</div>
+<span id="productTitle">  Disco SSD <b>1TB</b>
+</span>
+<a id="bylineInfo" href="/s">Visita la tienda de Samsung</a>
+<div id="imgTagWrapperId"><img id="landingImage" src="data:x" data-old-hires="https://m.media-amazon.com/x.jpg"></div>
+<div id="availability"><script>var a = "no";</script><span>Disponible el 3 de marzo de 2030.</span></div>
+<div id="tradeInButton_tradeInValue">12,50 €</div>
+</body></html>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := parseDetail(doc)
+	want := detail{
+		title:        "Disco SSD 1TB",
+		link:         "https://www.amazon.es/dp/B000000000",
+		image:        "https://m.media-amazon.com/x.jpg",
+		category:     []string{"Informática", "Discos SSD"},
+		brand:        "Visita la tienda de Samsung",
+		availability: "Disponible el 3 de marzo de 2030.",
+		tradeIn:      "12,50 €",
+	}
+	if !reflect.DeepEqual(*d, want) {
+		t.Errorf("invalid detail: want %+v, got %+v", want, *d)
+	}
+}
+
+func TestParseBrand(t *testing.T) {
+	tests := map[string]string{
+		"Visit the Samsung Store":     "Samsung",
+		"Visita la tienda de SanDisk": "SanDisk",
+		"Besuche den Anker-Store":     "Anker",
+		"Marca: Crucial":              "Crucial",
+		"  Brand:   Kingston  ":       "Kingston",
+		"Logitech":                    "Logitech",
+	}
+	for in, want := range tests {
+		if got := parseBrand(in); got != want {
+			t.Errorf("invalid brand for %q: want %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := map[string]string{
+		"Disponible a partir del 20 de mayo de 2021.":      "2021-05-20",
+		"Dieser Artikel erscheint am 3. Juni 2021.":        "2021-06-03",
+		"This item will be released on August 4, 2021.":    "2021-08-04",
+		"Disponible le 12 juillet 2021.":                   "2021-07-12",
+		"Available from 1 September 2021.":                 "2021-09-01",
+		"この商品は2021/10/15に発売予定です。":                          "2021-10-15",
+		"Currently unavailable. We don't know when or if.": "",
+	}
+	for text, want := range tests {
+		var got string
+		if d, ok := parseDate(text); ok {
+			got = d.Format("2006-01-02")
+		}
+		if want != got {
+			t.Errorf("%s: want %q, got %q", text, want, got)
+		}
+	}
+}
+
+func TestParsePoints(t *testing.T) {
+	tests := map[string]float64{
+		"獲得ポイント: 120pt (10%)":  10,
+		"120ポイント (1.5%)":       1.5,
+		"獲得ポイント:  36pt  (1%)":  1,
+		"ポイント還元なし":             -1,
+		"120pt":                -1,
+		"10% off with coupon":  -1,
+		"獲得ポイント: 120pt (abc%)": -1,
+	}
+	for text, want := range tests {
+		got, ok := parsePoints(text)
+		if !ok {
+			got = -1
+		}
+		if got != want {
+			t.Errorf("%s: want %v, got %v", text, want, got)
+		}
+	}
+	for seller, want := range map[string]bool{"Amazon.co.jp": true, "Amazon": true, "Amazon アウトレット": false, "Amazon Warehouse": false, "LABORSA": false} {
+		if got := isAmazon(seller); got != want {
+			t.Errorf("%s: want amazon %v, got %v", seller, want, got)
+		}
+	}
+}
+
+func TestParseDelivery(t *testing.T) {
+	now := time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"Schnellste Lieferung: Samstag, 15. Mai":                                     "2021-05-15",
+		"Entrega GRATIS: jueves, 20 de mayo. Entrega más rápida: sábado, 15 de mayo": "2021-05-20",
+		"Arrives: Thursday, May 27":                                                  "2021-05-27",
+		"Arrives: Jan 3 - 10":                                                        "2022-01-10",
+		"Livraison GRATUITE : 2 - 7 juin":                                            "2021-06-07",
+		"お届け日: 5月25日":                                                                "2021-05-25",
+		"Bestellung innerhalb 22 Stdn. und 32 Min.":                                  "",
+	}
+	for text, want := range tests {
+		var got string
+		if d, ok := parseDelivery(text, now); ok {
+			got = d.Format("2006-01-02")
+		}
+		if want != got {
+			t.Errorf("%s: want %q, got %q", text, want, got)
+		}
+	}
+	for id, want := range map[string]int{"B0.es?delivery<=5d": 5, "B0.es?delivery=2w": 14, "B0.es": 0} {
+		_, _, opts, err := parseID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.maxDelivery != want {
+			t.Errorf("%s: want %d days, got %d", id, want, opts.maxDelivery)
+		}
+	}
+	if _, _, _, err := parseID("B0.es?delivery<=soon"); err == nil {
+		t.Error("expected error for invalid delivery option")
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	in := `<input type="hidden" name="session-id" value="262-9649097-2113910">` +
+		`<span id="nav-link-accountList-nav-line-1" class="nav-line-1">Hola, Iñigo</span>` +
+		`<script>var opts = {"anti-csrftoken-a2z":"hNmZ2l"}; var mail = "foo@bar.com";</script>`
+	want := `<input type="hidden" name="session-id" value="x">` +
+		`<span id="nav-link-accountList-nav-line-1" class="nav-line-1"></span>` +
+		`<script>var opts = {"anti-csrftoken-a2z":"x"}; var mail = "user@example.com";</script>`
+	if got := string(Sanitize([]byte(in))); got != want {
+		t.Errorf("invalid sanitize: want %s, got %s", want, got)
+	}
+}
+
+func TestDump(t *testing.T) {
+	c := &Client{}
+	c.dump("disabled", []byte("<html></html>"))
+
+	dir := t.TempDir()
+	if err := c.Dump(dir, 25, 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		c.dump(fmt.Sprintf("B00000000%d.es_title", i), []byte("<html>foo@bar.com</html>"))
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each dump is 25 bytes long, only the last one fits
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "_B000000003.es_title.html") {
+		t.Fatalf("unexpected dumps: %v", files)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+	if string(data) != "<html>user@example.com</h" {
+		t.Errorf("dump not sanitized or truncated: %s", data)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Dump(filepath.Join(file, "dumps"), 0, 0); err == nil {
+		t.Error("expected error on a non writable directory")
+	}
+	if c.dumper != nil {
+		t.Error("dumps should be disabled")
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	nav, _ := http.NewRequest("GET", "https://www.amazon.es/dp/B000000000", nil)
+	setHeaders(nav, "agent", nil)
+	xhr, _ := http.NewRequest("POST", "https://www.amazon.es/gp/delivery/ajax/address-change.html", nil)
+	ajax(xhr, "https://www.amazon.es/")
+	setHeaders(xhr, "agent", map[string]string{"es": "eu-ES"})
+
+	tests := []struct {
+		r      *http.Request
+		header string
+		want   string
+	}{
+		{nav, "sec-fetch-site", "none"},
+		{nav, "sec-fetch-mode", "navigate"},
+		{nav, "sec-fetch-dest", "document"},
+		{nav, "upgrade-insecure-requests", "1"},
+		{nav, "x-requested-with", ""},
+		{nav, "accept-language", "es-ES,es;q=0.9,en-US;q=0.8,en;q=0.7"},
+		{xhr, "accept-language", "eu-ES"},
+		{xhr, "sec-fetch-site", "same-origin"},
+		{xhr, "sec-fetch-mode", "cors"},
+		{xhr, "sec-fetch-dest", "empty"},
+		{xhr, "sec-fetch-user", ""},
+		{xhr, "upgrade-insecure-requests", ""},
+		{xhr, "origin", "https://www.amazon.es"},
+		{xhr, "user-agent", "agent"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Header.Get(tt.header); got != tt.want {
+			t.Errorf("%s %s: want %q, got %q", tt.r.URL.Path, tt.header, tt.want, got)
+		}
+	}
+}
+
+func TestItemID(t *testing.T) {
+	tests := map[string]string{
+		"https://www.amazon.es/dp/B000000000":                               "B000000000.es",
+		"mira https://www.amazon.es/Disco-SSD/dp/B000000000/ref=sr_1_1?k=x": "B000000000.es",
+		"https://smile.amazon.de/dp/B000000000":                             "B000000000.de",
+		"https://smile.amazon.co.uk/gp/product/B000000000?psc=1":            "B000000000.co.uk",
+		"https://music.amazon.com/dp/B000000000":                            "B000000000.com",
+		"https://fresh.amazon.co.jp/gp/aw/d/B000000000":                     "B000000000.co.jp",
+		"https://pharmacy.amazon.com/exec/obidos/ASIN/B000000000":           "B000000000.com",
+		"https://WWW.Amazon.IT./dp/B000000000":                              "B000000000.it",
+		"https://www.amazon.at/dp/B000000000":                               "B000000000.de",
+		"https://amazon.jp/dp/B000000000":                                   "B000000000.co.jp",
+		"https://www.amazon.es:443/-/en/dp/B000000000":                      "B000000000.es",
+		"https://www.amazon.com.mx/dp/B000000000":                           "B000000000.com.mx",
+		"https://www.notamazon.es/dp/B000000000":                            "",
+		"https://www.amazon.es/s?k=ssd":                                     "",
+		"https://www.amazon.es/dp/b08xyz1234&psc=1":                         "B08XYZ1234.es",
+		"https://www.amazon.es/dp/B0000/":                                   "",
+		"B000000000":                                                        "",
+	}
+	for link, want := range tests {
+		got, err := ItemID(link)
+		if (err == nil) != (want != "") || got != want {
+			t.Errorf("%s: want %q, got %q (%v)", link, want, got, err)
+		}
+	}
+}
+
+func TestNormalizeID(t *testing.T) {
+	tests := map[string]string{
+		"b08xyz1234.ES?2&vat": "B08XYZ1234.es?2&vat",
+		" B08XYZ1234/.de":     "B08XYZ1234.de",
+		"B08XYZ12.es":         "",
+		"B08XYZ1234":          "",
+	}
+	for id, want := range tests {
+		got, err := NormalizeID(id)
+		if (err == nil) != (want != "") || got != want {
+			t.Errorf("%s: want %q, got %q (%v)", id, want, got, err)
+		}
+	}
+}
+
+func TestMarketplace(t *testing.T) {
+	tests := map[string]string{
+		"www.amazon.co.uk": "co.uk",
+		"Amazon.es":        "es",
+		".de":              "de",
+		"co.jp":            "co.jp",
+		"UK":               "co.uk",
+		"us":               "com",
+		"at":               "de",
+		"com.mx":           "com.mx",
+		"xx":               "",
+		"":                 "",
+	}
+	for s, want := range tests {
+		got, ok := Marketplace(s)
+		if ok != (want != "") || got != want {
+			t.Errorf("%s: want %q, got %q", s, want, got)
+		}
+	}
+}
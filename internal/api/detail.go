@@ -0,0 +1,74 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// detail are the fields of the product page used by the search
+type detail struct {
+	title        string
+	link         string
+	image        string
+	category     []string
+	brand        string
+	availability string
+	tradeIn      string
+	points       string
+}
+
+// parseDetail extracts the detail fields of the product page
+func parseDetail(doc *goquery.Document) *detail {
+	d := &detail{
+		title:        selectionText(doc.Find("#productTitle").First()),
+		brand:        selectionText(doc.Find("#bylineInfo").First()),
+		availability: selectionText(doc.Find("#availability").First()),
+		tradeIn:      selectionText(doc.Find("#tradeInButton_tradeInValue").First()),
+	}
+
+	// search link
+	doc.Find("link").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if rel, _ := s.Attr("rel"); rel != "canonical" {
+			return true
+		}
+		d.link, _ = s.Attr("href")
+		return false
+	})
+
+	// search image
+	doc.Find("#landingImage, #imgBlkFront").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		for _, attr := range []string{"data-old-hires", "src"} {
+			if v, ok := s.Attr(attr); ok && strings.HasPrefix(v, "http") {
+				d.image = v
+				return false
+			}
+		}
+		return true
+	})
+
+	// search category breadcrumb
+	doc.Find("#wayfinding-breadcrumbs_feature_div li a").Each(func(i int, s *goquery.Selection) {
+		if c := selectionText(s); c != "" {
+			d.category = append(d.category, c)
+		}
+	})
+
+	// search points-back text, it may be split in several blocks
+	var points []string
+	doc.Find("#points_feature_div, #pointsInsideBuyBox_feature_div").Each(func(i int, s *goquery.Selection) {
+		if p := selectionText(s); p != "" {
+			points = append(points, p)
+		}
+	})
+	d.points = strings.Join(points, " ")
+	return d
+}
+
+// selectionText returns the text of the selection without its scripts and
+// styles and with the whitespace collapsed
+func selectionText(s *goquery.Selection) string {
+	s = s.Clone()
+	s.Find("script, style").Remove()
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func usedText(domain string) string {
@@ -63,6 +64,146 @@ func Coin(domain string) string {
 	}
 }
 
+// CurrencySymbol returns the symbol of an ISO 4217 currency code, the code
+// itself if it is unknown.
+func CurrencySymbol(currency string) string {
+	switch currency = strings.ToUpper(currency); currency {
+	case "USD", "CAD", "AUD":
+		return "$"
+	case "GBP":
+		return "£"
+	case "JPY":
+		return "¥"
+	case "BRL":
+		return "R$"
+	case "EUR":
+		return "€"
+	default:
+		return fmt.Sprintf(" %s", currency)
+	}
+}
+
+// Currency returns the ISO 4217 currency code of the domain.
+func Currency(domain string) string {
+	switch domain {
+	case "com":
+		return "USD"
+	case "ca":
+		return "CAD"
+	case "com.au":
+		return "AUD"
+	case "co.uk":
+		return "GBP"
+	case "co.jp":
+		return "JPY"
+	case "com.br":
+		return "BRL"
+	case "se":
+		return "SEK"
+	case "pl":
+		return "PLN"
+	default:
+		return "EUR"
+	}
+}
+
+// domainAliases are vanity domains redirected to a marketplace
+var domainAliases = map[string]string{
+	"at": "de",
+	"ch": "de",
+	"uk": "co.uk",
+	"jp": "co.jp",
+}
+
+// marketplace returns the domain of the marketplace of an amazon host,
+// storefront subdomains like smile, music or fresh are ignored
+func marketplace(host string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	idx := strings.LastIndex("."+host, ".amazon.")
+	if idx < 0 {
+		return "", false
+	}
+	domain := host[idx+len("amazon."):]
+	if alias, ok := domainAliases[domain]; ok {
+		domain = alias
+	}
+	return domain, domain != ""
+}
+
+// countryDomains are the country codes of the marketplaces whose domain
+// isn't the country code
+var countryDomains = map[string]string{
+	"us": "com",
+	"gb": "co.uk",
+	"be": "com.be",
+	"au": "com.au",
+	"br": "com.br",
+	"mx": "com.mx",
+}
+
+// Marketplace returns the domain of a marketplace written as an amazon host,
+// a domain (co.uk, .es) or a country code (uk, us)
+func Marketplace(s string) (string, bool) {
+	s = strings.Trim(strings.ToLower(strings.TrimSpace(s)), ".")
+	domain, ok := marketplace(s)
+	if !ok {
+		domain, ok = countryDomains[s]
+	}
+	if !ok {
+		domain, ok = marketplace("amazon." + s)
+	}
+	if _, supported := locales[domain]; !ok || !supported {
+		return "", false
+	}
+	return domain, true
+}
+
+var locales = map[string]string{
+	"es":     "es-ES",
+	"de":     "de-DE",
+	"fr":     "fr-FR",
+	"it":     "it-IT",
+	"nl":     "nl-NL",
+	"se":     "sv-SE",
+	"pl":     "pl-PL",
+	"com.be": "fr-BE",
+	"co.uk":  "en-GB",
+	"com":    "en-US",
+	"ca":     "en-CA",
+	"com.au": "en-AU",
+	"co.jp":  "ja-JP",
+	"com.br": "pt-BR",
+	"com.mx": "es-MX",
+}
+
+// acceptLanguage returns the accept-language header of a browser in the
+// locale of the domain, with english as fallback
+func acceptLanguage(domain string) string {
+	locale, ok := locales[domain]
+	if !ok {
+		return "en-US,en;q=0.9"
+	}
+	lang := strings.SplitN(locale, "-", 2)[0]
+	if lang == "en" {
+		return fmt.Sprintf("%s,en;q=0.9", locale)
+	}
+	return fmt.Sprintf("%s,%s;q=0.9,en-US;q=0.8,en;q=0.7", locale, lang)
+}
+
+var vatRates = map[string]float64{
+	"es":     21,
+	"de":     19,
+	"fr":     20,
+	"it":     22,
+	"nl":     21,
+	"se":     25,
+	"pl":     23,
+	"com.be": 21,
+	"co.uk":  20,
+	"co.jp":  10,
+	"com.au": 10,
+}
+
 var priceRegex = map[string]*regexp.Regexp{
 	"es":     regexp.MustCompile(`([.0-9]+),([0-9][0-9]) €`),
 	"it":     regexp.MustCompile(`([.0-9]+),([0-9][0-9]) €`),
@@ -99,3 +240,160 @@ func parsePrice(domain, text string) (float64, error) {
 	}
 	return price, nil
 }
+
+var months = map[string]time.Month{
+	"january": 1, "february": 2, "march": 3, "april": 4, "may": 5, "june": 6,
+	"july": 7, "august": 8, "september": 9, "october": 10, "november": 11, "december": 12,
+	"enero": 1, "febrero": 2, "marzo": 3, "abril": 4, "mayo": 5, "junio": 6,
+	"julio": 7, "agosto": 8, "septiembre": 9, "octubre": 10, "noviembre": 11, "diciembre": 12,
+	"januar": 1, "februar": 2, "märz": 3, "mai": 5, "juni": 6,
+	"juli": 7, "oktober": 10, "dezember": 12,
+	"janvier": 1, "février": 2, "mars": 3, "avril": 4, "juin": 6,
+	"juillet": 7, "août": 8, "septembre": 9, "octobre": 10, "novembre": 11, "décembre": 12,
+	"gennaio": 1, "febbraio": 2, "aprile": 4, "maggio": 5, "giugno": 6,
+	"luglio": 7, "settembre": 9, "ottobre": 10, "dicembre": 12,
+	"janeiro": 1, "fevereiro": 2, "março": 3, "maio": 5, "junho": 6,
+	"julho": 7, "setembro": 9, "outubro": 10, "dezembro": 12,
+}
+
+var (
+	dayMonthRegex = regexp.MustCompile(`(\d{1,2})\.?\s+(?:de\s+)?(\pL+)\.?\s+(?:de\s+)?(\d{4})`)
+	monthDayRegex = regexp.MustCompile(`(\pL+)\.?\s+(\d{1,2}),?\s+(\d{4})`)
+	numericRegex  = regexp.MustCompile(`(\d{4})[/年](\d{1,2})[/月](\d{1,2})`)
+)
+
+// parseDate looks for a date in the text, written in any of the supported
+// marketplace languages.
+func parseDate(text string) (time.Time, bool) {
+	text = strings.Replace(text, string('\u00A0'), " ", -1)
+	date := func(year, month, day string) (time.Time, bool) {
+		y, _ := strconv.Atoi(year)
+		d, _ := strconv.Atoi(day)
+		m, ok := months[strings.ToLower(month)]
+		if !ok {
+			n, err := strconv.Atoi(month)
+			if err != nil || n < 1 || n > 12 {
+				return time.Time{}, false
+			}
+			m = time.Month(n)
+		}
+		if d < 1 || d > 31 {
+			return time.Time{}, false
+		}
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), true
+	}
+	if sm := numericRegex.FindStringSubmatch(text); len(sm) == 4 {
+		if t, ok := date(sm[1], sm[2], sm[3]); ok {
+			return t, true
+		}
+	}
+	for _, sm := range dayMonthRegex.FindAllStringSubmatch(text, -1) {
+		if t, ok := date(sm[3], sm[2], sm[1]); ok {
+			return t, true
+		}
+	}
+	for _, sm := range monthDayRegex.FindAllStringSubmatch(text, -1) {
+		if t, ok := date(sm[3], sm[1], sm[2]); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+var (
+	deliveryDayMonthRegex = regexp.MustCompile(`(?:\d{1,2}\.?\s*[-–]\s*)?(\d{1,2})\.?\s+(?:de\s+)?(\pL+)`)
+	deliveryMonthDayRegex = regexp.MustCompile(`(\pL+)\.?\s+(?:\d{1,2}\s*[-–]\s*)?(\d{1,2})\b`)
+	deliveryJapanRegex    = regexp.MustCompile(`(\d{1,2})月(\d{1,2})日`)
+)
+
+// month returns the month of a name or an abbreviation of at least three
+// letters
+func month(name string) (time.Month, bool) {
+	name = strings.ToLower(name)
+	if m, ok := months[name]; ok {
+		return m, true
+	}
+	if len([]rune(name)) < 3 {
+		return 0, false
+	}
+	var found time.Month
+	for k, m := range months {
+		if !strings.HasPrefix(k, name) {
+			continue
+		}
+		if found != 0 && found != m {
+			return 0, false
+		}
+		found = m
+	}
+	return found, found != 0
+}
+
+// parseDelivery looks for the first delivery date of an offer, written
+// without year in any of the supported marketplace languages, the end of a
+// range is taken and the year is inferred from now.
+func parseDelivery(text string, now time.Time) (time.Time, bool) {
+	text = strings.Replace(text, string('\u00A0'), " ", -1)
+	var first time.Time
+	pos := -1
+	add := func(idx int, m time.Month, day string) {
+		d, _ := strconv.Atoi(day)
+		if d < 1 || d > 31 || (pos >= 0 && idx >= pos) {
+			return
+		}
+		t := time.Date(now.Year(), m, d, 0, 0, 0, 0, time.UTC)
+		// Dates long past are deliveries of the next year
+		if t.Before(now.AddDate(0, 0, -7)) {
+			t = t.AddDate(1, 0, 0)
+		}
+		first, pos = t, idx
+	}
+	for _, sm := range deliveryDayMonthRegex.FindAllStringSubmatchIndex(text, -1) {
+		if m, ok := month(text[sm[4]:sm[5]]); ok {
+			add(sm[0], m, text[sm[2]:sm[3]])
+		}
+	}
+	for _, sm := range deliveryMonthDayRegex.FindAllStringSubmatchIndex(text, -1) {
+		if m, ok := month(text[sm[2]:sm[3]]); ok {
+			add(sm[0], m, text[sm[4]:sm[5]])
+		}
+	}
+	for _, sm := range deliveryJapanRegex.FindAllStringSubmatchIndex(text, -1) {
+		if n, _ := strconv.Atoi(text[sm[2]:sm[3]]); n >= 1 && n <= 12 {
+			add(sm[0], time.Month(n), text[sm[4]:sm[5]])
+		}
+	}
+	return first, pos >= 0
+}
+
+// parseDays parses a number of days like 5, 5d or 2w
+func parseDays(text string) (int, bool) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(text, "d"):
+		text = strings.TrimSuffix(text, "d")
+	case strings.HasSuffix(text, "w"):
+		text = strings.TrimSuffix(text, "w")
+		mult = 7
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n * mult, true
+}
+
+var pointsRegex = regexp.MustCompile(`(?:pt|ポイント)\s*\((\d+(?:\.\d+)?)%\)`)
+
+// parsePoints returns the points-back percentage of an amazon.co.jp offer.
+func parsePoints(text string) (float64, bool) {
+	sm := pointsRegex.FindStringSubmatch(text)
+	if len(sm) < 2 {
+		return 0, false
+	}
+	points, err := strconv.ParseFloat(sm[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return points, true
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// dumper saves the pages that couldn't be parsed to debug layout changes
+type dumper struct {
+	lck     sync.Mutex
+	dir     string
+	maxSize int64
+	keep    int
+}
+
+// Dump saves the sanitized pages that couldn't be parsed in the directory,
+// the oldest dumps are removed when the directory exceeds maxSize bytes or
+// keep files (0 means no limit). An empty directory disables dumps, an error
+// is returned if the directory isn't writable, e.g. on a read-only
+// filesystem, and dumps stay disabled.
+func (c *Client) Dump(dir string, maxSize int64, keep int) error {
+	c.dumper = nil
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("api: dump directory not writable: %w", err)
+	}
+	f, err := ioutil.TempFile(dir, ".probe")
+	if err != nil {
+		return fmt.Errorf("api: dump directory not writable: %w", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	c.dumper = &dumper{dir: dir, maxSize: maxSize, keep: keep}
+	return nil
+}
+
+// dump saves the page as <time>_<name>.html and rotates the directory
+func (c *Client) dump(name string, html []byte) {
+	d := c.dumper
+	if d == nil || len(html) == 0 {
+		return
+	}
+	d.lck.Lock()
+	defer d.lck.Unlock()
+	if d.dir == "" {
+		return
+	}
+	html = Sanitize(html)
+	if d.maxSize > 0 && int64(len(html)) > d.maxSize {
+		html = html[:d.maxSize]
+	}
+	path := filepath.Join(d.dir, fmt.Sprintf("%s_%s.html", time.Now().UTC().Format("20060102T150405.000"), filepath.Base(name)))
+	if err := ioutil.WriteFile(path, html, 0644); err != nil {
+		log.Println(fmt.Errorf("api: couldn't dump %s: %w", name, err))
+		if errors.Is(err, syscall.EROFS) {
+			d.dir = ""
+		}
+		return
+	}
+	if err := d.rotate(); err != nil {
+		log.Println(err)
+	}
+}
+
+// rotate removes the oldest dumps until the limits are satisfied
+func (d *dumper) rotate() error {
+	if d.maxSize <= 0 && d.keep <= 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return fmt.Errorf("api: couldn't rotate dumps: %w", err)
+	}
+	var dumps []os.FileInfo
+	var size int64
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".html" {
+			continue
+		}
+		dumps = append(dumps, f)
+		size += f.Size()
+	}
+	sort.Slice(dumps, func(i, j int) bool {
+		return dumps[i].Name() < dumps[j].Name()
+	})
+	for len(dumps) > 0 {
+		if (d.keep <= 0 || len(dumps) <= d.keep) && (d.maxSize <= 0 || size <= d.maxSize) {
+			break
+		}
+		if err := os.Remove(filepath.Join(d.dir, dumps[0].Name())); err != nil {
+			return fmt.Errorf("api: couldn't rotate dumps: %w", err)
+		}
+		size -= dumps[0].Size()
+		dumps = dumps[1:]
+	}
+	return nil
+}
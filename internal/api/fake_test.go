@@ -0,0 +1,372 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAmazon serves the golden fixtures of amazon.es along with the location
+// modal, captcha challenges until they are solved and 503 errors
+type fakeAmazon struct {
+	lock     sync.Mutex
+	captchas int
+	errors   int
+	resets   int
+	located  bool
+	// referers of the first request per path
+	referers map[string]string
+}
+
+const captchaPage = `<html><body><form method="get" action="/errors/validateCaptcha">
+<input type="hidden" name="amzn" value="a1"><input type="hidden" name="amzn-r" value="%s">
+<img src="https://images-na.ssl-images-amazon.com/captcha/x/Captcha_x.jpg">
+<input type="text" id="captchacharacters" name="field-keywords"></form></body></html>`
+
+const homePage = `<html><body>
+<span id="glow-ingress-line2">%s</span>
+<span id="nav-global-location-data-modal-action" data-a-modal='{"url":"/gp/glow/get-address-selections.html","ajaxHeaders":{"anti-csrftoken-a2z":"modal-token"}}'></span>
+</body></html>`
+
+func (f *fakeAmazon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.referers[r.URL.Path]; !ok && f.referers != nil {
+		f.referers[r.URL.Path] = r.Header.Get("referer")
+	}
+	// The captcha service path contains the image url, so the mux isn't used
+	switch p := r.URL.Path; {
+	case strings.HasPrefix(p, "/captcha/"):
+		fmt.Fprint(w, "AAFXMX")
+	case p == "/" || p == "":
+		f.resets++
+		location := "España"
+		if c, err := r.Cookie("location"); err == nil {
+			location = c.Value
+		}
+		fmt.Fprintf(w, homePage, location)
+	case p == "/gp/glow/get-address-selections.html":
+		if r.Header.Get("anti-csrftoken-a2z") != "modal-token" {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `<script>P.when("A").execute(function(A){ var CSRF_TOKEN : "change-token"; });</script>`)
+	case p == "/gp/delivery/ajax/address-change.html":
+		_ = r.ParseForm()
+		if r.Header.Get("anti-csrftoken-a2z") != "change-token" || r.PostForm.Get("zipCode") == "" || r.Header.Get("origin") == "" {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		f.located = true
+		http.SetCookie(w, &http.Cookie{Name: "location", Value: r.PostForm.Get("zipCode"), Path: "/"})
+		fmt.Fprint(w, "{}")
+	case p == "/errors/validateCaptcha":
+		q := r.URL.Query()
+		if q.Get("field-keywords") != "AAFXMX" || q.Get("amzn") != "a1" {
+			http.Error(w, "invalid captcha", http.StatusForbidden)
+			return
+		}
+		f.captchas--
+		http.Redirect(w, r, q.Get("amzn-r"), http.StatusFound)
+	case strings.HasPrefix(p, "/gp/") && !strings.HasPrefix(p, "/gp/aod/"):
+		fmt.Fprint(w, "<html><body></body></html>")
+	case strings.HasPrefix(p, "/dp/"):
+		if f.captchas > 0 {
+			fmt.Fprintf(w, captchaPage, p)
+			return
+		}
+		f.serve(w, r)
+	case strings.HasPrefix(p, "/gp/aod/ajax"):
+		if r.Header.Get("sec-fetch-mode") != "cors" || !strings.Contains(r.Header.Get("referer"), "/dp/") {
+			http.Error(w, "not an ajax call of the product page", http.StatusBadRequest)
+			return
+		}
+		if f.errors > 0 {
+			f.errors--
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		f.serve(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serve writes the fixture of the product or offer listing page
+func (f *fakeAmazon) serve(w http.ResponseWriter, r *http.Request) {
+	u := *r.URL
+	u.Host = r.Host
+	data, err := ioutil.ReadFile(fixturePath(filepath.Join("testdata", "golden"), &u))
+	if err != nil && strings.HasPrefix(u.Path, "/dp/") {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+// rewriteTransport sends the amazon requests to the fake server keeping the
+// original host header
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := r.Clone(r.Context())
+	r2.URL.Scheme = t.target.Scheme
+	r2.URL.Host = t.target.Host
+	r2.Host = r.URL.Host
+	return http.DefaultTransport.RoundTrip(r2)
+}
+
+func TestFakeAmazon(t *testing.T) {
+	tests := map[string]struct {
+		captchas int
+		errors   int
+		resets   int
+		solved   int
+	}{
+		"location": {resets: 1},
+		"captcha":  {captchas: 1, resets: 1, solved: 1},
+		"retry":    {errors: 1, resets: 2},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeAmazon{captchas: tt.captchas, errors: tt.errors}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+			target, _ := url.Parse(srv.URL)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c, err := New(ctx, srv.URL+"/captcha", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.transport.tr = rewriteTransport{target: target}
+			c.transport.delay = 0
+			solved := 0
+			c.OnCaptcha(func(string) { solved++ })
+
+			var item Item
+			if err := c.SearchContext(ctx, "B000000000.es", &item, func(Item, Alert) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+			if item.Title != "Disco SSD 1TB" || float32(item.Prices[0]) != 11.49 {
+				t.Errorf("invalid item: %+v", item)
+			}
+			if !fake.located {
+				t.Error("location not changed")
+			}
+			if fake.resets != tt.resets {
+				t.Errorf("invalid resets: want %d, got %d", tt.resets, fake.resets)
+			}
+			if solved != tt.solved {
+				t.Errorf("invalid captchas solved: want %d, got %d", tt.solved, solved)
+			}
+		})
+	}
+}
+
+func TestFakeAmazonCancel(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/captcha/") {
+			_, _ = w.Write([]byte("ABCDEF"))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+	target, _ := url.Parse(srv.URL)
+
+	c, err := New(context.Background(), srv.URL+"/captcha", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.transport.tr = rewriteTransport{target: target}
+	c.transport.delay = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	errC := make(chan error, 1)
+	go func() {
+		var item Item
+		errC <- c.SearchContext(ctx, "B000000000.es", &item, func(Item, Alert) error { return nil })
+	}()
+	select {
+	case err := <-errC:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("search not cancelled")
+	}
+}
+
+func TestFakeAmazonWarmUp(t *testing.T) {
+	fake := &fakeAmazon{referers: make(map[string]string)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := New(ctx, srv.URL+"/captcha", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.transport.tr = rewriteTransport{target: target}
+	c.transport.delay = 0
+	c.WarmUp(time.Millisecond)
+
+	var item Item
+	if err := c.SearchContext(ctx, "B000000000.es", &item, func(Item, Alert) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	var category string
+	for _, p := range warmUpPages {
+		if _, ok := fake.referers[p]; ok {
+			category = p
+		}
+	}
+	if category == "" {
+		t.Fatalf("category page not visited: %v", fake.referers)
+	}
+	if got := fake.referers[category]; got != "https://www.amazon.es/" {
+		t.Errorf("invalid category referer: %s", got)
+	}
+	if got, want := fake.referers["/dp/B000000000"], "https://www.amazon.es"+category; got != want {
+		t.Errorf("invalid product referer: want %s, got %s", want, got)
+	}
+}
+
+func TestFakeAmazonMinState(t *testing.T) {
+	fake := &fakeAmazon{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+	ctx := context.Background()
+	c, err := New(ctx, srv.URL+"/captcha", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.transport.tr = rewriteTransport{target: target}
+	c.transport.delay = 0
+
+	tests := []struct {
+		id     string
+		min    float32
+		states []int
+	}{
+		{"B000000000.es", 11.49, []int{0, 2}},
+		{"B000000000.es?min=1", 11.49, []int{0, 1, 2}},
+		{"B000000000.es?min=2", 10.22, []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		item := Item{MinPrice: 12, Prices: [5]float64{12, 12, 12}}
+		var states []int
+		if err := c.SearchContext(ctx, tt.id, &item, func(_ Item, a Alert) error {
+			states = append(states, a.State)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if float32(item.MinPrice) != tt.min || fmt.Sprint(states) != fmt.Sprint(tt.states) {
+			t.Errorf("%s: got min %.2f and alerts %v, want %.2f and %v", tt.id, item.MinPrice, states, tt.min, tt.states)
+		}
+	}
+	if _, _, _, err := parseID("B000000000.es?min=5"); err == nil {
+		t.Error("invalid min state accepted")
+	}
+}
+
+func TestFakeAmazonAnyUsed(t *testing.T) {
+	fake := &fakeAmazon{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+	ctx := context.Background()
+	c, err := New(ctx, srv.URL+"/captcha", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.transport.tr = rewriteTransport{target: target}
+	c.transport.delay = 0
+
+	search := func(id string, item *Item) []Alert {
+		var alerts []Alert
+		if err := c.SearchContext(ctx, id, item, func(_ Item, a Alert) error {
+			alerts = append(alerts, a)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return alerts
+	}
+	item := Item{ID: "B000000000", Domain: "es", MinPrice: 12, Prices: [5]float64{12}}
+	alerts := search("B000000000.es?any-used", &item)
+	if len(alerts) != 2 || alerts[0].Kind != UsedAlert || alerts[0].State != 2 || alerts[1].State != 0 {
+		t.Errorf("unexpected alerts %+v", alerts)
+	}
+	if alerts := search("B000000000.es?any-used", &item); len(alerts) != 0 {
+		t.Errorf("used offers reported again %+v", alerts)
+	}
+	var unknown Item
+	for _, a := range search("B000000000.es?any-used", &unknown) {
+		if a.Kind == UsedAlert {
+			t.Errorf("used offers reported on first search %+v", a)
+		}
+	}
+}
+
+func TestFakeAmazonWait(t *testing.T) {
+	fake := &fakeAmazon{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+	ctx := context.Background()
+	c, err := New(ctx, srv.URL+"/captcha", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.transport.tr = rewriteTransport{target: target}
+	c.transport.delay = 0
+
+	search := func(item *Item) {
+		if err := c.SearchContext(ctx, "B000000000.es?wait", item, func(Item, Alert) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Items without availability date are scraped
+	var item Item
+	search(&item)
+	if item.Title == "" || item.Available == nil || item.Available.Format("2006-01-02") != "2030-03-03" {
+		t.Fatalf("unexpected item %+v", item)
+	}
+	// The item isn't scraped until the day after the availability date
+	item.Title = ""
+	search(&item)
+	if item.Title != "" {
+		t.Error("item scraped before its availability date")
+	}
+	past := time.Now().Add(-25 * time.Hour)
+	item.Available = &past
+	search(&item)
+	if item.Title == "" {
+		t.Error("item not scraped after its availability date")
+	}
+}
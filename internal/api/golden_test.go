@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Goldens are refreshed with: go test ./internal/api -run TestGolden -update
+var update = flag.Bool("update", false, "update golden files")
+
+// fixtureTransport serves the pages recorded with Client.Record, missing
+// offer listing pages are empty
+type fixtureTransport struct {
+	dir string
+}
+
+func (f fixtureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	path := fixturePath(f.dir, r.URL)
+	data, err := ioutil.ReadFile(path)
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, os.ErrNotExist) && strings.Contains(path, ".aod"):
+	case errors.Is(err, os.ErrNotExist):
+		status = http.StatusNotFound
+	case err != nil:
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       ioutil.NopCloser(bytes.NewReader(data)),
+		Header:     http.Header{"Content-Type": []string{"text/html"}},
+		Request:    r,
+	}, nil
+}
+
+type golden struct {
+	Item   Item    `json:"item"`
+	Alerts []Alert `json:"alerts"`
+}
+
+// TestGolden runs the search of each recorded product of
+// testdata/golden/<domain>/<asin>.html and compares the result with
+// <asin>.golden.json
+func TestGolden(t *testing.T) {
+	pages, err := filepath.Glob(filepath.Join("testdata", "golden", "*", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html")
+		if strings.Contains(name, ".") {
+			continue
+		}
+		dir := filepath.Dir(page)
+		domain := filepath.Base(dir)
+		t.Run(domain+"/"+name, func(t *testing.T) {
+			c := &Client{
+				ctx:      context.Background(),
+				client:   &http.Client{Transport: fixtureTransport{dir: filepath.Dir(dir)}},
+				started:  map[string]struct{}{domain: {}},
+				vat:      map[string]float64{},
+				parallel: 1,
+			}
+			var got golden
+			if err := c.SearchContext(context.Background(), name+"."+domain, &got.Item, func(_ Item, a Alert) error {
+				got.Alerts = append(got.Alerts, a)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, name+".golden.json")
+			if *update {
+				data, err := json.MarshalIndent(got, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want golden
+			if err := json.Unmarshal(data, &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(want, got) {
+				t.Errorf("invalid search result:\nwant %+v\ngot  %+v", want, got)
+			}
+		})
+	}
+}
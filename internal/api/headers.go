@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ajax marks the request as an ajax call made by the referer page
+func ajax(r *http.Request, referer string) {
+	r.Header.Set("x-requested-with", "XMLHttpRequest")
+	r.Header.Set("referer", referer)
+}
+
+// setHeaders sets the headers a browser sends for the request, navigation
+// headers for documents and cors headers for the ajax calls. The language
+// overrides the one of the domain per domain.
+func setHeaders(r *http.Request, userAgent string, languages map[string]string) {
+	r.Header.Set("rtt", "150")
+	r.Header.Set("downlink", "10")
+	r.Header.Set("ect", "4g")
+	r.Header.Set("sec-ch-ua", `"Google Chrome";v="89", "Chromium";v="89", ";Not A Brand";v="99"`)
+	r.Header.Set("sec-ch-ua-mobile", "?0")
+	r.Header.Set("user-agent", userAgent)
+	domain := strings.TrimPrefix(r.URL.Hostname(), "www.amazon.")
+	language, ok := languages[domain]
+	if !ok {
+		language = acceptLanguage(domain)
+	}
+	r.Header.Set("accept-language", language)
+	r.Header.Set("sec-fetch-site", fetchSite(r))
+
+	if r.Header.Get("x-requested-with") != "" {
+		r.Header.Set("accept", "text/html,*/*")
+		r.Header.Set("sec-fetch-mode", "cors")
+		r.Header.Set("sec-fetch-dest", "empty")
+		if r.Method != http.MethodGet {
+			r.Header.Set("origin", fmt.Sprintf("%s://%s", r.URL.Scheme, r.URL.Host))
+		}
+		return
+	}
+	r.Header.Set("cache-control", "max-age=0")
+	r.Header.Set("upgrade-insecure-requests", "1")
+	r.Header.Set("accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9")
+	r.Header.Set("sec-fetch-mode", "navigate")
+	r.Header.Set("sec-fetch-user", "?1")
+	r.Header.Set("sec-fetch-dest", "document")
+}
+
+// fetchSite returns the relation between the referer and the request,
+// none for typed urls without referer
+func fetchSite(r *http.Request) string {
+	referer := r.Header.Get("referer")
+	if referer == "" {
+		return "none"
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return "cross-site"
+	}
+	if u.Scheme == r.URL.Scheme && u.Host == r.URL.Host {
+		return "same-origin"
+	}
+	return "cross-site"
+}
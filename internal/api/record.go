@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Record saves the sanitized html responses in the directory to be used as
+// test fixtures, product pages are saved as <domain>/<asin>.html and offer
+// listing pages as <domain>/<asin>.aod<page>.html, the layout of the golden
+// tests in testdata/golden
+func (c *Client) Record(dir string) {
+	c.transport.record = dir
+}
+
+var sanitizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Session and customer ids
+	{regexp.MustCompile(`\d{3}-\d{7}-\d{7}`), "000-0000000-0000000"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "user@example.com"},
+	// Tokens
+	{regexp.MustCompile(`((?i:csrf|token)[\w-]*["']?\s*[:=]\s*["'])[^"']+`), "${1}x"},
+	{regexp.MustCompile(`(name="(?:amzn|amzn-r|session-id|ubid-main)"\s+value=")[^"]*`), "${1}x"},
+	// Customer name and delivery location
+	{regexp.MustCompile(`(id="(?:glow-ingress-line2|nav-link-accountList-nav-line-1|nav-greeting-name)"[^>]*>)[^<]*`), "${1}"},
+}
+
+// Sanitize removes cookies, tokens and personal data of an html response
+func Sanitize(html []byte) []byte {
+	for _, s := range sanitizers {
+		html = s.re.ReplaceAll(html, []byte(s.repl))
+	}
+	return html
+}
+
+// fixturePath returns the path of the fixture of the url, empty if the url
+// isn't a product or offer listing page
+func fixturePath(dir string, u *url.URL) string {
+	domain := strings.TrimPrefix(u.Hostname(), "www.amazon.")
+	if domain == u.Hostname() {
+		return ""
+	}
+	var name string
+	switch {
+	case strings.HasPrefix(u.Path, "/dp/"):
+		name = fmt.Sprintf("%s.html", strings.SplitN(strings.TrimPrefix(u.Path, "/dp/"), "/", 2)[0])
+	case strings.HasPrefix(u.Path, "/gp/aod/ajax"):
+		q := u.Query()
+		name = fmt.Sprintf("%s.aod%s.html", q.Get("asin"), q.Get("pageno"))
+	default:
+		return ""
+	}
+	return filepath.Join(dir, domain, filepath.Base(name))
+}
+
+// save records the body of the response replacing it with a copy
+func (t *transport) save(r *http.Request, resp *http.Response) {
+	path := fixturePath(t.record, r.URL)
+	if path == "" || resp.StatusCode != http.StatusOK {
+		return
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		log.Println(fmt.Errorf("api: couldn't record %s: %w", r.URL, err))
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Println(fmt.Errorf("api: couldn't record %s: %w", r.URL, err))
+		return
+	}
+	if err := ioutil.WriteFile(path, Sanitize(data), 0644); err != nil {
+		log.Println(fmt.Errorf("api: couldn't record %s: %w", r.URL, err))
+	}
+}
+
+// ExpectedPrices returns the prices extracted from an offer listing fixture
+// formatted as the expected values of TestPrices
+func ExpectedPrices(domain string, html []byte) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return "", fmt.Errorf("api: couldn't parse fixture: %w", err)
+	}
+	var p [5]float64
+	p = extractPrices(domain, "", doc, p)
+	return fmt.Sprintf("%.2f %.2f %.2f %.2f %.2f", p[0], p[1], p[2], p[3], p[4]), nil
+}
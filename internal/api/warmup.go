@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// warmUpPages are the category pages visited before the product page
+var warmUpPages = []string{
+	"/gp/bestsellers/",
+	"/gp/new-releases/",
+	"/gp/movers-and-shakers/",
+	"/gp/goldbox",
+}
+
+// WarmUp enables browsing a category page and the product page after each
+// session reset with random pauses up to max between them, which reduces
+// the captchas of fresh sessions. Zero disables it. It must be called before
+// searching.
+func (c *Client) WarmUp(max time.Duration) {
+	c.warmUp = max
+}
+
+// browse visits a category page from the home page and then the product
+// page, failures are logged and ignored
+func (c *Client) browse(ctx context.Context, domain, id string) {
+	if c.warmUp <= 0 {
+		return
+	}
+	home := fmt.Sprintf("https://www.amazon.%s/", domain)
+	pages := []string{
+		home + warmUpPages[rand.Intn(len(warmUpPages))][1:],
+		fmt.Sprintf("%sdp/%s", home, id),
+	}
+	referer := home
+	for _, u := range pages {
+		pause := c.warmUp/2 + time.Duration(rand.Int63n(int64(c.warmUp/2)+1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pause):
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			log.Println(fmt.Errorf("api: warm up failed: %w", err))
+			return
+		}
+		req.Header.Set("referer", referer)
+		if _, err := c.getDocWithReq(req, id, 0); err != nil {
+			log.Println(fmt.Errorf("api: warm up failed: %w", err))
+			return
+		}
+		referer = u
+	}
+}
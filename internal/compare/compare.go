@@ -0,0 +1,53 @@
+package compare
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Listing is the best offer found on an external site
+type Listing struct {
+	Price    float64
+	Currency string
+	Link     string
+}
+
+// Comparer finds the best price of an item on an external site
+type Comparer interface {
+	Name() string
+	BestPrice(title, domain string, used bool) (Listing, bool, error)
+}
+
+type cached struct {
+	Comparer
+	cache *cache.Cache
+}
+
+type result struct {
+	listing Listing
+	ok      bool
+}
+
+// Cached wraps a comparer caching its results to limit external calls
+func Cached(c Comparer, ttl time.Duration) Comparer {
+	return &cached{
+		Comparer: c,
+		cache:    cache.New(ttl, ttl),
+	}
+}
+
+func (c *cached) BestPrice(title, domain string, used bool) (Listing, bool, error) {
+	key := fmt.Sprintf("%s/%t/%s", domain, used, title)
+	if v, ok := c.cache.Get(key); ok {
+		r := v.(result)
+		return r.listing, r.ok, nil
+	}
+	l, ok, err := c.Comparer.BestPrice(title, domain, used)
+	if err != nil {
+		return Listing{}, false, err
+	}
+	c.cache.Set(key, result{listing: l, ok: ok}, cache.DefaultExpiration)
+	return l, ok, nil
+}
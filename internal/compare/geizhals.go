@@ -0,0 +1,103 @@
+package compare
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Geizhals finds prices on geizhals and its english version skinflint
+type Geizhals struct {
+	client *http.Client
+}
+
+func NewGeizhals() *Geizhals {
+	return &Geizhals{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+func (g *Geizhals) Name() string {
+	return "Geizhals"
+}
+
+var geizhalsRegex = regexp.MustCompile(`([0-9.,]+)`)
+
+func (g *Geizhals) BestPrice(title, domain string, used bool) (Listing, bool, error) {
+	if used {
+		return Listing{}, false, nil
+	}
+	var host, currency string
+	switch domain {
+	case "de":
+		host, currency = "geizhals.de", "EUR"
+	case "co.uk":
+		host, currency = "skinflint.co.uk", "GBP"
+	case "es", "fr", "it", "nl", "com.be":
+		host, currency = "geizhals.eu", "EUR"
+	default:
+		return Listing{}, false, nil
+	}
+	u := fmt.Sprintf("https://%s/?fs=%s", host, url.QueryEscape(title))
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return Listing{}, false, fmt.Errorf("compare: couldn't create request: %w", err)
+	}
+	req.Header.Set("user-agent", "Mozilla/5.0 (X11; Linux x86_64)")
+	r, err := g.client.Do(req)
+	if err != nil {
+		return Listing{}, false, fmt.Errorf("compare: get request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return Listing{}, false, fmt.Errorf("compare: invalid status code: %s", r.Status)
+	}
+	doc, err := goquery.NewDocumentFromReader(r.Body)
+	if err != nil {
+		return Listing{}, false, fmt.Errorf("compare: couldn't parse document: %w", err)
+	}
+	var l Listing
+	var found bool
+	doc.Find(".productlist__item").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		text := s.Find(".productlist__price .gh_price").First().Text()
+		price, ok := parseGeizhalsPrice(text, currency)
+		if !ok {
+			return true
+		}
+		href, _ := s.Find("a.productlist__link").First().Attr("href")
+		l = Listing{
+			Price:    price,
+			Currency: currency,
+			Link:     fmt.Sprintf("https://%s/%s", host, strings.TrimLeft(href, "/")),
+		}
+		found = true
+		return false
+	})
+	return l, found, nil
+}
+
+func parseGeizhalsPrice(text, currency string) (float64, bool) {
+	v := geizhalsRegex.FindString(text)
+	if v == "" {
+		return 0, false
+	}
+	if currency == "EUR" {
+		v = strings.Replace(v, ".", "", -1)
+		v = strings.Replace(v, ",", ".", 1)
+	} else {
+		v = strings.Replace(v, ",", "", -1)
+	}
+	price, err := strconv.ParseFloat(v, 64)
+	if err != nil || price == 0 {
+		return 0, false
+	}
+	return price, true
+}
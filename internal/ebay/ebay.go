@@ -0,0 +1,163 @@
+package ebay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/compare"
+)
+
+// Client searchs listings using the eBay browse API
+type Client struct {
+	client  *http.Client
+	id      string
+	secret  string
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// New creates an eBay client, credentials are provided as clientID:clientSecret
+func New(credentials string) (*Client, error) {
+	split := strings.SplitN(credentials, ":", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return nil, fmt.Errorf("ebay: invalid credentials, expected id:secret")
+	}
+	return &Client{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		id:     split[0],
+		secret: split[1],
+	}, nil
+}
+
+var marketplaces = map[string]string{
+	"es":     "EBAY_ES",
+	"de":     "EBAY_DE",
+	"fr":     "EBAY_FR",
+	"it":     "EBAY_IT",
+	"nl":     "EBAY_NL",
+	"pl":     "EBAY_PL",
+	"com.be": "EBAY_BE",
+	"co.uk":  "EBAY_GB",
+	"com":    "EBAY_US",
+	"ca":     "EBAY_CA",
+	"com.au": "EBAY_AU",
+}
+
+func (c *Client) Name() string {
+	return "eBay"
+}
+
+// BestPrice returns the cheapest listing on the eBay marketplace matching the
+// amazon domain.
+func (c *Client) BestPrice(title, domain string, used bool) (compare.Listing, bool, error) {
+	marketplace, ok := marketplaces[domain]
+	if !ok {
+		return compare.Listing{}, false, nil
+	}
+	token, err := c.accessToken()
+	if err != nil {
+		return compare.Listing{}, false, err
+	}
+	condition := "1000"
+	if used {
+		condition = "3000"
+	}
+	q := url.Values{}
+	q.Set("q", query(title))
+	q.Set("sort", "price")
+	q.Set("limit", "5")
+	q.Set("filter", fmt.Sprintf("conditionIds:{%s},buyingOptions:{FIXED_PRICE}", condition))
+	req, err := http.NewRequest("GET", "https://api.ebay.com/buy/browse/v1/item_summary/search?"+q.Encode(), nil)
+	if err != nil {
+		return compare.Listing{}, false, fmt.Errorf("ebay: couldn't create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-EBAY-C-MARKETPLACE-ID", marketplace)
+	r, err := c.client.Do(req)
+	if err != nil {
+		return compare.Listing{}, false, fmt.Errorf("ebay: get request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return compare.Listing{}, false, fmt.Errorf("ebay: invalid status code: %s", r.Status)
+	}
+	var resp searchResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return compare.Listing{}, false, fmt.Errorf("ebay: couldn't decode response: %w", err)
+	}
+	for _, s := range resp.ItemSummaries {
+		price, err := strconv.ParseFloat(s.Price.Value, 64)
+		if err != nil || price == 0 {
+			continue
+		}
+		return compare.Listing{Price: price, Currency: s.Price.Currency, Link: s.ItemWebURL}, true, nil
+	}
+	return compare.Listing{}, false, nil
+}
+
+type searchResponse struct {
+	ItemSummaries []struct {
+		Price struct {
+			Value    string `json:"value"`
+			Currency string `json:"currency"`
+		} `json:"price"`
+		ItemWebURL string `json:"itemWebUrl"`
+	} `json:"itemSummaries"`
+}
+
+// query shortens the title to the maximum query length allowed by eBay
+func query(title string) string {
+	var q string
+	for _, w := range strings.Fields(title) {
+		if len(q)+len(w)+1 > 100 {
+			break
+		}
+		q = strings.TrimSpace(q + " " + w)
+	}
+	return q
+}
+
+func (c *Client) accessToken() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("scope", "https://api.ebay.com/oauth/api_scope")
+	req, err := http.NewRequest("POST", "https://api.ebay.com/identity/v1/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("ebay: couldn't create token request: %w", err)
+	}
+	req.SetBasicAuth(c.id, c.secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ebay: token request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return "", fmt.Errorf("ebay: invalid token status code: %s", r.Status)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return "", fmt.Errorf("ebay: couldn't decode token: %w", err)
+	}
+	c.token = resp.AccessToken
+	// Renew the token a minute before it expires
+	c.expires = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
@@ -0,0 +1,119 @@
+package fx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ecbURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+
+// Client converts currencies using the euro reference rates published daily
+// by the European Central Bank.
+type Client struct {
+	client  *http.Client
+	lock    sync.Mutex
+	rates   map[string]float64
+	updated time.Time
+	fixed   bool
+}
+
+func New() *Client {
+	return &Client{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Fixed returns a client with constant rates against euro that are never
+// updated.
+func Fixed(rates map[string]float64) *Client {
+	c := New()
+	c.rates = map[string]float64{"EUR": 1}
+	for k, v := range rates {
+		c.rates[strings.ToUpper(k)] = v
+	}
+	c.fixed = true
+	return c
+}
+
+// Convert converts the amount between currencies using ISO 4217 codes.
+func (c *Client) Convert(amount float64, from, to string) (float64, error) {
+	from = strings.ToUpper(from)
+	to = strings.ToUpper(to)
+	if from == to {
+		return amount, nil
+	}
+	rates, err := c.Rates()
+	if err != nil {
+		return 0, err
+	}
+	fromRate, ok := rates[from]
+	if !ok {
+		return 0, fmt.Errorf("fx: unknown currency %s", from)
+	}
+	toRate, ok := rates[to]
+	if !ok {
+		return 0, fmt.Errorf("fx: unknown currency %s", to)
+	}
+	return amount / fromRate * toRate, nil
+}
+
+// Rates returns the cached rates against euro, updating them if they are
+// older than a few hours.
+func (c *Client) Rates() (map[string]float64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fixed || (c.rates != nil && time.Since(c.updated) < 6*time.Hour) {
+		return c.rates, nil
+	}
+	rates, err := c.fetch()
+	if err != nil {
+		if c.rates != nil {
+			// Use outdated rates instead of failing
+			return c.rates, nil
+		}
+		return nil, err
+	}
+	c.rates = rates
+	c.updated = time.Now()
+	return rates, nil
+}
+
+type envelope struct {
+	Cube struct {
+		Cube struct {
+			Rates []struct {
+				Currency string  `xml:"currency,attr"`
+				Rate     float64 `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+func (c *Client) fetch() (map[string]float64, error) {
+	r, err := c.client.Get(ecbURL)
+	if err != nil {
+		return nil, fmt.Errorf("fx: get request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return nil, fmt.Errorf("fx: invalid status code: %s", r.Status)
+	}
+	var env envelope
+	if err := xml.NewDecoder(r.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("fx: couldn't decode rates: %w", err)
+	}
+	rates := map[string]float64{"EUR": 1}
+	for _, r := range env.Cube.Cube.Rates {
+		rates[r.Currency] = r.Rate
+	}
+	if len(rates) == 1 {
+		return nil, fmt.Errorf("fx: rates not found")
+	}
+	return rates, nil
+}
@@ -0,0 +1,362 @@
+// Package graphql executes read-only graphql queries with resolvers of the
+// root fields, the values they return are projected to the selected fields
+// through their json encoding, fields omitted by the encoding are null.
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// maxBody is the maximum size of a request body
+const maxBody = 1 << 20
+
+// Args are the arguments of a root field
+type Args map[string]interface{}
+
+// String returns the string argument, empty if it isn't set
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns the integer argument, the fallback if it isn't set
+func (a Args) Int(name string, fallback int) int {
+	switch v := a[name].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return fallback
+}
+
+// Resolver returns the value of a root field
+type Resolver func(args Args) (interface{}, error)
+
+// Schema are the resolvers of the root fields of the queries
+type Schema map[string]Resolver
+
+// Request is a graphql request sent as json or as query parameters
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of a request, data is omitted if the request
+// couldn't be executed
+type Response struct {
+	Data   object  `json:"data,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// Error is an error of the request or of a field, path is the path of the
+// field in the response
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// ReadRequest reads a request from the query parameters of a GET request or
+// the json or graphql body of a POST request
+func ReadRequest(r *http.Request) (Request, error) {
+	var req Request
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				return req, fmt.Errorf("graphql: invalid variables: %w", err)
+			}
+		}
+	case http.MethodPost:
+		data, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBody))
+		if err != nil {
+			return req, fmt.Errorf("graphql: couldn't read body: %w", err)
+		}
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/graphql" {
+			req.Query = string(data)
+			break
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, fmt.Errorf("graphql: invalid json: %w", err)
+		}
+	default:
+		return req, fmt.Errorf("graphql: method %s not allowed", r.Method)
+	}
+	if req.Query == "" {
+		return req, fmt.Errorf("graphql: missing query")
+	}
+	return req, nil
+}
+
+// execution is the state of a request being executed
+type execution struct {
+	doc    *document
+	vars   map[string]interface{}
+	errors []Error
+}
+
+// Execute runs the query of the request, the errors of the fields are
+// returned with the data of the rest
+func (s Schema) Execute(req Request) Response {
+	fail := func(err error) Response {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	doc, err := parse(req.Query)
+	if err != nil {
+		return fail(err)
+	}
+	var op *operation
+	for _, o := range doc.operations {
+		if (req.OperationName == "" && len(doc.operations) == 1) || o.name == req.OperationName {
+			op = o
+			break
+		}
+	}
+	if op == nil {
+		return fail(fmt.Errorf("graphql: operation %q not found", req.OperationName))
+	}
+	e := &execution{doc: doc, vars: make(map[string]interface{})}
+	for _, v := range op.variables {
+		val, ok := req.Variables[v.name]
+		if !ok {
+			val = e.value(v.fallback)
+		}
+		if val == nil && v.nonNull {
+			return fail(fmt.Errorf("graphql: missing variable $%s", v.name))
+		}
+		e.vars[v.name] = val
+	}
+	fields, err := e.collect(op.selection, 0)
+	if err != nil {
+		return fail(err)
+	}
+	data := object{}
+	for _, f := range fields {
+		key := f.key()
+		if f.name == "__typename" {
+			data = append(data, member{key, "Query"})
+			continue
+		}
+		resolve, ok := s[f.name]
+		if !ok {
+			e.fail(fmt.Errorf("graphql: unknown field %s", f.name), key)
+			data = append(data, member{key, nil})
+			continue
+		}
+		args := Args{}
+		for _, a := range f.args {
+			args[a.name] = e.value(a.value)
+		}
+		v, err := resolve(args)
+		if err != nil {
+			e.fail(err, key)
+			data = append(data, member{key, nil})
+			continue
+		}
+		var generic interface{}
+		raw, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(raw, &generic)
+		}
+		if err != nil {
+			e.fail(fmt.Errorf("graphql: couldn't encode %s: %w", f.name, err), key)
+			data = append(data, member{key, nil})
+			continue
+		}
+		projected, err := e.project(generic, f.selection, []interface{}{key})
+		if err != nil {
+			data = append(data, member{key, nil})
+			continue
+		}
+		data = append(data, member{key, projected})
+	}
+	return Response{Data: data, Errors: e.errors}
+}
+
+func (e *execution) fail(err error, path ...interface{}) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: path})
+}
+
+// project returns the selected fields of the value, objects without
+// selection are returned whole
+func (e *execution) project(v interface{}, sels []selection, path []interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			p, err := e.project(item, sels, append(append([]interface{}{}, path...), i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = p
+		}
+		return list, nil
+	case map[string]interface{}:
+		if len(sels) == 0 {
+			return v, nil
+		}
+		fields, err := e.collect(sels, 0)
+		if err != nil {
+			e.fail(err, path...)
+			return nil, err
+		}
+		obj := object{}
+		for _, f := range fields {
+			key := f.key()
+			fieldPath := append(append([]interface{}{}, path...), key)
+			if f.name == "__typename" {
+				obj = append(obj, member{key, nil})
+				continue
+			}
+			if len(f.args) > 0 {
+				err := fmt.Errorf("graphql: arguments of %s aren't supported", f.name)
+				e.fail(err, fieldPath...)
+				return nil, err
+			}
+			p, err := e.project(v[f.name], f.selection, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{key, p})
+		}
+		return obj, nil
+	case nil:
+		return nil, nil
+	default:
+		if len(sels) > 0 {
+			err := fmt.Errorf("graphql: scalar field can't have a selection")
+			e.fail(err, path...)
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+// collect expands the fragments of the selection and skips the fields
+// excluded by @skip and @include, fields with the same key are merged
+func (e *execution) collect(sels []selection, depth int) ([]selection, error) {
+	if depth > 10 {
+		return nil, fmt.Errorf("graphql: fragments nested too deep")
+	}
+	var fields []selection
+	index := make(map[string]int)
+	add := func(f selection) {
+		if i, ok := index[f.key()]; ok {
+			fields[i].selection = append(append([]selection{}, fields[i].selection...), f.selection...)
+			return
+		}
+		index[f.key()] = len(fields)
+		fields = append(fields, f)
+	}
+	for _, s := range sels {
+		if !e.included(s.directives) {
+			continue
+		}
+		var nested []selection
+		switch {
+		case s.spread != "":
+			f, ok := e.doc.fragments[s.spread]
+			if !ok {
+				return nil, fmt.Errorf("graphql: unknown fragment %s", s.spread)
+			}
+			nested = f.selection
+		case s.inline:
+			nested = s.selection
+		default:
+			add(s)
+			continue
+		}
+		expanded, err := e.collect(nested, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range expanded {
+			add(f)
+		}
+	}
+	return fields, nil
+}
+
+// included evaluates the @skip and @include directives
+func (e *execution) included(ds []directive) bool {
+	for _, d := range ds {
+		var cond bool
+		for _, a := range d.args {
+			if a.name == "if" {
+				cond, _ = e.value(a.value).(bool)
+			}
+		}
+		if (d.name == "skip" && cond) || (d.name == "include" && !cond) {
+			return false
+		}
+	}
+	return true
+}
+
+// value returns the go value of a literal or variable
+func (e *execution) value(v value) interface{} {
+	switch v.kind {
+	case variableValue:
+		return e.vars[v.variable]
+	case listValue:
+		list := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			list[i] = e.value(item)
+		}
+		return list
+	case objectValue:
+		obj := make(map[string]interface{})
+		for _, a := range v.object {
+			obj[a.name] = e.value(a.value)
+		}
+		return obj
+	}
+	return v.literal
+}
+
+func (s selection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// object is a json object that keeps the order of the selected fields
+type object []member
+
+type member struct {
+	key   string
+	value interface{}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(m.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,577 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed query with its operations and fragments
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	name      string
+	variables []variable
+	selection []selection
+}
+
+type variable struct {
+	name     string
+	nonNull  bool
+	fallback value
+}
+
+type fragment struct {
+	name      string
+	selection []selection
+}
+
+// selection is a field, a fragment spread (spread set) or an inline fragment
+// (inline set)
+type selection struct {
+	alias      string
+	name       string
+	args       []argument
+	directives []directive
+	selection  []selection
+	spread     string
+	inline     bool
+}
+
+type argument struct {
+	name  string
+	value value
+}
+
+type directive struct {
+	name string
+	args []argument
+}
+
+// value is a literal or a variable (variable set) of the query
+type value struct {
+	variable string
+	literal  interface{}
+	list     []value
+	object   []argument
+	kind     byte
+}
+
+const (
+	literalValue byte = iota
+	variableValue
+	listValue
+	objectValue
+)
+
+type token struct {
+	kind byte
+	text string
+	pos  int
+}
+
+const (
+	eofToken byte = iota
+	punctToken
+	nameToken
+	intToken
+	floatToken
+	stringToken
+)
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+// parse parses a query document, mutations and subscriptions aren't
+// supported
+func parse(src string) (*document, error) {
+	p := &parser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != eofToken {
+		switch {
+		case p.peek(punctToken, "{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{selection: sel})
+		case p.peek(nameToken, "query"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peek(nameToken, "fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments[f.name] = f
+		case p.peek(nameToken, "mutation"), p.peek(nameToken, "subscription"):
+			return nil, fmt.Errorf("graphql: %s operations aren't supported", p.tok.text)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("graphql: no operation found")
+	}
+	return doc, nil
+}
+
+func (p *parser) operation() (*operation, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	op := &operation{}
+	if p.tok.kind == nameToken {
+		op.name = p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek(punctToken, "(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.peek(punctToken, ")") {
+			v, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, v)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selection = sel
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (variable, error) {
+	var v variable
+	if err := p.expect(punctToken, "$"); err != nil {
+		return v, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return v, err
+	}
+	v.name = name
+	if err := p.expect(punctToken, ":"); err != nil {
+		return v, err
+	}
+	if v.nonNull, err = p.typeRef(); err != nil {
+		return v, err
+	}
+	if p.peek(punctToken, "=") {
+		if err := p.next(); err != nil {
+			return v, err
+		}
+		if v.fallback, err = p.value(true); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// typeRef skips a type reference and returns if it is non null, types are
+// checked by the resolvers
+func (p *parser) typeRef() (bool, error) {
+	if p.peek(punctToken, "[") {
+		if err := p.next(); err != nil {
+			return false, err
+		}
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect(punctToken, "]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.peek(punctToken, "!") {
+		return true, p.next()
+	}
+	return false, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(nameToken, "on"); err != nil {
+		return nil, err
+	}
+	if _, err := p.name(); err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &fragment{name: name, selection: sel}, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect(punctToken, "{"); err != nil {
+		return nil, err
+	}
+	var sels []selection
+	for !p.peek(punctToken, "}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, s)
+	}
+	if len(sels) == 0 {
+		return nil, fmt.Errorf("graphql: empty selection at %d", p.tok.pos)
+	}
+	return sels, p.next()
+}
+
+func (p *parser) selection() (selection, error) {
+	var s selection
+	var err error
+	if p.peek(punctToken, "...") {
+		if err := p.next(); err != nil {
+			return s, err
+		}
+		if p.tok.kind == nameToken && p.tok.text != "on" {
+			s.spread = p.tok.text
+			if err := p.next(); err != nil {
+				return s, err
+			}
+			s.directives, err = p.directives()
+			return s, err
+		}
+		s.inline = true
+		if p.peek(nameToken, "on") {
+			if err := p.next(); err != nil {
+				return s, err
+			}
+			if _, err := p.name(); err != nil {
+				return s, err
+			}
+		}
+		if s.directives, err = p.directives(); err != nil {
+			return s, err
+		}
+		s.selection, err = p.selectionSet()
+		return s, err
+	}
+	if s.name, err = p.name(); err != nil {
+		return s, err
+	}
+	if p.peek(punctToken, ":") {
+		if err := p.next(); err != nil {
+			return s, err
+		}
+		s.alias = s.name
+		if s.name, err = p.name(); err != nil {
+			return s, err
+		}
+	}
+	if s.args, err = p.arguments(false); err != nil {
+		return s, err
+	}
+	if s.directives, err = p.directives(); err != nil {
+		return s, err
+	}
+	if p.peek(punctToken, "{") {
+		s.selection, err = p.selectionSet()
+	}
+	return s, err
+}
+
+func (p *parser) arguments(constant bool) ([]argument, error) {
+	if !p.peek(punctToken, "(") {
+		return nil, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	var args []argument
+	for !p.peek(punctToken, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(punctToken, ":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, argument{name: name, value: v})
+	}
+	return args, p.next()
+}
+
+func (p *parser) directives() ([]directive, error) {
+	var ds []directive
+	for p.peek(punctToken, "@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments(false)
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, directive{name: name, args: args})
+	}
+	return ds, nil
+}
+
+func (p *parser) value(constant bool) (value, error) {
+	t := p.tok
+	switch {
+	case t.kind == punctToken && t.text == "$" && !constant:
+		if err := p.next(); err != nil {
+			return value{}, err
+		}
+		name, err := p.name()
+		return value{kind: variableValue, variable: name}, err
+	case t.kind == punctToken && t.text == "[":
+		if err := p.next(); err != nil {
+			return value{}, err
+		}
+		v := value{kind: listValue, list: []value{}}
+		for !p.peek(punctToken, "]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return value{}, err
+			}
+			v.list = append(v.list, item)
+		}
+		return v, p.next()
+	case t.kind == punctToken && t.text == "{":
+		if err := p.next(); err != nil {
+			return value{}, err
+		}
+		v := value{kind: objectValue}
+		for !p.peek(punctToken, "}") {
+			name, err := p.name()
+			if err != nil {
+				return value{}, err
+			}
+			if err := p.expect(punctToken, ":"); err != nil {
+				return value{}, err
+			}
+			field, err := p.value(constant)
+			if err != nil {
+				return value{}, err
+			}
+			v.object = append(v.object, argument{name: name, value: field})
+		}
+		return v, p.next()
+	case t.kind == intToken:
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return value{}, fmt.Errorf("graphql: invalid int %s at %d", t.text, t.pos)
+		}
+		return value{literal: n}, p.next()
+	case t.kind == floatToken:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return value{}, fmt.Errorf("graphql: invalid float %s at %d", t.text, t.pos)
+		}
+		return value{literal: f}, p.next()
+	case t.kind == stringToken:
+		return value{literal: t.text}, p.next()
+	case t.kind == nameToken:
+		v := value{literal: t.text}
+		switch t.text {
+		case "true":
+			v.literal = true
+		case "false":
+			v.literal = false
+		case "null":
+			v.literal = nil
+		}
+		return v, p.next()
+	}
+	return value{}, p.unexpected()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != nameToken {
+		return "", p.unexpected()
+	}
+	name := p.tok.text
+	return name, p.next()
+}
+
+func (p *parser) peek(kind byte, text string) bool {
+	return p.tok.kind == kind && p.tok.text == text
+}
+
+func (p *parser) expect(kind byte, text string) error {
+	if !p.peek(kind, text) {
+		return p.unexpected()
+	}
+	return p.next()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == eofToken {
+		return fmt.Errorf("graphql: unexpected end of query")
+	}
+	return fmt.Errorf("graphql: unexpected %q at %d", p.tok.text, p.tok.pos)
+}
+
+// next reads the next token skipping whitespace, commas and comments
+func (p *parser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: eofToken, pos: start}
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = token{kind: punctToken, text: "...", pos: start}
+	case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+		p.pos++
+		p.tok = token{kind: punctToken, text: string(c), pos: start}
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: nameToken, text: p.src[start:p.pos], pos: start}
+	case c == '-' || isDigit(c):
+		kind := intToken
+		p.pos++
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == '.' || c == 'e' || c == 'E' || ((c == '+' || c == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+				kind = floatToken
+			} else if !isDigit(c) {
+				break
+			}
+			p.pos++
+		}
+		p.tok = token{kind: kind, text: p.src[start:p.pos], pos: start}
+	case c == '"':
+		text, err := p.string()
+		if err != nil {
+			return err
+		}
+		p.tok = token{kind: stringToken, text: text, pos: start}
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		return fmt.Errorf("graphql: unexpected character %q at %d", r, start)
+	}
+	return nil
+}
+
+// string reads a quoted string or a block string
+func (p *parser) string() (string, error) {
+	start := p.pos
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			return "", fmt.Errorf("graphql: unterminated string at %d", start)
+		}
+		text := p.src[p.pos+3 : p.pos+3+end]
+		p.pos += end + 6
+		return strings.TrimSpace(text), nil
+	}
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return sb.String(), nil
+		case c == '\n':
+			return "", fmt.Errorf("graphql: unterminated string at %d", start)
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'u':
+				if p.pos+5 > len(p.src) {
+					return "", fmt.Errorf("graphql: invalid escape at %d", p.pos)
+				}
+				n, err := strconv.ParseUint(p.src[p.pos+1:p.pos+5], 16, 32)
+				if err != nil {
+					return "", fmt.Errorf("graphql: invalid escape at %d", p.pos)
+				}
+				sb.WriteRune(rune(n))
+				p.pos += 4
+			default:
+				sb.WriteByte(e)
+			}
+			p.pos++
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("graphql: unterminated string at %d", start)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
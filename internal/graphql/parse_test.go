@@ -0,0 +1,151 @@
+package graphql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want *document
+	}{
+		{
+			name: "shorthand",
+			src:  "{ searches { id } }",
+			want: &document{
+				operations: []*operation{{selection: []selection{
+					{name: "searches", selection: []selection{{name: "id"}}},
+				}}},
+				fragments: map[string]*fragment{},
+			},
+		},
+		{
+			name: "arguments",
+			src: `query Items {
+  # comments and commas are ignored
+  a: item(id: "B0\"1é", limit: -5, ratio: 1.5e2, on: true, off: false, none: null, kind: NEW),
+  list(ids: ["x", 2], filter: {domain: "es", min: 0.5})
+}`,
+			want: &document{
+				operations: []*operation{{name: "Items", selection: []selection{
+					{alias: "a", name: "item", args: []argument{
+						{name: "id", value: value{literal: "B0\"1é"}},
+						{name: "limit", value: value{literal: -5}},
+						{name: "ratio", value: value{literal: 150.0}},
+						{name: "on", value: value{literal: true}},
+						{name: "off", value: value{literal: false}},
+						{name: "none", value: value{literal: nil}},
+						{name: "kind", value: value{literal: "NEW"}},
+					}},
+					{name: "list", args: []argument{
+						{name: "ids", value: value{kind: listValue, list: []value{{literal: "x"}, {literal: 2}}}},
+						{name: "filter", value: value{kind: objectValue, object: []argument{
+							{name: "domain", value: value{literal: "es"}},
+							{name: "min", value: value{literal: 0.5}},
+						}}},
+					}},
+				}}},
+				fragments: map[string]*fragment{},
+			},
+		},
+		{
+			name: "variables",
+			src:  `query($item: String!, $days: Int = 7, $ids: [ID!]) { history(item: $item, days: $days) @include(if: $ids) }`,
+			want: &document{
+				operations: []*operation{{
+					variables: []variable{
+						{name: "item", nonNull: true},
+						{name: "days", fallback: value{literal: 7}},
+						{name: "ids"},
+					},
+					selection: []selection{{
+						name: "history",
+						args: []argument{
+							{name: "item", value: value{kind: variableValue, variable: "item"}},
+							{name: "days", value: value{kind: variableValue, variable: "days"}},
+						},
+						directives: []directive{{name: "include", args: []argument{
+							{name: "if", value: value{kind: variableValue, variable: "ids"}},
+						}}},
+					}},
+				}},
+				fragments: map[string]*fragment{},
+			},
+		},
+		{
+			name: "fragments",
+			src: `{ searches { ...fields @skip(if: false) ... on Search { note } ... { id } } }
+fragment fields on Search { item { title } }`,
+			want: &document{
+				operations: []*operation{{selection: []selection{
+					{name: "searches", selection: []selection{
+						{spread: "fields", directives: []directive{{name: "skip", args: []argument{{name: "if", value: value{literal: false}}}}}},
+						{inline: true, selection: []selection{{name: "note"}}},
+						{inline: true, selection: []selection{{name: "id"}}},
+					}},
+				}}},
+				fragments: map[string]*fragment{
+					"fields": {name: "fields", selection: []selection{
+						{name: "item", selection: []selection{{name: "title"}}},
+					}},
+				},
+			},
+		},
+		{
+			name: "block string",
+			src:  `{ note(text: """  multi "quoted"` + "\n" + `line  """) }`,
+			want: &document{
+				operations: []*operation{{selection: []selection{
+					{name: "note", args: []argument{{name: "text", value: value{literal: "multi \"quoted\"\nline"}}}},
+				}}},
+				fragments: map[string]*fragment{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"":                                "graphql: no operation found",
+		"fragment f on T { id }":          "graphql: no operation found",
+		"{ id":                            "graphql: unexpected end of query",
+		"{ }":                             "graphql: empty selection at 2",
+		"{ id(x 1) }":                     `graphql: unexpected "1" at 7`,
+		"{ a: }":                          `graphql: unexpected "}" at 5`,
+		"query($x Int) { id }":            `graphql: unexpected "Int" at 9`,
+		"query($x: Int = $y) { id }":      `graphql: unexpected "$" at 16`,
+		"{ id } }":                        `graphql: unexpected "}" at 7`,
+		"mutation { stop }":               "graphql: mutation operations aren't supported",
+		"subscription { events }":         "graphql: subscription operations aren't supported",
+		"{ id(x: ?) }":                    `graphql: unexpected character '?' at 8`,
+		`{ id(x: "open) }`:                "graphql: unterminated string at 8",
+		"{ id(x: \"a\nb\") }":             "graphql: unterminated string at 8",
+		`{ id(x: """open) }`:              "graphql: unterminated string at 8",
+		`{ id(x: "\u12") }`:               "graphql: invalid escape at 10",
+		"{ id(x: 99999999999999999999) }": "graphql: invalid int 99999999999999999999 at 8",
+		"{ id(x: 1e) }":                   "graphql: invalid float 1e at 8",
+		"fragment f T { id }":             `graphql: unexpected "T" at 11`,
+		"{ ... on { id } }":               `graphql: unexpected "{" at 9`,
+		"{ id @ }":                        `graphql: unexpected "}" at 7`,
+		"query Q($x: [Int) { id }":        `graphql: unexpected ")" at 16`,
+	}
+	for src, want := range tests {
+		if _, err := parse(src); err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %s", src, err, want)
+		}
+	}
+}
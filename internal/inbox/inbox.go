@@ -0,0 +1,250 @@
+package inbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRecipients is the maximum number of recipients of a message
+	maxRecipients = 10
+	// maxDepth is the maximum nesting of multipart and forwarded messages
+	maxDepth = 5
+	// timeout is how long the server waits for the next command
+	timeout = 5 * time.Minute
+)
+
+// Message is a received email with the text of its plain and html parts
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+}
+
+// Server is a minimal smtp receiver without authentication nor tls, it is
+// meant to run behind a mail server that relays the emails of a domain
+type Server struct {
+	// Domain is announced in the greeting
+	Domain string
+	// MaxSize is the maximum size in bytes of the messages
+	MaxSize int64
+	// Accept returns whether the messages to the address are accepted
+	Accept func(addr string) bool
+	// Handler is called with each received message
+	Handler func(Message)
+}
+
+// Serve accepts connections until the listener is closed
+func (s *Server) Serve(lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				time.Sleep(time.Second)
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.session(conn); err != nil && !errors.Is(err, io.EOF) {
+				log.Println(fmt.Errorf("inbox: session with %s failed: %w", conn.RemoteAddr(), err))
+			}
+		}()
+	}
+}
+
+// session runs the smtp commands of a connection
+func (s *Server) session(conn net.Conn) error {
+	c := textproto.NewConn(conn)
+	reply := func(code int, text string) error {
+		return c.PrintfLine("%d %s", code, text)
+	}
+	if err := reply(220, fmt.Sprintf("%s ESMTP amazbot", s.Domain)); err != nil {
+		return err
+	}
+	var from string
+	var to []string
+	var started bool
+	for {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		line, err := c.ReadLine()
+		if err != nil {
+			return err
+		}
+		split := strings.SplitN(line, " ", 2)
+		cmd, arg := strings.ToUpper(split[0]), ""
+		if len(split) > 1 {
+			arg = strings.TrimSpace(split[1])
+		}
+		switch cmd {
+		case "HELO":
+			err = reply(250, s.Domain)
+		case "EHLO":
+			err = c.PrintfLine("250-%s\r\n250-SIZE %d\r\n250 8BITMIME", s.Domain, s.MaxSize)
+		case "MAIL":
+			addr, ok := address(arg, "FROM:")
+			if !ok {
+				err = reply(501, "syntax error")
+				break
+			}
+			from, to, started = addr, nil, true
+			err = reply(250, "OK")
+		case "RCPT":
+			addr, ok := address(arg, "TO:")
+			switch {
+			case !started:
+				err = reply(503, "need MAIL first")
+			case !ok:
+				err = reply(501, "syntax error")
+			case len(to) >= maxRecipients:
+				err = reply(452, "too many recipients")
+			case s.Accept != nil && !s.Accept(addr):
+				err = reply(550, "no such user")
+			default:
+				to = append(to, addr)
+				err = reply(250, "OK")
+			}
+		case "DATA":
+			if len(to) == 0 {
+				err = reply(503, "need RCPT first")
+				break
+			}
+			if err := reply(354, "end data with <CR><LF>.<CR><LF>"); err != nil {
+				return err
+			}
+			dr := c.DotReader()
+			data, rerr := ioutil.ReadAll(io.LimitReader(dr, s.MaxSize+1))
+			if rerr != nil {
+				return rerr
+			}
+			if int64(len(data)) > s.MaxSize {
+				// Drain the rest of the message before replying
+				if _, err := io.Copy(ioutil.Discard, dr); err != nil {
+					return err
+				}
+				err = reply(552, "message too big")
+				break
+			}
+			m, perr := Parse(data)
+			if perr != nil {
+				err = reply(554, "couldn't parse message")
+				break
+			}
+			m.From, m.To = from, to
+			from, to, started = "", nil, false
+			if s.Handler != nil {
+				s.Handler(m)
+			}
+			err = reply(250, "OK")
+		case "RSET":
+			from, to, started = "", nil, false
+			err = reply(250, "OK")
+		case "NOOP":
+			err = reply(250, "OK")
+		case "VRFY":
+			err = reply(252, "cannot verify user")
+		case "QUIT":
+			_ = reply(221, "bye")
+			return nil
+		default:
+			err = reply(502, "command not implemented")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// address returns the address of a MAIL FROM:<addr> or RCPT TO:<addr>
+// argument, extensions after the address are ignored
+func address(arg, prefix string) (string, bool) {
+	if !strings.HasPrefix(strings.ToUpper(arg), prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	start, end := strings.Index(arg, "<"), strings.Index(arg, ">")
+	if start != 0 || end < 0 {
+		return "", false
+	}
+	return strings.ToLower(arg[1:end]), true
+}
+
+// Parse parses an email, the text is the content of all the plain and html
+// parts, including the ones of forwarded messages
+func Parse(data []byte) (Message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return Message{}, fmt.Errorf("inbox: couldn't read message: %w", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	var texts []string
+	if err := walk(textproto.MIMEHeader(msg.Header), msg.Body, 0, &texts); err != nil {
+		return Message{}, err
+	}
+	return Message{Subject: subject, Text: strings.Join(texts, "\n")}, nil
+}
+
+// walk appends the text parts of the body to texts
+func walk(header textproto.MIMEHeader, body io.Reader, depth int, texts *[]string) error {
+	if depth > maxDepth {
+		return nil
+	}
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("inbox: couldn't read part: %w", err)
+			}
+			if err := walk(p.Header, p, depth+1, texts); err != nil {
+				return err
+			}
+		}
+	case mediaType == "message/rfc822":
+		msg, err := mail.ReadMessage(body)
+		if err != nil {
+			return fmt.Errorf("inbox: couldn't read forwarded message: %w", err)
+		}
+		return walk(textproto.MIMEHeader(msg.Header), msg.Body, depth+1, texts)
+	case mediaType == "text/plain", mediaType == "text/html":
+		text, err := ioutil.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("inbox: couldn't read text: %w", err)
+		}
+		*texts = append(*texts, string(text))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds counters and summaries identified by name and labels,
+// values are also emitted to statsd if configured
+type Registry struct {
+	lock      sync.Mutex
+	counters  map[string]float64
+	summaries map[string]*summary
+	statsd    net.Conn
+}
+
+type summary struct {
+	count int
+	sum   float64
+}
+
+// New creates a registry
+func New() *Registry {
+	return &Registry{
+		counters:  make(map[string]float64),
+		summaries: make(map[string]*summary),
+	}
+}
+
+// StatsD emits the metrics to the statsd server (host:port) using telegraf
+// tags, counters are sent as counts and summaries as histograms
+func (r *Registry) StatsD(addr string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics: couldn't dial statsd %s: %w", addr, err)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.statsd = conn
+	return nil
+}
+
+var tagEscaper = strings.NewReplacer(",", "_", "=", "_", ":", "_", "|", "_", " ", "_")
+
+// emit sends a statsd line (name,k=v:value|type), errors are ignored as
+// statsd is lossy
+func (r *Registry) emit(name string, v float64, kind string, labels []string) {
+	if r.statsd == nil {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		fmt.Fprintf(&sb, ",%s=%s", labels[i], tagEscaper.Replace(labels[i+1]))
+	}
+	fmt.Fprintf(&sb, ":%g|%s", v, kind)
+	_, _ = r.statsd.Write([]byte(sb.String()))
+}
+
+// key returns name{k="v",...} from label pairs
+func key(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+}
+
+// Add adds the value to a counter, labels are key value pairs
+func (r *Registry) Add(name string, v float64, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.counters[key(name, labels)] += v
+	r.emit(name, v, "c", labels)
+}
+
+// Observe adds a value to a summary, labels are key value pairs
+func (r *Registry) Observe(name string, v float64, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	k := key(name, labels)
+	s, ok := r.summaries[k]
+	if !ok {
+		s = &summary{}
+		r.summaries[k] = s
+	}
+	s.count++
+	s.sum += v
+	r.emit(name, v, "h", labels)
+}
+
+// Counter returns the value of a counter, labels are key value pairs
+func (r *Registry) Counter(name string, labels ...string) float64 {
+	if r == nil {
+		return 0
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.counters[key(name, labels)]
+}
+
+// Summary returns the count and sum of a summary, labels are key value pairs
+func (r *Registry) Summary(name string, labels ...string) (int, float64) {
+	if r == nil {
+		return 0, 0
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s, ok := r.summaries[key(name, labels)]
+	if !ok {
+		return 0, 0
+	}
+	return s.count, s.sum
+}
+
+// WriteTo writes the metrics in prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	var lines []string
+	for k, v := range r.counters {
+		lines = append(lines, fmt.Sprintf("%s %g", k, v))
+	}
+	for k, s := range r.summaries {
+		name, labels := k, ""
+		if i := strings.Index(k, "{"); i >= 0 {
+			name, labels = k[:i], k[i:]
+		}
+		lines = append(lines,
+			fmt.Sprintf("%s_sum%s %g", name, labels, s.sum),
+			fmt.Sprintf("%s_count%s %d", name, labels, s.count),
+		)
+	}
+	r.lock.Unlock()
+	sort.Strings(lines)
+	n, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return int64(n), err
+}
+
+// Handler serves the metrics in prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = r.WriteTo(w)
+	})
+}
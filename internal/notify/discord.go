@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Discord sends messages to discord webhooks, destinations have the format
+// webhookID:webhookToken
+type Discord struct {
+	client *http.Client
+}
+
+func NewDiscord() *Discord {
+	return &Discord{
+		client: newHTTPClient(),
+	}
+}
+
+func (d *Discord) Send(webhook string, m Message) error {
+	split := strings.SplitN(webhook, ":", 2)
+	if len(split) != 2 {
+		return fmt.Errorf("notify: invalid discord webhook %s, expected id:token", webhook)
+	}
+	embed := map[string]interface{}{
+		"title":       m.Title,
+		"url":         m.Link,
+		"description": m.Text,
+		"color":       color(m.Score),
+	}
+	if m.Image != "" {
+		embed["thumbnail"] = map[string]string{"url": m.Image}
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"embeds": []interface{}{embed},
+	})
+	if err != nil {
+		return fmt.Errorf("notify: couldn't marshal discord message: %w", err)
+	}
+	u := fmt.Sprintf("https://discord.com/api/webhooks/%s/%s", split[0], split[1])
+	r, err := d.client.Post(u, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("notify: discord request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 && r.StatusCode != 204 {
+		return fmt.Errorf("notify: invalid discord status code: %s", r.Status)
+	}
+	return nil
+}
+
+// color returns the embed color for the deal score, from grey to green
+func color(score float64) int {
+	switch {
+	case score >= 50:
+		return 0x2ecc71
+	case score >= 25:
+		return 0xf1c40f
+	case score >= 10:
+		return 0xe67e22
+	default:
+		return 0x95a5a6
+	}
+}
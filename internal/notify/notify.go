@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Message is sent by notifiers, score is the discount percentage of the deal
+// and is mapped to the priority of each service.
+type Message struct {
+	Title string
+	Text  string
+	Link  string
+	Image string
+	Score float64
+}
+
+// Notifier sends messages to a destination of a service
+type Notifier interface {
+	Send(dest string, m Message) error
+}
+
+// Split splits destinations with the format service:destination
+func Split(chat string) (string, string, bool) {
+	idx := strings.Index(chat, ":")
+	if idx <= 0 {
+		return "", "", false
+	}
+	scheme := chat[:idx]
+	for _, r := range scheme {
+		if r < 'a' || r > 'z' {
+			return "", "", false
+		}
+	}
+	return scheme, chat[idx+1:], true
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+	}
+}
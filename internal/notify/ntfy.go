@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Ntfy sends messages to ntfy topics
+type Ntfy struct {
+	client *http.Client
+	server string
+}
+
+func NewNtfy(server string) *Ntfy {
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	return &Ntfy{
+		client: newHTTPClient(),
+		server: strings.TrimRight(server, "/"),
+	}
+}
+
+func (n *Ntfy) Send(topic string, m Message) error {
+	priority := 2
+	switch {
+	case m.Score >= 50:
+		priority = 5
+	case m.Score >= 25:
+		priority = 4
+	case m.Score >= 10:
+		priority = 3
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"topic":    topic,
+		"title":    m.Title,
+		"message":  m.Text,
+		"click":    m.Link,
+		"priority": priority,
+	})
+	if err != nil {
+		return fmt.Errorf("notify: couldn't marshal ntfy message: %w", err)
+	}
+	r, err := n.client.Post(n.server, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("notify: ntfy request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return fmt.Errorf("notify: invalid ntfy status code: %s", r.Status)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Pushover sends messages to pushover user keys
+type Pushover struct {
+	client *http.Client
+	token  string
+}
+
+func NewPushover(token string) *Pushover {
+	return &Pushover{
+		client: newHTTPClient(),
+		token:  token,
+	}
+}
+
+func (p *Pushover) Send(user string, m Message) error {
+	priority := -1
+	switch {
+	case m.Score >= 25:
+		priority = 1
+	case m.Score >= 10:
+		priority = 0
+	}
+	form := url.Values{}
+	form.Set("token", p.token)
+	form.Set("user", user)
+	form.Set("title", m.Title)
+	form.Set("message", m.Text)
+	form.Set("url", m.Link)
+	form.Set("priority", strconv.Itoa(priority))
+	r, err := p.client.PostForm("https://api.pushover.net/1/messages.json", form)
+	if err != nil {
+		return fmt.Errorf("notify: pushover request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return fmt.Errorf("notify: invalid pushover status code: %s", r.Status)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Twitter posts tweets using OAuth 1.0a user credentials
+type Twitter struct {
+	client         *http.Client
+	consumerKey    string
+	consumerSecret string
+	token          string
+	tokenSecret    string
+}
+
+// NewTwitter creates a twitter client, credentials are provided as
+// consumerKey:consumerSecret:accessToken:accessSecret
+func NewTwitter(credentials string) (*Twitter, error) {
+	split := strings.Split(credentials, ":")
+	if len(split) != 4 {
+		return nil, fmt.Errorf("notify: invalid twitter credentials, expected consumerKey:consumerSecret:accessToken:accessSecret")
+	}
+	return &Twitter{
+		client:         newHTTPClient(),
+		consumerKey:    split[0],
+		consumerSecret: split[1],
+		token:          split[2],
+		tokenSecret:    split[3],
+	}, nil
+}
+
+// Tweet posts the text with an optional image url
+func (t *Twitter) Tweet(text, image string) error {
+	body := map[string]interface{}{
+		"text": text,
+	}
+	if image != "" {
+		mediaID, err := t.upload(image)
+		if err != nil {
+			return err
+		}
+		body["media"] = map[string]interface{}{
+			"media_ids": []string{mediaID},
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("notify: couldn't marshal tweet: %w", err)
+	}
+	u := "https://api.twitter.com/2/tweets"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("notify: couldn't create tweet request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", t.authorization("POST", u, nil))
+	r, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: tweet request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 201 {
+		return fmt.Errorf("notify: invalid tweet status code: %s", r.Status)
+	}
+	return nil
+}
+
+func (t *Twitter) upload(image string) (string, error) {
+	r, err := t.client.Get(image)
+	if err != nil {
+		return "", fmt.Errorf("notify: couldn't download image %s: %w", image, err)
+	}
+	defer r.Body.Close()
+	img, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", fmt.Errorf("notify: couldn't read image %s: %w", image, err)
+	}
+	u := "https://upload.twitter.com/1.1/media/upload.json"
+	form := url.Values{}
+	form.Set("media_data", base64.StdEncoding.EncodeToString(img))
+	req, err := http.NewRequest("POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("notify: couldn't create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", t.authorization("POST", u, form))
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("notify: upload request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("notify: invalid upload status code: %s", resp.Status)
+	}
+	var media struct {
+		ID string `json:"media_id_string"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&media); err != nil {
+		return "", fmt.Errorf("notify: couldn't decode upload response: %w", err)
+	}
+	return media.ID, nil
+}
+
+// authorization returns the OAuth 1.0a header, params are the form values
+// included in the signature.
+func (t *Twitter) authorization(method, u string, params url.Values) string {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	oauth := map[string]string{
+		"oauth_consumer_key":     t.consumerKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_token":            t.token,
+		"oauth_version":          "1.0",
+	}
+	var pairs []string
+	for k, v := range oauth {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", percentEncode(k), percentEncode(v)))
+	}
+	for k, vs := range params {
+		for _, v := range vs {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", percentEncode(k), percentEncode(v)))
+		}
+	}
+	sort.Strings(pairs)
+	base := fmt.Sprintf("%s&%s&%s", method, percentEncode(u), percentEncode(strings.Join(pairs, "&")))
+	key := fmt.Sprintf("%s&%s", percentEncode(t.consumerSecret), percentEncode(t.tokenSecret))
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(base))
+	oauth["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	var header []string
+	for k, v := range oauth {
+		header = append(header, fmt.Sprintf(`%s="%s"`, percentEncode(k), percentEncode(v)))
+	}
+	sort.Strings(header)
+	return "OAuth " + strings.Join(header, ", ")
+}
+
+func percentEncode(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// WhatsApp sends messages using the WhatsApp Business Cloud API
+type WhatsApp struct {
+	client  *http.Client
+	phoneID string
+	token   string
+}
+
+// NewWhatsApp creates a notifier, credentials are provided as phoneID:token
+func NewWhatsApp(credentials string) (*WhatsApp, error) {
+	split := strings.SplitN(credentials, ":", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return nil, fmt.Errorf("notify: invalid whatsapp credentials, expected phoneID:token")
+	}
+	return &WhatsApp{
+		client:  newHTTPClient(),
+		phoneID: split[0],
+		token:   split[1],
+	}, nil
+}
+
+func (w *WhatsApp) Send(to string, m Message) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"to":                to,
+		"type":              "text",
+		"text": map[string]interface{}{
+			"body":        m.Text,
+			"preview_url": true,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("notify: couldn't marshal whatsapp message: %w", err)
+	}
+	u := fmt.Sprintf("https://graph.facebook.com/v17.0/%s/messages", w.phoneID)
+	req, err := http.NewRequest("POST", u, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("notify: couldn't create whatsapp request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+w.token)
+	req.Header.Set("Content-Type", "application/json")
+	r, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: whatsapp request failed: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return fmt.Errorf("notify: invalid whatsapp status code: %s", r.Status)
+	}
+	return nil
+}
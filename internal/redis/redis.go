@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is a minimal redis client supporting the commands used by the bot,
+// commands are serialized over a single connection
+type Client struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	lock     sync.Mutex
+	conn     net.Conn
+	rd       *bufio.Reader
+}
+
+// ErrNil is returned when the key doesn't exist
+var ErrNil = errors.New("redis: nil")
+
+// New creates a client from a url (redis://:password@host:6379/db), keys are
+// prefixed with the prefix
+func New(rawurl, prefix string) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("redis: couldn't parse url: %w", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("redis: invalid scheme %q", u.Scheme)
+	}
+	c := &Client{addr: u.Host, prefix: prefix}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis: invalid db %q", db)
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("redis: couldn't connect to %s: %w", c.addr, err)
+	}
+	c.conn = conn
+	c.rd = bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := c.do("AUTH", c.password); err != nil {
+			c.close()
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	c.conn = nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.close()
+	return nil
+}
+
+// Do sends a command and returns its reply, the connection is reopened and
+// the command sent again once if it couldn't be written.
+// Commands aren't sent again if the reply couldn't be read because they may
+// have been executed (e.g. INCR or SET NX), the connection is reopened by the
+// next command.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i := 0; ; i++ {
+		if c.conn == nil {
+			if err := c.connect(); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.write(args...); err != nil {
+			c.close()
+			if i == 0 {
+				continue
+			}
+			return nil, err
+		}
+		v, err := c.read()
+		if broken(err) {
+			c.close()
+		}
+		return v, err
+	}
+}
+
+// broken returns true if the error leaves the connection in an unknown state
+func broken(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+func (c *Client) do(args ...string) (interface{}, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// write sends a command encoded as a RESP array of bulk strings
+func (c *Client) write(args ...string) error {
+	_ = c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.WriteString(c.conn, encode(args)); err != nil {
+		return fmt.Errorf("redis: couldn't write command: %w", err)
+	}
+	return nil
+}
+
+func encode(args []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return sb.String()
+}
+
+// read parses a RESP reply
+func (c *Client) read() (interface{}, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: couldn't read reply: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, fmt.Errorf("redis: couldn't read reply: %w", err)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			v, err := c.read()
+			if err != nil && !errors.Is(err, ErrNil) {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
+
+func ms(ttl time.Duration) string {
+	return strconv.FormatInt(ttl.Milliseconds(), 10)
+}
+
+// SetNX sets the key if it doesn't exist, it returns false if it existed
+func (c *Client) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	_, err := c.Do("SET", c.prefix+key, value, "NX", "PX", ms(ttl))
+	if errors.Is(err, ErrNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Del removes the key
+func (c *Client) Del(key string) error {
+	_, err := c.Do("DEL", c.prefix+key)
+	return err
+}
+
+// Incr increments the counter of the key, the expiration is set when the key
+// is created
+func (c *Client) Incr(key string, ttl time.Duration) (int64, error) {
+	v, err := c.Do("INCR", c.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: invalid incr reply %v", v)
+	}
+	if n == 1 {
+		if _, err := c.Do("PEXPIRE", c.prefix+key, ms(ttl)); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+var renewScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+
+var unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// Lock acquires or renews the lock of the key for the owner, it returns false
+// if it is held by another owner
+func (c *Client) Lock(key, owner string, ttl time.Duration) (bool, error) {
+	ok, err := c.SetNX(key, owner, ttl)
+	if err != nil || ok {
+		return ok, err
+	}
+	v, err := c.Do("EVAL", renewScript, "1", c.prefix+key, owner, ms(ttl))
+	if err != nil {
+		return false, err
+	}
+	return v == int64(1), nil
+}
+
+// Unlock releases the lock of the key if it is held by the owner
+func (c *Client) Unlock(key, owner string) error {
+	_, err := c.Do("EVAL", unlockScript, "1", c.prefix+key, owner)
+	return err
+}
@@ -0,0 +1,212 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	got := encode([]string{"SET", "k", "a b", ""})
+	want := "*4\r\n$3\r\nSET\r\n$1\r\nk\r\n$3\r\na b\r\n$0\r\n\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  interface{}
+		err   string
+	}{
+		{"+OK\r\n", "OK", ""},
+		{":42\r\n", int64(42), ""},
+		{"$5\r\nhello\r\n", "hello", ""},
+		{"$0\r\n\r\n", "", ""},
+		{"$-1\r\n", nil, "redis: nil"},
+		{"*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", []interface{}{"a", int64(1), nil}, ""},
+		{"*-1\r\n", nil, "redis: nil"},
+		{"-ERR wrong type\r\n", nil, "redis: ERR wrong type"},
+		{"?x\r\n", nil, `redis: invalid reply "?x"`},
+		{"$5\r\nhel", nil, "redis: couldn't read reply: unexpected EOF"},
+	}
+	for _, tt := range tests {
+		c := &Client{rd: bufio.NewReader(strings.NewReader(tt.reply))}
+		got, err := c.read()
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %s", tt.reply, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.reply, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, want %#v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+// fakeServer replies to the commands with the handler, the connection is
+// closed without reply if the handler returns an empty string
+type fakeServer struct {
+	lis     net.Listener
+	lock    sync.Mutex
+	conns   int
+	cmds    [][]string
+	handler func(conn int, args []string) string
+}
+
+func newFakeServer(t *testing.T, handler func(conn int, args []string) string) *fakeServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{lis: lis, handler: handler}
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.conns++
+			n := s.conns
+			s.lock.Unlock()
+			go s.serve(n, conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(n int, conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.cmds = append(s.cmds, args)
+		s.lock.Unlock()
+		reply := s.handler(n, args)
+		if reply == "" {
+			return
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) commands() [][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([][]string(nil), s.cmds...)
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for i := 0; i < n; i++ {
+		if _, err := rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
+
+func TestDo(t *testing.T) {
+	s := newFakeServer(t, func(_ int, args []string) string {
+		switch args[0] {
+		case "AUTH", "SELECT":
+			return "+OK\r\n"
+		case "INCR":
+			return ":1\r\n"
+		case "PEXPIRE":
+			return ":1\r\n"
+		}
+		return "$-1\r\n"
+	})
+	c, err := New("redis://:secret@"+s.lis.Addr().String()+"/2", "bot:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if n, err := c.Incr("budget", 0); err != nil || n != 1 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if ok, err := c.SetNX("claim", "x", 0); err != nil || ok {
+		t.Errorf("got %v %v, want existing key", ok, err)
+	}
+	want := [][]string{
+		{"AUTH", "secret"},
+		{"SELECT", "2"},
+		{"INCR", "bot:budget"},
+		{"PEXPIRE", "bot:budget", "0"},
+		{"SET", "bot:claim", "x", "NX", "PX", "0"},
+	}
+	if got := s.commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	// The first connection is closed after reading the INCR without replying
+	s := newFakeServer(t, func(conn int, args []string) string {
+		if conn == 1 && args[0] == "INCR" {
+			return ""
+		}
+		return ":1\r\n"
+	})
+	c, err := New("redis://"+s.lis.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Do("INCR", "k"); err == nil {
+		t.Fatal("lost reply not reported")
+	}
+	var count int
+	for _, cmd := range s.commands() {
+		if cmd[0] == "INCR" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("INCR sent %d times after a lost reply", count)
+	}
+	// The next command reconnects
+	if v, err := c.Do("INCR", "k"); err != nil || v != int64(1) {
+		t.Errorf("got %v %v after reconnect", v, err)
+	}
+
+	// Commands that couldn't be written are sent again
+	c.lock.Lock()
+	_ = c.conn.Close()
+	c.lock.Unlock()
+	if v, err := c.Do("INCR", "k"); err != nil || v != int64(1) {
+		t.Errorf("got %v %v after a write error", v, err)
+	}
+}
@@ -1,8 +1,12 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
@@ -14,7 +18,31 @@ func New(path string) (*Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("store: couldn't open bold db %s: %w", path, err)
 	}
-	for _, bucket := range []string{"db", "config"} {
+	return newStore(db)
+}
+
+// Wait opens the store waiting until the file lock held by another process
+// is released or the context is done
+func Wait(ctx context.Context, path string) (*Store, error) {
+	for {
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+		if errors.Is(err, bolt.ErrTimeout) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("store: couldn't open bold db %s: %w", path, err)
+		}
+		return newStore(db)
+	}
+}
+
+func newStore(db *bolt.DB) (*Store, error) {
+	for _, bucket := range []string{"db", "config", "arbitrage", "links", "stats", "history", "deals", "feed", "notes", "listings", "posted", "fx", "deleted", "shares"} {
 		if err := db.Update(func(tx *bolt.Tx) error {
 			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
 				return err
@@ -87,3 +115,40 @@ func (s *Store) Delete(bucket, key string) error {
 	}
 	return nil
 }
+
+// Size returns the size in bytes of the data of the store
+func (s *Store) Size() (int64, error) {
+	var size int64
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("store: couldn't get size: %w", err)
+	}
+	return size, nil
+}
+
+// ReadOnly opens a store that can't be modified, the file is shared with
+// other readers
+func ReadOnly(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("store: couldn't open bold db %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Snapshot writes a consistent copy of the store to the path, the file is
+// replaced atomically
+func (s *Store) Snapshot(path string) error {
+	tmp := fmt.Sprintf("%s.tmp", path)
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(tmp, 0600)
+	}); err != nil {
+		return fmt.Errorf("store: couldn't write snapshot %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("store: couldn't replace snapshot %s: %w", path, err)
+	}
+	return nil
+}
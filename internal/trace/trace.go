@@ -0,0 +1,248 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer records spans and exports them to an OTLP/HTTP collector using the
+// json encoding
+type Tracer struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	lock     sync.Mutex
+	spans    []*Span
+}
+
+// Span is a timed operation, spans are nil when tracing is disabled and all
+// their methods are nil-safe
+type Span struct {
+	tracer  *Tracer
+	traceID string
+	spanID  string
+	parent  string
+	name    string
+	start   time.Time
+	end     time.Time
+	attrs   map[string]string
+	err     string
+}
+
+type spanKey struct{}
+
+// maxSpans limits the spans waiting to be exported
+const maxSpans = 10000
+
+// New creates a tracer exporting to the collector (http://host:4318)
+func New(endpoint, service string) *Tracer {
+	return &Tracer{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start starts a root span if there is no span in the context, attrs are key
+// value pairs
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if _, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return Start(ctx, name, attrs...)
+	}
+	s := &Span{tracer: t, traceID: randomID(16)}
+	return s.init(ctx, name, attrs)
+}
+
+// Start starts a child of the span of the context, nothing is traced if
+// there is no span
+func Start(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok || parent == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: parent.tracer, traceID: parent.traceID, parent: parent.spanID}
+	return s.init(ctx, name, attrs)
+}
+
+func (s *Span) init(ctx context.Context, name string, attrs []string) (context.Context, *Span) {
+	s.spanID = randomID(8)
+	s.name = name
+	s.start = time.Now()
+	s.attrs = make(map[string]string)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[attrs[i]] = attrs[i+1]
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Set sets an attribute of the span
+func (s *Span) Set(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// Error marks the span as failed if the error isn't nil
+func (s *Span) Error(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+// End finishes the span and queues it to be exported
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	t := s.tracer
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.spans) >= maxSpans {
+		// Drop the oldest spans while the collector is unreachable
+		t.spans = t.spans[1:]
+	}
+	t.spans = append(t.spans, s)
+}
+
+// Run exports the spans periodically until the context is done
+func (t *Tracer) Run(ctx context.Context, wg *sync.WaitGroup) {
+	if t == nil {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := t.Flush(context.Background()); err != nil {
+					log.Println(err)
+				}
+				return
+			case <-ticker.C:
+			}
+			if err := t.Flush(ctx); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+func attrs(m map[string]string) []otlpAttr {
+	var out []otlpAttr
+	for k, v := range m {
+		out = append(out, otlpAttr{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	return out
+}
+
+// Flush exports the finished spans
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.lock.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.lock.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	var out []otlpSpan
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:      s.traceID,
+			SpanID:       s.spanID,
+			ParentSpanID: s.parent,
+			Name:         s.name,
+			Kind:         1,
+			Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+			End:          strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:   attrs(s.attrs),
+		}
+		if s.err != "" {
+			o.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		out = append(out, o)
+	}
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": attrs(map[string]string{"service.name": t.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": t.service},
+						"spans": out,
+					},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("trace: couldn't encode spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("trace: couldn't create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("trace: couldn't export %d spans: %w", len(spans), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("trace: export returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package update
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Restart replaces the current process with the executable
+func Restart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("update: couldn't get executable: %w", err)
+	}
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		return fmt.Errorf("update: couldn't exec %s: %w", exe, err)
+	}
+	return nil
+}
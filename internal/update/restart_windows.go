@@ -0,0 +1,8 @@
+package update
+
+import "errors"
+
+// Restart isn't supported on windows, the process must be restarted manually
+func Restart() error {
+	return errors.New("update: restart not supported on windows")
+}
@@ -0,0 +1,209 @@
+package update
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Release is a github release
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	Assets []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a github release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+var client = &http.Client{Timeout: 5 * time.Minute}
+
+// Latest returns the latest release of the github repository (owner/name)
+func Latest(ctx context.Context, repo string) (*Release, error) {
+	u := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo)
+	data, err := get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	var r Release
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("update: couldn't decode release: %w", err)
+	}
+	if r.Tag == "" {
+		return nil, fmt.Errorf("update: release without tag")
+	}
+	return &r, nil
+}
+
+// Newer returns true if the latest version is newer than the current one,
+// versions have the format vMAJOR.MINOR.PATCH
+func Newer(current, latest string) (bool, error) {
+	c, err := parseVersion(current)
+	if err != nil {
+		return false, err
+	}
+	l, err := parseVersion(latest)
+	if err != nil {
+		return false, err
+	}
+	for i := range c {
+		if l[i] != c[i] {
+			return l[i] > c[i], nil
+		}
+	}
+	return false, nil
+}
+
+func parseVersion(v string) ([3]int, error) {
+	var n [3]int
+	v = strings.SplitN(strings.TrimPrefix(v, "v"), "-", 2)[0]
+	split := strings.Split(v, ".")
+	if len(split) != 3 {
+		return n, fmt.Errorf("update: invalid version %q", v)
+	}
+	for i, s := range split {
+		var err error
+		if n[i], err = strconv.Atoi(s); err != nil {
+			return n, fmt.Errorf("update: invalid version %q", v)
+		}
+	}
+	return n, nil
+}
+
+// Install downloads the release binary for the current platform, verifies
+// it against the release checksums and replaces the running executable.
+// The checksums come from the same release, they detect corrupted downloads
+// but they aren't a signature, anyone able to publish a release can replace
+// both files. Windows isn't supported because the running executable can't
+// be replaced.
+func Install(ctx context.Context, r *Release) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("update: self update isn't supported on windows, download the release manually")
+	}
+	platform := fmt.Sprintf("%s_%s", runtime.GOOS, runtime.GOARCH)
+	var bin, sums *Asset
+	for i, a := range r.Assets {
+		switch {
+		case a.Name == "checksums.txt" || strings.HasSuffix(a.Name, "_checksums.txt"):
+			sums = &r.Assets[i]
+		case strings.Contains(a.Name, platform) && !strings.HasSuffix(a.Name, ".zip"):
+			bin = &r.Assets[i]
+		}
+	}
+	if bin == nil {
+		return fmt.Errorf("update: no asset for %s in %s", platform, r.Tag)
+	}
+	if sums == nil {
+		return fmt.Errorf("update: no checksums in %s", r.Tag)
+	}
+
+	data, err := get(ctx, bin.URL)
+	if err != nil {
+		return err
+	}
+	sumsData, err := get(ctx, sums.URL)
+	if err != nil {
+		return err
+	}
+	want, err := checksum(sumsData, bin.Name)
+	if err != nil {
+		return err
+	}
+	got := sha256.Sum256(data)
+	if hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("update: checksum mismatch for %s", bin.Name)
+	}
+	if strings.HasSuffix(bin.Name, ".tar.gz") {
+		if data, err = untar(data); err != nil {
+			return err
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("update: couldn't get executable: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("update: couldn't resolve executable: %w", err)
+	}
+	tmp := fmt.Sprintf("%s.new", exe)
+	if err := ioutil.WriteFile(tmp, data, 0755); err != nil {
+		return fmt.Errorf("update: couldn't write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("update: couldn't replace %s: %w", exe, err)
+	}
+	return nil
+}
+
+// checksum returns the sha256 of the file from a sha256sum formatted list
+func checksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("update: checksum not found for %s", name)
+}
+
+// untar returns the amazbot binary of a tar.gz archive
+func untar(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("update: couldn't read archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("update: binary not found in archive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("update: couldn't read archive: %w", err)
+		}
+		if h.Typeflag == tar.TypeReg && filepath.Base(h.Name) == "amazbot" {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+func get(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("update: couldn't create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("update: request to %s failed: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("update: request to %s returned %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("update: couldn't read %s: %w", u, err)
+	}
+	return data, nil
+}
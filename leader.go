@@ -0,0 +1,72 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/redis"
+)
+
+// leaderTTL is the expiration of the leader lock, it is renewed every third
+const leaderTTL = 15 * time.Second
+
+// elect blocks until the instance holds the leader lock, the returned
+// channel is closed if the lock is lost
+func elect(ctx context.Context, r *redis.Client) (<-chan struct{}, func(), error) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s/%d", host, os.Getpid())
+	ticker := time.NewTicker(leaderTTL / 3)
+	log.Printf("standby %s waiting for leadership\n", owner)
+	for {
+		ok, err := r.Lock("leader", owner, leaderTTL)
+		if err != nil {
+			log.Println(err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return nil, nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	log.Printf("%s is the leader\n", owner)
+
+	lost := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ok, err := r.Lock("leader", owner, leaderTTL)
+			if err != nil {
+				log.Println(err)
+				// The lock may still be ours until it expires
+				ok = time.Since(renewed) < leaderTTL
+			} else if ok {
+				renewed = time.Now()
+			}
+			if !ok {
+				close(lost)
+				return
+			}
+		}
+	}()
+	resign := func() {
+		close(done)
+		if err := r.Unlock("leader", owner); err != nil {
+			log.Println(err)
+		}
+	}
+	return lost, resign, nil
+}
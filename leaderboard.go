@@ -0,0 +1,236 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/notify"
+)
+
+const (
+	// maxLeaderboard is the number of deals of each leaderboard ranking
+	maxLeaderboard = 5
+	// leaderboardDay and leaderboardHour are when the weekly leaderboards
+	// are posted
+	leaderboardDay  = time.Monday
+	leaderboardHour = 10
+)
+
+// dayPosts are the deals posted to a chat during a day, top keeps the ones
+// with the biggest discounts
+type dayPosts struct {
+	Count int          `json:"count"`
+	Top   []postedDeal `json:"top,omitempty"`
+}
+
+type postedDeal struct {
+	ID    string  `json:"id"`
+	Title string  `json:"title"`
+	Price float64 `json:"price"`
+	Coin  string  `json:"coin"`
+	Score float64 `json:"score"`
+}
+
+func postsKey(chat string, day time.Time) string {
+	return fmt.Sprintf("posts/%s/%s", chat, day.Format("2006-01-02"))
+}
+
+// tally adds the deal posted to the chat to its daily posts
+func (b *bot) tally(chat string, i api.Item, a api.Alert, score float64) {
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+	key := postsKey(chat, time.Now().UTC())
+	var p dayPosts
+	if err := b.db.Get("stats", key, &p); err != nil {
+		b.log(err)
+		return
+	}
+	p.Count++
+	p.Top = append(p.Top, postedDeal{ID: i.ID, Title: i.Title, Price: a.Price, Coin: api.Coin(i.Domain), Score: score})
+	sort.SliceStable(p.Top, func(i, j int) bool { return p.Top[i].Score > p.Top[j].Score })
+	if len(p.Top) > maxLeaderboard {
+		p.Top = p.Top[:maxLeaderboard]
+	}
+	if err := b.db.Put("stats", key, p); err != nil {
+		b.log(err)
+	}
+}
+
+// leaderboard returns the summary of the deals posted to the chat during
+// the week before the time, an empty text is returned if there were none
+func (b *bot) leaderboard(chat string, now time.Time) (string, error) {
+	var count int
+	var top []postedDeal
+	clicks := make(map[string]dealStat)
+	for d := 0; d < 7; d++ {
+		day := now.AddDate(0, 0, -d-1)
+		var p dayPosts
+		if err := b.db.Get("stats", postsKey(chat, day), &p); err != nil {
+			return "", err
+		}
+		count += p.Count
+		top = append(top, p.Top...)
+		var s dayStats
+		if err := b.db.Get("stats", dayKey(chat, day), &s); err != nil {
+			return "", err
+		}
+		for id, d := range s.Deals {
+			c := clicks[id]
+			c.Title = d.Title
+			c.Clicks += d.Clicks
+			clicks[id] = c
+		}
+	}
+	if count == 0 {
+		return "", nil
+	}
+	lines := []string{"🏆 <b>RESUMEN SEMANAL</b>", "", fmt.Sprintf("📦 %d ofertas publicadas", count)}
+
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Score > top[j].Score })
+	seen := make(map[string]bool)
+	var deals []string
+	for _, d := range top {
+		if seen[d.ID] || len(deals) == maxLeaderboard {
+			continue
+		}
+		seen[d.ID] = true
+		deals = append(deals, fmt.Sprintf("%d. -%.0f%% · %.2f%s · %s", len(deals)+1, d.Score, d.Price, d.Coin, html.EscapeString(d.Title)))
+	}
+	if len(deals) > 0 {
+		lines = append(lines, "", "🔥 <b>Mayores descuentos</b>")
+		lines = append(lines, deals...)
+	}
+
+	ids := make([]string, 0, len(clicks))
+	for id := range clicks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if clicks[ids[i]].Clicks != clicks[ids[j]].Clicks {
+			return clicks[ids[i]].Clicks > clicks[ids[j]].Clicks
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > maxLeaderboard {
+		ids = ids[:maxLeaderboard]
+	}
+	if len(ids) > 0 {
+		lines = append(lines, "", "👆 <b>Más clicadas</b>")
+	}
+	for n, id := range ids {
+		lines = append(lines, fmt.Sprintf("%d. %d clics · %s", n+1, clicks[id].Clicks, html.EscapeString(clicks[id].Title)))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// leaderboardChats returns the telegram groups and channels with deals
+// posted during the week before the time
+func (b *bot) leaderboardChats(now time.Time) ([]string, error) {
+	keys, err := b.db.Keys("stats")
+	if err != nil {
+		return nil, err
+	}
+	from := now.AddDate(0, 0, -7).Format("2006-01-02")
+	chats := make(map[string]bool)
+	for _, k := range keys {
+		if !strings.HasPrefix(k, "posts/") {
+			continue
+		}
+		i := strings.LastIndex(k, "/")
+		chat, day := k[len("posts/"):i], k[i+1:]
+		if day < from {
+			continue
+		}
+		// Private chats don't get leaderboards
+		if id, err := strconv.ParseInt(chat, 10, 64); err == nil && id > 0 {
+			continue
+		}
+		if _, _, ok := notify.Split(chat); ok {
+			continue
+		}
+		chats[chat] = true
+	}
+	var list []string
+	for c := range chats {
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// postLeaderboards posts the leaderboard of the last week to the chats once
+// per week, chats with leaderboards disabled are skipped
+func (b *bot) postLeaderboards(ctx context.Context, now time.Time) {
+	year, week := now.ISOWeek()
+	current := fmt.Sprintf("%d-%02d", year, week)
+	var last string
+	if err := b.db.Get("config", "leaderboard", &last); err != nil {
+		b.log(err)
+		return
+	}
+	if last == current || now.Weekday() != leaderboardDay || now.Hour() < leaderboardHour {
+		return
+	}
+	chats, err := b.leaderboardChats(now)
+	if err != nil {
+		b.log(err)
+		return
+	}
+	for _, chat := range chats {
+		if b.settings(chat).NoLeaderboard {
+			continue
+		}
+		text, err := b.leaderboard(chat, now)
+		if err != nil {
+			b.log(err)
+			return
+		}
+		if text != "" {
+			b.htmlMessage(ctx, chat, text)
+		}
+	}
+	if err := b.db.Put("config", "leaderboard", current); err != nil {
+		b.log(err)
+	}
+}
+
+// leaderboards checks every hour if the weekly leaderboards must be posted
+func (b *bot) leaderboards(ctx context.Context) {
+	b.wg.Add(1)
+	go func() {
+		defer log.Println("leaderboard routine finished")
+		defer b.wg.Done()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			b.postLeaderboards(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// leaderboardCommand handles /leaderboard, it shows the leaderboard that
+// would be posted to the chat now
+func (b *bot) leaderboardCommand(user int, chat, _ string) {
+	text, err := b.leaderboard(chat, time.Now())
+	if err != nil {
+		b.log(err)
+		return
+	}
+	if text == "" {
+		b.message(user, fmt.Sprintf("no deals posted to %s in the last week", chat))
+		return
+	}
+	b.htmlMessage(b.ctx, user, text)
+}
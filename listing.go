@@ -0,0 +1,151 @@
+package amazbot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+const (
+	// minSellerScrapes is the number of scrapes needed to trust the
+	// dominant seller of an item
+	minSellerScrapes = 5
+	// maxSellerScrapes halves the seller counts so old sellers fade out
+	maxSellerScrapes = 50
+	// hijackChanges is the number of traits that must change at once to
+	// report a listing hijack
+	hijackChanges = 2
+)
+
+// listing are the traits of an item page tracked to detect hijacks, sellers
+// counts the scrapes each seller had the cheapest new offer
+type listing struct {
+	Category string         `json:"category,omitempty"`
+	Brand    string         `json:"brand,omitempty"`
+	Sellers  map[string]int `json:"sellers,omitempty"`
+}
+
+// dominant returns the seller with the most scrapes if there are enough
+func (l listing) dominant() string {
+	var seller string
+	var max, total int
+	for s, n := range l.Sellers {
+		total += n
+		if n > max || (n == max && s < seller) {
+			seller, max = s, n
+		}
+	}
+	if total < minSellerScrapes {
+		return ""
+	}
+	return seller
+}
+
+// changes returns the traits of the item that differ from the listing, title
+// changes are reported on their own
+func (l listing) changes(i api.Item) []string {
+	var changes []string
+	if c := topCategory(i); l.Category != "" && c != "" && c != l.Category {
+		changes = append(changes, fmt.Sprintf("category: %s → %s", l.Category, c))
+	}
+	if l.Brand != "" && i.Brand != "" && !strings.EqualFold(l.Brand, i.Brand) {
+		changes = append(changes, fmt.Sprintf("brand: %s → %s", l.Brand, i.Brand))
+	}
+	if d, s := l.dominant(), i.Sellers[0]; d != "" && s != "" && l.Sellers[s] == 0 {
+		changes = append(changes, fmt.Sprintf("seller: %s → %s", d, s))
+	}
+	return changes
+}
+
+// update adds the traits of the item to the listing
+func (l *listing) update(i api.Item) {
+	if c := topCategory(i); c != "" {
+		l.Category = c
+	}
+	if i.Brand != "" {
+		l.Brand = i.Brand
+	}
+	s := i.Sellers[0]
+	if s == "" {
+		return
+	}
+	if l.Sellers == nil {
+		l.Sellers = make(map[string]int)
+	}
+	l.Sellers[s]++
+	total := 0
+	for _, n := range l.Sellers {
+		total += n
+	}
+	if total <= maxSellerScrapes {
+		return
+	}
+	for k, n := range l.Sellers {
+		if n /= 2; n == 0 {
+			delete(l.Sellers, k)
+		} else {
+			l.Sellers[k] = n
+		}
+	}
+}
+
+func topCategory(i api.Item) string {
+	if len(i.Category) == 0 {
+		return ""
+	}
+	return i.Category[0]
+}
+
+// inspect compares the scraped item with its listing and publishes a listing
+// changed event when several traits change at once
+func (b *bot) inspect(parsed parsedArgs, i api.Item) {
+	b.listingLock.Lock()
+	defer b.listingLock.Unlock()
+	key := historyKey(i.ID, i.Domain)
+	var l listing
+	if err := b.db.Get("listings", key, &l); err != nil {
+		b.log(err)
+	}
+	changes := l.changes(i)
+	l.update(i)
+	if err := b.db.Put("listings", key, l); err != nil {
+		b.log(err)
+	}
+	if len(changes) >= hijackChanges {
+		b.bus.Publish(Event{Type: ListingChanged, Search: parsed.id, Chat: parsed.chat, Item: &i, Changes: changes})
+	}
+}
+
+// subscribers returns the chats of all the searchs of the item
+func (b *bot) subscribers(i api.Item) []string {
+	item := historyKey(i.ID, i.Domain)
+	var chats []string
+	b.searchs.Range(func(k interface{}, _ interface{}) bool {
+		parsed, err := parseArgs(k.(string), "")
+		if err == nil && strings.SplitN(parsed.query, "?", 2)[0] == item {
+			chats = append(chats, parsed.chat)
+		}
+		return true
+	})
+	sort.Strings(chats)
+	return chats
+}
+
+// hijacked warns the owners of all the searchs of the item that its listing
+// changed wholesale, deal channels shouldn't promote it
+func (b *bot) hijacked(e Event) {
+	log.Printf("listing of %s changed: %s\n", e.Search, strings.Join(e.Changes, ", "))
+	text := fmt.Sprintf("🚨 listing of %s.%s changed\n%s\n%s", e.Item.ID, e.Item.Domain, strings.Join(e.Changes, "\n"), e.Item.Link)
+	sent := make(map[int]bool)
+	for _, chat := range append(b.subscribers(*e.Item), e.Chat) {
+		for _, u := range b.owners(chat) {
+			if !sent[u] {
+				sent[u] = true
+				b.message(u, text)
+			}
+		}
+	}
+}
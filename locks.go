@@ -0,0 +1,40 @@
+package amazbot
+
+import "sync"
+
+// keyLock is a mutex per key, it serializes the updates of each search
+// between the search loop and the command handlers
+type keyLock struct {
+	lock  sync.Mutex
+	locks map[string]*refLock
+}
+
+type refLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks the key and returns the function to unlock it
+func (l *keyLock) Lock(key string) func() {
+	l.lock.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*refLock)
+	}
+	k, ok := l.locks[key]
+	if !ok {
+		k = &refLock{}
+		l.locks[key] = k
+	}
+	k.refs++
+	l.lock.Unlock()
+
+	k.Lock()
+	return func() {
+		k.Unlock()
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if k.refs--; k.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
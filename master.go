@@ -0,0 +1,94 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/notify"
+)
+
+// master returns the channel that receives the alerts of all the chats
+func (b *bot) master() string {
+	var master string
+	if err := b.db.Get("config", "master", &master); err != nil {
+		b.log(err)
+	}
+	return master
+}
+
+// masterCommand handles /master [chat|off]
+func (b *bot) masterCommand(_ context.Context, r request) {
+	chat := strings.TrimSpace(r.args)
+	switch chat {
+	case "":
+		if master := b.master(); master != "" {
+			b.message(r.user, fmt.Sprintf("master channel: %s", master))
+		} else {
+			b.message(r.user, "no master channel")
+		}
+		return
+	case "off":
+		if err := b.db.Delete("config", "master"); err != nil {
+			b.log(err)
+			return
+		}
+		b.message(r.user, "master channel removed")
+		return
+	}
+	if _, _, ok := notify.Split(chat); ok {
+		b.message(r.user, "the master channel must be a telegram chat")
+		return
+	}
+	if err := b.checkChat(r.user, chat); err != nil {
+		b.message(r.user, fmt.Sprintf("master channel not updated: %s", err))
+		return
+	}
+	if err := b.db.Put("config", "master", chat); err != nil {
+		b.log(err)
+		return
+	}
+	b.message(r.user, fmt.Sprintf("master channel updated: %s", chat))
+}
+
+// crosspost sends the alert sent to the origin chats to the master channel
+// tagged with them
+func (b *bot) crosspost(e Event, origins []string) {
+	master := b.master()
+	if master == "" || len(origins) == 0 {
+		return
+	}
+	var tags []string
+	for _, o := range origins {
+		if o == master {
+			return
+		}
+		tags = append(tags, html.EscapeString(o))
+	}
+	i, a := *e.Item, *e.Alert
+	cacheID := fmt.Sprintf("master/%s/%s/%d/%d/%.2f", master, i.ID, a.Kind, a.State, a.Price)
+	if e.Type == SearchAnnounced {
+		cacheID = fmt.Sprintf("announce/%s", cacheID)
+	}
+	if !b.claim(cacheID) {
+		return
+	}
+	data := newAlertData(i, a, master)
+	data.Settings = b.settings(master)
+	if e.Type == SearchAnnounced {
+		data.Kind = "tracking"
+	}
+	data.Link = b.shorten(master, "", i, a.Price)
+	_, text, err := execute(b.template("telegram"), data)
+	if err != nil {
+		b.log(err)
+		b.release(cacheID)
+		return
+	}
+	text = fmt.Sprintf("%s %s\n\n%s", data.Emoji("origin"), strings.Join(tags, ", "), text)
+	b.throttle.push(master, data.Score, func() {
+		b.htmlMessage(b.ctx, master, text)
+		b.metrics.Add("amazbot_alerts_total", 1, "kind", data.Kind, "destination", "master")
+	})
+}
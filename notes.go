@@ -0,0 +1,86 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNote is the maximum length in characters of a search note
+const maxNote = 200
+
+// note returns the note of the search, empty if it has none
+func (b *bot) note(search string) string {
+	var note string
+	if err := b.db.Get("notes", search, &note); err != nil {
+		b.log(err)
+	}
+	return note
+}
+
+// forget removes the note of a stopped search
+func (b *bot) forget(e Event) {
+	if err := b.db.Delete("notes", e.Search); err != nil {
+		b.log(err)
+	}
+}
+
+// noteCommand handles /note <asin[.domain][?state]> [text|off], searchs
+// with options match the id without them
+func (b *bot) noteCommand(_ context.Context, r request) {
+	split := r.fields(2)
+	parsed, err := parseArgs(split[0], r.chat)
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	if !b.ownsSearch(r.user, parsed.id) {
+		b.message(r.user, fmt.Sprintf("couldn't note %s: it belongs to another tenant", parsed.id))
+		return
+	}
+	var ids []string
+	b.searchs.Range(func(k interface{}, _ interface{}) bool {
+		id := k.(string)
+		if id == parsed.id || strings.HasPrefix(id, parsed.id+"?") {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	if len(ids) == 0 {
+		b.message(r.user, fmt.Sprintf("search %s not found", parsed.id))
+		return
+	}
+	text := strings.TrimSpace(split[1])
+	if text == "" {
+		for _, id := range ids {
+			if note := b.note(id); note != "" {
+				b.message(r.user, fmt.Sprintf("%s: %s", id, note))
+			} else {
+				b.message(r.user, fmt.Sprintf("%s has no note", id))
+			}
+		}
+		return
+	}
+	if utf8.RuneCountInString(text) > maxNote {
+		b.message(r.user, fmt.Sprintf("note too long, the maximum is %d characters", maxNote))
+		return
+	}
+	for _, id := range ids {
+		var err error
+		if strings.ToLower(text) == "off" {
+			err = b.db.Delete("notes", id)
+		} else {
+			err = b.db.Put("notes", id, text)
+		}
+		if err != nil {
+			b.log(err)
+			return
+		}
+	}
+	if strings.ToLower(text) == "off" {
+		b.message(r.user, fmt.Sprintf("note removed from %s", strings.Join(ids, ", ")))
+		return
+	}
+	b.message(r.user, fmt.Sprintf("note added to %s", strings.Join(ids, ", ")))
+}
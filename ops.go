@@ -0,0 +1,91 @@
+package amazbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+const (
+	// circuitFailures is the number of consecutive scrape failures of a
+	// domain that open its circuit
+	circuitFailures = 5
+	// opsInterval limits how often the same operational event is published
+	opsInterval = 10 * time.Minute
+)
+
+// failed counts the consecutive scrape failures of the domain and publishes
+// an event when its circuit opens or closes
+func (b *bot) failed(domain string, err error) {
+	b.loopLock.Lock()
+	n := b.failures[domain]
+	if err == nil {
+		delete(b.failures, domain)
+	} else {
+		b.failures[domain] = n + 1
+	}
+	b.loopLock.Unlock()
+	switch {
+	case err != nil && n+1 == circuitFailures:
+		b.bus.Publish(Event{Type: CircuitOpened, Domain: domain, Error: err.Error()})
+	case err == nil && n >= circuitFailures:
+		b.bus.Publish(Event{Type: CircuitClosed, Domain: domain})
+	}
+}
+
+// opsError publishes an operational event for captcha service and db errors,
+// the same type is published at most once per interval
+func (b *bot) opsError(err error) {
+	var typ string
+	switch {
+	case errors.Is(err, api.ErrCaptcha):
+		typ = CaptchaFailed
+	case strings.Contains(err.Error(), "store: "):
+		typ = StoreFailed
+	default:
+		return
+	}
+	if b.cache.Add(fmt.Sprintf("ops/%s", typ), struct{}{}, opsInterval) != nil {
+		return
+	}
+	b.bus.Publish(Event{Type: typ, Error: err.Error()})
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhook posts the events as json to the url
+func (b *bot) webhook(ctx context.Context, u string) func(Event) {
+	return func(e Event) {
+		data, err := json.Marshal(e)
+		if err != nil {
+			b.log(fmt.Errorf("webhook: couldn't marshal event: %w", err))
+			return
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(data))
+			if err != nil {
+				b.log(fmt.Errorf("webhook: couldn't create request: %w", err))
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := webhookClient.Do(req)
+			if err != nil {
+				b.log(fmt.Errorf("webhook: request to %s failed: %w", req.URL.Host, err))
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				b.log(fmt.Errorf("webhook: %s returned %s", req.URL.Host, resp.Status))
+			}
+		}()
+	}
+}
@@ -0,0 +1,113 @@
+package amazbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pauseAll is the key used to pause all domains
+const pauseAll = "*"
+
+// pauses returns the paused domains with the time they are resumed, zero
+// time means paused until resumed manually
+func (b *bot) pauses() map[string]time.Time {
+	var pauses map[string]time.Time
+	if err := b.db.Get("config", "paused", &pauses); err != nil {
+		b.log(err)
+	}
+	if pauses == nil {
+		pauses = make(map[string]time.Time)
+	}
+	return pauses
+}
+
+// paused returns true if scraping of the domain is paused
+func (b *bot) paused(domain string) bool {
+	now := time.Now()
+	pauses := b.pauses()
+	for _, k := range []string{pauseAll, domain} {
+		until, ok := pauses[k]
+		if ok && (until.IsZero() || now.Before(until)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pauseCommand handles /pauseall [duration] and /pausedomain <domain> [duration]
+func (b *bot) pauseCommand(user int, domain, args string) {
+	pauses := b.pauses()
+	var until time.Time
+	if args = strings.TrimSpace(args); args != "" {
+		d, err := time.ParseDuration(args)
+		if err != nil || d <= 0 {
+			b.message(user, "usage: /pauseall [duration] or /pausedomain <domain> [duration]")
+			return
+		}
+		until = time.Now().Add(d)
+	}
+	pauses[domain] = until
+	if err := b.db.Put("config", "paused", pauses); err != nil {
+		b.log(err)
+		return
+	}
+	b.message(user, fmt.Sprintf("paused %s", pauseText(domain, until)))
+}
+
+// resumeCommand handles /resume [domain], all pauses are removed if no
+// domain is provided
+func (b *bot) resumeCommand(user int, domain string) {
+	pauses := b.pauses()
+	if domain = strings.TrimSpace(domain); domain == "" {
+		pauses = nil
+	} else {
+		delete(pauses, domain)
+	}
+	if err := b.db.Put("config", "paused", pauses); err != nil {
+		b.log(err)
+		return
+	}
+	if domain == "" {
+		domain = "all"
+	}
+	b.message(user, fmt.Sprintf("resumed %s", domain))
+}
+
+// pausesText returns the list of active pauses
+func (b *bot) pausesText() string {
+	now := time.Now()
+	var lines []string
+	for k, until := range b.pauses() {
+		if !until.IsZero() && now.After(until) {
+			continue
+		}
+		lines = append(lines, pauseText(k, until))
+	}
+	if len(lines) == 0 {
+		return "nothing paused"
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("paused:\n%s", strings.Join(lines, "\n"))
+}
+
+func pauseText(domain string, until time.Time) string {
+	if domain == pauseAll {
+		domain = "all"
+	}
+	if until.IsZero() {
+		return domain
+	}
+	return fmt.Sprintf("%s until %s", domain, until.Format("2006-01-02 15:04"))
+}
+
+// searchDomain returns the domain of a search query (ASIN.domain?opts)
+func searchDomain(query string) string {
+	query = strings.SplitN(query, "?", 2)[0]
+	split := strings.SplitN(query, ".", 2)
+	if len(split) < 2 {
+		return ""
+	}
+	return split[1]
+}
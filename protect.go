@@ -0,0 +1,253 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+const (
+	// protectRule is the destination rule of the searchs that track the
+	// price of a purchase, they don't send regular alerts
+	protectRule = "protect"
+	// returnWindow is the default return period of a purchase
+	returnWindow = 30 * 24 * time.Hour
+)
+
+// purchase is a product bought by an user, its price is tracked until the
+// end of the return window to claim a refund if it drops, lowest is the
+// lowest price already alerted
+type purchase struct {
+	Item   string    `json:"item"`
+	Price  float64   `json:"price"`
+	Date   time.Time `json:"date"`
+	Until  time.Time `json:"until"`
+	Lowest float64   `json:"lowest,omitempty"`
+}
+
+// search returns the id of the search that tracks the purchase
+func (p purchase) search(user int) string {
+	return fmt.Sprintf("%d=%s/%s?0", user, protectRule, p.Item)
+}
+
+func purchasesKey(user int) string {
+	return fmt.Sprintf("user/%d/purchases", user)
+}
+
+func (b *bot) purchases(user int) []purchase {
+	var ps []purchase
+	if err := b.db.Get("config", purchasesKey(user), &ps); err != nil {
+		b.log(err)
+	}
+	return ps
+}
+
+func (b *bot) savePurchases(user int, ps []purchase) {
+	var err error
+	if len(ps) == 0 {
+		err = b.db.Delete("config", purchasesKey(user))
+	} else {
+		err = b.db.Put("config", purchasesKey(user), ps)
+	}
+	if err != nil {
+		b.log(err)
+	}
+}
+
+// protectedUser returns the user of a purchase search chat
+func protectedUser(chat string) (int, bool) {
+	ds := destinations(chat)
+	if len(ds) != 1 || ds[0].rule != protectRule {
+		return 0, false
+	}
+	user, err := strconv.Atoi(ds[0].chat)
+	return user, err == nil
+}
+
+// protect alerts the user when the new price of a purchase drops below the
+// price paid before the return window ends, only new lows are alerted
+func (b *bot) protect(e Event) {
+	user, ok := protectedUser(e.Chat)
+	if !ok {
+		return
+	}
+	price := e.Item.Prices[0]
+	if price <= 0 {
+		return
+	}
+	b.purchaseLock.Lock()
+	defer b.purchaseLock.Unlock()
+	ps := b.purchases(user)
+	for i, p := range ps {
+		if p.search(user) != e.Search || e.Time.After(p.Until) {
+			continue
+		}
+		if price >= p.Price || (p.Lowest > 0 && price >= p.Lowest) {
+			return
+		}
+		ps[i].Lowest = price
+		b.savePurchases(user, ps)
+		coin := api.Coin(e.Item.Domain)
+		refund := p.Price - price
+		text := fmt.Sprintf("🛡️ <b>BAJADA DE PRECIO EN TU COMPRA</b>\n\n%s\n\n💸 Pagaste: %.2f%s\n💰 Ahora: %.2f%s\n🤑 Reembolso posible: <b>%.2f%s</b> (%.0f%%)\n⏳ Devolución hasta el %s\n\n%s",
+			html.EscapeString(e.Item.Title), p.Price, coin, price, coin, refund, coin, refund/p.Price*100,
+			p.Until.Format("02/01/2006"), e.Item.Link)
+		b.htmlMessage(b.ctx, user, text)
+		return
+	}
+}
+
+// expirePurchases stops tracking the purchases whose return window ended
+func (b *bot) expirePurchases(now time.Time) {
+	keys, err := b.db.Keys("config")
+	if err != nil {
+		b.log(err)
+		return
+	}
+	b.purchaseLock.Lock()
+	defer b.purchaseLock.Unlock()
+	for _, k := range keys {
+		var user int
+		if _, err := fmt.Sscanf(k, "user/%d/purchases", &user); err != nil || k != purchasesKey(user) {
+			continue
+		}
+		var active []purchase
+		for _, p := range b.purchases(user) {
+			if now.Before(p.Until) {
+				active = append(active, p)
+				continue
+			}
+			b.remove(p.search(user), fmt.Sprintf("%d=%s", user, protectRule))
+			b.message(user, fmt.Sprintf("⌛ return window of %s ended, price protection stopped", p.Item))
+		}
+		b.savePurchases(user, active)
+	}
+}
+
+// protections runs every hour the expiration of the purchases
+func (b *bot) protections(ctx context.Context) {
+	b.wg.Add(1)
+	go func() {
+		defer log.Println("protection routine finished")
+		defer b.wg.Done()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			b.expirePurchases(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+const boughtUsage = "usage: /bought <asin[.domain]> <price> [YYYY-MM-DD] [return days], /bought <asin[.domain]> off or /bought to list your purchases"
+
+// boughtCommand registers, removes or lists the purchases whose price is
+// tracked during the return window
+func (b *bot) boughtCommand(_ context.Context, r request) {
+	fields := strings.Fields(r.args)
+	if len(fields) == 0 {
+		b.message(r.user, b.purchasesText(r.user, time.Now()))
+		return
+	}
+	d := b.defaults(r.user)
+	item, err := api.NormalizeID(userDefaults{Domain: d.Domain}.apply(fields[0]))
+	if err != nil {
+		b.message(r.user, err.Error())
+		return
+	}
+	item = strings.SplitN(item, "?", 2)[0]
+	b.purchaseLock.Lock()
+	defer b.purchaseLock.Unlock()
+	ps := b.purchases(r.user)
+	var rest []purchase
+	var found *purchase
+	for i, p := range ps {
+		if p.Item == item {
+			found = &ps[i]
+			continue
+		}
+		rest = append(rest, p)
+	}
+	if len(fields) == 2 && strings.ToLower(fields[1]) == "off" {
+		if found == nil {
+			b.message(r.user, fmt.Sprintf("purchase %s not found", item))
+			return
+		}
+		b.savePurchases(r.user, rest)
+		b.spend(r.user, item, 0, found.Date)
+		b.remove(found.search(r.user), fmt.Sprintf("%d=%s", r.user, protectRule))
+		b.message(r.user, fmt.Sprintf("price protection of %s stopped", item))
+		return
+	}
+	if len(fields) < 2 || len(fields) > 4 {
+		b.message(r.user, boughtUsage)
+		return
+	}
+	price, ok := parseWatchlistPrice(fields[1])
+	if !ok {
+		b.message(r.user, fmt.Sprintf("invalid price %s", fields[1]))
+		return
+	}
+	now := time.Now().UTC()
+	p := purchase{Item: item, Price: price, Date: now.Truncate(24 * time.Hour)}
+	window := returnWindow
+	for _, f := range fields[2:] {
+		if date, err := time.Parse("2006-01-02", f); err == nil {
+			p.Date = date
+			continue
+		}
+		days, err := strconv.Atoi(f)
+		if err != nil || days <= 0 {
+			b.message(r.user, boughtUsage)
+			return
+		}
+		window = time.Duration(days) * 24 * time.Hour
+	}
+	p.Until = p.Date.Add(window)
+	if !now.Before(p.Until) {
+		b.message(r.user, fmt.Sprintf("the return window of %s already ended", item))
+		return
+	}
+	b.savePurchases(r.user, append(rest, p))
+	parsed, err := parseArgs(p.search(r.user), "")
+	if err != nil {
+		b.log(err)
+		return
+	}
+	if err := b.add(parsed); err != nil {
+		b.message(r.user, fmt.Sprintf("couldn't track %s: %s", item, err))
+		return
+	}
+	b.message(r.user, fmt.Sprintf("🛡️ price protection of %s until %s, paid %.2f%s", item, p.Until.Format("2006-01-02"), price, api.Coin(strings.SplitN(item, ".", 2)[1])))
+	b.spend(r.user, item, price, p.Date)
+}
+
+// purchasesText lists the purchases of the user
+func (b *bot) purchasesText(user int, now time.Time) string {
+	ps := b.purchases(user)
+	if len(ps) == 0 {
+		return fmt.Sprintf("no purchases, %s", boughtUsage)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Until.Before(ps[j].Until) })
+	lines := []string{"🛡️ price protection:"}
+	for _, p := range ps {
+		coin := api.Coin(strings.SplitN(p.Item, ".", 2)[1])
+		line := fmt.Sprintf("%s paid %.2f%s, %d days left", p.Item, p.Price, coin, int(p.Until.Sub(now).Hours()/24))
+		if p.Lowest > 0 {
+			line = fmt.Sprintf("%s, refund %.2f%s", line, p.Price-p.Lowest, coin)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,98 @@
+package amazbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxQueue limits the lines of the queue message
+const maxQueue = 50
+
+// scrapeStat is the result of the last scrape of a search
+type scrapeStat struct {
+	duration time.Duration
+	exceeded int
+	failed   bool
+	// errors is the number of failed scrapes since the bot started
+	errors int
+	// succeeded is the time of the last successful scrape
+	succeeded time.Time
+}
+
+// scraped records the duration of a scrape in the metrics and the queue stats
+func (b *bot) scraped(p parsedArgs, d time.Duration, err error) {
+	domain := searchDomain(p.query)
+	b.metrics.Observe("amazbot_scrape_seconds", d.Seconds(), "domain", domain)
+	exceeded := errors.Is(err, context.DeadlineExceeded)
+	if err != nil {
+		b.metrics.Add("amazbot_scrape_errors_total", 1, "domain", domain)
+	}
+	if exceeded {
+		b.metrics.Add("amazbot_scrape_budget_exceeded_total", 1, "domain", domain)
+	}
+	if !errors.Is(err, context.Canceled) {
+		b.failed(domain, err)
+	}
+
+	b.loopLock.Lock()
+	defer b.loopLock.Unlock()
+	s := b.scrapes[p.id]
+	s.duration = d
+	s.failed = err != nil
+	if s.failed {
+		s.errors++
+	} else {
+		s.succeeded = time.Now()
+	}
+	if exceeded {
+		s.exceeded++
+	}
+	b.scrapes[p.id] = s
+}
+
+// queueCommand handles /queue, the searchs are listed in loop order with the
+// duration of their last scrape
+func (b *bot) queueCommand(user int) {
+	var keys []string
+	b.searchs.Range(func(k interface{}, _ interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	sort.Strings(keys)
+
+	b.loopLock.Lock()
+	current, started := b.current, b.started
+	lines := []string{fmt.Sprintf("%d searchs, last loop %s, budget %s", len(keys), b.elapsed.Round(time.Second), b.budget)}
+	exceeded := 0
+	for i, k := range keys {
+		s := b.scrapes[k]
+		exceeded += s.exceeded
+		if i >= maxQueue {
+			continue
+		}
+		line := fmt.Sprintf("%d. %s", i+1, k)
+		switch {
+		case k == current:
+			line = fmt.Sprintf("%s ▶️ %s", line, time.Since(started).Round(time.Second))
+		case s.duration > 0:
+			line = fmt.Sprintf("%s %s", line, s.duration.Round(time.Second))
+		}
+		if s.failed {
+			line = fmt.Sprintf("%s ❌", line)
+		}
+		if s.exceeded > 0 {
+			line = fmt.Sprintf("%s ⏱%d", line, s.exceeded)
+		}
+		lines = append(lines, line)
+	}
+	b.loopLock.Unlock()
+	if len(keys) > maxQueue {
+		lines = append(lines, fmt.Sprintf("... %d more", len(keys)-maxQueue))
+	}
+	lines = append(lines, fmt.Sprintf("budget exceeded %d times", exceeded))
+	b.message(user, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,106 @@
+package amazbot
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// defaultInterval is the pause between search cycles
+const defaultInterval = 5 * time.Second
+
+func (b *bot) cycleInterval() time.Duration {
+	b.loopLock.Lock()
+	defer b.loopLock.Unlock()
+	if b.interval <= 0 {
+		return defaultInterval
+	}
+	return b.interval
+}
+
+// setUsers replaces the users and operators allowed to control the bot, the
+// chat of new users is loaded from the db. It must be called from the update
+// loop.
+func (b *bot) setUsers(users, operators []int) {
+	users = append(append(append(append([]int{}, users...), operators...), b.tenantUsers()...), b.admin)
+	userChats := make(map[int]string)
+	for _, u := range users {
+		if chat, ok := b.userChats[u]; ok {
+			userChats[u] = chat
+			continue
+		}
+		userChats[u] = strconv.Itoa(u)
+		var chat string
+		if err := b.db.Get("config", strconv.Itoa(u), &chat); err != nil {
+			b.log(fmt.Errorf("couldn't get config for %d: %w", u, err))
+			continue
+		}
+		if chat != "" {
+			userChats[u] = chat
+		}
+	}
+	ops := make(map[int]bool)
+	for _, o := range operators {
+		ops[o] = true
+	}
+	b.usersLock.Lock()
+	b.userChats = userChats
+	b.operators = ops
+	b.usersLock.Unlock()
+}
+
+// reload applies the parts of the configuration that can change without a
+// restart, the current configuration is kept if the new one is invalid. It
+// must be called from the update loop.
+func (b *bot) reload() error {
+	cfg := &Config{Templates: b.templatesDir}
+	if b.load != nil {
+		var err error
+		if cfg, err = b.load(); err != nil {
+			return fmt.Errorf("couldn't reload configuration: %w", err)
+		}
+	}
+	templates, err := loadTemplates(cfg.Templates)
+	if err != nil {
+		return fmt.Errorf("couldn't reload configuration: %w", err)
+	}
+	if b.load != nil {
+		schedules, err := parseSchedules(cfg.Schedules)
+		if err != nil {
+			return fmt.Errorf("couldn't reload configuration: %w", err)
+		}
+		tenants, err := parseTenants(cfg.Tenants)
+		if err != nil {
+			return fmt.Errorf("couldn't reload configuration: %w", err)
+		}
+		if err := b.client.Reconfigure(cfg.CaptchaURL, cfg.Proxy, cfg.Delay); err != nil {
+			return fmt.Errorf("couldn't reload configuration: %w", err)
+		}
+		b.loopLock.Lock()
+		b.interval = cfg.Interval
+		b.schedules = schedules
+		b.proxy = cfg.Proxy
+		b.loopLock.Unlock()
+		b.setTenants(tenants)
+		b.setUsers(cfg.Users, cfg.Operators)
+	}
+	b.templatesLock.Lock()
+	b.templatesDir = cfg.Templates
+	b.templates = templates
+	b.templatesLock.Unlock()
+	return nil
+}
+
+func (b *bot) reloadCommand(user int) {
+	if err := b.reload(); err != nil {
+		b.log(err)
+		if user != b.admin {
+			b.message(user, err.Error())
+		}
+		return
+	}
+	b.log("configuration reloaded")
+	if user != b.admin {
+		b.message(user, "configuration reloaded")
+	}
+}
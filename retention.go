@@ -0,0 +1,171 @@
+package amazbot
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// defaultRetention is how long stopped searchs are kept if it isn't set
+const defaultRetention = 30 * 24 * time.Hour
+
+// deletedSearch is a stopped search kept until the retention ends, the
+// history of its item is kept with it
+type deletedSearch struct {
+	Item    api.Item  `json:"item"`
+	Note    string    `json:"note,omitempty"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// softDelete moves the stored item of the search to the deleted bucket, it
+// must be called with the lock of the search and the deleted lock
+func (b *bot) softDelete(id string, now time.Time) {
+	d := deletedSearch{Note: b.note(id), Deleted: now.UTC()}
+	if err := b.db.Get("db", id, &d.Item); err != nil {
+		b.log(err)
+	}
+	if err := b.db.Put("deleted", id, d); err != nil {
+		b.log(err)
+	}
+	if err := b.db.Delete("db", id); err != nil {
+		b.log(err)
+	}
+}
+
+// itemKey returns the history key of the query (ASIN.domain[?options])
+func itemKey(query string) string {
+	return strings.SplitN(query, "?", 2)[0]
+}
+
+// retained returns the id of the stopped search of the same item and chat,
+// the one with the same options if there are several
+func (b *bot) retained(parsed parsedArgs) string {
+	keys, err := b.db.Keys("deleted")
+	if err != nil {
+		b.log(err)
+		return ""
+	}
+	var found string
+	for _, k := range keys {
+		if k == parsed.id {
+			return k
+		}
+		p, err := parseArgs(k, "")
+		if err == nil && found == "" && p.chat == parsed.chat && itemKey(p.query) == itemKey(parsed.query) {
+			found = k
+		}
+	}
+	return found
+}
+
+// restore moves the stopped search of the same item and chat back to the
+// db so the search resumes with its minimum price, it must be called with
+// the lock of the search and the deleted lock.
+// The minimum depends on the options (state, vat, points...) so it is only
+// restored if they are the same, otherwise the search resumes with the
+// history of the item and a new minimum.
+func (b *bot) restore(parsed parsedArgs) (api.Item, bool) {
+	id := b.retained(parsed)
+	if id == "" {
+		return api.Item{}, false
+	}
+	var d deletedSearch
+	if err := b.db.Get("deleted", id, &d); err != nil {
+		b.log(err)
+		return api.Item{}, false
+	}
+	exact := id == parsed.id
+	if exact {
+		if err := b.db.Put("db", parsed.id, d.Item); err != nil {
+			b.log(err)
+			return api.Item{}, false
+		}
+	}
+	if d.Note != "" {
+		if err := b.db.Put("notes", parsed.id, d.Note); err != nil {
+			b.log(err)
+		}
+	}
+	if err := b.db.Delete("deleted", id); err != nil {
+		b.log(err)
+	}
+	log.Printf("restored %s as %s\n", id, parsed.id)
+	return d.Item, exact
+}
+
+// purge deletes the searchs stopped before the retention and the history of
+// their items if no other search has them
+func (b *bot) purge(now time.Time) {
+	b.deletedLock.Lock()
+	defer b.deletedLock.Unlock()
+	keys, err := b.db.Keys("deleted")
+	if err != nil {
+		b.log(err)
+		return
+	}
+	used := make(map[string]bool)
+	b.searchs.Range(func(k, _ interface{}) bool {
+		if p, err := parseArgs(k.(string), ""); err == nil {
+			used[itemKey(p.query)] = true
+		}
+		return true
+	})
+	var expired []string
+	for _, k := range keys {
+		var d deletedSearch
+		if err := b.db.Get("deleted", k, &d); err != nil {
+			b.log(err)
+			continue
+		}
+		p, err := parseArgs(k, "")
+		if err != nil {
+			continue
+		}
+		if now.Sub(d.Deleted) < b.retention {
+			used[itemKey(p.query)] = true
+			continue
+		}
+		expired = append(expired, k)
+	}
+	for _, k := range expired {
+		if err := b.db.Delete("deleted", k); err != nil {
+			b.log(err)
+		}
+		p, _ := parseArgs(k, "")
+		item := itemKey(p.query)
+		if used[item] {
+			continue
+		}
+		// the searchs of the same item share the history
+		used[item] = true
+		b.historyLock.Lock()
+		if err := b.db.Delete("history", item); err != nil {
+			b.log(err)
+		}
+		b.historyLock.Unlock()
+		log.Printf("purged %s\n", k)
+	}
+}
+
+// purges deletes the expired searchs and share links every hour
+func (b *bot) purges(ctx context.Context) {
+	b.wg.Add(1)
+	go func() {
+		defer log.Println("purge routine finished")
+		defer b.wg.Done()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			b.purge(time.Now())
+			b.purgeShares(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,153 @@
+package amazbot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// dayStats are the clicks of a chat during a day, revenue is estimated in
+// euros for the clicks on links with an affiliate tag
+type dayStats struct {
+	Clicks     int                 `json:"clicks"`
+	Revenue    float64             `json:"revenue"`
+	Deals      map[string]dealStat `json:"deals"`
+	Categories map[string]int      `json:"categories"`
+}
+
+type dealStat struct {
+	Title   string  `json:"title"`
+	Clicks  int     `json:"clicks"`
+	Revenue float64 `json:"revenue"`
+}
+
+func dayKey(chat string, day time.Time) string {
+	return fmt.Sprintf("clicks/%s/%s", chat, day.Format("2006-01-02"))
+}
+
+// estimate returns the expected commission in euros of a click on the link
+func (b *bot) estimate(l shortLink) float64 {
+	if !l.Tagged || l.Price == 0 {
+		return 0
+	}
+	eur, err := b.fx.Convert(l.Price, api.Currency(l.Domain), "EUR")
+	if err != nil {
+		b.log(err)
+		return 0
+	}
+	return eur * b.conversion / 100 * b.commission / 100
+}
+
+// click adds the click on the link to the daily stats of its chat
+func (b *bot) click(l shortLink, now time.Time) {
+	revenue := b.estimate(l)
+	category := "-"
+	if len(l.Category) > 0 {
+		category = l.Category[0]
+	}
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+	key := dayKey(l.Chat, now)
+	var s dayStats
+	if err := b.db.Get("stats", key, &s); err != nil {
+		b.log(err)
+		return
+	}
+	if s.Deals == nil {
+		s.Deals = make(map[string]dealStat)
+		s.Categories = make(map[string]int)
+	}
+	s.Clicks++
+	s.Revenue += revenue
+	d := s.Deals[l.Item]
+	d.Title = l.Title
+	d.Clicks++
+	d.Revenue += revenue
+	s.Deals[l.Item] = d
+	s.Categories[category]++
+	if err := b.db.Put("stats", key, s); err != nil {
+		b.log(err)
+	}
+}
+
+// revenueCommand handles /revenue [days]
+func (b *bot) revenueCommand(user int, chat, args string) {
+	days := 7
+	if args = strings.TrimSpace(args); args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n <= 0 {
+			b.message(user, "usage: /revenue [days]")
+			return
+		}
+		days = n
+	}
+	if b.baseURL == "" {
+		b.message(user, "clicks aren't tracked, base url is not configured")
+		return
+	}
+	var total dayStats
+	deals := make(map[string]dealStat)
+	categories := make(map[string]int)
+	var daily []string
+	now := time.Now().UTC()
+	for i := days - 1; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i)
+		var s dayStats
+		if err := b.db.Get("stats", dayKey(chat, day), &s); err != nil {
+			b.log(err)
+			return
+		}
+		total.Clicks += s.Clicks
+		total.Revenue += s.Revenue
+		for id, d := range s.Deals {
+			t := deals[id]
+			t.Title = d.Title
+			t.Clicks += d.Clicks
+			t.Revenue += d.Revenue
+			deals[id] = t
+		}
+		for c, n := range s.Categories {
+			categories[c] += n
+		}
+		if s.Clicks > 0 {
+			daily = append(daily, fmt.Sprintf("%s: %d clicks, %.2f€", day.Format("2006-01-02"), s.Clicks, s.Revenue))
+		}
+	}
+	text := fmt.Sprintf("revenue for %s in the last %d days:\n%d clicks, %.2f€ estimated", chat, days, total.Clicks, total.Revenue)
+	if len(daily) > 0 {
+		text = fmt.Sprintf("%s\n\nper day:\n%s", text, strings.Join(daily, "\n"))
+	}
+
+	ids := make([]string, 0, len(deals))
+	for id := range deals {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return deals[ids[i]].Clicks > deals[ids[j]].Clicks })
+	if len(ids) > 10 {
+		ids = ids[:10]
+	}
+	if len(ids) > 0 {
+		text = fmt.Sprintf("%s\n\ntop deals:", text)
+	}
+	for _, id := range ids {
+		d := deals[id]
+		text = fmt.Sprintf("%s\n%d clicks, %.2f€ · %s %s", text, d.Clicks, d.Revenue, id, d.Title)
+	}
+
+	names := make([]string, 0, len(categories))
+	for c := range categories {
+		names = append(names, c)
+	}
+	sort.Slice(names, func(i, j int) bool { return categories[names[i]] > categories[names[j]] })
+	if len(names) > 0 {
+		text = fmt.Sprintf("%s\n\nper category:", text)
+	}
+	for _, c := range names {
+		text = fmt.Sprintf("%s\n%d clicks · %s", text, categories[c], c)
+	}
+	b.message(user, text)
+}
@@ -0,0 +1,122 @@
+package amazbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/notify"
+)
+
+func routeKey(chat string) string {
+	return fmt.Sprintf("%s/routes", chat)
+}
+
+// routeCommand handles /route [<category> <chat>|off <category>]
+func (b *bot) routeCommand(user int, chat, args string) {
+	var routes map[string]string
+	if err := b.db.Get("config", routeKey(chat), &routes); err != nil {
+		b.log(err)
+		return
+	}
+	if routes == nil {
+		routes = make(map[string]string)
+	}
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		if len(routes) == 0 {
+			b.message(user, fmt.Sprintf("no routes for %s", chat))
+			return
+		}
+		text := fmt.Sprintf("routes for %s:", chat)
+		for _, c := range sortedKeys(routes) {
+			text = fmt.Sprintf("%s\n%s → %s", text, c, routes[c])
+		}
+		b.message(user, text)
+		return
+	case len(fields) >= 2 && fields[0] == "off":
+		category := strings.ToLower(strings.Join(fields[1:], " "))
+		delete(routes, category)
+		if err := b.db.Put("config", routeKey(chat), routes); err != nil {
+			b.log(err)
+			return
+		}
+		b.message(user, fmt.Sprintf("route %s removed for %s", category, chat))
+		return
+	case len(fields) >= 2:
+		dest := fields[len(fields)-1]
+		if _, _, ok := notify.Split(dest); !ok {
+			dest = strings.ToLower(dest)
+		}
+		if dest != chat {
+			if err := b.checkChat(user, dest); err != nil {
+				b.message(user, fmt.Sprintf("couldn't route to %s: %s", dest, err))
+				return
+			}
+		}
+		category := strings.ToLower(strings.Join(fields[:len(fields)-1], " "))
+		routes[category] = dest
+		if err := b.db.Put("config", routeKey(chat), routes); err != nil {
+			b.log(err)
+			return
+		}
+		b.message(user, fmt.Sprintf("%s deals of %s routed to %s", category, chat, dest))
+	default:
+		b.message(user, "usage: /route [<category> <chat>|off <category>]")
+	}
+}
+
+// route replaces the destinations that have a route matching the item
+// category, destinations without matches are kept
+func (b *bot) route(dests []destination, i api.Item) []destination {
+	if len(i.Category) == 0 {
+		return dests
+	}
+	var routed []destination
+	for _, d := range dests {
+		var routes map[string]string
+		if err := b.db.Get("config", routeKey(d.chat), &routes); err != nil {
+			b.log(err)
+		}
+		target := ""
+		for _, c := range sortedKeys(routes) {
+			if matchCategory(i.Category, c) {
+				target = routes[c]
+				break
+			}
+		}
+		if target == "" {
+			routed = append(routed, d)
+			continue
+		}
+		for _, r := range destinations(target) {
+			if r.rule == "" {
+				r.rule = d.rule
+			}
+			routed = append(routed, r)
+		}
+	}
+	return routed
+}
+
+// matchCategory returns true if any level of the breadcrumb contains the
+// category
+func matchCategory(breadcrumb []string, category string) bool {
+	for _, c := range breadcrumb {
+		if strings.Contains(strings.ToLower(c), category) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
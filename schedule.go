@@ -0,0 +1,105 @@
+package amazbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schedule are the daily windows when a domain is scraped
+type schedule struct {
+	spec    string
+	loc     *time.Location
+	windows [][2]int
+}
+
+// parseSchedule parses windows as HH:MM-HH:MM separated by commas with an
+// optional @timezone, e.g. 00:00-08:00,22:00-24:00@Asia/Tokyo, windows
+// ending before they start span midnight
+func parseSchedule(spec string) (schedule, error) {
+	s := schedule{spec: spec, loc: time.Local}
+	split := strings.SplitN(spec, "@", 2)
+	if len(split) > 1 {
+		loc, err := time.LoadLocation(strings.TrimSpace(split[1]))
+		if err != nil {
+			return schedule{}, fmt.Errorf("invalid schedule %s: %w", spec, err)
+		}
+		s.loc = loc
+	}
+	for _, w := range strings.Split(split[0], ",") {
+		times := strings.SplitN(strings.TrimSpace(w), "-", 2)
+		if len(times) != 2 {
+			return schedule{}, fmt.Errorf("invalid schedule %s: expected HH:MM-HH:MM", spec)
+		}
+		var window [2]int
+		for i, t := range times {
+			var h, m int
+			if _, err := fmt.Sscanf(t, "%d:%d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+				return schedule{}, fmt.Errorf("invalid schedule %s: invalid time %s", spec, t)
+			}
+			window[i] = h*60 + m
+		}
+		s.windows = append(s.windows, window)
+	}
+	return s, nil
+}
+
+// active returns true if the time is inside any of the windows
+func (s schedule) active(t time.Time) bool {
+	t = t.In(s.loc)
+	now := t.Hour()*60 + t.Minute()
+	for _, w := range s.windows {
+		from, to := w[0], w[1]
+		if from <= to && now >= from && now < to {
+			return true
+		}
+		if from > to && (now >= from || now < to) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSchedules parses the schedules per domain, * applies to all domains
+func parseSchedules(specs map[string]string) (map[string]schedule, error) {
+	schedules := make(map[string]schedule)
+	for domain, spec := range specs {
+		s, err := parseSchedule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("domain %s: %w", domain, err)
+		}
+		schedules[domain] = s
+	}
+	return schedules, nil
+}
+
+// scheduled returns true if the domain can be scraped at the time
+func (b *bot) scheduled(domain string, t time.Time) bool {
+	b.loopLock.Lock()
+	defer b.loopLock.Unlock()
+	for _, k := range []string{pauseAll, domain} {
+		if s, ok := b.schedules[k]; ok && !s.active(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// schedulesText returns the list of schedules
+func (b *bot) schedulesText() string {
+	b.loopLock.Lock()
+	defer b.loopLock.Unlock()
+	var lines []string
+	for domain, s := range b.schedules {
+		if domain == pauseAll {
+			domain = "all"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", domain, s.spec))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("scheduled:\n%s", strings.Join(lines, "\n"))
+}
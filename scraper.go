@@ -0,0 +1,297 @@
+package amazbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/fx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// scrapeFunc scrapes an item calling back for each alert, it is the api
+// client search or a dispatch to remote workers
+type scrapeFunc func(ctx context.Context, query string, item *api.Item, callback func(api.Item, api.Alert) error) error
+
+// scrapeJob is sent to a worker to scrape an item
+type scrapeJob struct {
+	ID     string        `json:"id"`
+	Query  string        `json:"query"`
+	Item   api.Item      `json:"item"`
+	Budget time.Duration `json:"budget,omitempty"`
+}
+
+type scrapeAlert struct {
+	Item  api.Item  `json:"item"`
+	Alert api.Alert `json:"alert"`
+}
+
+// scrapeResult is returned by a worker after scraping an item
+type scrapeResult struct {
+	ID       string        `json:"id"`
+	Worker   string        `json:"worker"`
+	Item     api.Item      `json:"item"`
+	Alerts   []scrapeAlert `json:"alerts,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Exceeded bool          `json:"exceeded,omitempty"`
+}
+
+// dispatcher hands scrape jobs to the workers polling the coordinator
+type dispatcher struct {
+	jobs    chan scrapeJob
+	lock    sync.Mutex
+	next    int
+	pending map[string]chan scrapeResult
+}
+
+// defaultJobTimeout bounds jobs without deadline so a lost worker doesn't
+// block the search loop
+const defaultJobTimeout = 10 * time.Minute
+
+// pollTimeout is how long a worker poll waits for a job
+const pollTimeout = 30 * time.Second
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{
+		jobs:    make(chan scrapeJob),
+		pending: make(map[string]chan scrapeResult),
+	}
+}
+
+// scrape sends the search to a worker and waits for its result
+func (d *dispatcher) scrape(ctx context.Context, query string, item *api.Item, callback func(api.Item, api.Alert) error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultJobTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+	res := make(chan scrapeResult, 1)
+	d.lock.Lock()
+	d.next++
+	id := strconv.Itoa(d.next)
+	d.pending[id] = res
+	d.lock.Unlock()
+	defer func() {
+		d.lock.Lock()
+		delete(d.pending, id)
+		d.lock.Unlock()
+	}()
+
+	wait := func() error {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("worker: search %s: %w", query, ctx.Err())
+		}
+		return nil
+	}
+	select {
+	case d.jobs <- scrapeJob{ID: id, Query: query, Item: *item, Budget: time.Until(deadline)}:
+	case <-ctx.Done():
+		return wait()
+	}
+	var r scrapeResult
+	select {
+	case r = <-res:
+	case <-ctx.Done():
+		return wait()
+	}
+	for _, a := range r.Alerts {
+		if err := callback(a.Item, a.Alert); err != nil {
+			return err
+		}
+	}
+	*item = r.Item
+	switch {
+	case r.Exceeded:
+		return fmt.Errorf("worker %s: %s: %w", r.Worker, r.Error, context.DeadlineExceeded)
+	case r.Error != "":
+		return fmt.Errorf("worker %s: %s", r.Worker, r.Error)
+	}
+	return nil
+}
+
+func (s *grpcServer) NextJob(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.BytesValue, error) {
+	d := s.bot.dispatcher
+	if d == nil {
+		return nil, status.Error(codes.FailedPrecondition, "remote scraping disabled")
+	}
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-time.After(pollTimeout):
+		return &wrapperspb.BytesValue{}, nil
+	case j := <-d.jobs:
+		data, err := json.Marshal(j)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return wrapperspb.Bytes(data), nil
+	}
+}
+
+func (s *grpcServer) CompleteJob(_ context.Context, in *wrapperspb.BytesValue) (*emptypb.Empty, error) {
+	d := s.bot.dispatcher
+	if d == nil {
+		return nil, status.Error(codes.FailedPrecondition, "remote scraping disabled")
+	}
+	var r scrapeResult
+	if err := json.Unmarshal(in.GetValue(), &r); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	d.lock.Lock()
+	res, ok := d.pending[r.ID]
+	d.lock.Unlock()
+	if !ok {
+		return nil, status.Error(codes.NotFound, r.ID)
+	}
+	select {
+	case res <- r:
+	default:
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// WorkerConfig is the configuration of a scraper worker
+type WorkerConfig struct {
+	// Name identifies the worker in the coordinator logs
+	Name string
+	// Coordinator is the grpc address of the bot
+	Coordinator string
+	Token       string
+	// TLS enables transport security, CA is an optional certificate file
+	// to verify the coordinator
+	TLS        bool
+	CA         string
+	CaptchaURL string
+	Proxy      string
+	Parallel   int
+	VAT        map[string]float64
+	WarmUp     time.Duration
+	Languages  map[string]string
+	Locations  map[string]string
+	DumpDir    string
+	DumpSize   int
+	DumpKeep   int
+}
+
+// RunWorker scrapes the jobs of the coordinator until the context is done,
+// workers are stateless and can be scaled across machines with different IPs
+func RunWorker(ctx context.Context, cfg *WorkerConfig) error {
+	client, err := api.New(ctx, cfg.CaptchaURL, cfg.Proxy, cfg.VAT)
+	if err != nil {
+		return fmt.Errorf("couldn't create api client: %w", err)
+	}
+	client.Parallel(cfg.Parallel)
+	client.WarmUp(cfg.WarmUp)
+	client.Languages(cfg.Languages)
+	client.Locations(cfg.Locations)
+	client.Converter(fx.New().Convert)
+	if err := client.Dump(cfg.DumpDir, int64(cfg.DumpSize)<<20, cfg.DumpKeep); err != nil {
+		log.Println(err)
+	}
+
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if cfg.TLS {
+		creds := credentials.NewTLS(nil)
+		if cfg.CA != "" {
+			if creds, err = credentials.NewClientTLSFromFile(cfg.CA, ""); err != nil {
+				return fmt.Errorf("couldn't load grpc tls credentials: %w", err)
+			}
+		}
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	}
+	if cfg.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: cfg.Token, secure: cfg.TLS}))
+	}
+	conn, err := grpc.DialContext(ctx, cfg.Coordinator, opts...)
+	if err != nil {
+		return fmt.Errorf("couldn't dial coordinator %s: %w", cfg.Coordinator, err)
+	}
+	defer conn.Close()
+
+	log.Printf("worker %s started, coordinator %s\n", cfg.Name, cfg.Coordinator)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		in := &wrapperspb.BytesValue{}
+		if err := conn.Invoke(ctx, "/amazbot.Amazbot/NextJob", &emptypb.Empty{}, in); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if status.Code(err) == codes.FailedPrecondition || status.Code(err) == codes.Unauthenticated {
+				return fmt.Errorf("worker: %w", err)
+			}
+			log.Println(fmt.Errorf("worker: couldn't get job: %w", err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		if len(in.GetValue()) == 0 {
+			continue
+		}
+		var j scrapeJob
+		if err := json.Unmarshal(in.GetValue(), &j); err != nil {
+			log.Println(fmt.Errorf("worker: couldn't decode job: %w", err))
+			continue
+		}
+		r := work(ctx, client, j)
+		r.Worker = cfg.Name
+		data, err := json.Marshal(r)
+		if err != nil {
+			log.Println(fmt.Errorf("worker: couldn't encode result: %w", err))
+			continue
+		}
+		if err := conn.Invoke(ctx, "/amazbot.Amazbot/CompleteJob", wrapperspb.Bytes(data), &emptypb.Empty{}); err != nil {
+			log.Println(fmt.Errorf("worker: couldn't complete job %s: %w", j.ID, err))
+		}
+	}
+}
+
+// work scrapes the job item collecting its alerts
+func work(ctx context.Context, client *api.Client, j scrapeJob) scrapeResult {
+	if j.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Budget)
+		defer cancel()
+	}
+	r := scrapeResult{ID: j.ID, Item: j.Item}
+	err := client.SearchContext(ctx, j.Query, &r.Item, func(i api.Item, a api.Alert) error {
+		r.Alerts = append(r.Alerts, scrapeAlert{Item: i, Alert: a})
+		return nil
+	})
+	if err != nil {
+		r.Error = err.Error()
+		r.Exceeded = errors.Is(err, context.DeadlineExceeded)
+	}
+	return r
+}
+
+// tokenCredentials sends the grpc token as a bearer authorization header
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": fmt.Sprintf("Bearer %s", t.token)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
@@ -0,0 +1,276 @@
+package amazbot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// defaultEmojis are the emojis used by the alert templates
+var defaultEmojis = map[string]string{
+	"drop":      "⚡️",
+	"used":      "♻️",
+	"tradein":   "🔄",
+	"margin":    "💰",
+	"tracking":  "👀",
+	"appeared":  "🆕",
+	"expired":   "⌛",
+	"origin":    "📡",
+	"price":     "✅",
+	"previous":  "🚫",
+	"current":   "💶",
+	"reference": "🎯",
+	"spread":    "📈",
+	"state":     "🎁",
+	"points":    "🎌",
+	"vat":       "🧾",
+	"promo":     "📣",
+	"shop":      "🛒",
+	"best":      "✅",
+	"worse":     "❌",
+	"link":      "🔗",
+	"page":      "📄",
+	"note":      "📝",
+	"fake":      "⚠️",
+}
+
+// chatSettings customize the branding of the alerts of a chat, the footer
+// replaces the promo line added to @channels
+type chatSettings struct {
+	Emojis  map[string]string `json:"emojis,omitempty"`
+	Header  string            `json:"header,omitempty"`
+	Footer  string            `json:"footer,omitempty"`
+	NoPromo bool              `json:"no_promo,omitempty"`
+	// Permalink adds the link of the deal page to the alerts
+	Permalink bool `json:"permalink,omitempty"`
+	// FakeDiscounts annotates or suppresses the drops after an inflated price
+	FakeDiscounts string `json:"fake_discounts,omitempty"`
+	// Cooldowns are the minimum time between price alerts of a state
+	Cooldowns map[int]time.Duration `json:"cooldowns,omitempty"`
+	// Expired notifies or edits the alerts whose offer is gone
+	Expired string `json:"expired,omitempty"`
+	// NoLeaderboard disables the weekly leaderboard of the chat
+	NoLeaderboard bool `json:"no_leaderboard,omitempty"`
+	// BoughtButton adds a button to the alerts to register the purchase
+	BoughtButton bool `json:"bought_button,omitempty"`
+}
+
+func settingsKey(chat string) string {
+	return fmt.Sprintf("%s/settings", chat)
+}
+
+func (b *bot) settings(chat string) chatSettings {
+	var s chatSettings
+	if err := b.db.Get("config", settingsKey(chat), &s); err != nil {
+		b.log(err)
+	}
+	return s
+}
+
+// Emoji returns the emoji of the chat settings or the default one
+func (d alertData) Emoji(name string) string {
+	if e, ok := d.Settings.Emojis[name]; ok {
+		return e
+	}
+	return defaultEmojis[name]
+}
+
+func (s chatSettings) String() string {
+	var lines []string
+	var names []string
+	for n := range s.Emojis {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		lines = append(lines, fmt.Sprintf("emoji %s: %s", n, s.Emojis[n]))
+	}
+	if s.Header != "" {
+		lines = append(lines, fmt.Sprintf("header: %s", s.Header))
+	}
+	switch {
+	case s.Footer != "":
+		lines = append(lines, fmt.Sprintf("footer: %s", s.Footer))
+	case s.NoPromo:
+		lines = append(lines, "footer: off")
+	}
+	if s.Permalink {
+		lines = append(lines, "permalink: on")
+	}
+	if s.FakeDiscounts != "" {
+		lines = append(lines, fmt.Sprintf("fakes: %s", s.FakeDiscounts))
+	}
+	if s.Expired != "" {
+		lines = append(lines, fmt.Sprintf("expired: %s", s.Expired))
+	}
+	if s.NoLeaderboard {
+		lines = append(lines, "leaderboard: off")
+	}
+	if s.BoughtButton {
+		lines = append(lines, "bought button: on")
+	}
+	for state := 0; state < 5; state++ {
+		if c, ok := s.Cooldowns[state]; ok {
+			lines = append(lines, fmt.Sprintf("cooldown %s: %s", api.StateText("en", state), c))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+const settingsUsage = "usage: /settings [emoji <name> <emoji>|header <text>|footer <text>|header off|footer off|permalink on|off|fakes annotate|suppress|off|cooldown <new|used|0-4> <duration|off>|expired notify|edit|off|leaderboard on|off|bought on|off|reset]"
+
+// settingsCommand handles /settings [emoji <name> <emoji>|header <text>|footer <text>|reset]
+func (b *bot) settingsCommand(user int, chat, args string) {
+	s := b.settings(chat)
+	split := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	value := ""
+	if len(split) > 1 {
+		value = strings.TrimSpace(split[1])
+	}
+	switch {
+	case split[0] == "":
+		if text := s.String(); text != "" {
+			b.message(user, fmt.Sprintf("settings for %s:\n%s", chat, text))
+		} else {
+			b.message(user, fmt.Sprintf("default settings for %s", chat))
+		}
+		return
+	case split[0] == "reset":
+		s = chatSettings{}
+	case value == "":
+		b.message(user, settingsUsage)
+		return
+	case split[0] == "emoji":
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			b.message(user, "usage: /settings emoji <name> <emoji|off>")
+			return
+		}
+		name := strings.ToLower(fields[0])
+		if _, ok := defaultEmojis[name]; !ok {
+			var names []string
+			for n := range defaultEmojis {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			b.message(user, fmt.Sprintf("unknown emoji %s, available: %s", name, strings.Join(names, ", ")))
+			return
+		}
+		if s.Emojis == nil {
+			s.Emojis = make(map[string]string)
+		}
+		if fields[1] == "off" {
+			delete(s.Emojis, name)
+		} else {
+			s.Emojis[name] = fields[1]
+		}
+	case split[0] == "header":
+		if value == "off" {
+			value = ""
+		}
+		s.Header = value
+	case split[0] == "permalink":
+		if value != "on" && value != "off" {
+			b.message(user, "usage: /settings permalink on|off")
+			return
+		}
+		if value == "on" && b.baseURL == "" {
+			b.message(user, "permalinks require the base url of the http server")
+			return
+		}
+		s.Permalink = value == "on"
+	case split[0] == "fakes":
+		switch value {
+		case fakeAnnotate, fakeSuppress:
+		case "off":
+			value = ""
+		default:
+			b.message(user, "usage: /settings fakes annotate|suppress|off")
+			return
+		}
+		s.FakeDiscounts = value
+	case split[0] == "leaderboard":
+		if value != "on" && value != "off" {
+			b.message(user, "usage: /settings leaderboard on|off")
+			return
+		}
+		s.NoLeaderboard = value == "off"
+	case split[0] == "bought":
+		if value != "on" && value != "off" {
+			b.message(user, "usage: /settings bought on|off")
+			return
+		}
+		s.BoughtButton = value == "on"
+	case split[0] == "expired":
+		switch value {
+		case expiredNotify, expiredEdit:
+		case "off":
+			value = ""
+		default:
+			b.message(user, "usage: /settings expired notify|edit|off")
+			return
+		}
+		s.Expired = value
+	case split[0] == "cooldown":
+		fields := strings.Fields(value)
+		usage := "usage: /settings cooldown <new|used|0-4> <duration|off>, e.g. /settings cooldown used 48h"
+		if len(fields) != 2 {
+			b.message(user, usage)
+			return
+		}
+		var states []int
+		switch fields[0] {
+		case "new":
+			states = []int{0}
+		case "used":
+			states = []int{1, 2, 3, 4}
+		default:
+			state, err := strconv.Atoi(fields[0])
+			if err != nil || state < 0 || state > 4 {
+				b.message(user, usage)
+				return
+			}
+			states = []int{state}
+		}
+		var cooldown time.Duration
+		if fields[1] != "off" {
+			var err error
+			if cooldown, err = time.ParseDuration(fields[1]); err != nil || cooldown <= 0 {
+				b.message(user, usage)
+				return
+			}
+		}
+		if s.Cooldowns == nil {
+			s.Cooldowns = make(map[int]time.Duration)
+		}
+		for _, state := range states {
+			if cooldown == 0 {
+				delete(s.Cooldowns, state)
+			} else {
+				s.Cooldowns[state] = cooldown
+			}
+		}
+	case split[0] == "footer":
+		s.NoPromo = value == "off"
+		if s.NoPromo {
+			value = ""
+		}
+		s.Footer = value
+	default:
+		b.message(user, settingsUsage)
+		return
+	}
+	if err := b.db.Put("config", settingsKey(chat), s); err != nil {
+		b.log(err)
+		return
+	}
+	if text := s.String(); text != "" {
+		b.message(user, fmt.Sprintf("settings updated for %s:\n%s", chat, text))
+	} else {
+		b.message(user, fmt.Sprintf("default settings for %s", chat))
+	}
+}
@@ -0,0 +1,118 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// sharePrefix is the prefix of the start payload of shared searchs
+const sharePrefix = "share_"
+
+// shareTTL is how long a share link can be opened
+const shareTTL = 7 * 24 * time.Hour
+
+// sharedSearchs are the queries of a share link
+type sharedSearchs struct {
+	User    int       `json:"user"`
+	Queries []string  `json:"queries"`
+	Created time.Time `json:"created"`
+}
+
+// shareCommand handles /share <asin[.domain][?options]...|*>, it creates a
+// deep link that adds the searchs to the chat of the user that opens it
+func (b *bot) shareCommand(_ context.Context, r request) {
+	var queries []string
+	if strings.TrimSpace(r.args) == "*" {
+		prefix := fmt.Sprintf("%s/", r.chat)
+		b.searchs.Range(func(k, _ interface{}) bool {
+			if id := k.(string); strings.HasPrefix(id, prefix) {
+				queries = append(queries, strings.TrimPrefix(id, prefix))
+			}
+			return true
+		})
+		sort.Strings(queries)
+	} else {
+		for _, f := range strings.Fields(r.args) {
+			parsed, err := parseArgs(f, r.chat)
+			if err != nil {
+				b.message(r.user, err.Error())
+				return
+			}
+			query, err := api.NormalizeID(b.defaults(r.user).apply(parsed.query))
+			if err != nil {
+				b.message(r.user, err.Error())
+				return
+			}
+			queries = append(queries, query)
+		}
+	}
+	if len(queries) == 0 {
+		b.message(r.user, "no searchs to share")
+		return
+	}
+	code, err := newCode()
+	if err != nil {
+		b.log(fmt.Errorf("couldn't generate share code: %w", err))
+		return
+	}
+	s := sharedSearchs{User: r.user, Queries: queries, Created: time.Now().UTC()}
+	if err := b.db.Put("shares", code, s); err != nil {
+		b.log(err)
+		return
+	}
+	b.message(r.user, fmt.Sprintf("🔗 share %d searchs with this link, it expires in %d days:\nhttps://t.me/%s?start=%s%s", len(queries), int(shareTTL.Hours()/24), b.tg.Self().UserName, sharePrefix, code))
+}
+
+// startShared adds the searchs of a share link to the chat of the user
+func (b *bot) startShared(r request, code string) {
+	var s sharedSearchs
+	if err := b.db.Get("shares", code, &s); err != nil {
+		b.log(err)
+		return
+	}
+	if len(s.Queries) == 0 || time.Since(s.Created) > shareTTL {
+		b.message(r.user, "share link not found or expired")
+		return
+	}
+	lines := []string{"📥 shared searchs:"}
+	for _, q := range s.Queries {
+		parsed, err := parseArgs(fmt.Sprintf("%s/%s", r.chat, q), r.chat)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%s: %s", q, err))
+			continue
+		}
+		if err := b.add(parsed); err != nil {
+			lines = append(lines, fmt.Sprintf("%s: %s", parsed.id, err))
+			continue
+		}
+		lines = append(lines, parsed.id)
+	}
+	b.message(r.user, strings.Join(lines, "\n"))
+}
+
+// purgeShares deletes the share links that expired
+func (b *bot) purgeShares(now time.Time) {
+	codes, err := b.db.Keys("shares")
+	if err != nil {
+		b.log(err)
+		return
+	}
+	for _, code := range codes {
+		var s sharedSearchs
+		if err := b.db.Get("shares", code, &s); err != nil {
+			b.log(err)
+			continue
+		}
+		if now.Sub(s.Created) <= shareTTL {
+			continue
+		}
+		if err := b.db.Delete("shares", code); err != nil {
+			b.log(err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// status sort orders
+var statusSorts = []string{"price", "discount", "change", "domain"}
+
+// statusOptions are the arguments of /status [*] [by <sort>] [text]
+type statusOptions struct {
+	all    bool
+	sort   string
+	search string
+}
+
+func parseStatusOptions(args string) (statusOptions, error) {
+	var opts statusOptions
+	fields := strings.Fields(args)
+	if len(fields) > 0 && fields[0] == "*" {
+		opts.all = true
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.ToLower(fields[0]) == "by" {
+		if len(fields) < 2 {
+			return statusOptions{}, fmt.Errorf("usage: /status [*] [by %s] [text]", strings.Join(statusSorts, "|"))
+		}
+		opts.sort = strings.ToLower(fields[1])
+		valid := false
+		for _, s := range statusSorts {
+			valid = valid || s == opts.sort
+		}
+		if !valid {
+			return statusOptions{}, fmt.Errorf("invalid sort %s, use %s", fields[1], strings.Join(statusSorts, ", "))
+		}
+		fields = fields[2:]
+	}
+	opts.search = strings.ToLower(strings.Join(fields, " "))
+	return opts, nil
+}
+
+// statusEntry is a search listed by /status, key is the id without the
+// chat unless all the chats are listed
+type statusEntry struct {
+	id    string
+	key   string
+	value interface{}
+	note  string
+	// changed is the time of the last price change
+	changed time.Time
+}
+
+func (e statusEntry) item() (api.Item, bool) {
+	i, ok := e.value.(api.Item)
+	return i, ok
+}
+
+// price is the lowest current price of any condition, infinite if unknown
+func (e statusEntry) price() float64 {
+	p := math.Inf(1)
+	if i, ok := e.item(); ok {
+		for _, v := range i.Prices {
+			if v > 0 && v < p {
+				p = v
+			}
+		}
+	}
+	return p
+}
+
+// discount is the ratio of the lowest price to the minimum, lower is better
+func (e statusEntry) discount() float64 {
+	i, ok := e.item()
+	if !ok || i.MinPrice <= 0 {
+		return math.Inf(1)
+	}
+	return e.price() / i.MinPrice
+}
+
+func (e statusEntry) domain() string {
+	if i, ok := e.item(); ok && i.Domain != "" {
+		return i.Domain
+	}
+	query := e.id[strings.LastIndex(e.id, "/")+1:]
+	query = strings.SplitN(query, "?", 2)[0]
+	if split := strings.SplitN(query, ".", 2); len(split) > 1 {
+		return split[1]
+	}
+	return ""
+}
+
+// matches returns true if the text is found in the key, title or note
+func (e statusEntry) matches(text string) bool {
+	if text == "" {
+		return true
+	}
+	var title string
+	if i, ok := e.item(); ok {
+		title = i.Title
+	}
+	for _, s := range []string{e.key, title, e.note} {
+		if strings.Contains(strings.ToLower(s), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusEntries returns the searchs of the chat, or all of them, that match
+// the search text sorted by key or the chosen order
+func (b *bot) statusEntries(user int, chat string, opts statusOptions) []statusEntry {
+	var entries []statusEntry
+	prefix := fmt.Sprintf("%s/", chat)
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		e := statusEntry{id: k.(string), key: k.(string), value: v}
+		if opts.all && !b.ownsSearch(user, e.id) {
+			return true
+		}
+		if !opts.all {
+			if !strings.HasPrefix(e.key, prefix) {
+				return true
+			}
+			e.key = strings.TrimPrefix(e.key, prefix)
+		}
+		e.note = b.note(e.id)
+		if !e.matches(opts.search) {
+			return true
+		}
+		if i, ok := e.item(); ok && opts.sort == "change" {
+			if h := b.history(i.ID, i.Domain); len(h) > 0 {
+				e.changed = h[len(h)-1].Time
+			}
+		}
+		entries = append(entries, e)
+		return true
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch opts.sort {
+		case "price":
+			return a.price() < b.price()
+		case "discount":
+			return a.discount() < b.discount()
+		case "change":
+			return a.changed.After(b.changed)
+		case "domain":
+			return a.domain() < b.domain()
+		}
+		return false
+	})
+	return entries
+}
+
+// query returns the query of the search and the chat it is sent to
+func (e statusEntry) query() (string, string) {
+	split := strings.Split(e.id, "/")
+	query := split[len(split)-1]
+	return query, strings.TrimSuffix(e.id, "/"+query)
+}
+
+// maxGroupButtons limits the stop buttons of an item, telegram rows take up
+// to 8 buttons
+const maxGroupButtons = 7
+
+// statusGroup are the searchs of the same item across chats
+type statusGroup struct {
+	item    string
+	entries []statusEntry
+}
+
+// groupEntries groups the entries by item keeping their order
+func groupEntries(entries []statusEntry) []*statusGroup {
+	var groups []*statusGroup
+	index := make(map[string]*statusGroup)
+	for _, e := range entries {
+		query, _ := e.query()
+		item := strings.SplitN(query, "?", 2)[0]
+		g, ok := index[item]
+		if !ok {
+			g = &statusGroup{item: item}
+			index[item] = g
+			groups = append(groups, g)
+		}
+		g.entries = append(g.entries, e)
+	}
+	return groups
+}
+
+// statusGroups sends a message per item with the chats subscribed to it,
+// chats with several searchs of the item and the failed scrapes
+func (b *bot) statusGroups(ctx context.Context, user int, entries []statusEntry) {
+	for _, g := range groupEntries(entries) {
+		var title, link string
+		var min, price float64
+		var chats []string
+		var btns []tgbot.InlineKeyboardButton
+		counts := make(map[string]int)
+		errs, failed := 0, 0
+		for _, e := range g.entries {
+			query, chat := e.query()
+			if i, ok := e.item(); ok && title == "" {
+				title, link, min, price = i.Title, i.Link, i.MinPrice, e.price()
+			}
+			if opts := strings.TrimPrefix(query, g.item); opts != "" {
+				chat = fmt.Sprintf("%s (%s)", chat, opts)
+			}
+			chats = append(chats, chat)
+			for _, c := range destinations(strings.TrimSuffix(e.id, "/"+query)) {
+				counts[c.chat]++
+			}
+			b.loopLock.Lock()
+			s := b.scrapes[e.id]
+			b.loopLock.Unlock()
+			errs += s.errors
+			if s.failed {
+				failed++
+			}
+			btns = append(btns, tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("stop %s", chat), fmt.Sprintf("/stop %s", e.id)))
+		}
+		if link == "" {
+			link = api.Link(g.item)
+		}
+		if len(btns) > maxGroupButtons {
+			btns = btns[:maxGroupButtons]
+		}
+		lines := []string{strings.TrimSpace(fmt.Sprintf("%s %s", g.item, title)), fmt.Sprintf("chats: %s", strings.Join(chats, ", "))}
+		var dups []string
+		for c, n := range counts {
+			if n > 1 {
+				dups = append(dups, c)
+			}
+		}
+		if len(dups) > 0 {
+			sort.Strings(dups)
+			lines = append(lines, fmt.Sprintf("⚠️ duplicated in %s", strings.Join(dups, ", ")))
+		}
+		if errs > 0 {
+			lines = append(lines, fmt.Sprintf("❌ errors: %d, failing: %d/%d", errs, failed, len(g.entries)))
+		}
+		if !math.IsInf(price, 1) && title != "" {
+			lines = append(lines, fmt.Sprintf("min:%.2f€, price:%.2f€", min, price))
+		}
+		btns = append([]tgbot.InlineKeyboardButton{tgbot.NewInlineKeyboardButtonURL("link", link)}, btns...)
+		b.messageOpts(ctx, user, strings.Join(lines, "\n"), false, btns)
+	}
+}
@@ -0,0 +1,185 @@
+package amazbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// streamBuffer is the number of events queued per stream connection
+	streamBuffer = 100
+	// streamPing is how often a comment is sent to keep streams alive
+	streamPing = 30 * time.Second
+)
+
+// watch returns a channel receiving the new events until it is cancelled
+func (l *eventLog) watch() (<-chan Event, func()) {
+	c := make(chan Event, streamBuffer)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.watchers == nil {
+		l.watchers = make(map[chan Event]struct{})
+	}
+	l.watchers[c] = struct{}{}
+	return c, func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		delete(l.watchers, c)
+	}
+}
+
+// streamFilter are the filters of a stream connection, events without
+// discount are skipped if a minimum discount is set
+type streamFilter struct {
+	types    []string
+	chat     string
+	domain   string
+	discount float64
+}
+
+func parseStreamFilter(r *http.Request) (streamFilter, error) {
+	q := r.URL.Query()
+	f := streamFilter{chat: q.Get("chat"), domain: q.Get("domain")}
+	if t := q.Get("type"); t != "" {
+		f.types = strings.Split(t, ",")
+	}
+	if d := q.Get("discount"); d != "" {
+		n, err := strconv.ParseFloat(d, 64)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid discount %q", d)
+		}
+		f.discount = n
+	}
+	return f, nil
+}
+
+func (f streamFilter) match(e Event) bool {
+	if len(f.types) > 0 {
+		found := false
+		for _, t := range f.types {
+			found = found || t == e.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.chat != "" && eventChat(e) != f.chat {
+		return false
+	}
+	if f.domain != "" && eventDomain(e) != f.domain {
+		return false
+	}
+	return f.discount <= 0 || eventDiscount(e) >= f.discount
+}
+
+// eventDomain returns the domain of the event or of its search
+func eventDomain(e Event) string {
+	switch {
+	case e.Domain != "":
+		return e.Domain
+	case e.Item != nil:
+		return e.Item.Domain
+	}
+	split := strings.SplitN(e.Search, "/", 2)
+	return searchDomain(split[len(split)-1])
+}
+
+// eventDiscount returns the discount of the alert or the biggest price drop
+// of a price change
+func eventDiscount(e Event) float64 {
+	switch {
+	case e.Alert != nil && e.Item != nil:
+		return dealScore(*e.Item, *e.Alert)
+	case e.Previous != nil && e.Item != nil:
+		var max float64
+		for s, prev := range e.Previous {
+			if price := e.Item.Prices[s]; prev > 0 && price > 0 && (prev-price)/prev*100 > max {
+				max = (prev - price) / prev * 100
+			}
+		}
+		return max
+	}
+	return 0
+}
+
+// streamHandler streams the events visible by the user of the api token as
+// server-sent events, events after the Last-Event-ID are replayed
+func (b *bot) streamHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	user, ok := b.requestUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	filter, err := parseStreamFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// watch before replaying so no event is lost in between
+	events, cancel := b.events.watch()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// events are identified by their sequence, the events of a scrape may
+	// have the same time
+	var last uint64
+	send := func(e Event) error {
+		if e.Seq <= last {
+			return nil
+		}
+		last = e.Seq
+		if !b.visible(user, eventChat(e)) || !filter.match(e) {
+			return nil
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if seq, err := strconv.ParseUint(id, 10, 64); err == nil {
+			last = seq
+			for _, e := range b.events.list() {
+				if err := send(e); err != nil {
+					return
+				}
+			}
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			if err := send(e); err != nil {
+				return
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/amazbot/internal/notify"
+)
+
+// telegram is the part of the telegram bot api used by the bot, it allows
+// faking telegram in tests
+type telegram interface {
+	Self() tgbot.User
+	SendMessage(ctx context.Context, msg tgbot.MessageConfig) (tgbot.Message, error)
+	EditMessage(edit tgbot.EditMessageTextConfig) error
+	AnswerCallback(id string) error
+	ChatAdmins(chat tgbot.ChatConfig) ([]tgbot.ChatMember, error)
+	Chat(chat tgbot.ChatConfig) (tgbot.Chat, error)
+	ChatMember(member tgbot.ChatConfigWithUser) (tgbot.ChatMember, error)
+	GetUpdates(ctx context.Context) (<-chan tgbot.Update, error)
+	FileURL(file string) (string, error)
+}
+
+// telegramAPI implements telegram with the bot api
+type telegramAPI struct {
+	api *tgbot.BotAPI
+}
+
+func newTelegram(token string) (telegram, error) {
+	api, err := tgbot.NewBotAPI(token)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create bot api: %w", err)
+	}
+	return &telegramAPI{api: api}, nil
+}
+
+func (t *telegramAPI) Self() tgbot.User {
+	return t.api.Self
+}
+
+// SendMessage sends the message, the bot api doesn't support contexts so the
+// request keeps running in the background if the context is done first
+func (t *telegramAPI) SendMessage(ctx context.Context, msg tgbot.MessageConfig) (tgbot.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return tgbot.Message{}, err
+	}
+	type result struct {
+		msg tgbot.Message
+		err error
+	}
+	resC := make(chan result, 1)
+	go func() {
+		m, err := t.api.Send(msg)
+		resC <- result{m, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return tgbot.Message{}, ctx.Err()
+	case res := <-resC:
+		return res.msg, res.err
+	}
+}
+
+func (t *telegramAPI) EditMessage(edit tgbot.EditMessageTextConfig) error {
+	_, err := t.api.Send(edit)
+	return err
+}
+
+func (t *telegramAPI) AnswerCallback(id string) error {
+	_, err := t.api.AnswerCallbackQuery(tgbot.NewCallback(id, ""))
+	return err
+}
+
+func (t *telegramAPI) ChatAdmins(chat tgbot.ChatConfig) ([]tgbot.ChatMember, error) {
+	return t.api.GetChatAdministrators(chat)
+}
+
+func (t *telegramAPI) Chat(chat tgbot.ChatConfig) (tgbot.Chat, error) {
+	return t.api.GetChat(chat)
+}
+
+func (t *telegramAPI) ChatMember(member tgbot.ChatConfigWithUser) (tgbot.ChatMember, error) {
+	return t.api.GetChatMember(member)
+}
+
+// GetUpdates polls the updates until the context is done
+func (t *telegramAPI) GetUpdates(ctx context.Context) (<-chan tgbot.Update, error) {
+	u := tgbot.NewUpdate(0)
+	u.Timeout = 60
+	updates, err := t.api.GetUpdatesChan(u)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get update chan: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		t.api.StopReceivingUpdates()
+	}()
+	return updates, nil
+}
+
+func (t *telegramAPI) FileURL(file string) (string, error) {
+	return t.api.GetFileDirectURL(file)
+}
+
+// chatConfig returns the config of a chat id or @username
+func chatConfig(chat string) (tgbot.ChatConfig, error) {
+	if strings.HasPrefix(chat, "@") {
+		return tgbot.ChatConfig{SuperGroupUsername: chat}, nil
+	}
+	id, err := strconv.ParseInt(chat, 10, 64)
+	if err != nil {
+		return tgbot.ChatConfig{}, fmt.Errorf("invalid chat %q", chat)
+	}
+	return tgbot.ChatConfig{ChatID: id}, nil
+}
+
+// checkChat returns an error if the bot can't post in any of the telegram
+// destinations of the chat or they belong to another tenant than the user's
+func (b *bot) checkChat(user int, chat string) error {
+	if err := b.tenantChat(user, chat); err != nil {
+		return err
+	}
+	for _, d := range destinations(chat) {
+		if _, _, ok := notify.Split(d.chat); ok {
+			continue
+		}
+		cfg, err := chatConfig(d.chat)
+		if err != nil {
+			return err
+		}
+		c, err := b.tg.Chat(cfg)
+		if err != nil {
+			return fmt.Errorf("bot can't access %s: %w", d.chat, err)
+		}
+		if c.IsPrivate() {
+			continue
+		}
+		m, err := b.tg.ChatMember(tgbot.ChatConfigWithUser{
+			ChatID:             cfg.ChatID,
+			SuperGroupUsername: cfg.SuperGroupUsername,
+			UserID:             b.tg.Self().ID,
+		})
+		if err != nil {
+			return fmt.Errorf("bot can't access %s: %w", d.chat, err)
+		}
+		switch {
+		case m.HasLeft() || m.WasKicked():
+			return fmt.Errorf("bot isn't a member of %s", d.chat)
+		case c.IsChannel() && !m.IsCreator() && !(m.IsAdministrator() && m.CanPostMessages):
+			return fmt.Errorf("bot can't post in %s, make it an admin with permission to post messages", d.chat)
+		case m.Status == "restricted" && !m.CanSendMessages:
+			return fmt.Errorf("bot can't post in %s", d.chat)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package amazbot
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// alertData is the context shared by all the notification templates
+type alertData struct {
+	Item    api.Item
+	Alert   api.Alert
+	Kind    string
+	Chat    string
+	Coin    string
+	State   string
+	Score   float64
+	Link    string
+	Compare []comparison
+	// Settings customize the alerts of the chat
+	Settings chatSettings
+	// Permalink is the url of the deal page if enabled
+	Permalink string
+	// Note is the note of the search set with /note
+	Note string
+	// FakeDiscount is set if the price was inflated before the drop
+	FakeDiscount bool
+	// Base is the currency of the users of the chat and Rate its exchange
+	// rate from the currency of the domain, zero if they are the same
+	Base string
+	Rate float64
+}
+
+// Convert returns the price in the base currency, empty if there is none
+func (d alertData) Convert(p float64) string {
+	if d.Rate == 0 || p == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" ≈ %.2f%s", p*d.Rate, api.CurrencySymbol(d.Base))
+}
+
+type comparison struct {
+	Name     string
+	Price    float64
+	Currency string
+	Link     string
+	Best     bool
+}
+
+func newAlertData(i api.Item, a api.Alert, chat string) alertData {
+	kind := "drop"
+	switch {
+	case a.Kind == api.TradeInAlert:
+		kind = "tradein"
+	case a.Kind == api.MarginAlert:
+		kind = "margin"
+	case a.Kind == api.UsedAlert:
+		kind = "appeared"
+	case a.State > 0:
+		kind = "used"
+	}
+	return alertData{
+		Item:  i,
+		Alert: a,
+		Kind:  kind,
+		Chat:  chat,
+		Coin:  api.Coin(i.Domain),
+		State: api.StateText("es", a.State),
+		Score: dealScore(i, a),
+		Link:  i.Link,
+	}
+}
+
+// Shared templates, e escapes text and b formats it in bold for each
+// destination type.
+const baseTemplates = `
+{{- define "headline" -}}
+{{- if eq .Kind "tradein"}}{{.Emoji "tradein"}} SUBIDA DE RECOMPRA
+{{- else if eq .Kind "margin"}}{{.Emoji "margin"}} MARGEN
+{{- else if eq .Kind "used"}}{{.Emoji "used"}} REACONDICIONADO
+{{- else if eq .Kind "appeared"}}{{.Emoji "appeared"}} OFERTA USADA DISPONIBLE
+{{- else if eq .Kind "tracking"}}{{.Emoji "tracking"}} SEGUIMIENTO ACTIVADO
+{{- else}}{{.Emoji "drop"}} BAJADA DE PRECIO
+{{- end}}
+{{- end}}
+
+{{- define "prices" -}}
+{{- if eq .Kind "tradein" -}}
+{{.Emoji "price"}} Recompra: {{price .Alert.Price}}{{.Coin}}{{.Convert .Alert.Price}}
+{{.Emoji "previous"}} Anterior: {{price .Alert.Ref}}{{.Coin}}
+{{.Emoji "current"}} Precio: {{price (index .Item.Prices 0)}}{{.Coin}}
+{{- else if eq .Kind "margin" -}}
+{{.Emoji "price"}} Precio: {{price .Alert.Price}}{{.Coin}}{{.Convert .Alert.Price}}
+{{.Emoji "reference"}} Referencia: {{price .Alert.Ref}}{{.Coin}}
+{{.Emoji "spread"}} Margen: {{printf "%.0f" .Alert.Margin}}%
+{{.Emoji "state"}} Estado: {{.State}}
+{{- else if eq .Kind "tracking" -}}
+{{.Emoji "current"}} Precio actual: {{price .Alert.Price}}{{.Coin}}{{.Convert .Alert.Price}}
+{{.Emoji "state"}} Estado: {{.State}}
+{{- else if or (eq .Kind "used") (eq .Kind "appeared") -}}
+{{.Emoji "price"}} Precio: {{price .Alert.Price}}{{.Coin}}{{.Convert .Alert.Price}}
+{{.Emoji "previous"}} Nuevo: {{price .Item.MinPrice}}{{.Coin}}
+{{.Emoji "state"}} Estado: {{.State}}
+{{- else -}}
+{{.Emoji "price"}} Precio: {{price .Alert.Price}}{{.Coin}}{{.Convert .Alert.Price}}
+{{.Emoji "previous"}} Anterior: {{price .Item.MinPrice}}{{.Coin}}
+{{- end}}
+{{- end}}
+
+{{- define "notes" -}}
+{{- if .FakeDiscount}}
+{{.Emoji "fake"}} Descuento dudoso: el precio subió poco antes de bajar
+{{- end}}
+{{- if .Item.PointsNet}}
+{{.Emoji "points"}} Precio neto con {{printf "%.0f" .Item.Points}}% en puntos
+{{- end}}
+{{- if gt .Item.VAT 0.0}}
+{{.Emoji "vat"}} Precio sin IVA ({{printf "%.0f" .Item.VAT}}%)
+{{- end}}
+{{- end}}
+
+{{- define "permalink" -}}
+{{- with .Permalink}}
+{{$.Emoji "page"}} {{e .}}
+{{- end}}
+{{- end}}
+
+{{- define "header" -}}
+{{- with .Settings.Header}}{{e .}}
+
+{{end}}
+{{- end}}
+
+{{- define "promo" -}}
+{{- if .Settings.Footer}}
+
+{{e .Settings.Footer}}
+{{- else if and (hasPrefix .Chat "@") (not .Settings.NoPromo)}}
+
+{{.Emoji "promo"}} Más anuncios en {{e .Chat}}
+{{- end}}
+{{- end}}
+
+{{- define "compare" -}}
+{{- range $c := .Compare}}
+
+{{$.Emoji "shop"}} {{e $c.Name}}: {{price $c.Price}} {{$c.Currency}}
+{{if $c.Best}}{{$.Emoji "best"}} Mejor precio del mercado{{else}}{{$.Emoji "worse"}} Más barato fuera{{end}}
+{{$.Emoji "link"}} {{e $c.Link}}
+{{- end}}
+{{- end}}
+
+{{- define "body" -}}
+{{template "header" .}}{{b (print (tmpl "headline" .))}}
+
+{{e .Item.Title}}{{with .Note}}
+{{$.Emoji "note"}} {{e .}}{{end}}
+
+{{template "prices" .}}
+{{- end}}
+
+{{- define "compact" -}}
+{{price .Alert.Price}}{{.Coin}}{{if gt .Score 0.0}} (-{{printf "%.0f" .Score}}%){{end}} {{if eq .Kind "tradein"}}Recompra{{else if eq .Kind "tracking"}}Seguimiento{{else}}{{.State}}{{end}} · {{e .Item.Title}}
+{{- end}}
+`
+
+// Templates per destination type, each one defines a title and a text.
+var templateSets = map[string]string{
+	"telegram": `
+{{- define "title"}}{{.Item.Title}}{{end}}
+{{- define "text"}}{{template "body" .}}
+
+{{.Emoji "link"}} {{e .Link}}{{template "permalink" .}}{{template "notes" .}}{{template "promo" .}}{{template "compare" .}}{{end}}`,
+	"discord": `
+{{- define "title"}}{{.Item.Title}}{{end}}
+{{- define "text"}}{{template "body" .}}{{template "permalink" .}}{{template "notes" .}}{{template "compare" .}}{{end}}`,
+	"email": `
+{{- define "title"}}{{tmpl "headline" .}}: {{.Item.Title}}{{end}}
+{{- define "text"}}{{template "body" .}}
+
+{{.Emoji "link"}} {{e .Link}}{{template "permalink" .}}{{template "notes" .}}{{template "compare" .}}{{end}}`,
+	"plain": `
+{{- define "title"}}{{.Item.Title}}{{end}}
+{{- define "text"}}{{template "body" .}}
+
+{{.Emoji "link"}} {{e .Link}}{{template "permalink" .}}{{template "notes" .}}{{template "compare" .}}{{end}}`,
+	"compact": `
+{{- define "title"}}{{.Item.Title}}{{end}}
+{{- define "text"}}{{template "compact" .}}
+{{.Link}}{{end}}`,
+}
+
+// templateSet returns the template set used for the notifier scheme
+func templateSet(scheme string) string {
+	switch scheme {
+	case "":
+		return "telegram"
+	case "discord", "email":
+		return scheme
+	case "whatsapp":
+		return "compact"
+	default:
+		return "plain"
+	}
+}
+
+var discordEscaper = strings.NewReplacer("*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`)
+
+// loadTemplates parses the template sets, files named <set>.tmpl in dir
+// override the default templates.
+func loadTemplates(dir string) (map[string]*template.Template, error) {
+	sets := make(map[string]*template.Template)
+	for name, text := range templateSets {
+		var t *template.Template
+		funcs := template.FuncMap{
+			"price":     func(p float64) string { return fmt.Sprintf("%.2f", p) },
+			"hasPrefix": strings.HasPrefix,
+			"e":         func(s string) string { return s },
+			"b":         func(s string) string { return s },
+			"tmpl": func(name string, data interface{}) (string, error) {
+				var buf bytes.Buffer
+				err := t.ExecuteTemplate(&buf, name, data)
+				return buf.String(), err
+			},
+		}
+		switch name {
+		case "telegram":
+			funcs["e"] = html.EscapeString
+			funcs["b"] = func(s string) string { return fmt.Sprintf("<b>%s</b>", html.EscapeString(s)) }
+		case "discord":
+			funcs["e"] = discordEscaper.Replace
+			funcs["b"] = func(s string) string { return fmt.Sprintf("**%s**", s) }
+		}
+		t = template.New(name).Funcs(funcs)
+		if _, err := t.Parse(baseTemplates); err != nil {
+			return nil, fmt.Errorf("couldn't parse base templates: %w", err)
+		}
+		if _, err := t.Parse(text); err != nil {
+			return nil, fmt.Errorf("couldn't parse %s templates: %w", name, err)
+		}
+		if dir != "" {
+			data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%s.tmpl", name)))
+			switch {
+			case os.IsNotExist(err):
+			case err != nil:
+				return nil, fmt.Errorf("couldn't read %s templates: %w", name, err)
+			default:
+				if _, err := t.Parse(string(data)); err != nil {
+					return nil, fmt.Errorf("couldn't parse %s templates file: %w", name, err)
+				}
+			}
+		}
+		sets[name] = t
+	}
+	return sets, nil
+}
+
+// template returns the template set, nil if it doesn't exist
+func (b *bot) template(set string) *template.Template {
+	b.templatesLock.RLock()
+	defer b.templatesLock.RUnlock()
+	return b.templates[set]
+}
+
+// render returns the title and text of the alert for the template set
+func (b *bot) render(set string, d alertData) (string, string, error) {
+	t := b.template(set)
+	if t == nil {
+		return "", "", fmt.Errorf("template set %s not found", set)
+	}
+	return execute(t, d)
+}
+
+func execute(t *template.Template, d alertData) (string, string, error) {
+	var title, text bytes.Buffer
+	if err := t.ExecuteTemplate(&title, "title", d); err != nil {
+		return "", "", fmt.Errorf("couldn't render %s title: %w", t.Name(), err)
+	}
+	if err := t.ExecuteTemplate(&text, "text", d); err != nil {
+		return "", "", fmt.Errorf("couldn't render %s text: %w", t.Name(), err)
+	}
+	return title.String(), text.String(), nil
+}
@@ -0,0 +1,241 @@
+package amazbot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/notify"
+)
+
+// tenant is an independent group of users, they can only post to their own
+// chats and the chats of the tenant and share a quota of searchs
+type tenant struct {
+	name  string
+	users []int
+	chats []string
+	// max is the number of searchs of the tenant, zero means no limit
+	max int
+}
+
+// parseTenant parses space separated key:value fields, e.g.
+// users:1,2 chats:@deals,-100123 max:50
+func parseTenant(name, spec string) (tenant, error) {
+	t := tenant{name: name}
+	for _, f := range strings.Fields(spec) {
+		split := strings.SplitN(f, ":", 2)
+		if len(split) != 2 || split[1] == "" {
+			return tenant{}, fmt.Errorf("invalid tenant field %q, expected key:value", f)
+		}
+		switch split[0] {
+		case "users":
+			for _, u := range strings.Split(split[1], ",") {
+				id, err := strconv.Atoi(u)
+				if err != nil {
+					return tenant{}, fmt.Errorf("invalid tenant user %q", u)
+				}
+				t.users = append(t.users, id)
+			}
+		case "chats":
+			for _, c := range strings.Split(split[1], ",") {
+				t.chats = append(t.chats, strings.ToLower(c))
+			}
+		case "max":
+			n, err := strconv.Atoi(split[1])
+			if err != nil || n < 0 {
+				return tenant{}, fmt.Errorf("invalid tenant max %q", split[1])
+			}
+			t.max = n
+		default:
+			return tenant{}, fmt.Errorf("unknown tenant field %q", split[0])
+		}
+	}
+	if len(t.users) == 0 {
+		return tenant{}, fmt.Errorf("tenant without users")
+	}
+	return t, nil
+}
+
+// parseTenants parses the tenants by name, a user can only be in one
+func parseTenants(specs map[string]string) (map[string]tenant, error) {
+	tenants := make(map[string]tenant)
+	owners := make(map[int]string)
+	for name, spec := range specs {
+		t, err := parseTenant(name, spec)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", name, err)
+		}
+		for _, u := range t.users {
+			if other, ok := owners[u]; ok {
+				return nil, fmt.Errorf("tenant %s: user %d already in tenant %s", name, u, other)
+			}
+			owners[u] = name
+		}
+		tenants[name] = t
+	}
+	return tenants, nil
+}
+
+// setTenants replaces the tenants, the users of the tenants are allowed to
+// control the bot once setUsers is called
+func (b *bot) setTenants(tenants map[string]tenant) {
+	b.usersLock.Lock()
+	defer b.usersLock.Unlock()
+	b.tenants = tenants
+}
+
+// tenantUsers returns the users of all the tenants
+func (b *bot) tenantUsers() []int {
+	b.usersLock.RLock()
+	defer b.usersLock.RUnlock()
+	var users []int
+	for _, t := range b.tenants {
+		users = append(users, t.users...)
+	}
+	return users
+}
+
+// tenantOf returns the tenant of the user, empty for the default one
+func (b *bot) tenantOf(user int) string {
+	b.usersLock.RLock()
+	defer b.usersLock.RUnlock()
+	for name, t := range b.tenants {
+		for _, u := range t.users {
+			if u == user {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// chatTenant returns the tenant of the chat, which is one of its chats or
+// the chat of one of its users, empty for the default one
+func (b *bot) chatTenant(chat string) string {
+	b.usersLock.RLock()
+	defer b.usersLock.RUnlock()
+	for name, t := range b.tenants {
+		for _, c := range t.chats {
+			if c == chat {
+				return name
+			}
+		}
+		for _, u := range t.users {
+			if b.userChats[u] == chat || strconv.Itoa(u) == chat {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// tenantChat checks that the telegram destinations of the chat belong to the
+// tenant of the user, the admin can use any chat
+func (b *bot) tenantChat(user int, chat string) error {
+	if user == b.admin {
+		return nil
+	}
+	own := b.tenantOf(user)
+	for _, d := range destinations(chat) {
+		if _, _, ok := notify.Split(d.chat); ok {
+			continue
+		}
+		if t := b.chatTenant(d.chat); t != own {
+			return fmt.Errorf("%s belongs to another tenant", d.chat)
+		}
+	}
+	return nil
+}
+
+// searchTenant returns the tenant of the first destination of the search
+func (b *bot) searchTenant(chat string) string {
+	dests := destinations(chat)
+	if len(dests) == 0 {
+		return ""
+	}
+	return b.chatTenant(dests[0].chat)
+}
+
+// ownsSearch returns true if the search belongs to the tenant of the user,
+// the admin owns all of them
+func (b *bot) ownsSearch(user int, id string) bool {
+	if user == b.admin {
+		return true
+	}
+	p, err := parseArgs(id, "")
+	return err == nil && b.searchTenant(p.chat) == b.tenantOf(user)
+}
+
+// tenantSearchs returns the number of searchs of the tenant
+func (b *bot) tenantSearchs(name string) int {
+	var n int
+	b.searchs.Range(func(k, _ interface{}) bool {
+		p, err := parseArgs(k.(string), "")
+		if err == nil && b.searchTenant(p.chat) == name {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// lockTenant locks the searchs of the tenant of the chat so the quota is
+// checked and the search added at once, it doesn't lock the default tenant
+func (b *bot) lockTenant(chat string) func() {
+	name := b.searchTenant(chat)
+	if name == "" {
+		return func() {}
+	}
+	return b.locks.Lock(fmt.Sprintf("tenant/%s", name))
+}
+
+// checkQuota returns an error if the tenant of the chat has no searchs left,
+// it must be called with the lock of the tenant
+func (b *bot) checkQuota(chat string) error {
+	name := b.searchTenant(chat)
+	if name == "" {
+		return nil
+	}
+	b.usersLock.RLock()
+	max := b.tenants[name].max
+	b.usersLock.RUnlock()
+	if max > 0 && b.tenantSearchs(name) >= max {
+		return fmt.Errorf("the quota of %d searchs of tenant %s is reached", max, name)
+	}
+	return nil
+}
+
+// tenantCommand handles /tenant, it shows the usage of the tenant of the
+// user or of all of them to the admin
+func (b *bot) tenantCommand(user int) {
+	names := []string{b.tenantOf(user)}
+	if user == b.admin {
+		names = nil
+		b.usersLock.RLock()
+		for name := range b.tenants {
+			names = append(names, name)
+		}
+		b.usersLock.RUnlock()
+		sort.Strings(names)
+	}
+	if len(names) == 0 || names[0] == "" {
+		b.message(user, "no tenants")
+		return
+	}
+	var lines []string
+	for _, name := range names {
+		b.usersLock.RLock()
+		t := b.tenants[name]
+		b.usersLock.RUnlock()
+		line := fmt.Sprintf("%s: %d users, %d searchs", name, len(t.users), b.tenantSearchs(name))
+		if t.max > 0 {
+			line = fmt.Sprintf("%s of %d", line, t.max)
+		}
+		if len(t.chats) > 0 {
+			line = fmt.Sprintf("%s, chats %s", line, strings.Join(t.chats, " "))
+		}
+		lines = append(lines, line)
+	}
+	b.message(user, strings.Join(lines, "\n"))
+}
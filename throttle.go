@@ -0,0 +1,139 @@
+package amazbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/redis"
+)
+
+// throttle limits the posts per hour of each chat, queued deals are posted
+// highest score first and dropped once they are older than the window or
+// don't fit in the queue. With a shared redis the budget is counted across
+// instances in fixed windows.
+type throttle struct {
+	lock   sync.Mutex
+	budget int
+	window time.Duration
+	sent   map[string][]time.Time
+	queues map[string][]queued
+	shared *redis.Client
+	log    func(interface{})
+}
+
+type queued struct {
+	score float64
+	at    time.Time
+	send  func()
+}
+
+func newThrottle(budget int, shared *redis.Client, log func(interface{})) *throttle {
+	return &throttle{
+		budget: budget,
+		window: time.Hour,
+		sent:   make(map[string][]time.Time),
+		queues: make(map[string][]queued),
+		shared: shared,
+		log:    log,
+	}
+}
+
+// push queues a post for the chat, it is sent right away if there is no
+// budget configured
+func (t *throttle) push(chat string, score float64, send func()) {
+	if t.budget <= 0 {
+		send()
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	q := append(t.queues[chat], queued{score: score, at: time.Now(), send: send})
+	sort.SliceStable(q, func(i, j int) bool { return q[i].score > q[j].score })
+	if len(q) > t.budget {
+		t.log(fmt.Sprintf("throttle: dropped %d deals for %s", len(q)-t.budget, chat))
+		q = q[:t.budget]
+	}
+	t.queues[chat] = q
+}
+
+// flush sends the queued posts that fit in the budget of each chat
+func (t *throttle) flush(now time.Time) {
+	var sends []func()
+	t.lock.Lock()
+	for chat, q := range t.queues {
+		var sent []time.Time
+		for _, s := range t.sent[chat] {
+			if now.Sub(s) < t.window {
+				sent = append(sent, s)
+			}
+		}
+		var pending []queued
+		for _, p := range q {
+			switch {
+			case now.Sub(p.at) >= t.window:
+				t.log(fmt.Sprintf("throttle: deal expired for %s", chat))
+			case t.take(chat, now, &sent):
+				sends = append(sends, p.send)
+			default:
+				pending = append(pending, p)
+			}
+		}
+		t.sent[chat] = sent
+		t.queues[chat] = pending
+		if len(pending) == 0 {
+			delete(t.queues, chat)
+		}
+		if len(sent) == 0 {
+			delete(t.sent, chat)
+		}
+	}
+	t.lock.Unlock()
+	for _, send := range sends {
+		send()
+	}
+}
+
+// take consumes a post of the chat budget, the shared counter is used if
+// available
+func (t *throttle) take(chat string, now time.Time, sent *[]time.Time) bool {
+	if t.shared != nil {
+		key := fmt.Sprintf("throttle:%s:%d", chat, now.Truncate(t.window).Unix())
+		n, err := t.shared.Incr(key, t.window)
+		if err == nil {
+			return n <= int64(t.budget)
+		}
+		t.log(err)
+	}
+	if len(*sent) >= t.budget {
+		return false
+	}
+	*sent = append(*sent, now)
+	return true
+}
+
+// run flushes the queues periodically, the interval gives time to better
+// deals found in the same search loop to be posted first
+func (t *throttle) run(ctx context.Context, wg *sync.WaitGroup) {
+	if t.budget <= 0 {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer log.Println("throttle routine finished")
+		defer wg.Done()
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				t.flush(now)
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package amazbot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// hijackSimilarity is the similarity below which a title change is reported
+// as a possible listing hijack, the product is replaced by a different one
+const hijackSimilarity = 0.25
+
+// titleWords returns the set of lowercase words of the title
+func titleWords(title string) map[string]struct{} {
+	words := make(map[string]struct{})
+	for _, w := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		words[w] = struct{}{}
+	}
+	return words
+}
+
+// titleSimilarity returns the ratio of words shared by both titles, from 0
+// to 1
+func titleSimilarity(a, b string) float64 {
+	wa, wb := titleWords(a), titleWords(b)
+	if len(wa) == 0 && len(wb) == 0 {
+		return 1
+	}
+	shared := 0
+	for w := range wa {
+		if _, ok := wb[w]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(wa)+len(wb)-shared)
+}
+
+// owners returns the users whose searchs are sent to the chat, the user of
+// purchase searchs or the admin if there is none
+func (b *bot) owners(chat string) []int {
+	if user, ok := protectedUser(chat); ok {
+		return []int{user}
+	}
+	chats := make(map[string]bool)
+	for _, d := range destinations(chat) {
+		chats[d.chat] = true
+	}
+	b.usersLock.RLock()
+	var users []int
+	for u, c := range b.userChats {
+		if chats[c] {
+			users = append(users, u)
+		}
+	}
+	b.usersLock.RUnlock()
+	if len(users) == 0 {
+		return []int{b.admin}
+	}
+	sort.Ints(users)
+	return users
+}
+
+// renamed warns the owners of the search when its title changes to a
+// completely different product
+func (b *bot) renamed(e Event) {
+	log.Printf("title of %s changed from %q to %q\n", e.Search, e.Title, e.Item.Title)
+	if titleSimilarity(e.Title, e.Item.Title) >= hijackSimilarity {
+		return
+	}
+	text := fmt.Sprintf("⚠️ possible listing hijack of %s\nbefore: %s\nnow: %s\n%s", e.Search, e.Title, e.Item.Title, e.Item.Link)
+	for _, u := range b.owners(e.Chat) {
+		b.message(u, text)
+	}
+}
@@ -0,0 +1,218 @@
+package amazbot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+// trackRequest is the body of a /track request, sent as json or as a form
+type trackRequest struct {
+	Token     string `json:"token"`
+	Link      string `json:"link"`
+	Threshold string `json:"threshold"`
+	Target    string `json:"target"`
+}
+
+// trackResponse is the body of a successful /track request
+type trackResponse struct {
+	ID      string `json:"id"`
+	Created bool   `json:"created"`
+}
+
+func tokenKey(user int) string {
+	return fmt.Sprintf("user/%d/token", user)
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenUser returns the user of an api token, only the hash of the tokens is
+// stored
+func (b *bot) tokenUser(token string) (int, bool) {
+	if token == "" {
+		return 0, false
+	}
+	var user int
+	if err := b.db.Get("config", fmt.Sprintf("token/%s", tokenHash(token)), &user); err != nil {
+		b.log(err)
+		return 0, false
+	}
+	if user == 0 {
+		return 0, false
+	}
+	if _, ok := b.userChat(user); !ok {
+		return 0, false
+	}
+	return user, true
+}
+
+// revokeToken deletes the api token of the user, it returns false if there
+// was none
+func (b *bot) revokeToken(user int) bool {
+	var hash string
+	if err := b.db.Get("config", tokenKey(user), &hash); err != nil {
+		b.log(err)
+	}
+	if hash == "" {
+		return false
+	}
+	for _, k := range []string{tokenKey(user), fmt.Sprintf("token/%s", hash)} {
+		if err := b.db.Delete("config", k); err != nil {
+			b.log(err)
+		}
+	}
+	return true
+}
+
+// tokenCommand creates a new api token for /track and the inbox, replacing
+// the previous one, or revokes it
+func (b *bot) tokenCommand(_ context.Context, r request) {
+	if strings.TrimSpace(r.args) == "off" {
+		if !b.revokeToken(r.user) {
+			b.message(r.user, "no token to revoke")
+			return
+		}
+		b.message(r.user, "token revoked")
+		return
+	}
+	if b.baseURL == "" && b.inboxDomain == "" {
+		b.message(r.user, "tracking from the browser or email isn't available, there is no base url nor inbox configured")
+		return
+	}
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		b.log(fmt.Errorf("couldn't generate token: %w", err))
+		return
+	}
+	token := hex.EncodeToString(data)
+	b.revokeToken(r.user)
+	hash := tokenHash(token)
+	if err := b.db.Put("config", fmt.Sprintf("token/%s", hash), r.user); err != nil {
+		b.log(err)
+		return
+	}
+	if err := b.db.Put("config", tokenKey(r.user), hash); err != nil {
+		b.log(err)
+		return
+	}
+	lines := []string{fmt.Sprintf("🔑 token: %s", token)}
+	if b.baseURL != "" {
+		lines = append(lines, fmt.Sprintf("POST %s/track with the link of the product and an optional threshold, send the token as bearer authorization header", b.baseURL))
+		lines = append(lines, fmt.Sprintf("📊 query your searchs, history and events from dashboards at %s/graphql with the same token", b.baseURL))
+		lines = append(lines, fmt.Sprintf("📡 follow live events at %s/stream?token=<token>&chat=&domain=&discount=", b.baseURL))
+	}
+	if b.inboxDomain != "" {
+		lines = append(lines, fmt.Sprintf("📧 forward amazon emails to %s@%s to track their products", token, b.inboxDomain))
+	}
+	lines = append(lines, "Revoke it with /token off")
+	b.message(r.user, strings.Join(lines, "\n"))
+}
+
+// trackHandler starts tracking the product link sent by the browser extension
+// or bookmarklet of an user authenticated with its api token and returns the
+// search id
+func (b *bot) trackHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	var req trackRequest
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req = trackRequest{
+			Token:     r.FormValue("token"),
+			Link:      r.FormValue("link"),
+			Threshold: r.FormValue("threshold"),
+			Target:    r.FormValue("target"),
+		}
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		req.Token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	user, ok := b.tokenUser(req.Token)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := api.ItemID(req.Link)
+	if errors.Is(err, api.ErrNoLink) {
+		err = errors.New("invalid product link")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var opts []string
+	if req.Threshold != "" {
+		threshold, err := strconv.ParseFloat(strings.TrimSuffix(req.Threshold, "%"), 64)
+		if err != nil || threshold <= 0 || threshold >= 100 {
+			http.Error(w, "invalid threshold, it must be a percentage", http.StatusBadRequest)
+			return
+		}
+		opts = append(opts, fmt.Sprintf("drop=%s", strconv.FormatFloat(threshold, 'f', -1, 64)))
+	}
+	if req.Target != "" {
+		target, err := parseTarget(req.Target)
+		if err != nil || target == "" {
+			http.Error(w, "invalid target price", http.StatusBadRequest)
+			return
+		}
+		opts = append(opts, fmt.Sprintf("target=%s", target))
+	}
+	if len(opts) > 0 {
+		id = fmt.Sprintf("%s?%s", id, strings.Join(opts, "&"))
+	}
+	chat, _ := b.userChat(user)
+	query, err := api.NormalizeID(b.defaults(user).apply(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parsed, err := parseArgs(fmt.Sprintf("%s/%s", chat, query), chat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, exists := b.searchs.Load(parsed.id)
+	if !exists {
+		if err := b.add(parsed); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		b.message(user, fmt.Sprintf("searching %s", parsed.id))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+	if err := json.NewEncoder(w).Encode(trackResponse{ID: parsed.id, Created: !exists}); err != nil {
+		log.Println(fmt.Errorf("couldn't write track response: %w", err))
+	}
+}
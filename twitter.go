@@ -0,0 +1,98 @@
+package amazbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/igolaizola/amazbot/internal/api"
+)
+
+func twitterKey(chat string) string {
+	return fmt.Sprintf("%s/twitter", chat)
+}
+
+// twitterCommand handles /twitter on|off|<min score>
+func (b *bot) twitterCommand(user int, chat, args string) {
+	if b.twitter == nil {
+		b.message(user, "twitter is not configured")
+		return
+	}
+	args = strings.TrimSpace(args)
+	var min float64
+	switch args {
+	case "":
+		if err := b.db.Get("config", twitterKey(chat), &min); err != nil {
+			b.log(err)
+		}
+		if min == 0 {
+			b.message(user, fmt.Sprintf("twitter disabled for %s", chat))
+			return
+		}
+		b.message(user, fmt.Sprintf("twitter enabled for %s with minimum deal score %.0f%%", chat, min))
+		return
+	case "off":
+		if err := b.db.Delete("config", twitterKey(chat)); err != nil {
+			b.log(err)
+			return
+		}
+		b.message(user, fmt.Sprintf("twitter disabled for %s", chat))
+		return
+	case "on":
+		min = 10
+	default:
+		var err error
+		min, err = strconv.ParseFloat(strings.TrimSuffix(args, "%"), 64)
+		if err != nil || min <= 0 {
+			b.message(user, "usage: /twitter on|off|<min deal score>")
+			return
+		}
+	}
+	if err := b.db.Put("config", twitterKey(chat), min); err != nil {
+		b.log(err)
+		return
+	}
+	b.message(user, fmt.Sprintf("twitter enabled for %s with minimum deal score %.0f%%", chat, min))
+}
+
+// tweet posts alerts of chats that opted in to twitter
+func (b *bot) tweet(e Event) {
+	var min float64
+	if err := b.db.Get("config", twitterKey(e.Chat), &min); err != nil {
+		b.log(err)
+		return
+	}
+	i, a := *e.Item, *e.Alert
+	if min <= 0 || dealScore(i, a) < min {
+		return
+	}
+	cacheID := fmt.Sprintf("twitter/%s/%d/%d/%.2f", i.ID, a.Kind, a.State, a.Price)
+	if !b.claim(cacheID) {
+		return
+	}
+	// Links count as 23 characters
+	_, text, err := b.render("compact", newAlertData(i, a, e.Chat))
+	if err != nil {
+		b.log(err)
+		b.release(cacheID)
+		return
+	}
+	text = strings.Split(text, "\n")[0]
+	if r := []rune(text); len(r) > 250 {
+		text = string(r[:249]) + "…"
+	}
+	text = fmt.Sprintf("%s\n%s", text, b.affiliate(i.Link, i.Domain))
+	if err := b.twitter.Tweet(text, i.Image); err != nil {
+		b.log(err)
+		b.release(cacheID)
+	}
+}
+
+// affiliate adds the affiliate tag of the domain to the link
+func (b *bot) affiliate(link, domain string) string {
+	tag, ok := b.tags[domain]
+	if !ok {
+		return link
+	}
+	return api.Tag(link, tag)
+}
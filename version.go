@@ -0,0 +1,82 @@
+package amazbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/update"
+)
+
+// ErrRestart is returned by Run when the bot has been updated and the
+// process must be restarted
+var ErrRestart = errors.New("amazbot: restart required")
+
+const repo = "igolaizola/amazbot"
+
+// versionCommand handles /version
+func (b *bot) versionCommand(ctx context.Context, user int) {
+	text := fmt.Sprintf("amazbot %s (%s/%s)", b.version, runtime.GOOS, runtime.GOARCH)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	r, err := update.Latest(ctx, repo)
+	if err != nil {
+		b.message(user, fmt.Sprintf("%s\ncouldn't check latest release: %v", text, err))
+		return
+	}
+	if newer, err := update.Newer(b.version, r.Tag); err == nil && newer {
+		text = fmt.Sprintf("%s\nnew release available: %s, use /update to install it", text, r.Tag)
+	} else {
+		text = fmt.Sprintf("%s\nlatest release: %s", text, r.Tag)
+	}
+	b.message(user, text)
+}
+
+// updateCommand handles /update, the release is installed in the background
+// and the bot is stopped to be restarted with the new binary
+func (b *bot) updateCommand(ctx context.Context, user int) {
+	b.updateLock.Lock()
+	defer b.updateLock.Unlock()
+	if b.updating {
+		b.message(user, "update already in progress")
+		return
+	}
+	b.updating = true
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.update(ctx, user)
+		b.updateLock.Lock()
+		b.updating = false
+		b.updateLock.Unlock()
+	}()
+}
+
+// update installs the latest release if it is newer than the running one
+func (b *bot) update(ctx context.Context, user int) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	r, err := update.Latest(ctx, repo)
+	if err != nil {
+		b.message(user, err.Error())
+		return
+	}
+	newer, err := update.Newer(b.version, r.Tag)
+	if err != nil {
+		b.message(user, fmt.Sprintf("can't update version %s: %v", b.version, err))
+		return
+	}
+	if !newer {
+		b.message(user, fmt.Sprintf("already running the latest release %s", b.version))
+		return
+	}
+	b.message(user, fmt.Sprintf("downloading %s", r.Tag))
+	if err := update.Install(ctx, r); err != nil {
+		b.message(user, err.Error())
+		return
+	}
+	b.message(user, fmt.Sprintf("updated to %s, restarting", r.Tag))
+	b.restart()
+}
@@ -0,0 +1,226 @@
+package amazbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/store"
+)
+
+// viewer serves the searched items of the store as a read-only json api and
+// html page, chats are not exposed
+type viewer struct {
+	lock sync.RWMutex
+	db   *store.Store
+	// allow filters the searchs visible in the request if set
+	allow func(r *http.Request, key string) bool
+}
+
+// viewItem is an item of the viewer identified by its search query
+type viewItem struct {
+	Query string   `json:"query"`
+	Item  api.Item `json:"item"`
+}
+
+// items returns the items of the store visible in the request sorted by
+// query, searchs of the same item in several chats are merged
+func (v *viewer) items(r *http.Request) ([]viewItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	keys, err := v.db.Keys("db")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var items []viewItem
+	for _, k := range keys {
+		p, err := parseArgs(k, "")
+		if err != nil || p.query == "" || seen[p.query] {
+			continue
+		}
+		if v.allow != nil && !v.allow(r, k) {
+			continue
+		}
+		var i api.Item
+		if err := v.db.Get("db", k, &i); err != nil {
+			return nil, err
+		}
+		if i.ID == "" {
+			continue
+		}
+		seen[p.query] = true
+		items = append(items, viewItem{Query: p.query, Item: i})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Query < items[j].Query })
+	return items, nil
+}
+
+var viewerPage = template.Must(template.New("viewer").Funcs(template.FuncMap{
+	"price": func(v float64, coin string) string {
+		if v == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f%s", v, coin)
+	},
+	"coin": api.Coin,
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>amazbot</title></head>
+<body><table>
+<tr><th>Producto</th><th>Nuevo</th><th>Como nuevo</th><th>Muy bueno</th><th>Bueno</th><th>Aceptable</th></tr>
+{{range .}}<tr><td><a href="{{.Item.Link}}">{{.Item.Title}}</a> ({{.Item.Domain}})</td>{{$coin := coin .Item.Domain}}{{range .Item.Prices}}<td>{{price . $coin}}</td>{{end}}</tr>
+{{end}}</table></body></html>
+`))
+
+// register adds the viewer handlers: / html page, /api/items list and
+// /api/items/<query> item
+func (v *viewer) register(mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		items, err := v.items(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := viewerPage.Execute(w, items); err != nil {
+			log.Println(fmt.Errorf("couldn't render viewer: %w", err))
+		}
+	})
+	mux.HandleFunc("/api/items", func(w http.ResponseWriter, r *http.Request) {
+		items, err := v.items(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, items)
+	})
+	mux.HandleFunc("/api/items/", func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimPrefix(r.URL.Path, "/api/items/")
+		if r.URL.RawQuery != "" {
+			query = fmt.Sprintf("%s?%s", query, r.URL.RawQuery)
+		}
+		items, err := v.items(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, i := range items {
+			if i.Query == query {
+				writeJSON(w, i)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(fmt.Errorf("couldn't encode json: %w", err))
+	}
+}
+
+// snapshotInterval is how often the snapshot for mirrors is written
+const snapshotInterval = time.Minute
+
+// snapshot writes a copy of the store for mirrors periodically
+func (b *bot) snapshot(ctx context.Context, path string) {
+	b.wg.Add(1)
+	go func() {
+		defer log.Println("snapshot routine finished")
+		defer b.wg.Done()
+		ticker := time.NewTicker(snapshotInterval)
+		defer ticker.Stop()
+		for {
+			if err := b.db.Snapshot(path); err != nil {
+				b.log(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunMirror serves the viewer from a store snapshot written by the scraping
+// instance, the snapshot is reopened when it changes
+func RunMirror(ctx context.Context, path, addr string) error {
+	db, err := store.ReadOnly(path)
+	if err != nil {
+		return err
+	}
+	v := &viewer{db: db}
+	defer func() {
+		v.lock.Lock()
+		v.db.Close()
+		v.lock.Unlock()
+	}()
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("couldn't listen http on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	v.register(mux)
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Println(fmt.Errorf("http server failed: %w", err))
+		}
+	}()
+	log.Printf("mirror serving %s on %s\n", path, addr)
+
+	var modified time.Time
+	if info, err := os.Stat(path); err == nil {
+		modified = info.ModTime()
+	}
+	ticker := time.NewTicker(snapshotInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return srv.Shutdown(ctx)
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println(fmt.Errorf("couldn't stat %s: %w", path, err))
+			continue
+		}
+		if !info.ModTime().After(modified) {
+			continue
+		}
+		db, err := store.ReadOnly(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		modified = info.ModTime()
+		v.lock.Lock()
+		v.db.Close()
+		v.db = db
+		v.lock.Unlock()
+	}
+}
@@ -0,0 +1,275 @@
+package amazbot
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/igolaizola/amazbot/internal/api"
+	"github.com/igolaizola/amazbot/internal/notify"
+)
+
+// maxWatchlist is the maximum size of an uploaded watchlist export
+const maxWatchlist = 1 << 20
+
+var downloadClient = &http.Client{Timeout: 30 * time.Second}
+
+// keepaDomains are the marketplaces of the keepa domain ids
+var keepaDomains = map[string]string{
+	"1":  "com",
+	"2":  "co.uk",
+	"3":  "de",
+	"4":  "fr",
+	"5":  "co.jp",
+	"6":  "ca",
+	"8":  "it",
+	"9":  "es",
+	"11": "com.mx",
+	"12": "com.br",
+}
+
+// watchlistColumn is a target price column of a watchlist, state is the
+// condition of the target ("0" new, "4" used) or empty when not stated
+type watchlistColumn struct {
+	index int
+	state string
+}
+
+// parseWatchlist converts a camelcamelcamel or keepa csv export to searchs
+// (ASIN.domain?options) with the desired prices as targets, the rows without
+// a valid asin or domain are skipped
+func parseWatchlist(data, domain string) ([]string, int, error) {
+	data = strings.TrimPrefix(strings.TrimSpace(data), "\ufeff")
+	r := csv.NewReader(strings.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+	if header := strings.SplitN(data, "\n", 2)[0]; strings.Count(header, ";") > strings.Count(header, ",") {
+		r.Comma = ';'
+	}
+	header, err := r.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("couldn't read header: %w", err)
+	}
+	asinCol, linkCol, domainCol := -1, -1, -1
+	var targets []watchlistColumn
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		switch {
+		case strings.Contains(h, "asin"):
+			asinCol = i
+		case strings.Contains(h, "url"), strings.Contains(h, "link"):
+			linkCol = i
+		case strings.Contains(h, "locale"), strings.Contains(h, "domain"),
+			strings.Contains(h, "marketplace"), strings.Contains(h, "country"):
+			domainCol = i
+		case strings.Contains(h, "desired"), strings.Contains(h, "target"),
+			strings.Contains(h, "threshold"), strings.Contains(h, "watch"):
+			c := watchlistColumn{index: i}
+			switch {
+			case strings.Contains(h, "used"):
+				c.state = "4"
+			case strings.Contains(h, "new"), strings.Contains(h, "amazon"):
+				c.state = "0"
+			}
+			targets = append(targets, c)
+		}
+	}
+	if asinCol < 0 && linkCol < 0 {
+		return nil, 0, errors.New("asin column not found")
+	}
+	cell := func(row []string, i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	var queries []string
+	var skipped int
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("couldn't read row: %w", err)
+		}
+		var asin, market string
+		if id, err := api.ItemID(cell(row, linkCol)); err == nil {
+			split := strings.SplitN(id, ".", 2)
+			asin, market = split[0], split[1]
+		}
+		if a, err := api.ASIN(cell(row, asinCol)); err == nil {
+			asin = a
+		}
+		if d, ok := watchlistDomain(cell(row, domainCol)); ok {
+			market = d
+		}
+		if market == "" {
+			market = domain
+		}
+		if asin == "" || market == "" {
+			skipped++
+			continue
+		}
+		id := fmt.Sprintf("%s.%s", asin, market)
+		// Lowest target of each condition
+		prices := make(map[string]float64)
+		for _, c := range targets {
+			price, ok := parseWatchlistPrice(cell(row, c.index))
+			if !ok {
+				continue
+			}
+			if p, ok := prices[c.state]; !ok || price < p {
+				prices[c.state] = price
+			}
+		}
+		if len(prices) == 0 {
+			queries = append(queries, id)
+			continue
+		}
+		var states []string
+		for s := range prices {
+			states = append(states, s)
+		}
+		sort.Strings(states)
+		for _, s := range states {
+			var opts []string
+			if s != "" {
+				opts = append(opts, s)
+			}
+			opts = append(opts, fmt.Sprintf("target=%s", strconv.FormatFloat(prices[s], 'f', -1, 64)))
+			queries = append(queries, fmt.Sprintf("%s?%s", id, strings.Join(opts, "&")))
+		}
+	}
+	return queries, skipped, nil
+}
+
+// watchlistDomain returns the marketplace of a locale, domain or keepa
+// domain id cell
+func watchlistDomain(s string) (string, bool) {
+	if d, ok := keepaDomains[s]; ok {
+		return d, true
+	}
+	return api.Marketplace(s)
+}
+
+// parseWatchlistPrice parses a price with currency symbols and thousands
+// separators, written in any format
+func parseWatchlistPrice(s string) (float64, bool) {
+	s = strings.TrimFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+	s = strings.ReplaceAll(s, " ", "")
+	if s == "" {
+		return 0, false
+	}
+	comma, dot := strings.LastIndex(s, ","), strings.LastIndex(s, ".")
+	if comma > dot {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	} else {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	// A single separator followed by three digits groups thousands
+	if strings.Count(s, ".") == 1 && len(s)-strings.Index(s, ".") == 4 && (comma < 0 || dot < 0) {
+		s = strings.Replace(s, ".", "", 1)
+	}
+	price, err := strconv.ParseFloat(s, 64)
+	if err != nil || price <= 0 {
+		return 0, false
+	}
+	return price, true
+}
+
+// download returns the content of a telegram file
+func (b *bot) download(file string) (string, error) {
+	u, err := b.tg.FileURL(file)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get file url: %w", err)
+	}
+	resp, err := downloadClient.Get(u)
+	if err != nil {
+		return "", fmt.Errorf("couldn't download file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("couldn't download file: status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWatchlist+1))
+	if err != nil {
+		return "", fmt.Errorf("couldn't read file: %w", err)
+	}
+	if len(data) > maxWatchlist {
+		return "", fmt.Errorf("file bigger than %d bytes", maxWatchlist)
+	}
+	return string(data), nil
+}
+
+// isChat returns whether the line is a chat instead of a csv header
+func isChat(line string) bool {
+	first := strings.TrimSpace(strings.SplitN(line, ",", 2)[0])
+	if _, _, ok := notify.Split(first); ok {
+		return true
+	}
+	_, err := chatConfig(first)
+	return err == nil
+}
+
+// watchlistCommand imports the searchs of a camelcamelcamel or keepa csv
+// export, pasted after the command or uploaded with it as caption, an
+// optional first line sets the destination chat
+func (b *bot) watchlistCommand(_ context.Context, r request) {
+	chat, data := r.chat, r.args
+	if split := strings.SplitN(strings.TrimSpace(r.args), "\n", 2); len(split) == 2 && isChat(split[0]) {
+		chat, data = strings.TrimSpace(split[0]), split[1]
+	}
+	d := b.defaults(r.user)
+	// The targets of the watchlists are in the currency of the marketplace
+	d.Currency = ""
+	queries, skipped, err := parseWatchlist(data, d.Domain)
+	if err != nil {
+		b.message(r.user, fmt.Sprintf("couldn't import watchlist: %s", err))
+		return
+	}
+	if len(queries) == 0 {
+		b.message(r.user, fmt.Sprintf("no products found in watchlist, %d rows skipped", skipped))
+		return
+	}
+	if chat != r.chat {
+		if err := b.checkChat(r.user, chat); err != nil {
+			b.message(r.user, fmt.Sprintf("couldn't import watchlist: %s", err))
+			return
+		}
+	}
+	var imported int
+	for _, q := range queries {
+		query, err := api.NormalizeID(d.apply(q))
+		if err != nil {
+			skipped++
+			continue
+		}
+		parsed, err := parseArgs(fmt.Sprintf("%s/%s", chat, query), r.chat)
+		if err != nil {
+			skipped++
+			continue
+		}
+		if err := b.add(parsed); err != nil {
+			b.message(r.user, fmt.Sprintf("import stopped: %s", err))
+			break
+		}
+		imported++
+	}
+	text := fmt.Sprintf("imported %d searchs to %s", imported, chat)
+	if skipped > 0 {
+		text = fmt.Sprintf("%s, %d rows skipped", text, skipped)
+	}
+	b.message(r.user, text)
+}
@@ -0,0 +1,192 @@
+package amazbot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sessionCookie is the cookie with the signed session of the web user
+	sessionCookie = "amazbot_session"
+	// sessionTTL is how long web sessions and login data are valid
+	sessionTTL = 24 * time.Hour
+)
+
+// webLogin authenticates web users with the telegram login widget, only the
+// users of the bot are allowed
+type webLogin struct {
+	bot    *bot
+	name   string
+	token  string
+	secret []byte
+}
+
+func newWebLogin(b *bot, name, token string) *webLogin {
+	mac := hmac.New(sha256.New, []byte("amazbot session"))
+	mac.Write([]byte(token))
+	return &webLogin{bot: b, name: name, token: token, secret: mac.Sum(nil)}
+}
+
+// verify checks the login widget data signed with the bot token and returns
+// the telegram user id, see https://core.telegram.org/widgets/login
+func (l *webLogin) verify(values map[string][]string, now time.Time) (int, error) {
+	hash := ""
+	var pairs []string
+	for k, v := range values {
+		if len(v) == 0 {
+			continue
+		}
+		if k == "hash" {
+			hash = v[0]
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v[0]))
+	}
+	sort.Strings(pairs)
+	secret := sha256.Sum256([]byte(l.token))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(hash), []byte(want)) {
+		return 0, fmt.Errorf("invalid login hash")
+	}
+	date, err := strconv.ParseInt(first(values["auth_date"]), 10, 64)
+	if err != nil || now.Sub(time.Unix(date, 0)) > sessionTTL {
+		return 0, fmt.Errorf("login expired")
+	}
+	id, err := strconv.Atoi(first(values["id"]))
+	if err != nil {
+		return 0, fmt.Errorf("invalid login id")
+	}
+	return id, nil
+}
+
+func first(v []string) string {
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0]
+}
+
+// sign returns the session value of the user (id.expiration.signature)
+func (l *webLogin) sign(user int, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", user, expires.Unix())
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(payload))
+	return fmt.Sprintf("%s.%s", payload, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// session returns the user of a valid session value
+func (l *webLogin) session(value string, now time.Time) (int, bool) {
+	split := strings.Split(value, ".")
+	if len(split) != 3 {
+		return 0, false
+	}
+	user, err := strconv.Atoi(split[0])
+	if err != nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(split[1], 10, 64)
+	if err != nil || now.After(time.Unix(expires, 0)) {
+		return 0, false
+	}
+	if !hmac.Equal([]byte(value), []byte(l.sign(user, time.Unix(expires, 0)))) {
+		return 0, false
+	}
+	if _, ok := l.bot.userChat(user); !ok {
+		return 0, false
+	}
+	return user, true
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>amazbot</title></head>
+<body><script async src="https://telegram.org/js/telegram-widget.js?22" data-telegram-login="{{.}}" data-size="large" data-auth-url="/auth" data-request-access="write"></script></body></html>
+`))
+
+// register adds the login page and the widget callback
+func (l *webLogin) register(mux *http.ServeMux) {
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := loginPage.Execute(w, l.name); err != nil {
+			log.Println(fmt.Errorf("couldn't render login: %w", err))
+		}
+	})
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		user, err := l.verify(r.URL.Query(), now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if _, ok := l.bot.userChat(user); !ok {
+			http.Error(w, "user not allowed", http.StatusForbidden)
+			return
+		}
+		expires := now.Add(sessionTTL)
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    l.sign(user, expires),
+			Path:     "/",
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil || strings.HasPrefix(l.bot.baseURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+}
+
+type userKey struct{}
+
+// require rejects requests without a valid session, pages are redirected to
+// the login page
+func (l *webLogin) require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(sessionCookie)
+		if err == nil {
+			if user, ok := l.session(c.Value, time.Now()); ok {
+				h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+				return
+			}
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+}
+
+// allowed returns true if the web user can see the search, the admin can
+// see all of them and users the ones of their chat
+func (l *webLogin) allowed(r *http.Request, key string) bool {
+	user, ok := r.Context().Value(userKey{}).(int)
+	if !ok {
+		return false
+	}
+	if user == l.bot.admin {
+		return true
+	}
+	chat, ok := l.bot.userChat(user)
+	return ok && strings.HasPrefix(key, fmt.Sprintf("%s/", chat))
+}
+
+// userChat returns the chat of the user, it is safe to use outside the
+// update loop
+func (b *bot) userChat(user int) (string, bool) {
+	b.usersLock.RLock()
+	defer b.usersLock.RUnlock()
+	chat, ok := b.userChats[user]
+	return chat, ok
+}